@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// registerAdminRoutes adds profiling and runtime debug endpoints. They are
+// only mounted when the server is started with -admin, since they expose
+// internals and can be expensive to call.
+//
+// net/http/pprof also registers itself on http.DefaultServeMux, which is why
+// the web server uses its own mux.
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/dump", handleDebugDump)
+}
+
+// Handler: GET /debug/dump - Plain-text goroutine and heap dump
+func handleDebugDump(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	fmt.Fprintf(w, "skribbl-capture runtime dump at %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "go %s %s/%s, %d CPUs, %d goroutines\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.NumGoroutine())
+
+	fmt.Fprintln(w, "=== Memory ===")
+	fmt.Fprintf(w, "HeapAlloc:    %d\n", mem.HeapAlloc)
+	fmt.Fprintf(w, "HeapInuse:    %d\n", mem.HeapInuse)
+	fmt.Fprintf(w, "HeapObjects:  %d\n", mem.HeapObjects)
+	fmt.Fprintf(w, "Sys:          %d\n", mem.Sys)
+	fmt.Fprintf(w, "NumGC:        %d\n", mem.NumGC)
+	fmt.Fprintf(w, "PauseTotalNs: %d\n", mem.PauseTotalNs)
+	fmt.Fprintf(w, "Capture queue: %d of %d bytes\n\n", captureBudget.inUse(), captureBudget.limit)
+
+	fmt.Fprintln(w, "=== Goroutines ===")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+
+	fmt.Fprintln(w, "\n=== Heap ===")
+	rpprof.Lookup("heap").WriteTo(w, 1)
+}
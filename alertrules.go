@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// alertRulesFile decides where alerts go and when they're escalated.
+var alertRulesFile = "alert-rules.json"
+
+// alertEscalationTick is how often open alerts are checked for escalation.
+const alertEscalationTick = time.Minute
+
+// Alert channels a rule can send to.
+const (
+	alertChannelWebhook = "webhook"
+	alertChannelEmail   = "email"
+)
+
+// AlertRule decides where matching alerts are sent. The first rule that
+// matches an alert applies; alerts no rule matches go to the webhook, and
+// are emailed if -email-alerts includes them.
+type AlertRule struct {
+	Name   string   `json:"name,omitempty"`
+	Types  []string `json:"types,omitempty"`  // default: every type
+	Device string   `json:"device,omitempty"` // default: any device
+
+	// Notify lists the channels, "webhook" and "email"; an empty list
+	// keeps matching alerts to the console and alert streams
+	Notify []string `json:"notify"`
+
+	// Repeats of an alert that's still open are counted rather than sent
+	// for this long after it was last sent
+	DedupeMinutes int `json:"dedupeMinutes,omitempty"`
+
+	// An alert still open this long after it was raised is sent again to
+	// the Escalate channels, once
+	EscalateAfterMinutes int      `json:"escalateAfterMinutes,omitempty"`
+	Escalate             []string `json:"escalate,omitempty"`
+}
+
+var alertRules []AlertRule
+
+// openAlert is an alert whose problem hasn't been resolved yet. Repeats with
+// the same key are counted against it. Must be used with alerts.mu held.
+type openAlert struct {
+	first     Alert
+	rule      *AlertRule // nil if no rule matched
+	count     int
+	sent      []string  // channels it's gone to, which hear when it's resolved
+	lastSent  time.Time // zero if never sent
+	escalated bool
+}
+
+// OpenAlert describes an open alert for GET /api/alerts/open
+type OpenAlert struct {
+	Alert     Alert      `json:"alert"` // the one that opened it
+	Rule      string     `json:"rule,omitempty"`
+	Count     int        `json:"count"` // times raised
+	LastSent  *time.Time `json:"lastSent,omitempty"`
+	Escalated bool       `json:"escalated"`
+}
+
+// loadAlertRules reads the alert rules file. A missing file means no rules.
+func loadAlertRules() error {
+	data, err := os.ReadFile(alertRulesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("invalid alert rules file %s: %w", alertRulesFile, err)
+	}
+	for i, rule := range rules {
+		for _, channel := range append(slices.Clone(rule.Notify), rule.Escalate...) {
+			if channel != alertChannelWebhook && channel != alertChannelEmail {
+				return fmt.Errorf("alert rule %d: channel must be \"webhook\" or \"email\", not %q", i+1, channel)
+			}
+		}
+		if rule.DedupeMinutes < 0 || rule.EscalateAfterMinutes < 0 {
+			return fmt.Errorf("alert rule %d: minutes can't be negative", i+1)
+		}
+		if rule.EscalateAfterMinutes > 0 && len(rule.Escalate) == 0 {
+			return fmt.Errorf("alert rule %d: escalateAfterMinutes needs escalate channels", i+1)
+		}
+	}
+	alertRules = rules
+	return nil
+}
+
+// alertRuleWarnings lists rules that send to channels that aren't set up.
+func alertRuleWarnings() []string {
+	var warnings []string
+	for i, rule := range alertRules {
+		channels := append(slices.Clone(rule.Notify), rule.Escalate...)
+		if slices.Contains(channels, alertChannelWebhook) && webhookURL == "" {
+			warnings = append(warnings, fmt.Sprintf("alert rule %s sends to the webhook; set -webhook", rule.label(i)))
+		}
+		if slices.Contains(channels, alertChannelEmail) && email.server == "" {
+			warnings = append(warnings, fmt.Sprintf("alert rule %s sends email; set -smtp", rule.label(i)))
+		}
+	}
+	return warnings
+}
+
+// label names a rule in messages.
+func (rule *AlertRule) label(i int) string {
+	if rule.Name != "" {
+		return fmt.Sprintf("%q", rule.Name)
+	}
+	return fmt.Sprintf("%d", i+1)
+}
+
+// escalates reports whether any rule escalates alerts.
+func escalates() bool {
+	return slices.ContainsFunc(alertRules, func(rule AlertRule) bool { return rule.EscalateAfterMinutes > 0 })
+}
+
+// matchAlertRule returns the first rule matching an alert, or nil.
+func matchAlertRule(a Alert) *AlertRule {
+	for i, rule := range alertRules {
+		if len(rule.Types) > 0 && !slices.Contains(rule.Types, a.Type) {
+			continue
+		}
+		if rule.Device != "" && rule.Device != a.Device {
+			continue
+		}
+		return &alertRules[i]
+	}
+	return nil
+}
+
+// alertChannels is where an alert goes under a rule, or without one.
+func alertChannels(rule *AlertRule, a Alert) []string {
+	if rule != nil {
+		return rule.Notify
+	}
+	var channels []string
+	if webhookURL != "" {
+		channels = append(channels, alertChannelWebhook)
+	}
+	if email.emailsAlert(a.Type) {
+		channels = append(channels, alertChannelEmail)
+	}
+	return channels
+}
+
+// deliverAlert sends an alert, or an escalation or resolution of one, to
+// channels in the background.
+func deliverAlert(a Alert, channels []string) {
+	for _, channel := range channels {
+		switch {
+		case channel == alertChannelWebhook && webhookURL != "":
+			go postWebhook(a)
+		case channel == alertChannelEmail && email.server != "":
+			emailAlert(a)
+		}
+	}
+}
+
+// trackAlert counts an alert against the open one with its key, opening it
+// if there isn't one, and returns it with the channels to send the alert to:
+// none if it's a repeat within its rule's dedupe window. Must be called with
+// alerts.mu held.
+func trackAlert(a *Alert) (*openAlert, []string) {
+	open := alerts.open[a.Key]
+	if open == nil {
+		if len(alerts.open) >= maxRecentAlerts {
+			forgetOldestAlert()
+		}
+		open = &openAlert{first: *a, rule: matchAlertRule(*a)}
+		alerts.open[a.Key] = open
+	}
+	open.count++
+	if open.count > 1 {
+		a.Count = open.count
+	}
+	if rule := open.rule; rule != nil && open.count > 1 && !open.lastSent.IsZero() &&
+		a.Time.Sub(open.lastSent) < time.Duration(rule.DedupeMinutes)*time.Minute {
+		return open, nil
+	}
+	channels := alertChannels(open.rule, *a)
+	if len(channels) > 0 {
+		open.lastSent = a.Time
+		open.sent = union(open.sent, channels)
+	}
+	return open, channels
+}
+
+// forgetOldestAlert drops the longest open alert, so keys that are never
+// resolved can't pile up. Must be called with alerts.mu held.
+func forgetOldestAlert() {
+	oldest := ""
+	for key, open := range alerts.open {
+		if oldest == "" || open.first.ID < alerts.open[oldest].first.ID {
+			oldest = key
+		}
+	}
+	delete(alerts.open, oldest)
+}
+
+// union appends the channels not already in a.
+func union(a, b []string) []string {
+	for _, channel := range b {
+		if !slices.Contains(a, channel) {
+			a = append(a, channel)
+		}
+	}
+	return a
+}
+
+// resolveAlert closes the open alert with the key, sending a resolution
+// event with message to wherever it went, and reports whether there was one.
+// Like raiseAlert it's safe to call from any goroutine.
+func resolveAlert(key, message string) bool {
+	alerts.mu.Lock()
+	open := alerts.open[key]
+	if open == nil {
+		alerts.mu.Unlock()
+		return false
+	}
+	delete(alerts.open, key)
+	a := recordAlert(Alert{
+		Event:   "resolved",
+		Type:    open.first.Type,
+		Key:     key,
+		Device:  open.first.Device,
+		File:    open.first.File,
+		Message: message,
+		AlertID: open.first.ID,
+		Count:   open.count,
+	})
+	alerts.mu.Unlock()
+
+	fmt.Printf("✓ Resolved: %s\n", message)
+	deliverAlert(a, open.sent)
+	return true
+}
+
+// forgetSessionAlerts closes open alerts about a session's files without a
+// resolution event, since the problem ended with the recording.
+func forgetSessionAlerts(files []string) {
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+	for key, open := range alerts.open {
+		if open.first.File != "" && slices.Contains(files, open.first.File) {
+			delete(alerts.open, key)
+		}
+	}
+}
+
+// runAlertEscalation sends alerts still open past their rule's
+// escalateAfterMinutes to its escalate channels, for the life of the process.
+func runAlertEscalation() {
+	for now := range time.Tick(alertEscalationTick) {
+		alerts.mu.Lock()
+		type escalation struct {
+			alert    Alert
+			channels []string
+		}
+		var due []escalation
+		for key, open := range alerts.open {
+			rule := open.rule
+			if rule == nil || rule.EscalateAfterMinutes <= 0 || open.escalated ||
+				now.Sub(open.first.Time) < time.Duration(rule.EscalateAfterMinutes)*time.Minute {
+				continue
+			}
+			open.escalated = true
+			open.sent = union(open.sent, rule.Escalate)
+			a := recordAlert(Alert{
+				Event:   "escalated",
+				Type:    open.first.Type,
+				Key:     key,
+				Device:  open.first.Device,
+				File:    open.first.File,
+				Message: fmt.Sprintf("Still unresolved after %d minutes: %s", rule.EscalateAfterMinutes, open.first.Message),
+				AlertID: open.first.ID,
+				Count:   open.count,
+			})
+			due = append(due, escalation{a, rule.Escalate})
+		}
+		alerts.mu.Unlock()
+
+		for _, e := range due {
+			fmt.Printf("🚨 %s\n", e.alert.Message)
+			deliverAlert(e.alert, e.channels)
+		}
+	}
+}
+
+// Handler: GET /api/alerts/open - List alerts that haven't been resolved,
+// oldest first
+func handleListOpenAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts.mu.Lock()
+	list := []OpenAlert{}
+	for _, open := range alerts.open {
+		item := OpenAlert{Alert: open.first, Count: open.count, Escalated: open.escalated}
+		if !open.lastSent.IsZero() {
+			item.LastSent = &open.lastSent
+		}
+		if open.rule != nil {
+			item.Rule = open.rule.Name
+		}
+		list = append(list, item)
+	}
+	alerts.mu.Unlock()
+	slices.SortFunc(list, func(a, b OpenAlert) int { return cmp.Compare(a.Alert.ID, b.Alert.ID) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: DELETE /api/alerts/open/{key} - Resolve an open alert by hand, for
+// problems fixed some way the recorder can't see
+func handleResolveAlert(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	alerts.mu.Lock()
+	open := alerts.open[key]
+	alerts.mu.Unlock()
+	if open == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No open alert with key "+key)
+		return
+	}
+	resolveAlert(key, open.first.Message+" (resolved by hand)")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecentAlerts is how many alerts are kept for GET /api/alerts.
+	maxRecentAlerts = 100
+
+	// webhookTimeout bounds each webhook delivery.
+	webhookTimeout = 10 * time.Second
+)
+
+// Alert settings, set from flags in web mode.
+var (
+	// webhookURL receives every alert as a JSON POST. Disabled if empty.
+	webhookURL string
+
+	// clipAlertSamples is how many clipped samples within clipAlertWindow
+	// raise an alert for a device. 0 disables clipping alerts.
+	clipAlertSamples = 100
+	clipAlertWindow  = 5 * time.Second
+
+	// silentAlertAfter is how long a device may record nothing but digital
+	// silence from its start before an alert is raised. 0 disables it.
+	silentAlertAfter = 10 * time.Second
+)
+
+// Alert is a problem worth interrupting someone for, pushed to alert
+// streams, the Stream Deck socket, MQTT and the webhook. The same problem
+// raised again while it's open is counted under its key, and it's followed
+// up with "escalated" and "resolved" events.
+type Alert struct {
+	Event   string    `json:"event"` // "alert", "escalated" or "resolved"
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // e.g. "clipping"
+	Key     string    `json:"key"`  // the problem, by default type:device
+	Device  string    `json:"device,omitempty"`
+	File    string    `json:"file,omitempty"`
+	Message string    `json:"message"`
+
+	// AlertID is the alert an escalation or resolution follows up, and
+	// Count how many times it was raised while open
+	AlertID int64 `json:"alertId,omitempty"`
+	Count   int   `json:"count,omitempty"`
+
+	// Content repeats Message so a Discord webhook shows it as-is
+	Content string `json:"content"`
+}
+
+// alerts keeps recent alerts, and the open ones by key, and wakes anyone
+// waiting for new ones.
+var alerts = struct {
+	mu      sync.Mutex
+	recent  []Alert
+	open    map[string]*openAlert
+	lastID  int64
+	changed chan struct{}
+}{open: map[string]*openAlert{}, changed: make(chan struct{})}
+
+// raiseAlert records an alert and delivers it as the alert rules say. It's
+// safe to call from any goroutine, including capture writers, since it never
+// takes recordingMutex.
+func raiseAlert(a Alert) {
+	if a.Key == "" {
+		a.Key = a.Type
+		if a.Device != "" {
+			a.Key += ":" + a.Device
+		}
+	}
+	alerts.mu.Lock()
+	a.Event, a.Time = "alert", time.Now()
+	open, channels := trackAlert(&a)
+	a = recordAlert(a)
+	if open.count == 1 {
+		open.first = a
+	}
+	alerts.mu.Unlock()
+
+	fmt.Printf("⚠️  %s\n", a.Message)
+	deliverAlert(a, channels)
+}
+
+// recordAlert numbers an alert or follow-up, keeps it among the recent ones
+// and wakes the streams. Must be called with alerts.mu held.
+func recordAlert(a Alert) Alert {
+	alerts.lastID++
+	a.ID, a.Content = alerts.lastID, a.Message
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	alerts.recent = append(alerts.recent, a)
+	if len(alerts.recent) > maxRecentAlerts {
+		alerts.recent = alerts.recent[len(alerts.recent)-maxRecentAlerts:]
+	}
+	close(alerts.changed)
+	alerts.changed = make(chan struct{})
+	return a
+}
+
+// alertsSince returns the alerts after the given ID, along with the channel
+// that will be closed when the next one is raised.
+func alertsSince(id int64) ([]Alert, <-chan struct{}) {
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+	var since []Alert
+	for _, a := range alerts.recent {
+		if a.ID > id {
+			since = append(since, a)
+		}
+	}
+	return since, alerts.changed
+}
+
+// latestAlertID is the ID of the most recent alert, so listeners that connect
+// later only hear about new ones.
+func latestAlertID() int64 {
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+	return alerts.lastID
+}
+
+// postWebhook delivers an alert to the webhook.
+func postWebhook(a Alert) {
+	deliverWebhook(a, "alert")
+}
+
+// deliverWebhook POSTs v to the webhook as JSON, reporting failures as about
+// what.
+func deliverWebhook(v any, what string) error {
+	payload, _ := json.Marshal(v)
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		fmt.Printf("⚠️  Failed to deliver %s to webhook: %v\n", what, err)
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Printf("⚠️  Webhook rejected %s: %s\n", what, resp.Status)
+		return fmt.Errorf("webhook rejected %s: %s", what, resp.Status)
+	}
+	return nil
+}
+
+// Handler: GET /api/alerts - List recent alerts
+func handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	recent, _ := alertsSince(0)
+	if recent == nil {
+		recent = []Alert{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recent)
+}
+
+// Handler: GET /api/alerts/stream - Server-sent events, one per new alert,
+// escalation or resolution.
+// Reconnecting clients send Last-Event-ID to catch up on what they missed.
+func handleAlertStream(w http.ResponseWriter, r *http.Request) {
+	last := latestAlertID()
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		last = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	for {
+		pending, changed := alertsSince(last)
+		for _, a := range pending {
+			data, _ := json.Marshal(a)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", a.ID, a.Event, data)
+			last = a.ID
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
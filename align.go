@@ -0,0 +1,208 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// alignSearchSeconds is how far into each track an align job looks for
+	// the sync event: a clap, or a tone played into every microphone.
+	alignSearchSeconds = 60
+
+	// alignHopSeconds is the resolution of the envelope the sync event is
+	// found in, before it's refined to the sample.
+	alignHopSeconds = 0.001
+
+	// alignMinContrastDB is how far above the track's typical level the sync
+	// event must be to be trusted.
+	alignMinContrastDB = 12
+)
+
+// Alignment is what an align job found: where the sync event is in each
+// track, and how much to trim from the start of each so the event lines up
+type Alignment struct {
+	At     time.Time        `json:"at"`
+	Tracks []TrackAlignment `json:"tracks"`
+}
+
+// TrackAlignment is one track's part of an Alignment
+type TrackAlignment struct {
+	File   string `json:"file"`
+	Device string `json:"device,omitempty"`
+
+	// EventSeconds is where the sync event starts, from the track's start
+	EventSeconds float64 `json:"eventSeconds"`
+
+	// TrimSeconds is how much to cut from the start of the track so the
+	// sync event happens at the same moment in every track
+	TrimSeconds float64 `json:"trimSeconds"`
+
+	// OffsetSeconds is how far the track is from where the session placed
+	// it, by startOffsetSeconds, against the first track: positive if it
+	// runs late
+	OffsetSeconds float64 `json:"offsetSeconds"`
+
+	// ContrastDB is how far the event stands above the track's typical
+	// level; the higher, the surer the match
+	ContrastDB float64 `json:"contrastDb"`
+
+	// Aligned is the trimmed copy, when the job was asked for one
+	Aligned string `json:"aligned,omitempty"`
+}
+
+// findSyncEvent finds the start of the loudest sharp event in the first
+// alignSearchSeconds of a track's PCM, such as a clap or the start of a sync
+// tone: the first sample, before the loudest moment, from which the level
+// holds above half of it. It returns the event's time in seconds and how
+// far it stands above the track's median level.
+func findSyncEvent(pcm io.Reader, info AudioInfo) (float64, float64, error) {
+	frameBytes := int(info.Channels) * 2
+	data, err := io.ReadAll(io.LimitReader(pcm, int64(alignSearchSeconds*float64(info.SampleRate))*int64(frameBytes)))
+	if err != nil {
+		return 0, 0, err
+	}
+	frames := len(data) / frameBytes
+	hop := max(int(float64(info.SampleRate)*alignHopSeconds), 1)
+	if frames < hop*10 {
+		return 0, 0, fmt.Errorf("too short to align")
+	}
+
+	// The loudest channel of each frame
+	level := func(frame int) float64 {
+		loudest := 0.0
+		for c := 0; c < int(info.Channels); c++ {
+			i := frame*frameBytes + c*2
+			loudest = max(loudest, math.Abs(float64(int16(uint16(data[i])|uint16(data[i+1])<<8))))
+		}
+		return loudest
+	}
+	envelope := make([]float64, frames/hop)
+	for k := range envelope {
+		for frame := k * hop; frame < (k+1)*hop; frame++ {
+			envelope[k] = max(envelope[k], level(frame))
+		}
+	}
+
+	peak := slices.Index(envelope, slices.Max(envelope))
+	sorted := slices.Clone(envelope)
+	slices.Sort(sorted)
+	median := max(sorted[len(sorted)/2], 1)
+	contrast := 20 * math.Log10(envelope[peak]/median)
+
+	threshold := envelope[peak] / 2
+	start := peak
+	for start > 0 && envelope[start-1] >= threshold {
+		start--
+	}
+	// Refine to the first sample over the threshold
+	event := start * hop
+	for frame := max(start-1, 0) * hop; frame < (start+1)*hop; frame++ {
+		if level(frame) >= threshold {
+			event = frame
+			break
+		}
+	}
+	return float64(event) / float64(info.SampleRate), contrast, nil
+}
+
+// runAlign finds a shared sync event in every track of a session, records
+// how much to trim from each so it lines up, and with trim set, writes
+// trimmed copies as <track>_aligned.wav.
+func runAlign(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	share := 1 / float64(len(tracks))
+	if job.Trim {
+		share /= 2
+	}
+
+	alignment := &Alignment{At: time.Now().UTC()}
+	for i, track := range tracks {
+		f, info, pcm, err := openTrackPCM(ctx, track.File, progress, float64(i)*share, share)
+		if err != nil {
+			return nil, err
+		}
+		event, contrast, err := findSyncEvent(pcm, info)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", track.File, err)
+		}
+		if contrast < alignMinContrastDB {
+			return nil, fmt.Errorf("no clear sync event in the first %d seconds of %s", alignSearchSeconds, track.File)
+		}
+		alignment.Tracks = append(alignment.Tracks, TrackAlignment{
+			File:         track.File,
+			Device:       track.Device,
+			EventSeconds: roundMillis(event),
+			ContrastDB:   math.Round(contrast*10) / 10,
+		})
+	}
+	lead := slices.MinFunc(alignment.Tracks, func(a, b TrackAlignment) int { return cmp.Compare(a.EventSeconds, b.EventSeconds) }).EventSeconds
+	reference := tracks[0].StartOffset + alignment.Tracks[0].EventSeconds
+	for i := range alignment.Tracks {
+		a := &alignment.Tracks[i]
+		a.TrimSeconds = roundMillis(a.EventSeconds - lead)
+		a.OffsetSeconds = roundMillis(tracks[i].StartOffset + a.EventSeconds - reference)
+	}
+
+	var exports []ExportFile
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	fail := func(err error) ([]string, error) {
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	if job.Trim {
+		for i, track := range tracks {
+			f, info, pcm, err := openTrackPCM(ctx, track.File, progress, 0.5+float64(i)*share, share)
+			if err != nil {
+				return fail(err)
+			}
+			skip := int64(alignment.Tracks[i].TrimSeconds*float64(info.SampleRate)) * int64(info.Channels) * 2
+			if _, err := io.CopyN(io.Discard, pcm, skip); err != nil {
+				f.Close()
+				return fail(err)
+			}
+			name, release, err := writeGainedTrack(trackBase(track)+"_aligned", info, pcm, 1)
+			f.Close()
+			if err != nil {
+				return fail(err)
+			}
+			releases = append(releases, release)
+			exports = append(exports, ExportFile{Kind: jobAlign, File: name, Device: track.Device, Source: track.File})
+			alignment.Tracks[i].Aligned = name
+		}
+	}
+
+	err = updateManifest(job.SessionID, func(m *SessionManifest) {
+		m.Alignment = alignment
+		m.Exports = append(m.Exports, exports...)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	var names []string
+	for _, a := range alignment.Tracks {
+		fmt.Printf("✓ %s: sync event at %.3fs, trim %.3fs\n", a.File, a.EventSeconds, a.TrimSeconds)
+		if a.Aligned != "" {
+			names = append(names, a.Aligned)
+		}
+	}
+	return names, nil
+}
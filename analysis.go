@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// TrackLevels is the level analysis of one track of a finished session
+type TrackLevels struct {
+	File   string `json:"file"`
+	Device string `json:"device"`
+	Format string `json:"format,omitempty"`
+	*LevelAnalysis
+	Error string `json:"error,omitempty"`
+}
+
+// Handler: GET /api/sessions/{id}/levels - Measure peak, RMS and noise floor
+// of every track of a finished session, whatever format it's stored in
+func handleSessionLevels(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+
+	result := []TrackLevels{}
+	for _, track := range manifest.Tracks {
+		levels := TrackLevels{File: track.File, Device: track.Device}
+		analysis, format, err := analyzeTrack(track.File)
+		if err != nil {
+			levels.Error = err.Error()
+		} else {
+			levels.LevelAnalysis, levels.Format = &analysis, format
+		}
+		result = append(result, levels)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"tracks":    result,
+	})
+}
+
+// analyzeTrack decodes a recording and measures its levels.
+func analyzeTrack(name string) (LevelAnalysis, string, error) {
+	f, err := openRecording(name)
+	if err != nil {
+		return LevelAnalysis{}, "", err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return LevelAnalysis{}, "", err
+	}
+	levels, err := measureLevels(f, info)
+	return levels, info.Format, err
+}
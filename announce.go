@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+const (
+	// announceQueueLength is how many announcements may wait to be spoken.
+	// Any more are dropped, since they'd be stale by the time they're heard.
+	announceQueueLength = 8
+
+	// ttsTimeout bounds rendering one announcement.
+	ttsTimeout = 20 * time.Second
+
+	// diskCheckInterval is how often free disk space is checked while
+	// recording.
+	diskCheckInterval = 30 * time.Second
+)
+
+// Announcement settings, set from flags in web mode.
+var (
+	announceEnabled bool
+
+	// ttsCommand renders speech to a WAV file, with {file} and {text}
+	// replaced in its arguments. Detected for the platform if empty.
+	ttsCommand string
+
+	// diskAlertMB raises an alert when free space in the output directory
+	// drops below it while recording. 0 disables it.
+	diskAlertMB = 1024
+)
+
+// remainingWarnings are announced before a scheduled recording ends.
+var remainingWarnings = []time.Duration{5 * time.Minute, time.Minute}
+
+// announcements queues text for the announcer to speak.
+var announcements = make(chan string, announceQueueLength)
+
+// AnnounceRequest is the request body for a custom announcement
+type AnnounceRequest struct {
+	Text string `json:"text"`
+}
+
+// announce queues text to be spoken, if announcements are on. It never
+// blocks.
+func announce(text string) {
+	if !announceEnabled {
+		return
+	}
+	select {
+	case announcements <- text:
+	default:
+	}
+}
+
+// runAnnouncer speaks queued announcements one at a time through the default
+// output device, the one monitoring plays through, for the life of the
+// process.
+func runAnnouncer() {
+	for text := range announcements {
+		if err := speak(text); err != nil {
+			fmt.Printf("⚠️  Failed to announce %q: %v\n", text, err)
+		}
+	}
+}
+
+// watchForAnnouncements announces sessions starting and stopping, alerts,
+// and scheduled recordings about to end, for the life of the process.
+func watchForAnnouncements() {
+	lastAlert := latestAlertID()
+	sessionID := ""
+	warned := map[time.Duration]bool{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		recordingMutex.Lock()
+		current := ""
+		if activeSession != nil {
+			current = activeSession.id
+		}
+		changed := sessionChanged
+		recordingMutex.Unlock()
+
+		if current != sessionID {
+			switch {
+			case sessionID == "":
+				announce(tr(uiLang, "Recording started"))
+			case current == "":
+				announce(tr(uiLang, "Recording stopped"))
+			default:
+				announce(tr(uiLang, "New recording started"))
+			}
+			sessionID = current
+			clear(warned)
+		}
+
+		if end, ok := scheduledEnd(current); ok {
+			remaining := time.Until(end)
+			for _, warning := range remainingWarnings {
+				if remaining <= warning && remaining > warning-10*time.Second && !warned[warning] {
+					warned[warning] = true
+					announce(spokenRemaining(warning))
+				}
+			}
+		}
+
+		pending, alerted := alertsSince(lastAlert)
+		for _, a := range pending {
+			if a.Event != "escalated" {
+				announce(spokenAlert(a))
+			}
+			lastAlert = a.ID
+		}
+
+		select {
+		case <-changed:
+		case <-alerted:
+		case <-ticker.C:
+		}
+	}
+}
+
+// spokenAlert puts an alert into a few words worth hearing.
+func spokenAlert(a Alert) string {
+	if a.Event == "resolved" {
+		return a.Message
+	}
+	switch a.Type {
+	case "disk":
+		return tr(uiLang, "Warning: disk space low")
+	case "clipping":
+		return trf(uiLang, "Clipping on %s", a.Device)
+	case "stall":
+		return trf(uiLang, "%s stopped delivering audio", a.Device)
+	}
+	return a.Message
+}
+
+// spokenRemaining says how many whole minutes are left.
+func spokenRemaining(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes == 1 {
+		return tr(uiLang, "One minute remaining")
+	}
+	return trf(uiLang, "%d minutes remaining", minutes)
+}
+
+// watchDiskSpace raises an alert when the output directory runs low on space
+// during a recording, once per session, and resolves it once there's space
+// again.
+func watchDiskSpace() {
+	alerted := ""
+	for range time.Tick(diskCheckInterval) {
+		recordingMutex.Lock()
+		sessionID := ""
+		if activeSession != nil {
+			sessionID = activeSession.id
+		}
+		recordingMutex.Unlock()
+
+		free, err := diskFree(outputDirectory)
+		if err != nil {
+			continue
+		}
+		if free >= uint64(diskAlertMB)<<20 {
+			resolveAlert("disk", fmt.Sprintf("%d MB of disk space free for recordings again", free>>20))
+			continue
+		}
+		if sessionID == "" || sessionID == alerted {
+			continue
+		}
+		alerted = sessionID
+		raiseAlert(Alert{
+			Type:    "disk",
+			Message: fmt.Sprintf("Only %d MB of disk space left for recordings in %s", free>>20, outputDirectory),
+		})
+	}
+}
+
+// ttsArgs is the command line that renders text to a WAV file.
+func ttsArgs(file, text string) ([]string, error) {
+	template := ttsCommand
+	if template == "" {
+		switch runtime.GOOS {
+		case "darwin":
+			template = "say --file-format=WAVE --data-format=LEI16@22050 -o {file} {text}"
+		case "windows":
+			// The text is passed in the environment, so it can't be read as
+			// PowerShell
+			return []string{"powershell", "-NoProfile", "-Command",
+				"Add-Type -AssemblyName System.Speech; $s = New-Object System.Speech.Synthesis.SpeechSynthesizer; " +
+					"$s.SetOutputToWaveFile('" + strings.ReplaceAll(file, "'", "''") + "'); $s.Speak($env:SKRIBBL_TTS_TEXT); $s.Dispose()"}, nil
+		default:
+			for _, engine := range []string{"espeak-ng", "espeak"} {
+				if _, err := exec.LookPath(engine); err == nil {
+					template = engine + " -w {file} {text}"
+					break
+				}
+			}
+			if template == "" {
+				return nil, fmt.Errorf("no text-to-speech engine found; install espeak-ng or set -tts-command")
+			}
+		}
+	}
+
+	var args []string
+	for _, arg := range strings.Fields(template) {
+		arg = strings.ReplaceAll(arg, "{file}", file)
+		args = append(args, strings.ReplaceAll(arg, "{text}", text))
+	}
+	return args, nil
+}
+
+// speak renders text to speech and plays it, returning once it's been heard.
+func speak(text string) error {
+	tmp, err := os.CreateTemp("", "skribbl-announce-*.wav")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args, err := ttsArgs(tmp.Name(), text)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ttsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "SKRIBBL_TTS_TEXT="+text)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return fmt.Errorf("unreadable speech from %s: %w", args[0], err)
+	}
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return err
+	}
+	samples, err := io.ReadAll(pcm)
+	if err != nil {
+		return err
+	}
+	return playPCM(samples, info.SampleRate, info.Channels)
+}
+
+// announcementsPlaying counts announcements playing, which keep the malgo
+// context in use like a session does. Guarded by recordingMutex.
+var announcementsPlaying int
+
+// playPCM plays 16-bit PCM through the default output device and waits for
+// it to finish.
+func playPCM(samples []byte, sampleRate, channels uint32) error {
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = channels
+	deviceConfig.SampleRate = sampleRate
+
+	var pos atomic.Int64
+	done := make(chan struct{})
+	var finished atomic.Bool
+	onData := func(pOutput, pInput []byte, framecount uint32) {
+		start := int(pos.Load())
+		n := copy(pOutput, samples[min(start, len(samples)):])
+		clear(pOutput[n:])
+		pos.Add(int64(n))
+		if start+n >= len(samples) && finished.CompareAndSwap(false, true) {
+			close(done)
+		}
+	}
+
+	// The context is swapped out when devices are refreshed, unless an
+	// announcement is counted as playing
+	recordingMutex.Lock()
+	device, err := malgo.InitDevice(malgoContext.Context, deviceConfig, malgo.DeviceCallbacks{Data: onData})
+	if err == nil {
+		announcementsPlaying++
+	}
+	recordingMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to initialize output: %v", err)
+	}
+	defer func() {
+		device.Uninit()
+		recordingMutex.Lock()
+		announcementsPlaying--
+		recordingMutex.Unlock()
+	}()
+	if err := device.Start(); err != nil {
+		return fmt.Errorf("failed to start output: %v", err)
+	}
+
+	length := time.Duration(float64(len(samples)) / float64(sampleRate*channels*2) * float64(time.Second))
+	select {
+	case <-done:
+		// Let the device play out its last buffer
+		time.Sleep(100 * time.Millisecond)
+	case <-time.After(length + 2*time.Second):
+	}
+	return nil
+}
+
+// Handler: POST /api/announce - Speak a custom announcement through the
+// output device
+func handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	var req AnnounceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Text is required")
+		return
+	}
+	if !announceEnabled {
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Announcements are off; start the server with -announce")
+		return
+	}
+	announce(req.Text)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "queued"})
+}
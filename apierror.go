@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"syscall"
+)
+
+// Machine-readable error codes returned in the "code" field of API errors.
+const (
+	errCodeInvalidRequest   = "INVALID_REQUEST"
+	errCodeBodyTooLarge     = "BODY_TOO_LARGE"
+	errCodeURITooLong       = "URI_TOO_LONG"
+	errCodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	errCodeRateLimited      = "RATE_LIMITED"
+	errCodeNotFound         = "NOT_FOUND"
+	errCodeAlreadyRecording = "ALREADY_RECORDING"
+	errCodeNotRecording     = "NOT_RECORDING"
+	errCodeNoDevices        = "NO_DEVICES_SELECTED"
+	errCodeDeviceNotFound   = "DEVICE_NOT_FOUND"
+	errCodeDeviceError      = "DEVICE_ERROR"
+	errCodeDiskFull         = "DISK_FULL"
+	errCodeHookFailed       = "HOOK_FAILED"
+	errCodeLevelCheckFailed = "LEVEL_CHECK_FAILED"
+	errCodeReadOnly         = "READ_ONLY"
+	errCodeUnauthorized     = "UNAUTHORIZED"
+	errCodeForbidden        = "FORBIDDEN"
+	errCodeConflict         = "CONFLICT"
+	errCodeInternal         = "INTERNAL"
+
+	errCodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	errCodeIdempotencyKeyInUse  = "IDEMPOTENCY_KEY_IN_USE"
+)
+
+// Windows reports a full disk with its own error numbers rather than ENOSPC.
+const (
+	windowsErrorHandleDiskFull = 39
+	windowsErrorDiskFull       = 112
+)
+
+// APIError is the body of every error response
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse wraps APIError as {"error": {...}}
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeError sends a JSON error envelope with the given status and code, with
+// the message in the response's language if it's been translated.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	message = tr(responseLang(w), message)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// writeDecodeError reports a request body that couldn't be decoded.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body too large")
+		return
+	}
+	writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+}
+
+// writeStorageError reports a failure writing recordings, distinguishing a
+// full disk from other errors.
+func writeStorageError(w http.ResponseWriter, code string, err error) {
+	if isDiskFull(err) {
+		writeError(w, http.StatusInsufficientStorage, errCodeDiskFull, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, code, err.Error())
+}
+
+// isDiskFull reports whether err was caused by running out of disk space.
+func isDiskFull(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errno == windowsErrorDiskFull || errno == windowsErrorHandleDiskFull
+	}
+	return errno == syscall.ENOSPC
+}
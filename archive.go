@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveEntry is one file in a session archive
+type archiveEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	data    io.ReaderAt
+}
+
+// archiveSums caches the SHA-256 of files that aren't tracks, whose sums
+// are in the manifest, so an archive that's resumed doesn't hash them again.
+var archiveSums = struct {
+	sync.Mutex
+	sums map[string]archiveSum
+}{sums: map[string]archiveSum{}}
+
+type archiveSum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// sessionArchive lays a session's files out as an uncompressed tar, under a
+// directory named after the session, with a SHA256SUMS file last. Nothing in
+// it depends on when it's asked for, so the same bytes come back every time
+// and a download can be resumed with a Range request. It returns the archive,
+// an ETag naming its contents, and its latest modification time. The caller
+// closes the files.
+func sessionArchive(manifest *SessionManifest) (archive *io.SectionReader, etag string, modTime time.Time, files []*os.File, err error) {
+	defer func() {
+		if err != nil {
+			closeFiles(files)
+		}
+	}()
+
+	names := []string{manifest.ID + ".json"}
+	seen := map[string]bool{names[0]: true}
+	for _, name := range manifest.files() {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	trackSums := map[string]TrackInfo{}
+	for _, track := range manifest.Tracks {
+		trackSums[track.File] = track
+	}
+
+	var entries []archiveEntry
+	var sums strings.Builder
+	for _, name := range names {
+		f, err := openRecording(name)
+		if err != nil {
+			return nil, "", time.Time{}, files, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		files = append(files, f)
+		info, err := f.Stat()
+		if err != nil {
+			return nil, "", time.Time{}, files, err
+		}
+		sum, err := archiveFileSum(name, f, info, trackSums[name])
+		if err != nil {
+			return nil, "", time.Time{}, files, fmt.Errorf("failed to checksum %s: %w", name, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+		entries = append(entries, archiveEntry{name: name, size: info.Size(), modTime: info.ModTime(), data: f})
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	sumsData := []byte(sums.String())
+	entries = append(entries, archiveEntry{name: "SHA256SUMS", size: int64(len(sumsData)), modTime: modTime, data: bytes.NewReader(sumsData)})
+
+	// The tag covers every header, which carry each file's size and time,
+	// and the sums, which cover their contents
+	tag := sha256.New()
+	var parts multiReaderAt
+	for _, entry := range entries {
+		var header bytes.Buffer
+		tw := tar.NewWriter(&header)
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     manifest.ID + "/" + entry.name,
+			Mode:     0644,
+			Size:     entry.size,
+			ModTime:  entry.modTime.Truncate(time.Second),
+		})
+		if err != nil {
+			return nil, "", time.Time{}, files, err
+		}
+		tag.Write(header.Bytes())
+		parts.add(bytes.NewReader(header.Bytes()), int64(header.Len()))
+		parts.add(entry.data, entry.size)
+		if pad := (512 - entry.size%512) % 512; pad > 0 {
+			parts.add(bytes.NewReader(make([]byte, pad)), pad)
+		}
+	}
+	tag.Write(sumsData)
+	parts.add(bytes.NewReader(make([]byte, 1024)), 1024)
+
+	etag = `"` + hex.EncodeToString(tag.Sum(nil)[:16]) + `"`
+	return io.NewSectionReader(&parts, 0, parts.size), etag, modTime, files, nil
+}
+
+// archiveFileSum returns a file's SHA-256: the manifest's for a track that
+// hasn't changed since, otherwise from the cache or by reading it.
+func archiveFileSum(name string, f io.ReaderAt, info os.FileInfo, track TrackInfo) (string, error) {
+	if track.SHA256 != "" && track.Size == info.Size() {
+		return track.SHA256, nil
+	}
+	archiveSums.Lock()
+	cached, ok := archiveSums.sums[name]
+	archiveSums.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	archiveSums.Lock()
+	archiveSums.sums[name] = archiveSum{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	archiveSums.Unlock()
+	return sum, nil
+}
+
+// multiReaderAt reads a series of parts as if they were one file.
+type multiReaderAt struct {
+	parts  []io.ReaderAt
+	starts []int64
+	size   int64
+}
+
+func (m *multiReaderAt) add(r io.ReaderAt, size int64) {
+	m.parts = append(m.parts, r)
+	m.starts = append(m.starts, m.size)
+	m.size += size
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for i, part := range m.parts {
+		end := m.size
+		if i+1 < len(m.starts) {
+			end = m.starts[i+1]
+		}
+		if off >= end || len(p) == 0 {
+			continue
+		}
+		want := min(int64(len(p)), end-off)
+		read, err := part.ReadAt(p[:want], off-m.starts[i])
+		n += read
+		off += int64(read)
+		p = p[read:]
+		if int64(read) < want {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// closeFiles closes the files an archive reads from.
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Handler: GET /api/sessions/{id}/archive - Download a session's files as
+// a tar, with a SHA256SUMS file to check them against. Supports Range and
+// If-Range, so an interrupted download picks up where it stopped.
+func handleSessionArchive(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	if u := requestUser(r); u.limited() {
+		if files, ok := grantedTo(u.Name)[id]; !ok || files != nil {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "You can only download your own tracks and what's shared with you")
+			return
+		}
+	}
+	recordingMutex.Lock()
+	recording := activeSession != nil && activeSession.id == id
+	recordingMutex.Unlock()
+	if recording {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Session is still recording")
+		return
+	}
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	serveSessionArchive(w, r, manifest)
+}
+
+// serveSessionArchive serves a session's files as a tar archive.
+func serveSessionArchive(w http.ResponseWriter, r *http.Request, manifest *SessionManifest) {
+	id := manifest.ID
+	archive, etag, modTime, files, err := sessionArchive(manifest)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	defer closeFiles(files)
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.tar"`)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, id+".tar", modTime, archive)
+}
@@ -0,0 +1,16 @@
+//go:build !windows || !amd64 || !asio
+
+package main
+
+import "fmt"
+
+// listASIOSources lists nothing: ASIO needs a 64-bit Windows build with
+// -tags asio.
+func listASIOSources() []selectableDevice {
+	return nil
+}
+
+// recordASIO isn't available without ASIO support built in.
+func (c *captureDevice) recordASIO() error {
+	return fmt.Errorf("ASIO needs a 64-bit Windows build with -tags asio")
+}
@@ -0,0 +1,412 @@
+//go:build windows && amd64 && asio
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// ASIO drivers are COM objects, registered under HKLM\SOFTWARE\ASIO, with
+// the IASIO interface from Steinberg's SDK. On 64-bit Windows its methods
+// use the usual calling convention, with doubles passed in the registers
+// syscall also fills, so they can be called without the SDK or cgo.
+
+// Vtable slots of IASIO's methods, after IUnknown's three
+const (
+	asioInit           = 3
+	asioGetErrorMsg    = 6
+	asioStart          = 7
+	asioStop           = 8
+	asioGetChannels    = 9
+	asioGetBufferSize  = 11
+	asioCanSampleRate  = 12
+	asioGetSampleRate  = 13
+	asioSetSampleRate  = 14
+	asioGetChannelInfo = 18
+	asioCreateBuffers  = 19
+	asioDisposeBuffers = 20
+)
+
+// ASIO's sample types this records from, all little-endian.
+const (
+	asioSTInt16LSB   = 16
+	asioSTInt24LSB   = 17
+	asioSTInt32LSB   = 18
+	asioSTFloat32LSB = 19
+)
+
+// asioMessage selectors the host answers.
+const (
+	asioSelectorSupported = 1
+	asioEngineVersion     = 2
+	asioResetRequest      = 3
+	asioResyncRequest     = 5
+	asioLatenciesChanged  = 6
+)
+
+const (
+	asioOK   = 0
+	asioTrue = 1
+
+	clsctxInprocServer    = 0x1
+	coinitApartmentThread = 0x2
+)
+
+var (
+	procCLSIDFromString  = ole32.NewProc("CLSIDFromString")
+	procGetDesktopWindow = syscall.NewLazyDLL("user32.dll").NewProc("GetDesktopWindow")
+)
+
+// asioChannelInfo is the SDK's ASIOChannelInfo.
+type asioChannelInfo struct {
+	channel      int32
+	isInput      int32
+	isActive     int32
+	channelGroup int32
+	sampleType   int32
+	name         [32]byte
+}
+
+// asioBufferInfo is the SDK's ASIOBufferInfo: the driver fills in the two
+// halves of a channel's double buffer.
+type asioBufferInfo struct {
+	isInput    int32
+	channelNum int32
+	buffers    [2]unsafe.Pointer
+}
+
+// asioCallbacks is the SDK's ASIOCallbacks. The driver keeps a pointer to
+// it, so there's just the one, and it never moves.
+var asioCallbacks = struct {
+	bufferSwitch         uintptr
+	sampleRateDidChange  uintptr
+	asioMessage          uintptr
+	bufferSwitchTimeInfo uintptr
+}{
+	bufferSwitch:         syscall.NewCallback(asioBufferSwitch),
+	sampleRateDidChange:  syscall.NewCallback(asioSampleRateDidChange),
+	asioMessage:          syscall.NewCallback(asioMessageCallback),
+	bufferSwitchTimeInfo: syscall.NewCallback(asioBufferSwitchTimeInfo),
+}
+
+// asioInUse is set while a driver is loaded. ASIO's callbacks don't say
+// which driver they're for, and drivers expect one host each, so only one
+// ASIO source records at a time.
+var asioInUse struct {
+	sync.Mutex
+	driver string
+}
+
+// asioRecording is the stream the callbacks deliver to, while one runs.
+var asioRecording atomic.Pointer[asioStream]
+
+// asioStream is a started driver's input buffers and where their audio goes.
+type asioStream struct {
+	cap        *captureDevice
+	buffers    []asioBufferInfo
+	frames     int
+	sampleType int32
+	mix        bool   // average every input to mono, rather than interleave them
+	pcm        []byte // 16-bit audio for onData, reused
+}
+
+// listASIOSources lists the ASIO drivers installed, as sources of their own.
+func listASIOSources() []selectableDevice {
+	var key syscall.Handle
+	if syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(`SOFTWARE\ASIO`), 0, syscall.KEY_READ, &key) != nil {
+		return nil
+	}
+	defer syscall.RegCloseKey(key)
+
+	var drivers []selectableDevice
+	for i := uint32(0); ; i++ {
+		name := make([]uint16, 256)
+		n := uint32(len(name))
+		if syscall.RegEnumKeyEx(key, i, &name[0], &n, nil, nil, nil, nil) != nil {
+			break
+		}
+		drivers = append(drivers, selectableDevice{asio: syscall.UTF16ToString(name[:n])})
+	}
+	return drivers
+}
+
+// asioCLSID looks up the COM class of an installed ASIO driver.
+func asioCLSID(driver string) (syscall.GUID, error) {
+	var clsid syscall.GUID
+	var key syscall.Handle
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, syscall.StringToUTF16Ptr(`SOFTWARE\ASIO\`+driver), 0, syscall.KEY_READ, &key); err != nil {
+		return clsid, fmt.Errorf("ASIO driver %q isn't installed", driver)
+	}
+	defer syscall.RegCloseKey(key)
+
+	value := make([]uint16, 64)
+	size := uint32(len(value) * 2)
+	if err := syscall.RegQueryValueEx(key, syscall.StringToUTF16Ptr("CLSID"), nil, nil, (*byte)(unsafe.Pointer(&value[0])), &size); err != nil {
+		return clsid, fmt.Errorf("ASIO driver %q has no CLSID: %v", driver, err)
+	}
+	if hr, _, _ := procCLSIDFromString.Call(uintptr(unsafe.Pointer(&value[0])), uintptr(unsafe.Pointer(&clsid))); int32(hr) < 0 {
+		return clsid, fmt.Errorf("ASIO driver %q has an invalid CLSID", driver)
+	}
+	return clsid, nil
+}
+
+// asioErrorMessage is the driver's description of what went wrong last.
+func asioErrorMessage(driver *comObject) string {
+	var msg [124]byte
+	syscall.SyscallN(driver.vtbl[asioGetErrorMsg], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&msg[0])))
+	return strings.TrimRight(string(msg[:]), "\x00")
+}
+
+// recordASIO records the capture's ASIO driver: the inputs picked in its
+// settings, or a mono mix of every input. COM wants the driver called from
+// the thread that loaded it, so a locked goroutine holds it until the
+// capture stops.
+func (c *captureDevice) recordASIO() error {
+	ready := make(chan error)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		defer close(done)
+
+		asioInUse.Lock()
+		if asioInUse.driver != "" {
+			driver := asioInUse.driver
+			asioInUse.Unlock()
+			ready <- fmt.Errorf("ASIO driver %s is already recording; ASIO records one driver at a time", driver)
+			return
+		}
+		asioInUse.driver = c.source.asio
+		asioInUse.Unlock()
+		defer func() {
+			asioInUse.Lock()
+			asioInUse.driver = ""
+			asioInUse.Unlock()
+		}()
+
+		hr, _, _ := procCoInitializeEx.Call(0, coinitApartmentThread)
+		if int32(hr) >= 0 {
+			defer procCoUninitialize.Call()
+		} else if uint32(hr) != rpcEChangedMode {
+			ready <- fmt.Errorf("failed to initialize COM: HRESULT 0x%08X", uint32(hr))
+			return
+		}
+
+		driver, err := loadASIODriver(c.source.asio)
+		if err != nil {
+			ready <- err
+			return
+		}
+		defer driver.release()
+
+		if err := c.startASIO(driver); err != nil {
+			ready <- err
+			return
+		}
+		ready <- nil
+		<-stop
+
+		// Once stop returns the driver calls back no more
+		syscall.SyscallN(driver.vtbl[asioStop], uintptr(unsafe.Pointer(driver)))
+		syscall.SyscallN(driver.vtbl[asioDisposeBuffers], uintptr(unsafe.Pointer(driver)))
+		asioRecording.Store(nil)
+	}()
+
+	if err := <-ready; err != nil {
+		<-done
+		return err
+	}
+	c.stopSource = func() {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+// loadASIODriver creates an installed driver's COM object and initializes it.
+func loadASIODriver(name string) (*comObject, error) {
+	clsid, err := asioCLSID(name)
+	if err != nil {
+		return nil, err
+	}
+	// ASIO drivers take their class ID as the interface ID too
+	var driver *comObject
+	hr, _, _ := procCoCreateInstance.Call(
+		uintptr(unsafe.Pointer(&clsid)), 0, clsctxInprocServer,
+		uintptr(unsafe.Pointer(&clsid)), uintptr(unsafe.Pointer(&driver)))
+	if int32(hr) < 0 {
+		return nil, fmt.Errorf("failed to load ASIO driver %s: HRESULT 0x%08X", name, uint32(hr))
+	}
+	window, _, _ := procGetDesktopWindow.Call()
+	if ok, _, _ := syscall.SyscallN(driver.vtbl[asioInit], uintptr(unsafe.Pointer(driver)), window); int32(ok) != asioTrue {
+		err := fmt.Errorf("ASIO driver %s failed to start: %s", name, asioErrorMessage(driver))
+		driver.release()
+		return nil, err
+	}
+	return driver, nil
+}
+
+// startASIO sets a loaded driver to the capture's sample rate, creates
+// buffers for the inputs it records and starts it.
+func (c *captureDevice) startASIO(driver *comObject) error {
+	var inputs, outputs int32
+	if r, _, _ := syscall.SyscallN(driver.vtbl[asioGetChannels], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&inputs)), uintptr(unsafe.Pointer(&outputs))); int32(r) != asioOK {
+		return fmt.Errorf("failed to count inputs: %s", asioErrorMessage(driver))
+	}
+	mix := c.pick == nil
+	channels := int(c.inputChannels)
+	if mix {
+		channels = int(inputs)
+	}
+	if channels == 0 || channels > int(inputs) {
+		return fmt.Errorf("%s has %d inputs, not %d", c.source.asio, inputs, channels)
+	}
+
+	rate := float64(c.sampleRate)
+	if r, _, _ := syscall.SyscallN(driver.vtbl[asioCanSampleRate], uintptr(unsafe.Pointer(driver)), uintptr(math.Float64bits(rate))); int32(r) != asioOK {
+		return fmt.Errorf("%s can't record at %d Hz", c.source.asio, c.sampleRate)
+	}
+	var current float64
+	syscall.SyscallN(driver.vtbl[asioGetSampleRate], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&current)))
+	if current != rate {
+		if r, _, _ := syscall.SyscallN(driver.vtbl[asioSetSampleRate], uintptr(unsafe.Pointer(driver)), uintptr(math.Float64bits(rate))); int32(r) != asioOK {
+			return fmt.Errorf("failed to set %s to %d Hz: %s", c.source.asio, c.sampleRate, asioErrorMessage(driver))
+		}
+	}
+
+	var minSize, maxSize, preferred, granularity int32
+	if r, _, _ := syscall.SyscallN(driver.vtbl[asioGetBufferSize], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&minSize)), uintptr(unsafe.Pointer(&maxSize)), uintptr(unsafe.Pointer(&preferred)), uintptr(unsafe.Pointer(&granularity))); int32(r) != asioOK {
+		return fmt.Errorf("failed to get the buffer size: %s", asioErrorMessage(driver))
+	}
+
+	stream := &asioStream{cap: c, frames: int(preferred), mix: mix}
+	for ch := 0; ch < channels; ch++ {
+		info := asioChannelInfo{channel: int32(ch), isInput: asioTrue}
+		if r, _, _ := syscall.SyscallN(driver.vtbl[asioGetChannelInfo], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&info))); int32(r) != asioOK {
+			return fmt.Errorf("failed to get input %d: %s", ch+1, asioErrorMessage(driver))
+		}
+		switch info.sampleType {
+		case asioSTInt16LSB, asioSTInt24LSB, asioSTInt32LSB, asioSTFloat32LSB:
+		default:
+			return fmt.Errorf("%s delivers samples of ASIO type %d, which isn't supported", c.source.asio, info.sampleType)
+		}
+		if ch > 0 && info.sampleType != stream.sampleType {
+			return fmt.Errorf("%s delivers its inputs in different formats", c.source.asio)
+		}
+		stream.sampleType = info.sampleType
+		stream.buffers = append(stream.buffers, asioBufferInfo{isInput: asioTrue, channelNum: int32(ch)})
+	}
+	outChannels := channels
+	if mix {
+		outChannels = 1
+	}
+	stream.pcm = make([]byte, stream.frames*outChannels*2)
+
+	asioRecording.Store(stream)
+	if r, _, _ := syscall.SyscallN(driver.vtbl[asioCreateBuffers], uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(&stream.buffers[0])), uintptr(channels), uintptr(preferred), uintptr(unsafe.Pointer(&asioCallbacks))); int32(r) != asioOK {
+		asioRecording.Store(nil)
+		return fmt.Errorf("failed to create buffers: %s", asioErrorMessage(driver))
+	}
+	if r, _, _ := syscall.SyscallN(driver.vtbl[asioStart], uintptr(unsafe.Pointer(driver))); int32(r) != asioOK {
+		syscall.SyscallN(driver.vtbl[asioDisposeBuffers], uintptr(unsafe.Pointer(driver)))
+		asioRecording.Store(nil)
+		return fmt.Errorf("failed to start %s: %s", c.source.asio, asioErrorMessage(driver))
+	}
+	return nil
+}
+
+// asioBufferSwitch is called on the driver's thread when half of each input's
+// buffer is full. Like onData, which it hands the audio to, it must not
+// allocate.
+func asioBufferSwitch(index, processNow uintptr) uintptr {
+	if stream := asioRecording.Load(); stream != nil {
+		stream.deliver(int(int32(index)) & 1)
+	}
+	return 0
+}
+
+// asioBufferSwitchTimeInfo is bufferSwitch with timing, which drivers use
+// instead when the host says it understands it. It returns the timing it
+// was given.
+func asioBufferSwitchTimeInfo(params, index, processNow uintptr) uintptr {
+	asioBufferSwitch(index, processNow)
+	return params
+}
+
+// asioSampleRateDidChange warns that the driver's rate changed under the
+// recording, usually from its external clock. The new rate is passed in a
+// floating point register, which callbacks can't read.
+func asioSampleRateDidChange(rate uintptr) uintptr {
+	if stream := asioRecording.Load(); stream != nil {
+		fmt.Printf("⚠️  %s changed its sample rate while recording\n", stream.cap.name)
+	}
+	return 0
+}
+
+// asioMessageCallback answers the driver's questions about the host. Resets
+// and resyncs are acknowledged; if the driver stops delivering audio after
+// one, the watchdog reloads it.
+func asioMessageCallback(selector, value, message, opt uintptr) uintptr {
+	switch int32(selector) {
+	case asioSelectorSupported:
+		switch int32(value) {
+		case asioEngineVersion, asioResetRequest, asioResyncRequest, asioLatenciesChanged:
+			return 1
+		}
+	case asioEngineVersion:
+		return 2
+	case asioResetRequest, asioResyncRequest, asioLatenciesChanged:
+		return 1
+	}
+	return 0
+}
+
+// deliver converts one half of the inputs' buffers to interleaved 16-bit
+// audio, or a mono mix, and hands it to the capture.
+func (s *asioStream) deliver(half int) {
+	for f := 0; f < s.frames; f++ {
+		if s.mix {
+			sum := 0
+			for _, b := range s.buffers {
+				sum += s.sample(b.buffers[half], f)
+			}
+			putSample16(s.pcm[f*2:], sum/len(s.buffers))
+			continue
+		}
+		for ch, b := range s.buffers {
+			putSample16(s.pcm[(f*len(s.buffers)+ch)*2:], s.sample(b.buffers[half], f))
+		}
+	}
+	s.cap.onData(nil, s.pcm, uint32(s.frames))
+}
+
+// sample reads frame i of an input's buffer as a 16-bit sample.
+func (s *asioStream) sample(buf unsafe.Pointer, i int) int {
+	switch s.sampleType {
+	case asioSTInt16LSB:
+		return int(*(*int16)(unsafe.Add(buf, i*2)))
+	case asioSTInt24LSB:
+		p := (*[3]byte)(unsafe.Add(buf, i*3))
+		return int(int16(uint16(p[1]) | uint16(p[2])<<8))
+	case asioSTInt32LSB:
+		return int(*(*int32)(unsafe.Add(buf, i*4)) >> 16)
+	case asioSTFloat32LSB:
+		v := float64(*(*float32)(unsafe.Add(buf, i*4)))
+		return int(math.Max(-32768, math.Min(32767, v*32767)))
+	}
+	return 0
+}
+
+// putSample16 writes a 16-bit little-endian sample.
+func putSample16(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WAV format tags the probe understands.
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// AudioInfo describes an audio file on disk, whoever wrote it
+type AudioInfo struct {
+	Format          string  `json:"format"` // "wav" or "flac"
+	SampleRate      uint32  `json:"sampleRate"`
+	Channels        uint32  `json:"channels"`
+	BitsPerSample   uint32  `json:"bitsPerSample"`
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// Where the samples of a WAV file are, and how they're encoded
+	dataOffset int64
+	dataSize   int64
+	formatTag  uint16
+}
+
+// audioExtensions are the files probeAudio reads.
+var audioExtensions = []string{".wav", ".flac"}
+
+// isAudioFile reports whether a file name has an extension probeAudio reads.
+func isAudioFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range audioExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// probeAudio reads the format and length of a WAV or FLAC file from its
+// headers.
+func probeAudio(f *os.File) (AudioInfo, error) {
+	var magic [4]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return AudioInfo{}, errors.New("not an audio file")
+	}
+	switch string(magic[:]) {
+	case "RIFF":
+		return probeWAV(f)
+	case "fLaC":
+		return probeFLAC(f)
+	case "OggS":
+		return AudioInfo{}, errors.New("unsupported format: Ogg (Opus/Vorbis) can't be decoded yet, only WAV and FLAC")
+	}
+	if string(magic[:3]) == "ID3" || (magic[0] == 0xff && magic[1]&0xe0 == 0xe0) {
+		return AudioInfo{}, errors.New("unsupported format: MP3 can't be decoded yet, only WAV and FLAC")
+	}
+	return AudioInfo{}, errors.New("unsupported format: only WAV and FLAC are supported")
+}
+
+// probeWAV walks the RIFF chunks for the format and the sample data. Files
+// written by other tools often carry extra chunks (LIST, bext, fact) in
+// between.
+func probeWAV(f *os.File) (AudioInfo, error) {
+	info := AudioInfo{Format: "wav"}
+	var header [12]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil || string(header[8:12]) != "WAVE" {
+		return info, errors.New("invalid WAV file: missing WAVE header")
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	foundFormat := false
+	for offset := int64(12); offset+8 <= stat.Size(); {
+		var chunk [8]byte
+		if _, err := f.ReadAt(chunk[:], offset); err != nil {
+			return info, fmt.Errorf("invalid WAV file: %w", err)
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return info, errors.New("invalid WAV file: short fmt chunk")
+			}
+			fmtChunk := make([]byte, min(size, 40))
+			if _, err := f.ReadAt(fmtChunk, offset+8); err != nil {
+				return info, fmt.Errorf("invalid WAV file: %w", err)
+			}
+			info.formatTag = binary.LittleEndian.Uint16(fmtChunk[0:])
+			info.Channels = uint32(binary.LittleEndian.Uint16(fmtChunk[2:]))
+			info.SampleRate = binary.LittleEndian.Uint32(fmtChunk[4:])
+			info.BitsPerSample = uint32(binary.LittleEndian.Uint16(fmtChunk[14:]))
+			if info.formatTag == wavFormatExtensible && len(fmtChunk) >= 26 {
+				// The real format is the start of the sub-format GUID
+				info.formatTag = binary.LittleEndian.Uint16(fmtChunk[24:])
+			}
+			foundFormat = true
+		case "data":
+			info.dataOffset = offset + 8
+			// Recordings cut short keep a 0 or oversized length, so trust
+			// the file size over the header
+			info.dataSize = min(size, stat.Size()-info.dataOffset)
+			if size == 0 {
+				info.dataSize = stat.Size() - info.dataOffset
+			}
+		}
+		if info.dataOffset > 0 && foundFormat {
+			break
+		}
+		offset += 8 + size + size%2
+	}
+
+	if !foundFormat || info.dataOffset == 0 {
+		return info, errors.New("invalid WAV file: missing fmt or data chunk")
+	}
+	if info.formatTag != wavFormatPCM && info.formatTag != wavFormatFloat {
+		return info, fmt.Errorf("unsupported WAV encoding (format %d)", info.formatTag)
+	}
+	if info.SampleRate == 0 || info.Channels == 0 || info.BitsPerSample == 0 {
+		return info, errors.New("invalid WAV file: bad format fields")
+	}
+	bytesPerSecond := float64(info.SampleRate * info.Channels * info.BitsPerSample / 8)
+	info.DurationSeconds = float64(info.dataSize) / bytesPerSecond
+	return info, nil
+}
+
+// probeFLAC reads the STREAMINFO block at the start of every FLAC file.
+func probeFLAC(f *os.File) (AudioInfo, error) {
+	info := AudioInfo{Format: "flac"}
+	// "fLaC", a 4 byte metadata block header, then the 34 byte STREAMINFO
+	var block [42]byte
+	if _, err := f.ReadAt(block[:], 0); err != nil || block[4]&0x7f != 0 {
+		return info, errors.New("invalid FLAC file: missing STREAMINFO")
+	}
+	streamInfo := block[8:]
+	packed := binary.BigEndian.Uint64(streamInfo[10:18])
+	info.SampleRate = uint32(packed >> 44)
+	info.Channels = uint32(packed>>41&0x7) + 1
+	info.BitsPerSample = uint32(packed>>36&0x1f) + 1
+	totalSamples := packed & 0xfffffffff
+	if info.SampleRate == 0 {
+		return info, errors.New("invalid FLAC file: sample rate is 0")
+	}
+	info.DurationSeconds = float64(totalSamples) / float64(info.SampleRate)
+	return info, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// usersFile lists who may use the API, each with a token and a role. Without
+// one, anyone who can reach the server can do anything.
+var usersFile = "users.json"
+
+// Roles, each allowed everything the ones before it are.
+const (
+	roleGuest    = "guest"    // download their own tracks and what's shared with them
+	roleViewer   = "viewer"   // list, stream and download
+	roleOperator = "operator" // record, mark, clip, import, process and share
+	roleAdmin    = "admin"    // archive, and the admin endpoints
+)
+
+var roleRanks = map[string]int{roleGuest: 1, roleViewer: 2, roleOperator: 3, roleAdmin: 4}
+
+// User is someone allowed to use the API, who sends their token as
+// "Authorization: Bearer <token>", or as ?token= where a header can't be set,
+// such as a download link or an EventSource
+type User struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+
+	// Speaker limits a viewer's downloads to tracks recorded as them, and
+	// those shared with them; guests always are
+	Speaker string `json:"speaker,omitempty"`
+}
+
+// users are those in the users file. Empty means authentication is off.
+var users []User
+
+// routeRoles are the roles routes need, by ServeMux pattern, where it's not
+// the default of a viewer to look and an operator to change anything. ""
+// needs no token.
+var routeRoles = map[string]string{
+	"/":                              "", // the web page, which asks for a token
+	"GET /share/{token}":             "", // the link is the permission
+	"GET /feed.xml":                  "", // podcast apps can't sign in
+	"GET /episodes/{file}":           "",
+	"GET /api/me":                    roleGuest,
+	"/api/recordings":                roleGuest,
+	"/recordings/":                   roleGuest,
+	"GET /api/sessions/{id}/archive": roleGuest,
+	"/api/start":                     roleOperator,
+	"/api/stop":                      roleOperator,
+	"/api/quickstart/{preset}":       roleOperator,
+	"/api/quickstart/last":           roleOperator,
+	"GET /api/streamdeck":            roleOperator,
+	"GET /api/grants":                roleOperator,
+	"DELETE /api/trash":              roleAdmin,
+	"DELETE /api/trash/{id}":         roleAdmin,
+}
+
+// userContextKey carries the signed-in user in a request's context.
+type userContextKey struct{}
+
+// loadUsers reads the users file. A missing file leaves authentication off.
+func loadUsers() error {
+	data, err := os.ReadFile(usersFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid users file %s: %w", usersFile, err)
+	}
+	tokens := map[string]bool{}
+	for i, u := range list {
+		if u.Name == "" {
+			return fmt.Errorf("user %d is missing a name", i+1)
+		}
+		if len(u.Token) < 16 {
+			return fmt.Errorf("user %s: token must be at least 16 characters", u.Name)
+		}
+		if tokens[u.Token] {
+			return fmt.Errorf("user %s: token is already someone else's", u.Name)
+		}
+		tokens[u.Token] = true
+		if roleRanks[u.Role] == 0 {
+			return fmt.Errorf("user %s: role must be guest, viewer, operator or admin", u.Name)
+		}
+		if u.Speaker != "" && u.Role != roleViewer && u.Role != roleGuest {
+			return fmt.Errorf("user %s: only viewers and guests can be limited to a speaker", u.Name)
+		}
+	}
+	users = list
+	return nil
+}
+
+// findUser returns the user with a token, or nil.
+func findUser(token string) *User {
+	if token == "" {
+		return nil
+	}
+	for i := range users {
+		if subtle.ConstantTimeCompare([]byte(users[i].Token), []byte(token)) == 1 {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// requestToken is the token a request was sent with.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requestUser is who made a request, or nil if authentication is off or the
+// request came from inside the recorder, such as a trigger or the schedule.
+func requestUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey{}).(*User)
+	return u
+}
+
+// allows reports whether the user may do what needs a role. A nil user is
+// the recorder itself, or anyone when authentication is off.
+func (u *User) allows(role string) bool {
+	return u == nil || roleRanks[u.Role] >= roleRanks[role]
+}
+
+// requireRole writes a 403 and returns false unless the request's user has
+// the role, for handlers whose requests need more than their route does.
+func requireRole(w http.ResponseWriter, r *http.Request, role string, what string) bool {
+	if requestUser(r).allows(role) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, errCodeForbidden, fmt.Sprintf("Only %ss can %s", role, what))
+	return false
+}
+
+// routeRole is the role a request needs, by the route it matches.
+func routeRole(r *http.Request, pattern string) string {
+	if role, ok := routeRoles[pattern]; ok {
+		return role
+	}
+	if strings.HasPrefix(pattern, "/debug/") {
+		return roleAdmin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return roleViewer
+	}
+	return roleOperator
+}
+
+// authenticate checks a request's token against the users file and its role
+// against the route it's for, then passes the user on in its context.
+func authenticate(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(users) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, pattern := mux.Handler(r)
+		role := routeRole(r, pattern)
+		u := findUser(requestToken(r))
+		if u == nil && role != "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="skribbl-capture"`)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Sign in with a token from the users file")
+			return
+		}
+		if role != "" && !u.allows(role) {
+			writeError(w, http.StatusForbidden, errCodeForbidden, fmt.Sprintf("%s is a %s; this needs a %s", u.Name, u.Role, role))
+			return
+		}
+		if u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, u))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limited reports whether the user only gets their own tracks and what's
+// shared with them.
+func (u *User) limited() bool {
+	return u != nil && (u.Role == roleGuest || u.Speaker != "")
+}
+
+// ownTracks lists the files a limited user may download: tracks recorded as
+// them and what's been shared with them. It returns nil if the user may
+// download any.
+func (u *User) ownTracks() map[string]bool {
+	if !u.limited() {
+		return nil
+	}
+	own := map[string]bool{}
+	shared := grantedTo(u.Name)
+	for _, manifest := range loadCatalog() {
+		for _, track := range manifest.Tracks {
+			if u.Speaker != "" && strings.EqualFold(track.Speaker, u.Speaker) {
+				own[track.File] = true
+			}
+		}
+		files, ok := shared[manifest.ID]
+		if ok && files == nil {
+			files = manifest.files()
+		}
+		for _, name := range files {
+			own[name] = true
+		}
+	}
+	return own
+}
+
+// mayDownload reports whether the user may download a track.
+func (u *User) mayDownload(name string) bool {
+	own := u.ownTracks()
+	return own == nil || own[name]
+}
+
+// Handler: GET /api/me - Who the token belongs to and what they may do
+func handleGetMe(w http.ResponseWriter, r *http.Request) {
+	u := requestUser(r)
+	me := map[string]interface{}{"authenticated": len(users) > 0}
+	if u != nil {
+		me["name"], me["role"] = u.Name, u.Role
+		if u.Speaker != "" {
+			me["speaker"] = u.Speaker
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(me)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// autoConvert is the preset finished sessions' WAV tracks are converted by,
+// from -auto-convert. Empty leaves them alone.
+var autoConvert string
+
+// autoConvertPreset is a way of converting finished sessions' tracks: the
+// job queued for each session, and the format for a transcode job
+type autoConvertPreset struct {
+	kind, format string
+}
+
+// autoConvertPresets are the values -auto-convert takes. flac replaces the
+// WAV tracks, to save space; the others keep them and add a copy.
+var autoConvertPresets = map[string]autoConvertPreset{
+	"flac":     {jobCompact, ""},
+	"flac+wav": {jobTranscode, "flac"},
+	"mp3+wav":  {jobTranscode, "mp3"},
+	"opus+wav": {jobTranscode, "opus"},
+}
+
+// autoConvertPresetNames lists the presets for messages.
+func autoConvertPresetNames() string {
+	var names []string
+	for name := range autoConvertPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// queueAutoConvert queues the -auto-convert job for a newly finished or
+// cataloged session, if it has WAV tracks.
+func queueAutoConvert(manifest *SessionManifest) {
+	preset, ok := autoConvertPresets[autoConvert]
+	if !ok || !slices.ContainsFunc(manifest.Tracks, isWAVTrack) {
+		return
+	}
+	_, err := enqueueJob(JobRequest{Kind: preset.kind, SessionID: manifest.ID, Format: preset.format, Priority: jobPriorityLow})
+	if err != nil {
+		fmt.Printf("⚠️  Failed to queue converting %s: %v\n", manifest.ID, err)
+	}
+}
+
+// isWAVTrack reports whether a track is a WAV file, other than one of a
+// track split by -split-every, whose files only make sense together.
+func isWAVTrack(track TrackInfo) bool {
+	return strings.EqualFold(filepath.Ext(track.File), ".wav") && len(track.Segments) == 0
+}
+
+// runCompact replaces a session's WAV tracks with FLAC files. Each FLAC file
+// is decoded and checked against the WAV's audio before the session is
+// changed to it and the WAV removed, so nothing is lost if ffmpeg goes
+// wrong. Tracks already replaced are skipped, so a retry picks up where a
+// failed attempt stopped.
+func runCompact(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	tracks = slices.DeleteFunc(tracks, func(t TrackInfo) bool { return !isWAVTrack(t) })
+	var made []string
+	for i, track := range tracks {
+		name, err := compactTrack(ctx, job.SessionID, track, func(p float64) {
+			progress((float64(i) + p) / float64(len(tracks)))
+		})
+		if err != nil {
+			return made, err
+		}
+		made = append(made, name)
+	}
+	return made, nil
+}
+
+// compactTrack replaces one WAV track with a FLAC file, and returns its
+// name.
+func compactTrack(ctx context.Context, id string, track TrackInfo, progress func(float64)) (string, error) {
+	in, err := openRecording(track.File)
+	if err != nil {
+		return "", err
+	}
+	in.Close()
+	name, out, release, err := createExportFile(trackBase(track), ".flac")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	out.Close()
+	path := filepath.Join(outputDirectory, name)
+	fail := func(err error) (string, error) {
+		os.Remove(path)
+		return "", err
+	}
+
+	if err := ffmpegTranscode(ctx, in.Name(), path, transcodeFormats["flac"], track, func(p float64) { progress(p * 0.8) }); err != nil {
+		return fail(err)
+	}
+	want, err := pcmChecksum(in.Name())
+	if err != nil {
+		return fail(fmt.Errorf("failed to decode %s: %w", track.File, err))
+	}
+	got, err := pcmChecksum(path)
+	if err != nil {
+		return fail(fmt.Errorf("failed to decode %s: %w", name, err))
+	}
+	if got != want {
+		return fail(fmt.Errorf("%s doesn't decode to the same audio as %s; kept the WAV", name, track.File))
+	}
+	size, sum, err := checksumFile(path)
+	if err != nil {
+		return fail(fmt.Errorf("failed to checksum %s: %w", name, err))
+	}
+
+	err = updateManifest(id, func(m *SessionManifest) {
+		for i := range m.Tracks {
+			if m.Tracks[i].File == track.File {
+				m.Tracks[i].File, m.Tracks[i].Format, m.Tracks[i].Size, m.Tracks[i].SHA256 = name, "flac", size, sum
+			}
+		}
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.Remove(filepath.Join(outputDirectory, track.File)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("⚠️  Failed to remove %s, replaced by %s: %v\n", track.File, name, err)
+	}
+	if store != nil {
+		if err := unstore(track.File); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		}
+	}
+	progress(1)
+	fmt.Printf("✓ Replaced %s with %s (%s → %s)\n", track.File, name, formatBytes(track.Size), formatBytes(size))
+	return name, nil
+}
+
+// pcmChecksum is the SHA-256 of a file's audio decoded to 16-bit PCM, the
+// same for a WAV file and a FLAC file made from it.
+func pcmChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return "", err
+	}
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, pcm); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
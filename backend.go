@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+)
+
+// audioBackends are the audio backends to try, in order, or nil for the
+// platform's usual order. Set from -backend.
+var audioBackends []malgo.Backend
+
+// backendNames are the -backend values miniaudio understands.
+var backendNames = map[string]malgo.Backend{
+	"wasapi":     malgo.BackendWasapi,
+	"dsound":     malgo.BackendDsound,
+	"winmm":      malgo.BackendWinmm,
+	"coreaudio":  malgo.BackendCoreaudio,
+	"sndio":      malgo.BackendSndio,
+	"audio4":     malgo.BackendAudio4,
+	"oss":        malgo.BackendOss,
+	"pulseaudio": malgo.BackendPulseaudio,
+	"alsa":       malgo.BackendAlsa,
+	"jack":       malgo.BackendJack,
+
+	// malgo's enumeration leaves out miniaudio's custom backend, which comes
+	// just before null, so its BackendNull would pick the custom one
+	"null": malgo.BackendNull + 1,
+}
+
+// backendList is the -backend flag: backend names, comma-separated.
+type backendList struct{}
+
+func (backendList) String() string {
+	var names []string
+	for _, b := range audioBackends {
+		for name, value := range backendNames {
+			if value == b {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func (backendList) Set(value string) error {
+	audioBackends = nil
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "asio" {
+			return fmt.Errorf("ASIO isn't a miniaudio backend; a Windows build with -tags asio lists ASIO drivers as devices of their own")
+		}
+		b, ok := backendNames[name]
+		if !ok {
+			return fmt.Errorf("unknown audio backend %q", name)
+		}
+		audioBackends = append(audioBackends, b)
+	}
+	return nil
+}
+
+// addBackendFlag adds -backend to a command's flags.
+func addBackendFlag(fs *flag.FlagSet) {
+	fs.Var(backendList{}, "backend", "audio backends to try, comma-separated: wasapi, dsound, winmm, coreaudio, pulseaudio, alsa, jack, oss, sndio, audio4 or null (default: the platform's usual order)")
+}
+
+// initAudioContext initializes miniaudio with the -backend choice.
+func initAudioContext() (*malgo.AllocatedContext, error) {
+	return malgo.InitContext(audioBackends, malgo.ContextConfig{}, nil)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth caps in KB/s, from flags; 0 is unlimited. Uploads share one cap,
+// so background syncing leaves room for the game and the call, which matter
+// most while recording. Each -stream has its own.
+var (
+	uploadLimitKB          int64
+	uploadLimitRecordingKB int64
+	streamLimitKB          int64
+)
+
+func addBandwidthFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&uploadLimitKB, "upload-limit", 0, "maximum speed of uploads to -storage in KB/s (0 is unlimited)")
+	fs.Int64Var(&uploadLimitRecordingKB, "upload-limit-recording", 0, "maximum speed of uploads to -storage in KB/s while recording (0 uses -upload-limit)")
+	fs.Int64Var(&streamLimitKB, "stream-limit", 0, "maximum speed each HTTP or Icecast -stream is downloaded at in KB/s, which must be above its bitrate (0 is unlimited)")
+}
+
+// uploadLimit paces every upload to the store together.
+var uploadLimit = &bandwidthLimit{rate: func() int64 {
+	if uploadLimitRecordingKB > 0 {
+		recordingMutex.Lock()
+		recording := activeSession != nil
+		recordingMutex.Unlock()
+		if recording {
+			return uploadLimitRecordingKB << 10
+		}
+	}
+	return uploadLimitKB << 10
+}}
+
+// bandwidthLimit paces reads to a rate in bytes per second, which is looked
+// up as it goes so a change takes effect mid-transfer.
+type bandwidthLimit struct {
+	rate func() int64 // 0 is unlimited
+
+	mu   sync.Mutex
+	next time.Time // when what's been read so far will have been paid for
+}
+
+// wait sleeps until n more bytes fit within the rate.
+func (l *bandwidthLimit) wait(n int, rate int64) {
+	now := time.Now()
+	l.mu.Lock()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// reader paces reads from r. A nil limit doesn't.
+func (l *bandwidthLimit) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, limit: l}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	limit *bandwidthLimit
+}
+
+// Read reads at most a tenth of a second's worth at a time, so the rate is
+// even rather than a burst and a pause.
+func (r *limitedReader) Read(p []byte) (int, error) {
+	rate := r.limit.rate()
+	if rate <= 0 {
+		return r.r.Read(p)
+	}
+	if chunk := max(rate/10, 1024); int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.limit.wait(n, rate)
+	}
+	return n, err
+}
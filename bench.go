@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// benchSourceResult is what one synthetic source measured during a run.
+type benchSourceResult struct {
+	callbacks     int
+	latencies     []time.Duration
+	framesOffered uint64
+	bytesWritten  uint32
+	droppedFrames uint64
+}
+
+// benchReport is what a benchmark measured, printed as JSON with -json
+type benchReport struct {
+	Devices        int                 `json:"devices"`
+	PerDevice      []benchDeviceReport `json:"perDevice"`
+	LatencyP50Ms   float64             `json:"latencyP50Ms"`
+	LatencyP99Ms   float64             `json:"latencyP99Ms"`
+	LatencyMaxMs   float64             `json:"latencyMaxMs"`
+	ThroughputMBps float64             `json:"throughputMBps"`
+	RealTimeFactor float64             `json:"realTimeFactor"` // per device
+	FramesOffered  uint64              `json:"framesOffered"`
+	DroppedFrames  uint64              `json:"droppedFrames"`
+}
+
+// benchDeviceReport is what one synthetic source measured, for benchReport
+type benchDeviceReport struct {
+	Callbacks     int     `json:"callbacks"`
+	MBWritten     float64 `json:"mbWritten"`
+	DroppedFrames uint64  `json:"droppedFrames"`
+	DropPercent   float64 `json:"dropPercent"`
+}
+
+// runBench spins up synthetic sources that drive the real capture pipeline
+// and reports callback latency, writer throughput and dropouts. The
+// callback's cost and allocations are measured by BenchmarkOnData instead,
+// with go test.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	devices := fs.Int("devices", 8, "number of simulated capture devices")
+	sampleRate := fs.Int("rate", 44100, "sample rate of each simulated device")
+	periodMS := fs.Int("period-ms", 10, "callback period in milliseconds")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run the stress test")
+	dir := fs.String("dir", "", "directory to write test files to (default: a temporary directory)")
+	maxBufferMB := addMemoryFlags(fs)
+	addOutputFlags(fs)
+	fs.Parse(args)
+	applyMemoryFlags(maxBufferMB)
+
+	uiPrintln("Skribbl Audio Capture - Benchmark")
+
+	// Run synthetic sources in real time against real files. The temporary
+	// directory is removed by hand, since os.Exit skips deferred calls.
+	outputDir := *dir
+	cleanup := func() {}
+	if outputDir == "" {
+		var err error
+		outputDir, err = os.MkdirTemp("", "skribbl-bench-")
+		if err != nil {
+			uiWarnf("Failed to create temporary directory: %v\n", err)
+			os.Exit(1)
+		}
+		cleanup = func() { os.RemoveAll(outputDir) }
+	}
+
+	uiPrintf("\nStress test: %d devices @ %d Hz, %dms periods, %s → %s\n", *devices, *sampleRate, *periodMS, *duration, outputDir)
+	results, elapsed, err := runSyntheticSources(outputDir, *devices, *sampleRate, *periodMS, *duration)
+	cleanup()
+	if err != nil {
+		uiWarnf("Stress test failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	report := summarizeBench(results, elapsed, uint32(*sampleRate))
+	report.Devices = *devices
+	printBenchReport(report)
+	printResult(report)
+}
+
+// runSyntheticSources drives one capture device per source with a sine wave,
+// paced like a real audio callback, and collects per-source measurements.
+func runSyntheticSources(dir string, devices, sampleRate, periodMS int, duration time.Duration) ([]benchSourceResult, time.Duration, error) {
+	framesPerCallback := sampleRate * periodMS / 1000
+	period := time.Duration(periodMS) * time.Millisecond
+
+	captures := make([]*captureDevice, 0, devices)
+	for i := 0; i < devices; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("bench_%d.wav", i))
+		file, err := os.Create(filename)
+		if err != nil {
+			for _, cap := range captures {
+				cap.finish()
+			}
+			return nil, 0, err
+		}
+		writeWAVHeader(file, uint32(sampleRate), 1, 16, 0)
+		captures = append(captures, newCaptureDevice(fmt.Sprintf("bench-%d", i), file, filename, uint32(sampleRate), 1))
+	}
+
+	results := make([]benchSourceResult, devices)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i, cap := range captures {
+		wg.Add(1)
+		go func(i int, cap *captureDevice) {
+			defer wg.Done()
+
+			// Each source plays a different tone so files are distinguishable
+			samples := make([]byte, framesPerCallback*2)
+			freq := 220.0 * float64(i+1)
+			phase := 0.0
+			res := &results[i]
+			res.latencies = make([]time.Duration, 0, int(duration/period)+1)
+
+			ticker := time.NewTicker(period)
+			defer ticker.Stop()
+			deadline := start.Add(duration)
+
+			for now := range ticker.C {
+				if now.After(deadline) {
+					break
+				}
+				for f := 0; f < framesPerCallback; f++ {
+					v := int16(math.Sin(phase) * 8000)
+					samples[2*f] = byte(v)
+					samples[2*f+1] = byte(v >> 8)
+					phase += 2 * math.Pi * freq / float64(sampleRate)
+				}
+
+				t := time.Now()
+				cap.onData(nil, samples, uint32(framesPerCallback))
+				res.latencies = append(res.latencies, time.Since(t))
+				res.callbacks++
+				res.framesOffered += uint64(framesPerCallback)
+			}
+		}(i, cap)
+	}
+
+	wg.Wait()
+	for i, cap := range captures {
+		cap.finish()
+		results[i].bytesWritten = cap.totalBytesWritten.Load()
+		results[i].droppedFrames = cap.droppedFrames.Load()
+	}
+	return results, time.Since(start), nil
+}
+
+// summarizeBench totals up the stress test results.
+func summarizeBench(results []benchSourceResult, elapsed time.Duration, sampleRate uint32) benchReport {
+	var report benchReport
+	all := []time.Duration{}
+	var totalBytes uint64
+	for _, res := range results {
+		dropRate := 0.0
+		if res.framesOffered > 0 {
+			dropRate = float64(res.droppedFrames) / float64(res.framesOffered) * 100
+		}
+		report.PerDevice = append(report.PerDevice, benchDeviceReport{
+			Callbacks:     res.callbacks,
+			MBWritten:     float64(res.bytesWritten) / (1 << 20),
+			DroppedFrames: res.droppedFrames,
+			DropPercent:   dropRate,
+		})
+
+		all = append(all, res.latencies...)
+		totalBytes += uint64(res.bytesWritten)
+		report.FramesOffered += res.framesOffered
+		report.DroppedFrames += res.droppedFrames
+	}
+
+	sort.Slice(all, func(a, b int) bool { return all[a] < all[b] })
+	percentile := func(p float64) float64 {
+		if len(all) == 0 {
+			return 0
+		}
+		return float64(all[int(float64(len(all)-1)*p)]) / float64(time.Millisecond)
+	}
+	report.LatencyP50Ms, report.LatencyP99Ms, report.LatencyMaxMs = percentile(0.50), percentile(0.99), percentile(1)
+	report.ThroughputMBps = float64(totalBytes) / (1 << 20) / elapsed.Seconds()
+	report.RealTimeFactor = float64(totalBytes) / float64(len(results)) / 2 / float64(sampleRate) / elapsed.Seconds()
+	return report
+}
+
+// printBenchReport prints the stress test results.
+func printBenchReport(report benchReport) {
+	uiPrintln("\n=== Per-Device Results ===")
+	for i, res := range report.PerDevice {
+		uiPrintf("[%d] %d callbacks, %.1f MB written, %d frames dropped (%.3f%%)\n",
+			i, res.Callbacks, res.MBWritten, res.DroppedFrames, res.DropPercent)
+	}
+
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	uiPrintln("\n=== Summary ===")
+	uiPrintf("Callback latency:  p50 %s  p99 %s  max %s\n", ms(report.LatencyP50Ms), ms(report.LatencyP99Ms), ms(report.LatencyMaxMs))
+	uiPrintf("Writer throughput: %.2f MB/s (%.1fx real time per device)\n", report.ThroughputMBps, report.RealTimeFactor)
+	dropRate := 0.0
+	if report.FramesOffered > 0 {
+		dropRate = float64(report.DroppedFrames) / float64(report.FramesOffered) * 100
+	}
+	uiPrintf("Dropouts:          %d of %d frames (%.3f%%)\n", report.DroppedFrames, report.FramesOffered, dropRate)
+	if report.DroppedFrames > 0 {
+		uiWarnf("✗ Frames were dropped - this machine may not keep up with this many devices\n")
+	} else {
+		uiPrintln("✓ No frames dropped")
+	}
+}
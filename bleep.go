@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// bleepPadding is added either side of a flagged word, since word
+	// timings are rarely exact.
+	bleepPadding = 0.05
+
+	// bleepToneHz and bleepToneLevel make the classic TV bleep, at -12 dBFS.
+	bleepToneHz    = 1000
+	bleepToneLevel = 0.25
+)
+
+// bleepWordsFile lists extra words to bleep, one per line. Set from flags in
+// web mode.
+var bleepWordsFile string
+
+// defaultBleepWords are always bleeped. A trailing * matches any word
+// starting with the rest.
+var defaultBleepWords = []string{
+	"fuck*", "motherfuck*", "shit*", "bullshit*", "bitch*", "cunt*",
+	"asshole*", "bastard*", "dick", "dickhead*", "cock", "cocksucker*",
+	"piss", "pissed", "wank*", "twat*", "prick", "damn*", "goddamn*",
+}
+
+// BleepRequest is the request body for exporting bleeped tracks
+type BleepRequest struct {
+	Mode  string   `json:"mode"`  // "tone" (default) or "silence"
+	Words []string `json:"words"` // bleeped as well as the usual list
+}
+
+// bleepRange is a stretch of a track to cover, in seconds
+type bleepRange struct {
+	start, end float64
+}
+
+// Handler: POST /api/sessions/{id}/bleep - Export a copy of every track of a
+// finished session with a tone or silence over swearing, found using the
+// session's transcript, for sharing recordings publicly
+func handleBleepSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	var req BleepRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "tone"
+	}
+	if req.Mode != "tone" && req.Mode != "silence" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "mode must be tone or silence")
+		return
+	}
+	words, err := bleepWords(req.Words)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	recordingMutex.Lock()
+	recording := activeSession != nil && activeSession.id == id
+	recordingMutex.Unlock()
+	if recording {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Session is still recording; stop it before exporting")
+		return
+	}
+
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	segments, err := readTranscript(id)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No transcript for this session")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	ranges := map[string][]bleepRange{}
+	for _, segment := range segments {
+		ranges[segment.File] = append(ranges[segment.File], flaggedWords(segment, words)...)
+	}
+
+	bleeps := 0
+	var exports []ExportFile
+	for _, track := range manifest.Tracks {
+		name, err := writeBleepedTrack(track, ranges[track.File], req.Mode == "tone")
+		if err != nil {
+			for _, e := range exports {
+				os.Remove(filepath.Join(outputDirectory, e.File))
+			}
+			writeStorageError(w, errCodeInternal, err)
+			return
+		}
+		bleeps += len(ranges[track.File])
+		exports = append(exports, ExportFile{Kind: "bleep", File: name, Device: track.Device, Source: track.File})
+	}
+
+	// The tracks are bleeped without holding manifestMu, and the exports
+	// added to the metadata as it is by then
+	if err := updateManifest(id, func(m *SessionManifest) { m.Exports = append(m.Exports, exports...) }); err != nil {
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	fmt.Printf("✓ Bleeped %d word(s) in session %s\n", bleeps, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"bleeps":    bleeps,
+		"files":     exports,
+	})
+}
+
+// bleepWords combines the default list, the -bleep-words file and any extra
+// words, normalized for matching.
+func bleepWords(extra []string) ([]string, error) {
+	words := append([]string{}, defaultBleepWords...)
+	if bleepWordsFile != "" {
+		f, err := os.Open(bleepWordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bleep words: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				words = append(words, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read bleep words: %w", err)
+		}
+	}
+	words = append(words, extra...)
+
+	var normalized []string
+	for _, word := range words {
+		prefix := strings.HasSuffix(word, "*")
+		if word = normalizeSpeech(strings.TrimSuffix(word, "*")); word == "" {
+			continue
+		}
+		if prefix {
+			word += "*"
+		}
+		normalized = append(normalized, word)
+	}
+	return normalized, nil
+}
+
+// matchesBleep reports whether a spoken word is on the list.
+func matchesBleep(spoken string, words []string) bool {
+	spoken = normalizeSpeech(spoken)
+	if spoken == "" {
+		return false
+	}
+	for _, word := range words {
+		if prefix, ok := strings.CutSuffix(word, "*"); ok {
+			if strings.HasPrefix(spoken, prefix) {
+				return true
+			}
+		} else if spoken == word {
+			return true
+		}
+	}
+	return false
+}
+
+// flaggedWords finds the words to bleep in a segment. Without word timings
+// from the service, each word's time is estimated from its place in the
+// text, and the padding widened to make up for it.
+func flaggedWords(segment TranscriptSegment, words []string) []bleepRange {
+	var ranges []bleepRange
+	if len(segment.Words) > 0 {
+		for _, w := range segment.Words {
+			if matchesBleep(w.Word, words) {
+				ranges = append(ranges, bleepRange{w.Start - bleepPadding, w.End + bleepPadding})
+			}
+		}
+		return ranges
+	}
+
+	total := utf8.RuneCountInString(segment.Text)
+	if total == 0 {
+		return nil
+	}
+	perRune := (segment.End - segment.Start) / float64(total)
+	offset := 0
+	for _, w := range strings.SplitAfter(segment.Text, " ") {
+		length := utf8.RuneCountInString(w)
+		if matchesBleep(w, words) {
+			start := segment.Start + float64(offset)*perRune
+			ranges = append(ranges, bleepRange{start - 4*bleepPadding, start + float64(length)*perRune + 4*bleepPadding})
+		}
+		offset += length
+	}
+	return ranges
+}
+
+// writeBleepedTrack decodes a track and writes a copy with the ranges covered,
+// returning the new file's name.
+func writeBleepedTrack(track TrackInfo, ranges []bleepRange, tone bool) (string, error) {
+	in, err := openRecording(track.File)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	info, err := probeAudio(in)
+	if err != nil {
+		return "", err
+	}
+	pcm, err := openPCM16(in, info)
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.TrimSuffix(track.File, filepath.Ext(track.File)) + "_bleeped"
+	name, out, release, err := createExportFile(base, ".wav")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	fail := func(err error) (string, error) {
+		out.Close()
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := writeWAVHeader(out, info.SampleRate, info.Channels, 16, 0); err != nil {
+		return fail(err)
+	}
+
+	rate := float64(info.SampleRate)
+	channels := int(info.Channels)
+	frame := int64(0)
+	var written uint32
+	buf := make([]byte, 64*1024/(channels*2)*(channels*2))
+	for {
+		n, readErr := io.ReadFull(pcm, buf)
+		n -= n % (channels * 2)
+		chunk := buf[:n]
+		for i := 0; i < len(chunk); i += channels * 2 {
+			t := float64(frame) / rate
+			frame++
+			if !inBleepRange(t, ranges) {
+				continue
+			}
+			var v int16
+			if tone {
+				v = int16(bleepToneLevel * 32767 * math.Sin(2*math.Pi*bleepToneHz*t))
+			}
+			for c := range channels {
+				binary.LittleEndian.PutUint16(chunk[i+c*2:], uint16(v))
+			}
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return fail(err)
+		}
+		written += uint32(n)
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return fail(readErr)
+		}
+	}
+
+	// Go back and fill in the size, as when finishing a recording
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	if err := writeWAVHeader(out, info.SampleRate, info.Channels, 16, written); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// inBleepRange reports whether a time falls in any of the ranges.
+func inBleepRange(t float64, ranges []bleepRange) bool {
+	for _, r := range ranges {
+		if t >= r.start && t < r.end {
+			return true
+		}
+	}
+	return false
+}
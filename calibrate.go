@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// calibrationTargetPeak is the peak level calibration aims for, leaving
+	// headroom for people getting louder than they were while testing.
+	calibrationTargetPeak = -6.0
+
+	// maxCalibrationGain bounds the recommended gain either way.
+	maxCalibrationGain = 24.0
+
+	// silentBelowDBFS is the peak under which a device is treated as silent.
+	silentBelowDBFS = -60.0
+
+	// noisyAboveDBFS is the noise floor, after gain, that's worth a warning.
+	noisyAboveDBFS = -45.0
+)
+
+// deviceSettingsFile holds per-device settings such as calibrated gain. It's
+// re-read whenever a device starts, so edits take effect on the next recording.
+var deviceSettingsFile = "device-settings.json"
+
+// deviceSettingsMu serializes updates to the device settings file.
+var deviceSettingsMu sync.Mutex
+
+// DeviceSettings are applied whenever a device is recorded, keyed by device name
+type DeviceSettings struct {
+	GainDB float64 `json:"gainDb"`
+
+	// Speaker is the person the device records, for labelling transcripts
+	Speaker string `json:"speaker,omitempty"`
+
+	// Channels picks inputs of a multi-channel interface to record, numbered
+	// from 1, e.g. [3, 4] for a stereo pair. Empty records a mono mix.
+	Channels []int `json:"channels,omitempty"`
+
+	// Tap offers the device's live audio while it records, on a unix socket
+	// ("unix:/path/to.sock") or a named pipe (a path)
+	Tap string `json:"tap,omitempty"`
+}
+
+// CalibrationResult reports a device's levels and the gain to record it at
+type CalibrationResult struct {
+	DeviceIndex int    `json:"deviceIndex"`
+	Device      string `json:"device"`
+	LevelAnalysis
+	CurrentGainDB     float64  `json:"currentGainDb"`
+	RecommendedGainDB float64  `json:"recommendedGainDb"`
+	Warnings          []string `json:"warnings"`
+	Saved             bool     `json:"saved"`
+}
+
+// loadDeviceSettings reads the device settings file. A missing file means
+// no settings.
+func loadDeviceSettings() (map[string]DeviceSettings, error) {
+	settings := map[string]DeviceSettings{}
+	data, err := os.ReadFile(deviceSettingsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("invalid device settings file %s: %w", deviceSettingsFile, err)
+	}
+	return settings, nil
+}
+
+// updateDeviceSettings applies fn to one device's settings and saves the file.
+func updateDeviceSettings(name string, fn func(*DeviceSettings)) error {
+	deviceSettingsMu.Lock()
+	defer deviceSettingsMu.Unlock()
+
+	settings, err := loadDeviceSettings()
+	if err != nil {
+		return err
+	}
+	s := settings[name]
+	fn(&s)
+	settings[name] = s
+
+	data, _ := json.MarshalIndent(settings, "", "  ")
+	return writeFileAtomic(deviceSettingsFile, append(data, '\n'))
+}
+
+// recommendGain works out the gain that brings a device's peak to the target,
+// given the levels measured at its current gain.
+func recommendGain(levels LevelAnalysis, currentGainDB float64) (float64, []string) {
+	warnings := []string{}
+	if levels.PeakDBFS < silentBelowDBFS {
+		return currentGainDB, append(warnings, "No signal detected: check the device is connected and unmuted")
+	}
+	if levels.ClippedSamples > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d samples clipped", levels.ClippedSamples))
+	}
+
+	gain := currentGainDB + calibrationTargetPeak - levels.PeakDBFS
+	gain = math.Round(gain*2) / 2
+	gain = min(max(gain, -maxCalibrationGain), maxCalibrationGain)
+	if levels.NoiseFloorDBFS+gain-currentGainDB > noisyAboveDBFS {
+		warnings = append(warnings, "Background noise will be noticeable at this gain")
+	}
+	return gain, warnings
+}
+
+// Handler: POST /api/devices/{id}/calibrate?seconds=5&save=true - Record a
+// few seconds, report the levels and recommend a gain, optionally saving it
+// as the device's default
+func handleCalibrateDevice(w http.ResponseWriter, r *http.Request) {
+	selected, d, release, ok := sampleDevice(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	path, err := captureSample(r.Context(), selected, d)
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	data, err := os.ReadFile(path)
+	os.Remove(path)
+	if err != nil || len(data) < wavHeaderSize {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to read sample")
+		return
+	}
+
+	settings, err := loadDeviceSettings()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	// The header was written by startCapture, so the format fields are where
+	// writeWAVHeader put them
+	sampleRate := binary.LittleEndian.Uint32(data[24:])
+	channels := uint32(binary.LittleEndian.Uint16(data[22:]))
+	idx, _ := strconv.Atoi(r.PathValue("id"))
+	name := selected.name()
+	result := CalibrationResult{
+		DeviceIndex:   idx,
+		Device:        name,
+		LevelAnalysis: analyzeLevels(data[wavHeaderSize:], sampleRate, channels),
+		CurrentGainDB: settings[name].GainDB,
+	}
+	result.RecommendedGainDB, result.Warnings = recommendGain(result.LevelAnalysis, result.CurrentGainDB)
+
+	if r.URL.Query().Get("save") == "true" && result.PeakDBFS >= silentBelowDBFS {
+		err := updateDeviceSettings(name, func(s *DeviceSettings) { s.GainDB = result.RecommendedGainDB })
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to save device settings: %v", err))
+			return
+		}
+		result.Saved = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// SpeakerRequest is the request body for naming who a device records
+type SpeakerRequest struct {
+	Speaker string `json:"speaker"`
+}
+
+// Handler: PUT /api/devices/{id}/speaker - Name the person a device records,
+// so their transcript segments are labelled with it. An empty name clears it.
+// Takes effect the next time the device starts recording.
+func handleSetSpeaker(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, "Invalid device index")
+		return
+	}
+	var req SpeakerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	speaker := strings.TrimSpace(req.Speaker)
+
+	recordingMutex.Lock()
+	allDevices, err := cachedDevices()
+	recordingMutex.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if idx < 0 || idx >= len(allDevices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
+		return
+	}
+	name := allDevices[idx].name()
+
+	if err := updateDeviceSettings(name, func(s *DeviceSettings) { s.Speaker = speaker }); err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to save device settings: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deviceIndex": idx,
+		"device":      name,
+		"speaker":     speaker,
+	})
+}
+
+// maxInputChannels bounds the channels a device can be opened with.
+const maxInputChannels = 32
+
+// ChannelsRequest is the request body for picking a device's input channels
+type ChannelsRequest struct {
+	Channels []int `json:"channels"`
+}
+
+// Handler: PUT /api/devices/{id}/channels - Pick which inputs of a
+// multi-channel interface to record, numbered from 1, each becoming a channel
+// of the track. An empty list goes back to a mono mix. Takes effect the next
+// time the device starts recording.
+func handleSetChannels(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, "Invalid device index")
+		return
+	}
+	var req ChannelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	for _, ch := range req.Channels {
+		if ch < 1 || ch > maxInputChannels {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Channels are numbered 1 to %d", maxInputChannels))
+			return
+		}
+	}
+
+	recordingMutex.Lock()
+	allDevices, err := cachedDevices()
+	recordingMutex.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if idx < 0 || idx >= len(allDevices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
+		return
+	}
+	name := allDevices[idx].name()
+
+	if err := updateDeviceSettings(name, func(s *DeviceSettings) { s.Channels = req.Channels }); err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to save device settings: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deviceIndex": idx,
+		"device":      name,
+		"channels":    req.Channels,
+	})
+}
+
+// pickedChannels turns a device's 1-based channel setting into 0-based
+// indices, or nil for a mono mix. Out of range channels are ignored.
+func pickedChannels(channels []int) []int {
+	var pick []int
+	for _, ch := range channels {
+		if ch >= 1 && ch <= maxInputChannels {
+			pick = append(pick, ch-1)
+		}
+	}
+	return pick
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// captureQueueLength is the maximum number of callback buffers that can be
+// waiting for the writer. The memory budget is usually the tighter limit.
+const captureQueueLength = 4096
+
+const (
+	// levelDecayPerChunk is the percentage of the meter level kept per chunk,
+	// roughly 20 dB/s at 44.1 kHz mono.
+	levelDecayPerChunk = 90
+
+	// minLevelDBFS is reported for silence.
+	minLevelDBFS = -96
+)
+
+// captureDevice holds all the state for a single audio capture device
+type captureDevice struct {
+	name              string
+	file              *os.File
+	filename          string
+	device            *malgo.Device
+	isLoopback        bool
+	source            selectableDevice
+	sampleRate        uint32
+	channels          uint32
+	totalBytesWritten atomic.Uint32
+
+	// fileBytes is how much audio is in the file being written. With
+	// -split-every, the writer moves on to a new file every segmentBytes,
+	// listing the finished ones in segments, and hashes the audio across
+	// all of them in streamHash.
+	fileBytes    uint64
+	segmentBytes uint64
+	segmentPath  string
+	segmentsMu   sync.Mutex
+	segments     []TrackSegment
+	streamHash   hash.Hash
+
+	// sink, when set, takes the audio in place of the file, for a capture
+	// that's streamed rather than saved
+	sink io.Writer
+
+	// stopSource stops audio from a source other than a malgo device, such
+	// as the process recording a PipeWire port, and returns once it's done
+	// calling onData
+	stopSource func()
+
+	// detached is set, under sourceMu, when a source that can't be
+	// interrupted, like standard input, is let go of; readPCM delivers
+	// nothing more after it
+	sourceMu sync.Mutex
+	detached bool
+
+	// untimed is set for piped audio, which arrives as fast or as slow as
+	// the program feeding it, so its timing says nothing about dropouts
+	untimed bool
+
+	// gain is applied by the writer, from the device's saved settings
+	gain   float64
+	gainDB float64
+
+	// speaker is who the device records, from its settings
+	speaker string
+
+	// tap, when the device's settings ask for one, is sent the audio as it's
+	// written
+	tap *audioTap
+
+	// pick lists the device channels recorded, from 0, when its settings
+	// pick some; the device is opened with inputChannels and the callback
+	// keeps just those
+	pick          []int
+	inputChannels uint32
+
+	// osVolume is the device's OS input volume while recording, if it could
+	// be read, and osVolumeBefore what it was before -input-volume set it
+	osVolume       *OSVolume
+	osVolumeBefore *OSVolume
+
+	// level is a peak meter with decay, updated by the writer
+	level atomic.Uint32
+
+	// inSession is set once a capture joins a session. Only those raise
+	// alerts and log dropouts; sample clips and benchmarks stay quiet.
+	inSession atomic.Bool
+
+	// Clipping is counted over a window by the writer, which alone touches
+	// the window fields.
+	clipWindowStart time.Time
+	clipCount       int
+	clipAlerted     bool
+
+	// Digital silence at the start is counted by the writer too, until
+	// there's sound, which resolves the alert if it was raised
+	silentBytes    uint64
+	silenceChecked bool
+	silenceAlerted bool
+
+	// Where the track sits in its session. mutes lists when the track was
+	// muted, relative to the session start, and is guarded by recordingMutex.
+	deviceIndex int
+	startOffset time.Duration
+	muted       atomic.Bool
+	mutes       []MuteRange
+
+	// While monitored, the callback also copies audio into monitorRing for
+	// the monitor output to play.
+	monitored   atomic.Bool
+	monitorRing sampleRing
+
+	// Likewise into mixRing while the live mix for -virtual-output has it
+	mixed   atomic.Bool
+	mixRing sampleRing
+
+	// transcriber, when live transcripts are on, is fed by the writer
+	transcriber atomic.Pointer[transcriber]
+
+	// Audio flows from the malgo callback into queue, and a writer goroutine
+	// drains it to disk so slow I/O never blocks the audio thread.
+	budget        *memoryBudget
+	queue         chan *audioChunk
+	writerDone    chan struct{}
+	queuedBytes   atomic.Int64
+	droppedFrames atomic.Uint64
+	droppedBytes  atomic.Uint64
+
+	// Losses are noted by the callback, which alone touches these fields,
+	// and carried to the writer on the next queued chunk to be logged in
+	// dropouts.
+	callbackStart  time.Time
+	callbackFrames uint64
+	callbackLag    time.Duration
+	callbackSteps  time.Duration // of callbackLag, from gaps rather than drift
+	drift          driftFit
+	pendingGap     time.Duration
+	pendingDropped uint64
+	pendingFill    time.Duration
+	pendingSilence time.Duration
+	dropoutsMu     sync.Mutex
+	dropouts       []Dropout
+
+	// lastCallback is when audio last arrived, in Unix nanoseconds, for the
+	// watchdog. restarted is set by the watchdog before it starts a new
+	// device, and cleared by the first callback from it.
+	lastCallback atomic.Int64
+	restarted    bool
+}
+
+// newCaptureDevice wraps an open WAV file and starts its writer goroutine.
+func newCaptureDevice(name string, file *os.File, filename string, sampleRate, channels uint32) *captureDevice {
+	cap := &captureDevice{
+		name:       name,
+		file:       file,
+		filename:   filename,
+		sampleRate: sampleRate,
+		channels:   channels,
+		gain:       1,
+		budget:     captureBudget,
+		queue:      make(chan *audioChunk, captureQueueLength),
+		writerDone: make(chan struct{}),
+	}
+	cap.lastCallback.Store(time.Now().UnixNano())
+	cap.budget.register()
+	go cap.writeLoop()
+	return cap
+}
+
+// onData is the malgo data callback. It copies the samples into pooled
+// chunks and queues them, dropping frames (with accounting) if the memory
+// budget is exhausted. It must not allocate: it runs on the audio thread.
+func (c *captureDevice) onData(pSample2, pSample []byte, framecount uint32) {
+	c.checkCallbackTiming(framecount)
+	for len(pSample) > 0 {
+		chunk := c.budget.get(c.queuedBytes.Load())
+		if chunk == nil {
+			n := len(pSample)
+			if c.pick != nil {
+				n = n / int(c.inputChannels*2) * len(c.pick) * 2
+			}
+			c.drop(n)
+			return
+		}
+		if c.pick == nil {
+			chunk.n = copy(chunk.buf[:], pSample)
+			pSample = pSample[chunk.n:]
+		} else {
+			var used int
+			chunk.n, used = c.pickChannels(chunk.buf[:], pSample)
+			pSample = pSample[used:]
+			if used == 0 {
+				// Only a partial frame was left
+				c.budget.put(chunk)
+				return
+			}
+		}
+		if c.muted.Load() {
+			// Keep writing frames so the timeline stays the same length
+			clear(chunk.buf[:chunk.n])
+		}
+		if c.monitored.Load() {
+			c.monitorRing.push(chunk.buf[:chunk.n], int(c.channels))
+		}
+		if c.mixed.Load() {
+			c.mixRing.push(chunk.buf[:chunk.n], int(c.channels))
+		}
+
+		chunk.gap, chunk.dropped, chunk.fill, chunk.silence = c.pendingGap, c.pendingDropped, c.pendingFill, c.pendingSilence
+		c.queuedBytes.Add(chunkSize)
+		select {
+		case c.queue <- chunk:
+			c.pendingGap, c.pendingDropped, c.pendingFill, c.pendingSilence = 0, 0, 0, 0
+		default:
+			c.queuedBytes.Add(-chunkSize)
+			c.drop(chunk.n)
+			c.budget.put(chunk)
+		}
+	}
+}
+
+// pickChannels copies the picked channels of as many whole frames from src,
+// in the device's layout, as fit in dst, returning the bytes written and
+// read.
+func (c *captureDevice) pickChannels(dst, src []byte) (int, int) {
+	inFrame, outFrame := int(c.inputChannels)*2, len(c.pick)*2
+	frames := min(len(src)/inFrame, len(dst)/outFrame)
+	for f := range frames {
+		in, out := src[f*inFrame:], dst[f*outFrame:]
+		for i, ch := range c.pick {
+			out[i*2], out[i*2+1] = in[ch*2], in[ch*2+1]
+		}
+	}
+	return frames * outFrame, frames * inFrame
+}
+
+// readPCM feeds raw PCM in the capture's format, from a source process, to
+// onData as if it came from device callbacks, until the reader ends.
+func (c *captureDevice) readPCM(r io.Reader) {
+	frame := int(c.channels) * 2
+	buf := make([]byte, int(c.sampleRate)/50*frame) // 20 ms
+	have := 0
+	for {
+		n, err := r.Read(buf[have:])
+		have += n
+		if whole := have / frame * frame; whole > 0 {
+			c.sourceMu.Lock()
+			if c.detached {
+				c.sourceMu.Unlock()
+				return
+			}
+			c.onData(nil, buf[:whole], uint32(whole/frame))
+			c.sourceMu.Unlock()
+			have = copy(buf, buf[whole:have])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// detachSource stops readPCM delivering audio, so the capture can be
+// finished while it's still blocked reading.
+func (c *captureDevice) detachSource() {
+	c.sourceMu.Lock()
+	c.detached = true
+	c.sourceMu.Unlock()
+}
+
+// drop records n bytes of audio that never made it into the queue.
+func (c *captureDevice) drop(n int) {
+	frames := uint64(n) / uint64(c.channels*2)
+	c.droppedFrames.Add(frames)
+	c.droppedBytes.Add(uint64(n))
+	c.pendingDropped += frames
+}
+
+// writeLoop drains the queue to the WAV file until the queue is closed.
+func (c *captureDevice) writeLoop() {
+	defer close(c.writerDone)
+	for chunk := range c.queue {
+		if chunk.gap > 0 || chunk.dropped > 0 || chunk.fill > 0 || chunk.silence > 0 {
+			c.logDropouts(chunk)
+		}
+		if c.gain != 1 {
+			applyGain(chunk.buf[:chunk.n], c.gain)
+		}
+		if clipped := c.meter(chunk.buf[:chunk.n]); clipped > 0 {
+			c.checkClipping(clipped)
+		}
+		c.checkSilence(chunk.buf[:chunk.n])
+		if t := c.transcriber.Load(); t != nil {
+			t.add(chunk.buf[:chunk.n], c.totalBytesWritten.Load())
+		}
+		n, err := c.write(chunk.buf[:chunk.n])
+		if err != nil {
+			fmt.Printf("Error writing audio data for %s: %v\n", c.name, err)
+		}
+		if c.tap != nil {
+			c.tap.send(chunk.buf[:chunk.n])
+		}
+		c.totalBytesWritten.Add(uint32(n))
+		bytesWrittenTotal.Add(uint64(n))
+		c.queuedBytes.Add(-chunkSize)
+		c.budget.put(chunk)
+	}
+}
+
+// out is where the writer sends audio: the sink if there is one, or else the
+// WAV file.
+func (c *captureDevice) out() io.Writer {
+	if c.sink != nil {
+		return c.sink
+	}
+	return c.file
+}
+
+// writeSilence writes d worth of silent frames straight to the file. It must
+// be called before the device is started, while the writer is idle.
+func (c *captureDevice) writeSilence(d time.Duration) error {
+	if err := c.writeZeros(d); err != nil {
+		return err
+	}
+	c.startOffset = d
+	return nil
+}
+
+// writeZeros appends d of silence to the file.
+func (c *captureDevice) writeZeros(d time.Duration) error {
+	frames := uint64(d.Seconds() * float64(c.sampleRate))
+	remaining := frames * uint64(c.channels) * 2
+	var silence [chunkSize]byte
+	for remaining > 0 {
+		n := min(remaining, chunkSize)
+		written, err := c.write(silence[:n])
+		c.totalBytesWritten.Add(uint32(written))
+		bytesWrittenTotal.Add(uint64(written))
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// meter updates the level meter with a chunk of S16 samples and returns how
+// many of them are at full scale. The level falls back gradually so a short
+// peak is still visible when sampled.
+func (c *captureDevice) meter(pcm []byte) int {
+	peak := uint32(0)
+	clipped := 0
+	for i := 0; i+1 < len(pcm); i += 2 {
+		sample := int32(int16(uint16(pcm[i]) | uint16(pcm[i+1])<<8))
+		magnitude := uint32(max(sample, -sample))
+		peak = max(peak, magnitude)
+		if magnitude >= math.MaxInt16 {
+			clipped++
+		}
+	}
+	decayed := c.level.Load() * levelDecayPerChunk / 100
+	c.level.Store(max(peak, decayed))
+	return clipped
+}
+
+// checkClipping counts clipped samples and raises an alert, at most once per
+// window, when a window has more than clipAlertSamples of them.
+func (c *captureDevice) checkClipping(clipped int) {
+	if clipAlertSamples <= 0 || !c.inSession.Load() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.clipWindowStart) > clipAlertWindow {
+		c.clipWindowStart, c.clipCount, c.clipAlerted = now, 0, false
+	}
+	c.clipCount += clipped
+	if c.clipCount > clipAlertSamples && !c.clipAlerted {
+		c.clipAlerted = true
+		raiseAlert(Alert{
+			Type:    "clipping",
+			Device:  c.name,
+			File:    filepath.Base(c.filename),
+			Message: fmt.Sprintf("%s is clipping: %d samples in %s, turn it down", c.name, c.clipCount, clipAlertWindow),
+		})
+	}
+}
+
+// checkSilence raises an alert if a device's first silentAlertAfter is all
+// digital silence, usually a device muted in the OS or a dead input, and
+// resolves it at the first sound. Loopback devices, silent whenever nothing
+// plays, and muted tracks are skipped.
+func (c *captureDevice) checkSilence(pcm []byte) {
+	if c.silenceChecked || silentAlertAfter <= 0 || c.isLoopback || !c.inSession.Load() || c.muted.Load() {
+		return
+	}
+	for _, b := range pcm {
+		if b != 0 {
+			c.silenceChecked = true
+			if c.silenceAlerted {
+				resolveAlert("silent:"+c.name, fmt.Sprintf("%s is picking up sound now", c.name))
+			}
+			return
+		}
+	}
+	c.silentBytes += uint64(len(pcm))
+	silent := time.Duration(float64(c.silentBytes) / float64(c.sampleRate*c.channels*2) * float64(time.Second))
+	if silent >= silentAlertAfter && !c.silenceAlerted {
+		c.silenceAlerted = true
+		raiseAlert(Alert{
+			Type:    "silent",
+			Device:  c.name,
+			File:    filepath.Base(c.filename),
+			Message: fmt.Sprintf("%s appears silent: nothing but digital silence for %s. Muted in the OS?", c.name, silentAlertAfter),
+		})
+	}
+}
+
+// levelDBFS returns the metered level in dBFS.
+func (c *captureDevice) levelDBFS() float64 {
+	return toDBFS(float64(c.level.Load()) / 32768)
+}
+
+// finish stops the device, flushes any queued audio and, if it records to a
+// file, rewrites the WAV header with the final size and closes the file.
+func (c *captureDevice) finish() {
+	if c.device != nil {
+		c.device.Uninit()
+	}
+	if c.stopSource != nil {
+		c.stopSource()
+	}
+	c.restoreInputVolume()
+	close(c.queue)
+	<-c.writerDone
+	c.budget.unregister()
+	if c.tap != nil {
+		c.tap.close()
+	}
+	c.padToStop()
+
+	if c.file == nil {
+		return
+	}
+	c.closeFile()
+}
+
+// discard stops the device and deletes its file, for captures that were
+// started as part of a session that failed to start.
+func (c *captureDevice) discard() {
+	c.finish()
+	os.Remove(c.filename)
+}
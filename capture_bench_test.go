@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+const (
+	// benchDevices is how many capture devices the callback is fed across.
+	benchDevices = 8
+
+	// benchFramesPerCallback matches a typical 10ms period at 44.1kHz.
+	benchFramesPerCallback = 441
+)
+
+// newBenchCaptures opens capture devices writing to the null device, with a
+// small budget whose chunk pool is filled up front so measurements reflect
+// steady state rather than the pool growing.
+func newBenchCaptures(tb testing.TB, devices int, samples []byte) []*captureDevice {
+	tb.Helper()
+	saved := captureBudget
+	captureBudget = newMemoryBudget(4 << 20)
+	captures := make([]*captureDevice, 0, devices)
+	tb.Cleanup(func() {
+		for _, cap := range captures {
+			cap.finish()
+		}
+		captureBudget = saved
+	})
+
+	var chunks []*audioChunk
+	for chunk := captureBudget.get(0); chunk != nil; chunk = captureBudget.get(0) {
+		chunks = append(chunks, chunk)
+	}
+	for _, chunk := range chunks {
+		captureBudget.put(chunk)
+	}
+
+	for i := 0; i < devices; i++ {
+		file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		captures = append(captures, newCaptureDevice(fmt.Sprintf("bench-%d", i), file, os.DevNull, 44100, 1))
+	}
+	for i := 0; i < 1000; i++ {
+		captures[i%devices].onData(nil, samples, benchFramesPerCallback)
+	}
+	return captures
+}
+
+// BenchmarkOnData measures one data callback, round robin across devices.
+func BenchmarkOnData(b *testing.B) {
+	samples := make([]byte, benchFramesPerCallback*2)
+	captures := newBenchCaptures(b, benchDevices, samples)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		captures[i%benchDevices].onData(nil, samples, benchFramesPerCallback)
+	}
+}
+
+// TestOnDataAllocationFree checks the data callback copies into pooled,
+// preallocated buffers rather than allocating in steady state.
+func TestOnDataAllocationFree(t *testing.T) {
+	samples := make([]byte, benchFramesPerCallback*2)
+	captures := newBenchCaptures(t, benchDevices, samples)
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		captures[i%benchDevices].onData(nil, samples, benchFramesPerCallback)
+		i++
+	})
+	if allocs != 0 {
+		t.Errorf("data callback allocates %.2f times per call, want 0", allocs)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// loadCatalog reads the metadata of every finished session in the output
+// directory, oldest first. Sidecars that can't be read are skipped, so one
+// damaged file doesn't hide the rest.
+func loadCatalog() []*SessionManifest {
+	paths, _ := filepath.Glob(filepath.Join(outputDirectory, "*.json"))
+	var manifests []*SessionManifest
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !validSessionID(id) {
+			continue
+		}
+		manifest, err := readSessionManifest(id)
+		if err != nil || manifest.ID != id {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	slices.SortFunc(manifests, func(a, b *SessionManifest) int { return a.StartedAt.Compare(b.StartedAt) })
+	return manifests
+}
+
+// inCatalog reports whether name is a file the recorder wrote or ingested
+// into the output directory: a track or sidecar of a finished session, or a
+// track of the running one. Anything else found there isn't served.
+func inCatalog(name string) bool {
+	return catalogFiles()[name]
+}
+
+// catalogFiles lists the file names inCatalog accepts.
+func catalogFiles() map[string]bool {
+	files := map[string]bool{}
+	recordingMutex.Lock()
+	if activeSession != nil {
+		for _, cap := range activeSession.captures {
+			for _, name := range cap.segmentFiles() {
+				files[name] = true
+			}
+		}
+		for _, track := range activeSession.removed {
+			files[track.File] = true
+		}
+	}
+	recordingMutex.Unlock()
+
+	for _, manifest := range loadCatalog() {
+		files[manifest.ID+".json"] = true
+		for _, name := range manifest.files() {
+			files[name] = true
+		}
+	}
+	return files
+}
+
+// files lists the files a finished session is made of, other than its
+// sidecar: tracks, the transcript, clips and exports.
+func (m *SessionManifest) files() []string {
+	var files []string
+	if m.Transcript != "" {
+		files = append(files, m.Transcript)
+	}
+	for _, track := range m.Tracks {
+		files = append(files, track.File)
+		for _, s := range track.Segments[min(1, len(track.Segments)):] {
+			files = append(files, s.File)
+		}
+	}
+	for _, clip := range m.Clips {
+		for _, f := range clip.Files {
+			files = append(files, f.File)
+		}
+	}
+	for _, export := range m.Exports {
+		files = append(files, export.File)
+	}
+	return files
+}
+
+// openRecording opens a file in the output directory by name. The name must
+// be a plain file name, and the file is opened through an os.Root, so neither
+// ".." nor a symlink can reach outside the directory. A file that's been
+// moved to -storage is fetched from there.
+func openRecording(name string) (*os.File, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return nil, os.ErrNotExist
+	}
+	root, err := os.OpenRoot(outputDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	f, err := root.Open(name)
+	if errors.Is(err, os.ErrNotExist) && store != nil {
+		return fetchCached(name)
+	}
+	return f, err
+}
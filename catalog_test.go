@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestOpenRecording(t *testing.T) {
+	setupRecordings(t)
+
+	for _, name := range []string{testTrack, "inside.wav"} {
+		f, err := openRecording(name)
+		if err != nil {
+			t.Errorf("openRecording(%q): %v", name, err)
+			continue
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != "RIFF" {
+			t.Errorf("openRecording(%q) read %q", name, data)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "../secret.txt", "sub/" + testTrack, `..\secret.txt`, "missing.wav"} {
+		if f, err := openRecording(name); !errors.Is(err, os.ErrNotExist) {
+			if f != nil {
+				f.Close()
+			}
+			t.Errorf("openRecording(%q): error %v, want one that's os.ErrNotExist", name, err)
+		}
+	}
+
+	// os.Root refuses a symlink that leads out of the folder
+	if f, err := openRecording("escape.wav"); err == nil {
+		f.Close()
+		t.Error("openRecording followed a symlink out of the recordings folder")
+	}
+}
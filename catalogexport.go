@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionStats summarizes one session for the catalog export
+type SessionStats struct {
+	ID              string     `json:"id"`
+	Title           string     `json:"title,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
+	Starred         bool       `json:"starred,omitempty"`
+	StartedAt       time.Time  `json:"startedAt"`
+	StoppedAt       time.Time  `json:"stoppedAt"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	SizeBytes       int64      `json:"sizeBytes"` // of the tracks
+	Tracks          int        `json:"tracks"`
+	Markers         int        `json:"markers"`
+	Clips           int        `json:"clips"`
+	Source          string     `json:"source,omitempty"`
+	Word            string     `json:"word,omitempty"`
+	Archived        bool       `json:"archived,omitempty"`
+	Transcribed     bool       `json:"transcribed"`
+	TalkTime        []TalkTime `json:"talkTime,omitempty"`
+}
+
+// TalkTime is how long one speaker, or device, talked, from the transcript
+type TalkTime struct {
+	Speaker  string  `json:"speaker,omitempty"`
+	Device   string  `json:"device,omitempty"`
+	Seconds  float64 `json:"seconds"`
+	Share    float64 `json:"share"`              // of all talk in the session, or overall
+	Sessions int     `json:"sessions,omitempty"` // sessions they talked in, in the totals
+}
+
+// CatalogTotals adds up the exported sessions
+type CatalogTotals struct {
+	Sessions        int        `json:"sessions"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	SizeBytes       int64      `json:"sizeBytes"`
+	TalkTime        []TalkTime `json:"talkTime"`
+}
+
+// sessionStats summarizes a finished session. The duration is the wall
+// clock time it recorded for, or its longest track for imported files.
+func sessionStats(manifest *SessionManifest) SessionStats {
+	stats := SessionStats{
+		ID:          manifest.ID,
+		Title:       manifest.Title,
+		Tags:        manifest.Tags,
+		Starred:     manifest.Starred,
+		StartedAt:   manifest.StartedAt,
+		StoppedAt:   manifest.StoppedAt,
+		Tracks:      len(manifest.Tracks),
+		Markers:     len(manifest.Markers),
+		Clips:       len(manifest.Clips),
+		Source:      manifest.Source,
+		Word:        manifest.Word,
+		Archived:    manifest.Archived != nil,
+		Transcribed: manifest.Transcript != "",
+	}
+	stats.DurationSeconds = manifest.StoppedAt.Sub(manifest.StartedAt).Seconds()
+	for _, track := range manifest.Tracks {
+		stats.SizeBytes += track.Size
+		stats.DurationSeconds = max(stats.DurationSeconds, track.StartOffset+track.DurationSeconds)
+	}
+	stats.DurationSeconds = roundMillis(stats.DurationSeconds)
+	if stats.Transcribed {
+		if segments, err := readTranscript(manifest.ID); err == nil {
+			labelSpeakers(segments, manifest.Tracks)
+			labelSpeakers(segments, nil)
+			stats.TalkTime = talkTime(segments, false)
+		}
+	}
+	return stats
+}
+
+// talkTime adds up how long each speaker, or device, talked, counting
+// overlapping segments of the same one once, most talkative first.
+func talkTime(segments []TranscriptSegment, byDevice bool) []TalkTime {
+	grouped := map[string][]TranscriptSegment{}
+	for _, segment := range segments {
+		if segment.End > segment.Start {
+			key := segment.Speaker
+			if byDevice {
+				key = segment.Device
+			}
+			grouped[key] = append(grouped[key], segment)
+		}
+	}
+	var result []TalkTime
+	var total float64
+	for key, segments := range grouped {
+		slices.SortFunc(segments, func(a, b TranscriptSegment) int { return cmp.Compare(a.Start, b.Start) })
+		var seconds, end float64
+		for _, segment := range segments {
+			start := max(segment.Start, end)
+			if segment.End > start {
+				seconds += segment.End - start
+			}
+			end = max(end, segment.End)
+		}
+		talk := TalkTime{Speaker: key, Seconds: seconds}
+		if byDevice {
+			talk = TalkTime{Device: key, Seconds: seconds}
+		}
+		result = append(result, talk)
+		total += seconds
+	}
+	for i := range result {
+		result[i].Share = math.Round(result[i].Seconds/total*1000) / 1000
+		result[i].Seconds = roundMillis(result[i].Seconds)
+	}
+	sortTalkTime(result)
+	return result
+}
+
+// sortTalkTime puts the most talkative speakers first.
+func sortTalkTime(talk []TalkTime) {
+	slices.SortFunc(talk, func(a, b TalkTime) int {
+		if c := cmp.Compare(b.Seconds, a.Seconds); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Speaker+a.Device, b.Speaker+b.Device)
+	})
+}
+
+// catalogTotals adds up sessions' stats.
+func catalogTotals(sessions []SessionStats) CatalogTotals {
+	totals := CatalogTotals{Sessions: len(sessions)}
+	var talk [][]TalkTime
+	for _, s := range sessions {
+		totals.DurationSeconds += s.DurationSeconds
+		totals.SizeBytes += s.SizeBytes
+		talk = append(talk, s.TalkTime)
+	}
+	totals.DurationSeconds = roundMillis(totals.DurationSeconds)
+	totals.TalkTime = sumTalkTime(talk)
+	return totals
+}
+
+// sumTalkTime adds up several sessions' talk time, counting the sessions
+// each speaker or device talked in.
+func sumTalkTime(sessions [][]TalkTime) []TalkTime {
+	sums := map[string]*TalkTime{}
+	var total float64
+	for _, talk := range sessions {
+		for _, t := range talk {
+			key := t.Speaker + "\x00" + t.Device
+			if sums[key] == nil {
+				sums[key] = &TalkTime{Speaker: t.Speaker, Device: t.Device}
+			}
+			sums[key].Seconds += t.Seconds
+			sums[key].Sessions++
+			total += t.Seconds
+		}
+	}
+	result := []TalkTime{}
+	for _, t := range sums {
+		t.Share = math.Round(t.Seconds/total*1000) / 1000
+		t.Seconds = roundMillis(t.Seconds)
+		result = append(result, *t)
+	}
+	sortTalkTime(result)
+	return result
+}
+
+// writeCatalogCSV writes a row per session, with a talk time column for
+// each speaker, ready for a spreadsheet.
+func writeCatalogCSV(w io.Writer, sessions []SessionStats) error {
+	var speakers []string
+	for _, s := range sessions {
+		for _, t := range s.TalkTime {
+			if !slices.Contains(speakers, t.Speaker) {
+				speakers = append(speakers, t.Speaker)
+			}
+		}
+	}
+	slices.Sort(speakers)
+
+	out := csv.NewWriter(w)
+	header := []string{"id", "title", "tags", "started_at", "stopped_at", "duration_seconds", "size_bytes", "tracks", "markers", "clips", "source", "word", "archived", "transcribed"}
+	for _, speaker := range speakers {
+		header = append(header, "talk_seconds:"+speaker)
+	}
+	out.Write(header)
+	for _, s := range sessions {
+		row := []string{
+			s.ID,
+			s.Title,
+			strings.Join(s.Tags, ";"),
+			s.StartedAt.Format(time.RFC3339),
+			s.StoppedAt.Format(time.RFC3339),
+			strconv.FormatFloat(s.DurationSeconds, 'f', 3, 64),
+			strconv.FormatInt(s.SizeBytes, 10),
+			strconv.Itoa(s.Tracks),
+			strconv.Itoa(s.Markers),
+			strconv.Itoa(s.Clips),
+			s.Source,
+			s.Word,
+			strconv.FormatBool(s.Archived),
+			strconv.FormatBool(s.Transcribed),
+		}
+		for _, speaker := range speakers {
+			i := slices.IndexFunc(s.TalkTime, func(t TalkTime) bool { return t.Speaker == speaker })
+			if i < 0 {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(s.TalkTime[i].Seconds, 'f', 3, 64))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// parseExportTime parses a ?from= or ?to= bound, a date or an RFC 3339 time.
+// A date as ?to= includes the whole day.
+func parseExportTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want a date like 2024-01-31 or an RFC 3339 time")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Handler: GET /api/export/catalog?format=json - Every finished session with
+// its duration, size, tags and talk time per speaker, oldest first, for
+// analysis in a spreadsheet. ?format=csv gives a row per session; ?from= and
+// ?to= (dates or RFC 3339 times), ?tag=, ?collection= (an ID) and
+// ?starred=true pick sessions.
+func handleExportCatalog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "format must be json or csv")
+		return
+	}
+	var from, to time.Time
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = parseExportTime(value, false); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "from: "+err.Error())
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = parseExportTime(value, true); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "to: "+err.Error())
+			return
+		}
+	}
+	tag := query.Get("tag")
+	starred, err := starredFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	var inCollection []string
+	if id := query.Get("collection"); id != "" {
+		var ok bool
+		if inCollection, ok = collectionSessions(id); !ok {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+			return
+		}
+	}
+
+	sessions := []SessionStats{}
+	for _, manifest := range loadCatalog() {
+		if !from.IsZero() && manifest.StartedAt.Before(from) ||
+			!to.IsZero() && !manifest.StartedAt.Before(to) ||
+			tag != "" && !slices.Contains(manifest.Tags, tag) ||
+			inCollection != nil && !slices.Contains(inCollection, manifest.ID) ||
+			starred != nil && manifest.Starred != *starred {
+			continue
+		}
+		sessions = append(sessions, sessionStats(manifest))
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=catalog.csv")
+		writeCatalogCSV(w, sessions)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+		"totals":   catalogTotals(sessions),
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultClipBefore = 10.0
+	defaultClipAfter  = 5.0
+
+	// maxClipSeconds bounds a clip's length, which is held in memory while
+	// it's cut.
+	maxClipSeconds = 300.0
+)
+
+// ClipRequest is the request body for cutting clips around markers
+type ClipRequest struct {
+	Markers []int    `json:"markers"` // indices into the session's markers; all if empty
+	Before  *float64 `json:"before"`  // seconds before each marker, default 10
+	After   *float64 `json:"after"`   // seconds after each marker, default 5
+	Mixdown bool     `json:"mixdown"` // one mixed file per marker instead of one per track
+}
+
+// ClipInfo describes a clip cut from a finished session
+type ClipInfo struct {
+	Marker int        `json:"marker"` // index into the session's markers
+	Label  string     `json:"label,omitempty"`
+	Start  float64    `json:"start"` // seconds from the start of the session
+	End    float64    `json:"end"`
+	Files  []ClipFile `json:"files"`
+}
+
+// ClipFile is one file of a clip: a track's part of it, or the mixdown
+type ClipFile struct {
+	File   string `json:"file"`
+	Device string `json:"device"` // empty for a mixdown
+}
+
+// Handler: POST /api/sessions/{id}/clips - Cut a few seconds either side of
+// markers from every track, or from a mixdown of them, into WAV files ready
+// to share. The clips are added to the session's manifest.
+func handleCreateClips(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	var req ClipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	before, after := defaultClipBefore, defaultClipAfter
+	if req.Before != nil {
+		before = *req.Before
+	}
+	if req.After != nil {
+		after = *req.After
+	}
+	if before < 0 || after < 0 || before+after <= 0 || before+after > maxClipSeconds {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("before and after must add up to between 0 and %g seconds", maxClipSeconds))
+		return
+	}
+
+	recordingMutex.Lock()
+	recording := activeSession != nil && activeSession.id == id
+	recordingMutex.Unlock()
+	if recording {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Session is still recording; stop it before cutting clips")
+		return
+	}
+
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	selected := req.Markers
+	if len(selected) == 0 {
+		for i := range manifest.Markers {
+			selected = append(selected, i)
+		}
+	}
+	if len(selected) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Session has no markers")
+		return
+	}
+	for _, i := range selected {
+		if i < 0 || i >= len(manifest.Markers) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Invalid marker index: %d", i))
+			return
+		}
+	}
+	if req.Mixdown {
+		for _, track := range manifest.Tracks {
+			if track.SampleRate != manifest.Tracks[0].SampleRate {
+				writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Tracks have different sample rates and can't be mixed; clip them separately")
+				return
+			}
+		}
+	}
+
+	// The clips are cut without holding manifestMu, and added to the
+	// metadata as it is by then
+	clips := []ClipInfo{}
+	removeClips := func() {
+		for _, clip := range clips {
+			for _, f := range clip.Files {
+				os.Remove(filepath.Join(outputDirectory, f.File))
+			}
+		}
+	}
+	for _, i := range selected {
+		marker := manifest.Markers[i]
+		clip := ClipInfo{
+			Marker: i,
+			Label:  marker.Label,
+			Start:  roundMillis(max(marker.Time-before, 0)),
+			End:    roundMillis(marker.Time + after),
+		}
+		var length float64
+		clip.Files, length, err = cutClip(manifest, fmt.Sprintf("%s_clip%d", id, i+1), clip, req.Mixdown)
+		if err != nil {
+			removeClips()
+			writeStorageError(w, errCodeInternal, err)
+			return
+		}
+		clip.End = roundMillis(clip.Start + length)
+		clips = append(clips, clip)
+	}
+
+	if err := updateManifest(id, func(m *SessionManifest) { m.Clips = append(m.Clips, clips...) }); err != nil {
+		removeClips()
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	fmt.Printf("✂️  Cut %d clip(s) from session %s\n", len(clips), id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"clips":     clips,
+	})
+}
+
+// cutClip writes one clip's files, named after base, returning them and the
+// length of the longest, which is short if the session ends first. Every
+// track starts at the start of the session, since late tracks are padded to
+// line up, so the same section is cut from each.
+func cutClip(manifest *SessionManifest, base string, clip ClipInfo, mixdown bool) ([]ClipFile, float64, error) {
+	var files []ClipFile
+	var mix []int32
+	var mixRate uint32
+	var length float64
+	for _, track := range manifest.Tracks {
+		pcm, rate, channels, err := readTrackSection(track.File, clip.Start, clip.End)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %w", track.File, err)
+		}
+		if len(pcm) == 0 {
+			// The track had already stopped
+			continue
+		}
+		length = max(length, float64(len(pcm))/float64(rate*channels*2))
+		if !mixdown {
+			name, err := writeClipFile(base, clip.Label, track.Device, pcm, rate, channels)
+			if err != nil {
+				return nil, 0, err
+			}
+			files = append(files, ClipFile{File: name, Device: track.Device})
+			continue
+		}
+
+		// Each track is folded to mono and summed
+		frames := len(pcm) / 2 / int(channels)
+		if frames > len(mix) {
+			mix = append(mix, make([]int32, frames-len(mix))...)
+		}
+		for f := range frames {
+			var sum int32
+			for c := range int(channels) {
+				i := (f*int(channels) + c) * 2
+				sum += int32(int16(binary.LittleEndian.Uint16(pcm[i:])))
+			}
+			mix[f] += sum / int32(channels)
+		}
+		mixRate = rate
+	}
+
+	if mixdown && len(mix) > 0 {
+		pcm := make([]byte, 0, len(mix)*2)
+		for _, v := range mix {
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(min(max(v, -32768), 32767))))
+		}
+		name, err := writeClipFile(base, clip.Label, "mix", pcm, mixRate, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		files = append(files, ClipFile{File: name})
+	}
+	if files == nil {
+		return nil, 0, fmt.Errorf("no track has audio between %s and %s", formatElapsed(time.Duration(clip.Start*float64(time.Second))), formatElapsed(time.Duration(clip.End*float64(time.Second))))
+	}
+	return files, length, nil
+}
+
+// readTrackSection decodes the part of a track between two times as 16-bit
+// PCM, which is short or empty where the track has no audio.
+func readTrackSection(name string, start, end float64) ([]byte, uint32, uint32, error) {
+	f, err := openRecording(name)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	frame := int64(info.Channels) * 2
+	skip := int64(start*float64(info.SampleRate)) * frame
+	length := int64((end-start)*float64(info.SampleRate)) * frame
+	if seeker, ok := pcm.(io.Seeker); ok {
+		_, err = seeker.Seek(skip, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, pcm, skip)
+	}
+	if err == io.EOF {
+		return nil, info.SampleRate, info.Channels, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	data, err := io.ReadAll(io.LimitReader(pcm, length))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return data[:int64(len(data))/frame*frame], info.SampleRate, info.Channels, nil
+}
+
+// writeClipFile saves a clip as a WAV file next to the recordings.
+func writeClipFile(base, label, device string, pcm []byte, sampleRate, channels uint32) (string, error) {
+	if label != "" {
+		base += "_" + sanitizeFilename(label)
+	}
+	name, f, release, err := createExportFile(base+"_"+sanitizeFilename(device), ".wav")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	err = writeWAVHeader(f, sampleRate, channels, 16, uint32(len(pcm)))
+	if err == nil {
+		_, err = f.Write(pcm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// createExportFile creates a file next to the recordings for something made
+// from a session, such as a clip, named base+ext or numbered if that's taken.
+// The watcher leaves it alone until release is called, by which time it
+// should be listed in the session's manifest.
+func createExportFile(base, ext string) (string, *os.File, func(), error) {
+	ingestMu.Lock()
+	defer ingestMu.Unlock()
+	name := uniqueFilename(outputDirectory, base, ext)
+	f, err := createNewFile(filepath.Join(outputDirectory, name))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	importing[name] = true
+	release := func() {
+		ingestMu.Lock()
+		delete(importing, name)
+		ingestMu.Unlock()
+	}
+	return name, f, release, nil
+}
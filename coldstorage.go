@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveStore is where old sessions are moved to, from -archive: somewhere
+// cheap and slow, such as a USB drive or an S3 bucket with a cold storage
+// class. Nil disables archiving.
+var archiveStore recordingStore
+
+// archiveFormat is what tracks are transcoded to when they're archived, from
+// -archive-format: flac or opus.
+var archiveFormat = "flac"
+
+// archiveAfter archives sessions automatically once they've been finished,
+// or restored, this long, from -archive-after. 0 leaves it to /api/jobs.
+var archiveAfter time.Duration
+
+// archiveCheckInterval is how often sessions are checked against
+// archiveAfter.
+const archiveCheckInterval = time.Hour
+
+// runArchive transcodes a session's tracks to archiveFormat and moves its
+// audio, tracks, clips and exports, to the archive, along with a copy of its
+// sidecar. The sidecar and transcript stay, so the session is still in the
+// catalog, marked archived, until it's restored.
+func runArchive(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	if manifest.Archived != nil {
+		return nil, fmt.Errorf("session %s is already archived", manifest.ID)
+	}
+	format := transcodeFormats[archiveFormat]
+
+	var made []string
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	fail := func(err error) ([]string, error) {
+		for _, name := range made {
+			os.Remove(filepath.Join(outputDirectory, name))
+		}
+		return nil, err
+	}
+
+	tracks := slices.Clone(manifest.Tracks)
+	var others []string
+	for _, name := range manifest.files() {
+		if name != manifest.Transcript && !slices.ContainsFunc(tracks, func(t TrackInfo) bool { return t.File == name }) {
+			others = append(others, name)
+		}
+	}
+	steps := float64(len(tracks) + len(others))
+
+	var archived, originals []string
+	for i, track := range tracks {
+		in, err := openRecording(track.File)
+		if err != nil {
+			return fail(err)
+		}
+		in.Close()
+		path := in.Name()
+		if !strings.EqualFold(filepath.Ext(track.File), format.ext) {
+			name, out, release, err := createExportFile(trackBase(track), format.ext)
+			if err != nil {
+				return fail(err)
+			}
+			out.Close()
+			releases = append(releases, release)
+			made = append(made, name)
+			path = filepath.Join(outputDirectory, name)
+			err = ffmpegTranscode(ctx, in.Name(), path, format, track, func(p float64) {
+				progress((float64(i) + p*0.8) / steps)
+			})
+			if err != nil {
+				return fail(err)
+			}
+			size, sum, err := checksumFile(path)
+			if err != nil {
+				return fail(fmt.Errorf("failed to checksum %s: %w", name, err))
+			}
+			originals = append(originals, track.File)
+			tracks[i].File, tracks[i].Format, tracks[i].Size, tracks[i].SHA256 = name, archiveFormat, size, sum
+		}
+		if err := archiveStore.put(tracks[i].File, path, &transfer{ctx: ctx}); err != nil {
+			return fail(fmt.Errorf("failed to archive %s in %s: %v", tracks[i].File, archiveStore, err))
+		}
+		archived = append(archived, tracks[i].File)
+		progress(float64(i+1) / steps)
+	}
+	for i, name := range others {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		in, err := openRecording(name)
+		if err != nil {
+			return fail(err)
+		}
+		in.Close()
+		if err := archiveStore.put(name, in.Name(), &transfer{ctx: ctx}); err != nil {
+			return fail(fmt.Errorf("failed to archive %s in %s: %v", name, archiveStore, err))
+		}
+		archived = append(archived, name)
+		progress(float64(len(tracks)+i+1) / steps)
+	}
+
+	// Nothing is removed until the sidecar says where the audio went. The
+	// upload the new sidecar queues waits for the removal, so it doesn't
+	// copy the transcoded tracks to -storage.
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	info := &ArchiveInfo{At: time.Now().UTC(), Location: archiveStore.String(), Format: archiveFormat, Files: archived}
+	err = updateManifest(manifest.ID, func(m *SessionManifest) {
+		m.Tracks = tracks
+		m.Archived = info
+		m.RestoredAt = nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := archiveStore.put(manifest.ID+".json", sessionManifestPath(manifest.ID), nil); err != nil {
+		fmt.Printf("⚠️  Failed to archive the metadata of %s: %v\n", manifest.ID, err)
+	}
+	for _, name := range append(originals, archived...) {
+		if err := os.Remove(filepath.Join(outputDirectory, name)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("⚠️  Failed to remove archived %s: %v\n", name, err)
+		}
+		if store != nil {
+			if err := unstore(name); err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+			}
+		}
+	}
+	fmt.Printf("✓ Archived %s to %s (%d files)\n", manifest.ID, archiveStore, len(archived))
+	return archived, nil
+}
+
+// runRestore copies an archived session's audio back to the recordings
+// folder, from where -storage takes it as usual. The archive keeps its copy.
+func runRestore(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	if manifest.Archived == nil {
+		return nil, fmt.Errorf("session %s isn't archived", manifest.ID)
+	}
+	var restored []string
+	for i, name := range manifest.Archived.Files {
+		if err := ctx.Err(); err != nil {
+			return restored, err
+		}
+		progress(float64(i) / float64(len(manifest.Archived.Files)))
+		path := filepath.Join(outputDirectory, name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := fetchFile(archiveStore, name, path); err != nil {
+			return restored, fmt.Errorf("failed to restore %s from %s: %v", name, archiveStore, err)
+		}
+		restored = append(restored, name)
+	}
+	now := time.Now().UTC()
+	err = updateManifest(manifest.ID, func(m *SessionManifest) {
+		m.Archived = nil
+		m.RestoredAt = &now
+	})
+	if err != nil {
+		return restored, err
+	}
+	fmt.Printf("✓ Restored %s from %s\n", manifest.ID, archiveStore)
+	return restored, nil
+}
+
+// archiveOldSessions queues archive jobs for sessions older than
+// archiveAfter, other than starred ones, every archiveCheckInterval.
+func archiveOldSessions() {
+	for {
+		for _, manifest := range loadCatalog() {
+			last := manifest.StoppedAt
+			if manifest.RestoredAt != nil && manifest.RestoredAt.After(last) {
+				last = *manifest.RestoredAt
+			}
+			if manifest.Archived != nil || manifest.Starred || time.Since(last) < archiveAfter {
+				continue
+			}
+			if _, err := enqueueJob(JobRequest{Kind: jobArchive, SessionID: manifest.ID, Priority: jobPriorityLow}); err != nil {
+				fmt.Printf("⚠️  Failed to queue archiving of %s: %v\n", manifest.ID, err)
+			}
+		}
+		time.Sleep(archiveCheckInterval)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collection groups sessions under a name, such as "Campaign 2" or "Podcast
+// S01", however they're laid out on disk. A session can be in any number.
+type Collection struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Sessions  []string  `json:"sessions"` // IDs, in the order they were added
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CollectionRequest is the request body for POST /api/collections and
+// PATCH /api/collections/{id}
+type CollectionRequest struct {
+	Name     string   `json:"name"`
+	Sessions []string `json:"sessions"`
+}
+
+// collections are kept in the recordings folder, alongside the sessions.
+var collections struct {
+	sync.Mutex
+	list []Collection
+}
+
+func collectionsPath() string {
+	return filepath.Join(outputDirectory, ".collections.json")
+}
+
+// loadCollections reads the saved collections.
+func loadCollections() error {
+	data, err := os.ReadFile(collectionsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	collections.Lock()
+	defer collections.Unlock()
+	if err := json.Unmarshal(data, &collections.list); err != nil {
+		return fmt.Errorf("invalid collections file %s: %w", collectionsPath(), err)
+	}
+	return nil
+}
+
+// saveCollections writes the collections. Must be called with collections
+// locked.
+func saveCollections() error {
+	data, _ := json.MarshalIndent(collections.list, "", "  ")
+	return writeFileAtomic(collectionsPath(), append(data, '\n'))
+}
+
+// findCollection returns the collection with an ID, or nil. Must be called
+// with collections locked.
+func findCollection(id string) *Collection {
+	i := slices.IndexFunc(collections.list, func(c Collection) bool { return c.ID == id })
+	if i < 0 {
+		return nil
+	}
+	return &collections.list[i]
+}
+
+// collectionSessions returns the sessions in a collection, and whether there
+// is one with the ID.
+func collectionSessions(id string) ([]string, bool) {
+	collections.Lock()
+	defer collections.Unlock()
+	c := findCollection(id)
+	if c == nil {
+		return nil, false
+	}
+	return slices.Clone(c.Sessions), true
+}
+
+// decodeCollectionRequest reads a collection request body, writing an error
+// and returning false if it's not valid.
+func decodeCollectionRequest(w http.ResponseWriter, r *http.Request) (CollectionRequest, bool) {
+	var req CollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	for _, id := range req.Sessions {
+		if !validSessionID(id) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID: "+id)
+			return req, false
+		}
+		if _, err := readSessionManifest(id); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "No such session: "+id)
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// writeCollection writes a collection, or a storage error if it couldn't be
+// saved. Must be called with collections locked.
+func writeCollection(w http.ResponseWriter, status int, c *Collection) {
+	if err := saveCollections(); err != nil {
+		writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save collections: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+// Handler: GET /api/collections - List the collections, by name
+func handleListCollections(w http.ResponseWriter, r *http.Request) {
+	collections.Lock()
+	list := slices.Clone(collections.list)
+	collections.Unlock()
+	if list == nil {
+		list = []Collection{}
+	}
+	slices.SortFunc(list, func(a, b Collection) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: POST /api/collections - Create a collection, optionally with
+// sessions
+func handleCreateCollection(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCollectionRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "A collection needs a name")
+		return
+	}
+	now := time.Now().UTC()
+	c := Collection{ID: randomHex(4), Name: req.Name, Sessions: union([]string{}, req.Sessions), CreatedAt: now, UpdatedAt: now}
+	collections.Lock()
+	defer collections.Unlock()
+	collections.list = append(collections.list, c)
+	writeCollection(w, http.StatusCreated, &c)
+}
+
+// Handler: GET /api/collections/{id} - Get a collection with a summary of
+// each of its sessions, as in the catalog export
+func handleGetCollection(w http.ResponseWriter, r *http.Request) {
+	collections.Lock()
+	c := findCollection(r.PathValue("id"))
+	var found Collection
+	if c != nil {
+		found = *c
+	}
+	collections.Unlock()
+	if c == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+		return
+	}
+	sessions := []SessionStats{}
+	for _, id := range found.Sessions {
+		if manifest, err := readSessionManifest(id); err == nil {
+			sessions = append(sessions, sessionStats(manifest))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"collection": found,
+		"sessions":   sessions,
+		"totals":     catalogTotals(sessions),
+	})
+}
+
+// Handler: PATCH /api/collections/{id} - Rename a collection
+func handleRenameCollection(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCollectionRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "A collection needs a name")
+		return
+	}
+	collections.Lock()
+	defer collections.Unlock()
+	c := findCollection(r.PathValue("id"))
+	if c == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+		return
+	}
+	c.Name = req.Name
+	c.UpdatedAt = time.Now().UTC()
+	writeCollection(w, http.StatusOK, c)
+}
+
+// Handler: DELETE /api/collections/{id} - Delete a collection, leaving its
+// sessions alone
+func handleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	collections.Lock()
+	defer collections.Unlock()
+	id := r.PathValue("id")
+	i := slices.IndexFunc(collections.list, func(c Collection) bool { return c.ID == id })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+		return
+	}
+	collections.list = slices.Delete(collections.list, i, i+1)
+	if err := saveCollections(); err != nil {
+		writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save collections: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler: POST /api/collections/{id}/sessions - Add sessions to a collection
+func handleAddToCollection(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeCollectionRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Sessions) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "List the sessions to add")
+		return
+	}
+	collections.Lock()
+	defer collections.Unlock()
+	c := findCollection(r.PathValue("id"))
+	if c == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+		return
+	}
+	c.Sessions = union(c.Sessions, req.Sessions)
+	c.UpdatedAt = time.Now().UTC()
+	writeCollection(w, http.StatusOK, c)
+}
+
+// Handler: DELETE /api/collections/{id}/sessions/{sessionId} - Take a session
+// out of a collection, leaving the session alone
+func handleRemoveFromCollection(w http.ResponseWriter, r *http.Request) {
+	collections.Lock()
+	defer collections.Unlock()
+	c := findCollection(r.PathValue("id"))
+	if c == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Collection not found")
+		return
+	}
+	sessionID := r.PathValue("sessionId")
+	if !slices.Contains(c.Sessions, sessionID) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "The session isn't in the collection")
+		return
+	}
+	c.Sessions = slices.DeleteFunc(c.Sessions, func(id string) bool { return id == sessionID })
+	c.UpdatedAt = time.Now().UTC()
+	writeCollection(w, http.StatusOK, c)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Remote integrations drive the recorder with a small set of commands, each
+// carried out by the same handler as the equivalent HTTP request.
+
+// RemoteCommand is a control message from a remote integration such as a
+// Stream Deck plugin
+type RemoteCommand struct {
+	Action string `json:"action"` // "toggle", "start", "stop", "marker" or "state"
+	Preset string `json:"preset,omitempty"`
+	Label  string `json:"label,omitempty"`
+
+	// Title and Tags name a session being started
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// CommandResult answers a command with the API response it produced
+type CommandResult struct {
+	Event    string          `json:"event"` // always "result"
+	Action   string          `json:"action"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// callAPI runs an API handler in-process, so commands behave exactly like
+// the equivalent HTTP request.
+func callAPI(ctx context.Context, handler http.HandlerFunc, method string, body interface{}, pathValues map[string]string) (int, json.RawMessage) {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequestWithContext(ctx, method, "/", bytes.NewReader(payload))
+	for k, v := range pathValues {
+		req.SetPathValue(k, v)
+	}
+	rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	handler(rec, req)
+	return rec.status, bytes.TrimSpace(rec.body.Bytes())
+}
+
+// apiError returns a handler that always fails with the given error.
+func apiError(status int, code, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, status, code, message)
+	}
+}
+
+// runCommand carries out one remote command and returns its result.
+func runCommand(ctx context.Context, cmd RemoteCommand) CommandResult {
+	recordingMutex.Lock()
+	recording := activeSession != nil
+	sessionID := ""
+	if recording {
+		sessionID = activeSession.id
+	}
+	lastDevices := lastDeviceIndices
+	recordingMutex.Unlock()
+
+	action := cmd.Action
+	if action == "toggle" {
+		action = "start"
+		if recording {
+			action = "stop"
+		}
+	}
+
+	var status int
+	var response json.RawMessage
+	switch action {
+	case "start":
+		switch {
+		case cmd.Preset != "":
+			status, response = callAPI(ctx, handleQuickstart, http.MethodPost, QuickstartRequest{Title: cmd.Title, Tags: cmd.Tags}, map[string]string{"preset": cmd.Preset})
+		case len(lastDevices) > 0:
+			status, response = callAPI(ctx, handleStartRecording, http.MethodPost, StartRecordingRequest{DeviceIndices: lastDevices, Title: cmd.Title, Tags: cmd.Tags}, nil)
+		default:
+			// Nothing has been recorded since the server started, but
+			// something may have been before
+			status, response = callAPI(ctx, handleQuickstartLast, http.MethodPost, QuickstartRequest{Title: cmd.Title, Tags: cmd.Tags}, nil)
+		}
+	case "stop":
+		status, response = callAPI(ctx, handleStopRecording, http.MethodPost, nil, nil)
+	case "marker":
+		if !recording {
+			status, response = callAPI(ctx, apiError(http.StatusConflict, errCodeNotRecording, "Not currently recording"), http.MethodPost, nil, nil)
+			break
+		}
+		status, response = callAPI(ctx, handleAddMarker, http.MethodPost, MarkerRequest{Label: cmd.Label}, map[string]string{"id": sessionID})
+	case "state":
+		state, _ := streamDeckState()
+		response, _ = json.Marshal(state)
+		status = http.StatusOK
+	default:
+		status, response = callAPI(ctx, apiError(http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Unknown action: %q", cmd.Action)), http.MethodPost, nil, nil)
+	}
+
+	return CommandResult{Event: "result", Action: cmd.Action, Status: status, Response: response}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ConvertedFile is one of the files convert was given, printed as JSON with
+// -json
+type ConvertedFile struct {
+	File    string `json:"file"`
+	Output  string `json:"output,omitempty"`
+	Size    int64  `json:"size"`
+	NewSize int64  `json:"newSize,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"` // the original, with -delete
+	Kept    string `json:"kept,omitempty"`    // why the original wasn't deleted
+	Skipped string `json:"skipped,omitempty"` // why it wasn't converted
+	Error   string `json:"error,omitempty"`
+}
+
+// runConvert converts audio files to another format with ffmpeg, several at
+// once, writing each next to the original. With -delete, each original is
+// removed once it's converted, and sessions whose tracks they were are
+// changed to the converted files, which is how a directory of old WAV files
+// is shrunk.
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	format := fs.String("format", "flac", "format to convert to: flac, mp3 or opus")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "files converted at once")
+	remove := fs.Bool("delete", false, "remove each original once it's converted")
+	fs.StringVar(&outputDirectory, "output", outputDirectory, "directory of the sessions whose tracks are changed to the converted files with -delete")
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: skribbl-capture convert [flags] file...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	target, ok := transcodeFormats[*format]
+	if fs.NArg() == 0 || !ok || *jobs < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	paths := fs.Args()
+
+	// Ctrl+C stops ffmpeg, and removes what it had written of each file
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results := make([]ConvertedFile, len(paths))
+	progress := newConvertProgress(len(paths))
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for range min(*jobs, len(paths)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = convertFile(ctx, paths[i], *format, target, *remove, func(p float64) {
+					progress.update(paths[i], p)
+				})
+				progress.finish(paths[i], results[i])
+			}
+		}()
+	}
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	progress.stop()
+
+	var converted, failed, stopped int
+	var before, after int64
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			failed++
+		case result.Output == "" && result.Skipped == "":
+			stopped++
+		case result.Output != "":
+			converted++
+			before += result.Size
+			after += result.NewSize
+		}
+	}
+	if stopped > 0 {
+		uiWarnf("⚠️  Stopped: %d files weren't converted\n", stopped)
+	}
+	if converted > 0 {
+		uiPrintf("\n✓ Converted %d of %d files, %s → %s\n", converted, len(paths), formatBytes(before), formatBytes(after))
+	}
+	printResult(map[string]interface{}{"files": results})
+	if failed > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// convertFile converts the file at path to format, next to it, and removes
+// the original with remove.
+func convertFile(ctx context.Context, path, formatName string, format transcodeFormat, remove bool, progress func(float64)) ConvertedFile {
+	result := ConvertedFile{File: path}
+	stat, err := os.Stat(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Size = stat.Size()
+	if strings.EqualFold(filepath.Ext(path), format.ext) {
+		result.Skipped = "already " + formatName
+		return result
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + format.ext
+	if _, err := os.Stat(out); err == nil {
+		result.Skipped = filepath.Base(out) + " already exists"
+		return result
+	}
+	if ctx.Err() != nil {
+		return result
+	}
+
+	// The duration is only known for files read natively, and is only
+	// needed to report progress
+	var track TrackInfo
+	if f, err := os.Open(path); err == nil {
+		if info, err := probeAudio(f); err == nil {
+			track.DurationSeconds = info.DurationSeconds
+		}
+		f.Close()
+	}
+	track.File = filepath.Base(path)
+	progress(0)
+	if err := ffmpegTranscode(ctx, path, out, format, track, progress); err != nil {
+		os.Remove(out)
+		if ctx.Err() == nil {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	if err := checkConverted(out, track.DurationSeconds); err != nil {
+		os.Remove(out)
+		result.Error = err.Error()
+		return result
+	}
+	result.Output = out
+	if stat, err := os.Stat(out); err == nil {
+		result.NewSize = stat.Size()
+	}
+	if remove {
+		kept, err := removeConverted(path, out, formatName)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case kept != "":
+			result.Kept = kept
+		default:
+			result.Deleted = true
+		}
+	}
+	return result
+}
+
+// checkConverted checks a converted file lasts as long as the original,
+// when both can be read natively, before the original is trusted to it.
+func checkConverted(path string, duration float64) error {
+	if duration == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		// Lossy formats can't be read back; ffmpeg succeeding will have to do
+		return nil
+	}
+	if math.Abs(info.DurationSeconds-duration) > 0.1 {
+		return fmt.Errorf("%s lasts %s, but the original %s", filepath.Base(path), subtitleTime(info.DurationSeconds, "."), subtitleTime(duration, "."))
+	}
+	return nil
+}
+
+// removeConverted removes the original of a converted file. If it's a
+// session's track, the session is changed to the converted file first. A
+// file of a track split by -split-every is kept, since the track's other
+// files are still in the old format, and why is returned.
+func removeConverted(path, converted, formatName string) (string, error) {
+	if manifest, track, ok := sessionOfTrack(path); ok {
+		if len(track.Segments) > 0 {
+			return "it's a track of session " + manifest.ID + " split by -split-every", nil
+		}
+		size, sum, err := checksumFile(converted)
+		if err != nil {
+			return "", fmt.Errorf("failed to checksum %s: %w", converted, err)
+		}
+		err = updateManifest(manifest.ID, func(m *SessionManifest) {
+			for i := range m.Tracks {
+				if m.Tracks[i].File == track.File {
+					m.Tracks[i].File, m.Tracks[i].Format, m.Tracks[i].Size, m.Tracks[i].SHA256 = filepath.Base(converted), formatName, size, sum
+				}
+			}
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to update session %s, so the original was kept: %w", manifest.ID, err)
+		}
+	} else if segmentOfSession(path) {
+		return "it's one of the files of a track split by -split-every", nil
+	}
+	return "", os.Remove(path)
+}
+
+// segmentOfSession reports whether the file at path is one of the later
+// files of a session's track split by -split-every.
+func segmentOfSession(path string) bool {
+	if dir, err := filepath.Abs(filepath.Dir(path)); err != nil {
+		return false
+	} else if out, err := filepath.Abs(outputDirectory); err != nil || out != dir {
+		return false
+	}
+	name := filepath.Base(path)
+	for _, manifest := range loadCatalog() {
+		for _, track := range manifest.Tracks {
+			if slices.ContainsFunc(track.Segments, func(s TrackSegment) bool { return s.File == name }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convertProgress reports on a convert run: a line as each file is done,
+// and in a terminal, a status line below them redrawn with how far along
+// the files being converted are.
+type convertProgress struct {
+	mu          sync.Mutex
+	done, total int
+	active      []string // files being converted, in the order they started
+	fraction    map[string]float64
+	width       int // of the status line drawn last
+	live        bool
+	stopped     chan struct{}
+}
+
+func newConvertProgress(total int) *convertProgress {
+	p := &convertProgress{
+		total:    total,
+		fraction: map[string]float64{},
+		live:     outputMode != outputQuiet && outputMode != outputJSON && !plainOutput && isTerminal(os.Stdout),
+		stopped:  make(chan struct{}),
+	}
+	if p.live {
+		go func() {
+			ticker := time.NewTicker(statusInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-p.stopped:
+					return
+				case <-ticker.C:
+					p.mu.Lock()
+					p.draw()
+					p.mu.Unlock()
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// update records how far along the file at path is.
+func (p *convertProgress) update(path string, fraction float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.fraction[path]; !ok {
+		p.active = append(p.active, path)
+	}
+	p.fraction[path] = fraction
+}
+
+// finish prints what became of a file.
+func (p *convertProgress) finish(path string, result ConvertedFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.active = slices.DeleteFunc(p.active, func(a string) bool { return a == path })
+	delete(p.fraction, path)
+	p.clear()
+	prefix := fmt.Sprintf("[%d/%d] ", p.done, p.total)
+	switch {
+	case result.Error != "":
+		uiWarnf("✗ %s%s: %s\n", prefix, path, result.Error)
+	case result.Skipped != "":
+		uiPrintf("%s%s skipped: %s\n", prefix, path, result.Skipped)
+	case result.Output == "":
+		// Stopped by Ctrl+C, which the summary reports
+	default:
+		uiPrintf("✓ %s%s → %s (%s → %s)\n", prefix, path, filepath.Base(result.Output), formatBytes(result.Size), formatBytes(result.NewSize))
+		if result.Kept != "" {
+			uiWarnf("⚠️  Kept %s: %s\n", path, result.Kept)
+		}
+	}
+	p.draw()
+}
+
+// stop takes the status line away for good.
+func (p *convertProgress) stop() {
+	close(p.stopped)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.live = false
+}
+
+// draw redraws the status line. p.mu must be held.
+func (p *convertProgress) draw() {
+	if !p.live || len(p.active) == 0 {
+		return
+	}
+	line := fmt.Sprintf("⏳ %d/%d", p.done, p.total)
+	for _, path := range p.active {
+		line += fmt.Sprintf(" | %s %.0f%%", filepath.Base(path), p.fraction[path]*100)
+	}
+	n := utf8.RuneCountInString(line)
+	fmt.Printf("\r%s%s", line, strings.Repeat(" ", max(p.width-n, 0)))
+	p.width = n
+}
+
+// clear blanks the status line, so a line can be printed in its place. p.mu
+// must be held.
+func (p *convertProgress) clear() {
+	if p.live && p.width > 0 {
+		fmt.Printf("\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
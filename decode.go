@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// The decoder layer turns any file probeAudio accepts into the 16-bit PCM the
+// analysis code works on, so recordings analyze the same whatever format
+// they were saved or imported in.
+
+// openPCM16 returns a reader of the file's audio as interleaved 16-bit
+// little-endian PCM at its own rate and channel count.
+func openPCM16(f *os.File, info AudioInfo) (io.Reader, error) {
+	switch info.Format {
+	case "wav":
+		data := io.NewSectionReader(f, info.dataOffset, info.dataSize)
+		if info.formatTag == wavFormatPCM && info.BitsPerSample == 16 {
+			return data, nil
+		}
+		return newWAVConverter(data, info)
+	case "flac":
+		if info.BitsPerSample < 4 || info.BitsPerSample > 32 {
+			return nil, fmt.Errorf("unsupported FLAC sample size: %d bits", info.BitsPerSample)
+		}
+		return newFLACDecoder(io.NewSectionReader(f, 0, math.MaxInt64), info)
+	}
+	return nil, fmt.Errorf("no decoder for %s", info.Format)
+}
+
+// measureLevels decodes a whole file and measures its levels.
+func measureLevels(f *os.File, info AudioInfo) (LevelAnalysis, error) {
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return LevelAnalysis{}, err
+	}
+	return meterPCM(pcm, info)
+}
+
+// meterPCM measures the levels of 16-bit PCM read to the end.
+func meterPCM(pcm io.Reader, info AudioInfo) (LevelAnalysis, error) {
+	meter := newLevelMeter(info.SampleRate, info.Channels)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := io.ReadFull(pcm, buf)
+		meter.add(buf[:n&^1])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return meter.result(), nil
+		}
+		if err != nil {
+			return LevelAnalysis{}, err
+		}
+	}
+}
+
+// wavConverter converts 8, 24 and 32-bit integer and 32 and 64-bit float WAV
+// samples to 16-bit
+type wavConverter struct {
+	r       io.Reader
+	width   int
+	float   bool
+	in, out []byte
+}
+
+func newWAVConverter(r io.Reader, info AudioInfo) (*wavConverter, error) {
+	c := &wavConverter{r: r, width: int(info.BitsPerSample / 8), float: info.formatTag == wavFormatFloat}
+	switch {
+	case c.float && (c.width == 4 || c.width == 8):
+	case !c.float && info.BitsPerSample%8 == 0 && c.width >= 1 && c.width <= 4:
+	default:
+		return nil, errors.New("unsupported WAV sample size")
+	}
+	c.in = make([]byte, 16*1024*c.width)
+	return c, nil
+}
+
+func (c *wavConverter) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		n, err := io.ReadFull(c.r, c.in)
+		n -= n % c.width
+		if n == 0 {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		c.out = c.out[:0]
+		for i := 0; i < n; i += c.width {
+			c.out = binary.LittleEndian.AppendUint16(c.out, uint16(c.sample(c.in[i:i+c.width])))
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// sample converts one sample to 16-bit.
+func (c *wavConverter) sample(b []byte) int16 {
+	if c.float {
+		var v float64
+		if c.width == 4 {
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		} else {
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return int16(math.Round(min(max(v, -1), 32767.0/32768) * 32768))
+	}
+	switch c.width {
+	case 1:
+		return int16(int(b[0])-128) << 8 // 8-bit WAV is unsigned
+	case 3:
+		return int16(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 16)
+	default:
+		return int16(int32(binary.LittleEndian.Uint32(b)) >> 16)
+	}
+}
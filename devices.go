@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+)
+
+// selectableDevice represents a device the user can pick, which may be
+// either a regular capture device or a loopback (playback) device, or in web
+// mode, a single PipeWire output port on Linux, an ASIO driver on Windows
+// or a network stream.
+type selectableDevice struct {
+	info       malgo.DeviceInfo
+	isLoopback bool
+
+	// formats are what the backend says the device works in natively
+	formats []malgo.DataFormat
+
+	// port is the PipeWire port, as node:port, for a port source
+	port string
+
+	// asio is the ASIO driver's name, for an ASIO source
+	asio string
+
+	// stream is set for a network stream source
+	stream *streamSource
+}
+
+// name is the device's name, a port source's node:port, an ASIO driver's
+// name or a stream's name.
+func (d selectableDevice) name() string {
+	switch {
+	case d.port != "":
+		return d.port
+	case d.asio != "":
+		return d.asio
+	case d.stream != nil:
+		return d.stream.Name
+	}
+	return d.info.Name()
+}
+
+// typeName is the kind of source the device is: capture, loopback, port,
+// asio or stream.
+func (d selectableDevice) typeName() string {
+	switch {
+	case d.isLoopback:
+		return "loopback"
+	case d.port != "":
+		return "port"
+	case d.asio != "":
+		return "asio"
+	case d.stream != nil:
+		return "stream"
+	}
+	return "capture"
+}
+
+// isAudioDevice reports whether the source is opened through malgo, rather
+// than recorded by another program or through ASIO.
+func (d selectableDevice) isAudioDevice() bool {
+	return d.port == "" && d.asio == "" && d.stream == nil
+}
+
+// extraSources lists the sources recorded other than through malgo:
+// PipeWire ports, ASIO drivers and network streams.
+func extraSources() []selectableDevice {
+	sources := append(listPortSources(), listASIOSources()...)
+	return append(sources, streamSources()...)
+}
+
+// deviceCache is the device list the web UI was last shown, so the indices it
+// sends back to /api/start refer to the same devices. Guarded by recordingMutex.
+var deviceCache []selectableDevice
+
+// listSelectableDevices builds the unified list of capture devices plus, when
+// the audio backend can record them, playback devices as loopback sources.
+func listSelectableDevices(ctx malgo.Context) ([]selectableDevice, error) {
+	allDevices := []selectableDevice{}
+
+	// Get capture devices (microphones, virtual inputs)
+	captureInfos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capture devices: %v", err)
+	}
+	for _, info := range captureInfos {
+		allDevices = append(allDevices, selectableDevice{info: info, isLoopback: false, formats: nativeFormats(ctx, malgo.Capture, info)})
+	}
+
+	// Also list playback devices as loopback sources (system audio) if the
+	// backend supports it
+	if loopbackSupported(ctx) {
+		playbackInfos, err := ctx.Devices(malgo.Playback)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get playback devices: %v", err)
+		}
+		for _, info := range playbackInfos {
+			allDevices = append(allDevices, selectableDevice{info: info, isLoopback: true, formats: nativeFormats(ctx, malgo.Playback, info)})
+		}
+	}
+
+	return allDevices, nil
+}
+
+// nativeFormats asks the backend which formats a device works in natively.
+// Not every backend says when listing devices, so each is asked for its
+// details, falling back to what the list said.
+func nativeFormats(ctx malgo.Context, kind malgo.DeviceType, info malgo.DeviceInfo) []malgo.DataFormat {
+	if detail, err := ctx.DeviceInfo(kind, info.ID, malgo.Shared); err == nil && len(detail.Formats) > 0 {
+		return detail.Formats
+	}
+	return info.Formats
+}
+
+// sampleFormatNames names malgo's sample formats for DeviceCapabilities.
+var sampleFormatNames = map[malgo.FormatType]string{
+	malgo.FormatU8:  "u8",
+	malgo.FormatS16: "s16",
+	malgo.FormatS24: "s24",
+	malgo.FormatS32: "s32",
+	malgo.FormatF32: "f32",
+}
+
+// id is an identifier for the device that stays the same across restarts
+// and as other devices come and go, unlike its index: the backend's ID for
+// audio devices, and the port, driver or stream name for the others.
+func (d selectableDevice) id() string {
+	switch {
+	case d.port != "":
+		return "port:" + d.port
+	case d.asio != "":
+		return "asio:" + d.asio
+	case d.stream != nil:
+		return "stream:" + d.stream.Name
+	case d.isLoopback:
+		return "loopback:" + d.info.ID.String()
+	}
+	return "capture:" + d.info.ID.String()
+}
+
+// capabilities summarizes the formats the device works in natively, or nil
+// if the backend didn't say. Zeros in a format mean any, so they're left out.
+func (d selectableDevice) capabilities() *DeviceCapabilities {
+	if len(d.formats) == 0 {
+		return nil
+	}
+	caps := &DeviceCapabilities{}
+	for _, f := range d.formats {
+		if f.SampleRate > 0 && !slices.Contains(caps.SampleRates, f.SampleRate) {
+			caps.SampleRates = append(caps.SampleRates, f.SampleRate)
+		}
+		if f.Channels > 0 {
+			if caps.MinChannels == 0 || f.Channels < caps.MinChannels {
+				caps.MinChannels = f.Channels
+			}
+			caps.MaxChannels = max(caps.MaxChannels, f.Channels)
+		}
+		if name, ok := sampleFormatNames[f.Format]; ok && !slices.Contains(caps.SampleFormats, name) {
+			caps.SampleFormats = append(caps.SampleFormats, name)
+		}
+	}
+	if len(caps.SampleRates) == 0 && caps.MaxChannels == 0 && len(caps.SampleFormats) == 0 {
+		return nil
+	}
+	slices.Sort(caps.SampleRates)
+	return caps
+}
+
+// duplexPartner finds the other side of a headset or other device that both
+// records and plays: the loopback source for a capture device, or the
+// capture device for a loopback source. Windows names the two endpoints
+// after what they are with the hardware in brackets, e.g. "Microphone (USB
+// Headset)" and "Speakers (USB Headset)", so those are matched on the
+// bracketed part, and other names on the whole name.
+func duplexPartner(devices []selectableDevice, idx int) (int, bool) {
+	d := devices[idx]
+	if !d.isAudioDevice() {
+		return 0, false
+	}
+	for i, other := range devices {
+		if other.isAudioDevice() && other.isLoopback != d.isLoopback && hardwareName(other.name()) == hardwareName(d.name()) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// hardwareName is the bracketed part at the end of a device name, if it has
+// one, or else the whole name.
+func hardwareName(name string) string {
+	if strings.HasSuffix(name, ")") {
+		if i := strings.LastIndex(name, " ("); i >= 0 {
+			return name[i+2 : len(name)-1]
+		}
+	}
+	return name
+}
+
+// loopbackSupported asks the backend whether it can record a playback
+// device's output, by opening, but not starting, a loopback device on the
+// default playback device. Only WASAPI can today, but asking rather than
+// going by the OS means any backend that gains it works too.
+func loopbackSupported(ctx malgo.Context) bool {
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Loopback)
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = 1
+	deviceConfig.SampleRate = 44100
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{})
+	if err != nil {
+		return false
+	}
+	device.Uninit()
+	return true
+}
+
+// cachedDevices returns the device cache, enumerating devices the first time.
+// Must be called with recordingMutex held.
+func cachedDevices() ([]selectableDevice, error) {
+	if deviceCache == nil {
+		devices, err := listSelectableDevices(malgoContext.Context)
+		if err != nil {
+			return nil, err
+		}
+		deviceCache = append(devices, extraSources()...)
+	}
+	return deviceCache, nil
+}
+
+// refreshDevices re-enumerates devices into the cache. When nothing is
+// recording, the malgo context is reinitialized first, since some backends
+// only notice newly plugged-in hardware on a fresh context. The monitor
+// output, the live mix, sample clips and announcements also keep the old
+// context in use.
+// Must be called with recordingMutex held.
+func refreshDevices() (reinitialized bool, err error) {
+	if activeSession == nil && !monitoring.status().Enabled && liveMix.device == nil && samplesRunning == 0 && announcementsPlaying == 0 {
+		ctx, err := initAudioContext()
+		if err != nil {
+			return false, fmt.Errorf("failed to reinitialize audio context: %v", err)
+		}
+		malgoContext.Uninit()
+		malgoContext.Free()
+		malgoContext = ctx
+		reinitialized = true
+	}
+
+	devices, err := listSelectableDevices(malgoContext.Context)
+	if err != nil {
+		return reinitialized, err
+	}
+	deviceCache = append(devices, extraSources()...)
+	return reinitialized, nil
+}
+
+// deviceInfoList converts the device cache into its API representation.
+func deviceInfoList(devices []selectableDevice) []DeviceInfo {
+	list := []DeviceInfo{}
+	settings, _ := loadDeviceSettings()
+	for i, d := range devices {
+		info := DeviceInfo{
+			Index:        i,
+			ID:           d.id(),
+			Name:         d.name(),
+			Type:         d.typeName(),
+			Default:      d.isAudioDevice() && d.info.IsDefault != 0,
+			Capabilities: d.capabilities(),
+			Speaker:      settings[d.name()].Speaker,
+			Channels:     settings[d.name()].Channels,
+			Tap:          settings[d.name()].Tap,
+		}
+		if partner, ok := duplexPartner(devices, i); ok {
+			info.DuplexWith = &partner
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
+// Handler: GET /api/devices - List all available capture devices
+func handleListDevices(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	devices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deviceInfoList(devices))
+}
+
+// Handler: POST /api/devices/refresh - Re-enumerate devices without restarting
+func handleRefreshDevices(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	reinitialized, err := refreshDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to refresh devices: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"devices":       deviceInfoList(deviceCache),
+		"reinitialized": reinitialized,
+	})
+}
+
+// runDevices lists the devices that could be recorded, as /api/devices
+// does, for scripts with -json.
+func runDevices(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	addBackendFlag(fs)
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Var(&streams, "stream", "network audio stream to list as a source, as name=url or url (repeatable)")
+	fs.StringVar(&deviceSettingsFile, "device-settings", deviceSettingsFile, "JSON file of per-device settings such as speakers")
+	fs.Parse(args)
+
+	ctx, err := initAudioContext()
+	if err != nil {
+		uiWarnf("Failed to initialize audio context: %v\n", err)
+		os.Exit(1)
+	}
+	defer ctx.Uninit()
+
+	devices, err := listSelectableDevices(ctx.Context)
+	if err != nil {
+		uiWarnf("Failed to list devices: %v\n", err)
+		os.Exit(1)
+	}
+	list := deviceInfoList(append(devices, extraSources()...))
+	for _, d := range list {
+		details := d.Type
+		if d.Default {
+			details += ", default"
+		}
+		uiPrintf("[%d] %s (%s)\n", d.Index, d.Name, details)
+		uiVerbosef("    ID %s\n", d.ID)
+		if caps := d.Capabilities; caps != nil {
+			uiVerbosef("    %v Hz, %d-%d channel(s), %s\n", caps.SampleRates, caps.MinChannels, caps.MaxChannels, strings.Join(caps.SampleFormats, "/"))
+		}
+	}
+	printResult(list)
+}
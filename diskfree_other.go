@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// diskFree isn't implemented on this platform.
+func diskFree(path string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space is not available on %s", runtime.GOOS)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Results of a doctor check
+const (
+	checkPass = "pass"
+	checkWarn = "warn" // works, but may cause trouble
+	checkFail = "fail"
+)
+
+// doctorCheck is one thing doctor checked
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // pass, warn or fail
+	Detail string `json:"detail"`
+}
+
+// doctorReport is everything doctor checked, printed as JSON with -json to
+// attach to a support request
+type doctorReport struct {
+	OS       string        `json:"os"`
+	Recorder string        `json:"recorder"`
+	Checks   []doctorCheck `json:"checks"`
+	Passed   bool          `json:"passed"` // no check failed
+}
+
+// runDoctor checks that everything recording needs works on this machine:
+// the audio backend, loopback capture, the output directory and its free
+// space, the clock, and a short recording from every device. It exits with
+// status 1 if any check fails.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	addBackendFlag(fs)
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.StringVar(&outputDirectory, "output", outputDirectory, "directory recordings are saved in")
+	fs.StringVar(&deviceSettingsFile, "device-settings", deviceSettingsFile, "JSON file of per-device settings such as gain")
+	seconds := fs.Float64("seconds", 2, "how long to record from each device")
+	fs.Parse(args)
+
+	uiPrintln("Skribbl Audio Capture - Doctor")
+	uiPrintln("")
+
+	report := doctorReport{OS: runtime.GOOS + "/" + runtime.GOARCH, Recorder: recorderVersion(), Passed: true}
+	check := func(name, status, detail string) {
+		report.Checks = append(report.Checks, doctorCheck{Name: name, Status: status, Detail: detail})
+		switch status {
+		case checkPass:
+			uiPrintf("✓ %s: %s\n", name, detail)
+		case checkWarn:
+			uiWarnf("⚠️  %s: %s\n", name, detail)
+		default:
+			report.Passed = false
+			uiWarnf("✗ %s: %s\n", name, detail)
+		}
+	}
+	defer func() {
+		printResult(report)
+		if !report.Passed {
+			os.Exit(1)
+		}
+	}()
+
+	checkOutputDirectory(check)
+	checkClock(check)
+
+	backends := "the platform's usual ones"
+	if len(audioBackends) > 0 {
+		backends = backendList{}.String()
+	}
+	ctx, err := initAudioContext()
+	if err != nil {
+		check("Audio backend", checkFail, fmt.Sprintf("none of %s could be started: %v", backends, err))
+		return
+	}
+	defer ctx.Uninit()
+	// Sample clips are recorded the way the web server records
+	malgoContext = ctx
+	check("Audio backend", checkPass, fmt.Sprintf("started, from %s", backends))
+
+	if loopbackSupported(ctx.Context) {
+		check("Loopback", checkPass, "playback devices can be recorded as system audio")
+	} else {
+		detail := "this backend can't record playback devices, so system audio needs a loopback driver"
+		if runtime.GOOS == "darwin" {
+			detail += " such as BlackHole"
+		}
+		check("Loopback", checkWarn, detail)
+	}
+
+	devices, err := listSelectableDevices(ctx.Context)
+	if err != nil {
+		check("Devices", checkFail, fmt.Sprintf("failed to list devices: %v", err))
+		return
+	}
+	if len(devices) == 0 {
+		check("Devices", checkFail, "no devices to record from")
+		return
+	}
+	check("Devices", checkPass, fmt.Sprintf("%d to record from", len(devices)))
+
+	d := time.Duration(*seconds * float64(time.Second))
+	uiPrintf("\nRecording %g seconds from each device...\n", d.Seconds())
+	for _, device := range devices {
+		status, detail := sampleCheck(device, d)
+		check(device.name(), status, detail)
+	}
+}
+
+// checkOutputDirectory checks that recordings can be saved in the output
+// directory, and that it has room for them.
+func checkOutputDirectory(check func(name, status, detail string)) {
+	if err := os.MkdirAll(outputDirectory, 0o755); err != nil {
+		check("Output directory", checkFail, fmt.Sprintf("failed to create %s: %v", outputDirectory, err))
+		return
+	}
+	f, err := os.CreateTemp(outputDirectory, ".doctor-*")
+	if err != nil {
+		check("Output directory", checkFail, fmt.Sprintf("%s isn't writable: %v", outputDirectory, err))
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	check("Output directory", checkPass, fmt.Sprintf("%s is writable", outputDirectory))
+
+	free, err := diskFree(outputDirectory)
+	switch {
+	case err != nil:
+		check("Disk space", checkWarn, fmt.Sprintf("couldn't tell: %v", err))
+	case free < uint64(diskAlertMB)<<20:
+		check("Disk space", checkFail, fmt.Sprintf("only %d MB free, under the %d MB alert threshold", free>>20, diskAlertMB))
+	default:
+		// An hour of 44.1 kHz 16-bit mono is about 318 MB
+		check("Disk space", checkPass, fmt.Sprintf("%d MB free, about %d hours of a mono track", free>>20, free/(44100*2*3600)))
+	}
+}
+
+// checkClock checks the system clock is kept in sync, which session start
+// times and syncing recordings from several machines rely on.
+func checkClock(check func(name, status, detail string)) {
+	unsynced := "not synchronized; start times may be off, and recordings from other machines won't line up"
+	switch runtime.GOOS {
+	case "linux":
+		switch strings.TrimSpace(commandOutput("timedatectl", "show", "-p", "NTPSynchronized", "--value")) {
+		case "yes":
+			check("Clock", checkPass, "synchronized by NTP")
+		case "no":
+			check("Clock", checkWarn, unsynced)
+		default:
+			check("Clock", checkWarn, "couldn't tell: timedatectl isn't available")
+		}
+	case "darwin":
+		// sntp prints the offset first, such as "+0.012345 +/- 0.0234 ..."
+		fields := strings.Fields(commandOutput("sntp", "-t", "2", "time.apple.com"))
+		if len(fields) == 0 {
+			check("Clock", checkWarn, "couldn't tell: time.apple.com didn't answer")
+			return
+		}
+		offset, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			check("Clock", checkWarn, "couldn't tell: "+strings.Join(fields, " "))
+		} else if math.Abs(offset) > 1 {
+			check("Clock", checkWarn, fmt.Sprintf("%.1f seconds off time.apple.com", offset))
+		} else {
+			check("Clock", checkPass, fmt.Sprintf("within %.0f ms of time.apple.com", math.Abs(offset)*1000))
+		}
+	case "windows":
+		status := commandOutput("w32tm", "/query", "/status")
+		source := fieldValue(status, "Source:")
+		switch {
+		case status == "":
+			check("Clock", checkWarn, "couldn't tell: the Windows Time service isn't running")
+		case source == "" || strings.Contains(source, "Local CMOS Clock") || strings.Contains(source, "Free-running"):
+			check("Clock", checkWarn, unsynced)
+		default:
+			check("Clock", checkPass, "synchronized with "+source)
+		}
+	default:
+		check("Clock", checkWarn, "couldn't tell on "+runtime.GOOS)
+	}
+}
+
+// sampleCheck records d from a device and judges what arrived.
+func sampleCheck(device selectableDevice, d time.Duration) (string, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), d+10*time.Second)
+	defer cancel()
+	path, err := captureSample(ctx, device, d)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	if info.DurationSeconds < d.Seconds()/2 {
+		return checkFail, fmt.Sprintf("only %.1f seconds of audio arrived in %g", info.DurationSeconds, d.Seconds())
+	}
+	levels, err := measureLevels(f, info)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	detail := fmt.Sprintf("%.1f seconds at %d Hz, peak %.0f dBFS", info.DurationSeconds, info.SampleRate, levels.PeakDBFS)
+	if levels.PeakDBFS <= minLevelDBFS {
+		if device.isLoopback {
+			return checkWarn, detail + ", silent: nothing was playing"
+		}
+		return checkWarn, detail + ", silent: is it muted?"
+	}
+	if levels.ClippedSamples > 0 {
+		return checkWarn, detail + fmt.Sprintf(", %d samples clipped: turn the gain down", levels.ClippedSamples)
+	}
+	return checkPass, detail
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// minDriftSeconds is how long a device must record for its clock to be
+	// measured; over less, callback jitter outweighs drift.
+	minDriftSeconds = 60
+
+	// minDriftPPM is the least drift a drift job corrects. Below it tracks
+	// stay within a few milliseconds of each other over hours.
+	minDriftPPM = 1
+)
+
+// driftFit fits a line to how far a device's clock has fallen behind the
+// system's monotonic clock over time, by least squares: its slope is the
+// device's drift. It's updated by the audio callback, so it only keeps
+// running sums.
+type driftFit struct {
+	n, sx, sy, sxx, sxy float64
+	last                float64 // seconds since the first callback, at the last point
+}
+
+// add records that the device was lag seconds behind at elapsed seconds.
+func (f *driftFit) add(elapsed, lag float64) {
+	f.n++
+	f.sx += elapsed
+	f.sy += lag
+	f.sxx += elapsed * elapsed
+	f.sxy += elapsed * lag
+	f.last = elapsed
+}
+
+// measuredRate is the device's effective sample rate against the system
+// clock, and its drift in parts per million, or 0, 0 if it hasn't recorded
+// for long enough to tell.
+func (f *driftFit) measuredRate(nominal uint32) (float64, float64) {
+	d := f.n*f.sxx - f.sx*f.sx
+	if f.last < minDriftSeconds || d == 0 {
+		return 0, 0
+	}
+	// Falling behind by slope seconds a second means delivering that much
+	// less than a second of audio each second
+	slope := (f.n*f.sxy - f.sx*f.sy) / d
+	rate := float64(nominal) * (1 - slope)
+	return math.Round(rate*1000) / 1000, math.Round(-slope*1e6*100) / 100
+}
+
+// resampler reads 16-bit PCM at a slightly different rate than its source,
+// taking step source frames for every frame it gives, with cubic
+// interpolation, which is transparent for the tiny ratios drift needs.
+type resampler struct {
+	src      *bufio.Reader
+	channels int
+	step     float64
+
+	window [4][]float64 // source frames i-1 to i+2
+	pos    float64      // in source frames, from frame i
+	eof    int          // frames of the window past the end of the source
+	frame  []byte       // a source frame being read
+	next   []byte       // a frame being given
+	out    []byte       // what's left of next after a short read
+}
+
+func newResampler(pcm io.Reader, channels uint32, step float64) (*resampler, error) {
+	r := &resampler{
+		src:      bufio.NewReaderSize(pcm, 64*1024),
+		channels: int(channels),
+		step:     step,
+		frame:    make([]byte, channels*2),
+		next:     make([]byte, channels*2),
+	}
+	for i := range r.window {
+		r.window[i] = make([]float64, channels)
+	}
+	// Start with the first frame standing in for the one before it
+	if !r.read(r.window[1], nil) {
+		return nil, fmt.Errorf("no audio to resample")
+	}
+	copy(r.window[0], r.window[1])
+	r.read(r.window[2], r.window[1])
+	r.read(r.window[3], r.window[2])
+	return r, nil
+}
+
+// read reads the next source frame into dst, or past the end of the source
+// copies prev, and reports whether there was one.
+func (r *resampler) read(dst, prev []float64) bool {
+	if _, err := io.ReadFull(r.src, r.frame); err != nil {
+		r.eof++
+		copy(dst, prev)
+		return false
+	}
+	for c := range dst {
+		dst[c] = float64(int16(uint16(r.frame[c*2]) | uint16(r.frame[c*2+1])<<8))
+	}
+	return true
+}
+
+func (r *resampler) Read(p []byte) (int, error) {
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	for n < len(p) {
+		for r.pos >= 1 {
+			r.pos--
+			first := r.window[0]
+			copy(r.window[:], r.window[1:])
+			r.window[3] = first
+			r.read(r.window[3], r.window[2])
+		}
+		// Frame i is past the end of the source
+		if r.eof >= 3 {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+		t := r.pos
+		for c := 0; c < r.channels; c++ {
+			y0, y1, y2, y3 := r.window[0][c], r.window[1][c], r.window[2][c], r.window[3][c]
+			v := y1 + 0.5*t*(y2-y0+t*(2*y0-5*y1+4*y2-y3+t*(3*(y1-y2)+y3-y0)))
+			s := uint16(int16(max(min(math.Round(v), math.MaxInt16), math.MinInt16)))
+			r.next[c*2], r.next[c*2+1] = byte(s), byte(s>>8)
+		}
+		r.pos += r.step
+		copied := copy(p[n:], r.next)
+		r.out = r.next[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// runDrift copies tracks resampled to cancel the clock drift measured while
+// they were recorded, as <track>_locked.wav, so tracks from devices with
+// different clocks stay in step for the whole session. Tracks without a
+// measurement, or drifting less than minDriftPPM, are left alone.
+func runDrift(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	for i, track := range tracks {
+		if track.MeasuredSampleRate == 0 || math.Abs(track.DriftPPM) < minDriftPPM {
+			continue
+		}
+		f, info, pcm, err := openTrackPCM(ctx, track.File, progress, float64(i)/float64(len(tracks)), 1/float64(len(tracks)))
+		if err != nil {
+			return fail(err)
+		}
+		resampled, err := newResampler(pcm, info.Channels, track.MeasuredSampleRate/float64(track.SampleRate))
+		if err != nil {
+			f.Close()
+			return fail(fmt.Errorf("%s: %w", track.File, err))
+		}
+		name, release, err := writeGainedTrack(trackBase(track)+"_locked", info, resampled, 1)
+		f.Close()
+		if err != nil {
+			return fail(err)
+		}
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobDrift, File: name, Device: track.Device, Source: track.File})
+		fmt.Printf("✓ %s: corrected %+.2f ppm of drift\n", track.File, track.DriftPPM)
+	}
+	if len(exports) == 0 {
+		return nil, nil
+	}
+	return addExports(job.SessionID, exports, releases)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// dropoutThreshold is how much later than expected a callback must arrive to
+// count as a gap. Smaller delays are ordinary scheduling jitter.
+const dropoutThreshold = 50 * time.Millisecond
+
+// Dropout is a stretch of audio lost while recording a track
+type Dropout struct {
+	// "gap" when the device stopped delivering audio, "overrun" when audio
+	// was dropped because the writer fell behind, "stall" when the watchdog
+	// had to restart the device
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// PositionSeconds is where in the file the audio is missing. Tracks start
+	// with the session, so it's also the time into the session.
+	PositionSeconds float64 `json:"positionSeconds"`
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// Filled is set when the missing audio was replaced with silence, so the
+	// rest of the track still lines up
+	Filled bool `json:"filled,omitempty"`
+}
+
+// TrackDropouts lists the dropouts of one track
+type TrackDropouts struct {
+	File     string    `json:"file"`
+	Device   string    `json:"device"`
+	Dropouts []Dropout `json:"dropouts"`
+}
+
+// checkCallbackTiming notices a callback arriving later than the frames
+// delivered so far account for, meaning the device stalled and audio was
+// lost before it reached us. It runs on the audio thread and must not
+// allocate. Loopback devices stop calling back while nothing is playing, so
+// for them a late callback means silence, which is filled in rather than
+// logged. Piped audio is skipped.
+func (c *captureDevice) checkCallbackTiming(framecount uint32) {
+	if c.untimed {
+		return
+	}
+	now := time.Now()
+	c.lastCallback.Store(now.UnixNano())
+	if c.callbackStart.IsZero() {
+		c.callbackStart = now
+		c.restarted = false
+		return
+	}
+	c.callbackFrames += uint64(framecount)
+	expected := c.callbackStart.Add(time.Duration(float64(c.callbackFrames) / float64(c.sampleRate) * float64(time.Second)))
+	lag := now.Sub(expected)
+	step := lag - c.callbackLag
+	switch {
+	case c.restarted:
+		// Everything since the old device stalled is missing, so the
+		// writer fills it with silence to keep the track in line
+		c.pendingFill += max(step, 0)
+		c.callbackSteps += max(step, 0)
+		c.restarted = false
+	case c.isLoopback:
+		if step > dropoutThreshold {
+			c.pendingSilence += step
+			c.callbackSteps += step
+		}
+	case step > dropoutThreshold:
+		c.pendingGap += step
+		c.callbackSteps += step
+	}
+	c.callbackLag = lag
+	// What's left of the lag is the device's clock running slow or fast
+	c.drift.add(now.Sub(c.callbackStart).Seconds(), (lag - c.callbackSteps).Seconds())
+}
+
+// keepTime is called just before a loopback device is started, so its track
+// keeps time from then on, with silence filled in while nothing plays, even
+// before the first audio and after the last. Its tracks then line up with
+// the session's other tracks, like the two sides of a duplex headset.
+func (c *captureDevice) keepTime() {
+	if c.isLoopback {
+		c.callbackStart = time.Now()
+	}
+}
+
+// padToStop fills in the silence at the end of a loopback device's track,
+// after the last audio it sent. The device and writer must be stopped.
+func (c *captureDevice) padToStop() {
+	if !c.isLoopback || c.callbackStart.IsZero() {
+		return
+	}
+	expected := c.callbackStart.Add(time.Duration(float64(c.callbackFrames) / float64(c.sampleRate) * float64(time.Second)))
+	if silence := time.Since(expected) - c.callbackLag; silence > dropoutThreshold {
+		if err := c.writeZeros(silence); err != nil {
+			fmt.Printf("Error writing audio data for %s: %v\n", c.name, err)
+		}
+	}
+}
+
+// logDropouts records audio lost before a chunk about to be written, filling
+// in silence for a restarted device, and writes a loopback device's silence.
+func (c *captureDevice) logDropouts(chunk *audioChunk) {
+	if chunk.silence > 0 {
+		if err := c.writeZeros(chunk.silence); err != nil {
+			fmt.Printf("Error writing audio data for %s: %v\n", c.name, err)
+		}
+	}
+	bytesPerSecond := float64(c.sampleRate * c.channels * 2)
+	position := float64(c.totalBytesWritten.Load()) / bytesPerSecond
+	now := time.Now()
+
+	var found []Dropout
+	if chunk.gap > 0 {
+		found = append(found, Dropout{Type: "gap", Time: now, PositionSeconds: position, DurationSeconds: chunk.gap.Seconds()})
+	}
+	if chunk.dropped > 0 {
+		found = append(found, Dropout{Type: "overrun", Time: now, PositionSeconds: position, DurationSeconds: float64(chunk.dropped) / float64(c.sampleRate)})
+	}
+	if chunk.fill > 0 {
+		found = append(found, Dropout{Type: "stall", Time: now, PositionSeconds: position, DurationSeconds: chunk.fill.Seconds(), Filled: true})
+		if err := c.writeZeros(chunk.fill); err != nil {
+			fmt.Printf("Error writing audio data for %s: %v\n", c.name, err)
+		}
+	}
+	if c.inSession.Load() {
+		for _, d := range found {
+			fmt.Printf("⚠️  Dropout on %s: %.0fms %s at %s\n", c.name, d.DurationSeconds*1000, d.Type, formatElapsed(time.Duration(position*float64(time.Second))))
+		}
+	}
+
+	c.dropoutsMu.Lock()
+	c.dropouts = append(c.dropouts, found...)
+	c.dropoutsMu.Unlock()
+}
+
+// dropoutList returns a copy of the dropouts logged so far.
+func (c *captureDevice) dropoutList() []Dropout {
+	c.dropoutsMu.Lock()
+	defer c.dropoutsMu.Unlock()
+	return append([]Dropout(nil), c.dropouts...)
+}
+
+// Handler: GET /api/sessions/{id}/dropouts - List every dropout per track,
+// for a running or finished session
+func handleSessionDropouts(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+
+	var tracks []TrackInfo
+	var live []*captureDevice
+	recordingMutex.Lock()
+	if activeSession != nil && activeSession.id == id {
+		tracks = append(tracks, activeSession.removed...)
+		live = append(live, activeSession.captures...)
+	}
+	recordingMutex.Unlock()
+
+	if live == nil {
+		manifest, err := readSessionManifest(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+			return
+		}
+		tracks = manifest.Tracks
+	}
+
+	result := []TrackDropouts{}
+	for _, track := range tracks {
+		result = append(result, TrackDropouts{File: track.File, Device: track.Device, Dropouts: track.Dropouts})
+	}
+	for _, cap := range live {
+		result = append(result, TrackDropouts{File: filepath.Base(cap.filename), Device: cap.name, Dropouts: cap.dropoutList()})
+	}
+	for i := range result {
+		if result[i].Dropouts == nil {
+			result[i].Dropouts = []Dropout{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"tracks":    result,
+	})
+}
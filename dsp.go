@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// Sample-level processing shared by the capture writer, calibration and the
+// offline commands. All audio is 16-bit signed little-endian PCM.
+
+// analysisWindow is the length of the windows the noise floor is measured over.
+const analysisWindow = 0.05 // seconds
+
+// dbToGain converts decibels to a linear gain factor.
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// toDBFS converts a linear level, where 1 is full scale, to dBFS.
+func toDBFS(level float64) float64 {
+	if level <= 0 {
+		return minLevelDBFS
+	}
+	return max(20*math.Log10(level), minLevelDBFS)
+}
+
+// applyGain scales samples in place, saturating at full scale. It returns
+// how many samples were clipped.
+func applyGain(pcm []byte, gain float64) int {
+	clipped := 0
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := math.Round(float64(int16(uint16(pcm[i])|uint16(pcm[i+1])<<8)) * gain)
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+			clipped++
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+			clipped++
+		}
+		s := uint16(int16(v))
+		pcm[i], pcm[i+1] = byte(s), byte(s>>8)
+	}
+	return clipped
+}
+
+// LevelAnalysis summarizes the levels in a stretch of audio
+type LevelAnalysis struct {
+	PeakDBFS       float64 `json:"peakDbfs"`
+	RMSDBFS        float64 `json:"rmsDbfs"`
+	NoiseFloorDBFS float64 `json:"noiseFloorDbfs"` // quietest 10% of 50ms windows
+	ClippedSamples int     `json:"clippedSamples"`
+}
+
+// analyzeLevels measures peak, RMS and noise floor of PCM at the given rate.
+func analyzeLevels(pcm []byte, sampleRate, channels uint32) LevelAnalysis {
+	m := newLevelMeter(sampleRate, channels)
+	m.add(pcm)
+	return m.result()
+}
+
+// levelMeter works out a LevelAnalysis over PCM fed to it a piece at a time,
+// so long files don't have to be read into memory. Pieces must hold whole
+// samples.
+type levelMeter struct {
+	window                          int
+	peak, sumSquares, windowSquares float64
+	windowRMS                       []float64
+	clipped, n, inWindow            int
+}
+
+func newLevelMeter(sampleRate, channels uint32) *levelMeter {
+	return &levelMeter{window: max(int(float64(sampleRate*channels)*analysisWindow), 1)}
+}
+
+// add measures the next piece of PCM.
+func (m *levelMeter) add(pcm []byte) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := float64(int16(uint16(pcm[i])|uint16(pcm[i+1])<<8)) / 32768
+		a := math.Abs(v)
+		m.peak = max(m.peak, a)
+		if a >= 32767.0/32768 {
+			m.clipped++
+		}
+		m.sumSquares += v * v
+		m.windowSquares += v * v
+		m.n++
+		m.inWindow++
+		if m.inWindow == m.window {
+			m.windowRMS = append(m.windowRMS, math.Sqrt(m.windowSquares/float64(m.window)))
+			m.windowSquares, m.inWindow = 0, 0
+		}
+	}
+}
+
+// result summarizes everything added so far.
+func (m *levelMeter) result() LevelAnalysis {
+	result := LevelAnalysis{PeakDBFS: toDBFS(m.peak), RMSDBFS: minLevelDBFS, NoiseFloorDBFS: minLevelDBFS, ClippedSamples: m.clipped}
+	if m.n > 0 {
+		result.RMSDBFS = toDBFS(math.Sqrt(m.sumSquares / float64(m.n)))
+	}
+	if len(m.windowRMS) > 0 {
+		windowRMS := slices.Clone(m.windowRMS)
+		sort.Float64s(windowRMS)
+		result.NoiseFloorDBFS = toDBFS(windowRMS[len(windowRMS)/10])
+	}
+	return result
+}
+
+// biquad is a second-order IIR filter, in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two filters ITU-R BS.1770 weights audio with before
+// measuring its loudness, a high shelf modeling the head and a high-pass,
+// designed for sampleRate rather than only the 48 kHz the standard tabulates.
+func kWeighting(sampleRate uint32) [2]biquad {
+	const (
+		shelfFreq, shelfGainDB, shelfQ = 1681.974450955533, 3.999843853973347, 0.7071752369554196
+		highPassFreq, highPassQ        = 38.13547087602444, 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * shelfFreq / float64(sampleRate))
+	vh := math.Pow(10, shelfGainDB/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+	k = math.Tan(math.Pi * highPassFreq / float64(sampleRate))
+	a0 = 1 + k/highPassQ + k*k
+	highPass := biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/highPassQ + k*k) / a0}
+	return [2]biquad{shelf, highPass}
+}
+
+// loudnessMeter measures the integrated loudness of PCM fed to it a piece at
+// a time, per ITU-R BS.1770 and EBU R 128: the mean square of the K-weighted
+// audio over 400ms blocks overlapping by 75%, leaving out blocks quieter than
+// -70 LUFS and then those more than 10 LU below the rest. Pieces must hold
+// whole samples.
+type loudnessMeter struct {
+	filters      [][2]biquad // per channel
+	step, inStep int         // frames per 100ms, and so far in this one
+	channel      int         // of the next sample
+	squares      float64     // summed over this 100ms
+	stepPower    []float64   // mean square of every 100ms
+}
+
+func newLoudnessMeter(sampleRate, channels uint32) *loudnessMeter {
+	m := &loudnessMeter{step: max(int(sampleRate/10), 1)}
+	for range channels {
+		m.filters = append(m.filters, kWeighting(sampleRate))
+	}
+	return m
+}
+
+// add measures the next piece of PCM.
+func (m *loudnessMeter) add(pcm []byte) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := float64(int16(uint16(pcm[i])|uint16(pcm[i+1])<<8)) / 32768
+		f := &m.filters[m.channel]
+		v = f[1].process(f[0].process(v))
+		m.squares += v * v
+		if m.channel++; m.channel == len(m.filters) {
+			m.channel = 0
+			if m.inStep++; m.inStep == m.step {
+				m.stepPower = append(m.stepPower, m.squares/float64(m.step))
+				m.squares, m.inStep = 0, 0
+			}
+		}
+	}
+}
+
+// result is the integrated loudness in LUFS of everything added so far, or
+// minLevelDBFS if it was all silent or too short to measure.
+func (m *loudnessMeter) result() float64 {
+	const absoluteGate, relativeGate = -70.0, -10.0
+	loudness := func(power float64) float64 {
+		return -0.691 + 10*math.Log10(power)
+	}
+	var blocks []float64
+	for i := 0; i+4 <= len(m.stepPower); i++ {
+		power := (m.stepPower[i] + m.stepPower[i+1] + m.stepPower[i+2] + m.stepPower[i+3]) / 4
+		if power > 0 && loudness(power) > absoluteGate {
+			blocks = append(blocks, power)
+		}
+	}
+	gated := func(threshold float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, power := range blocks {
+			if loudness(power) > threshold {
+				sum += power
+				n++
+			}
+		}
+		return sum, n
+	}
+	sum, n := gated(absoluteGate)
+	if n == 0 {
+		return minLevelDBFS
+	}
+	sum, n = gated(loudness(sum/float64(n)) + relativeGate)
+	if n == 0 {
+		return minLevelDBFS
+	}
+	return loudness(sum / float64(n))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds each email delivery.
+const smtpTimeout = 30 * time.Second
+
+// emailConfig is where notifications are emailed, for those who don't run a
+// Discord webhook. Set from flags in web mode.
+type emailConfig struct {
+	server   string // host:port; disabled if empty
+	user     string
+	password string
+	from     string
+	to       []string
+
+	// sessions emails a report when a session finishes
+	sessions bool
+
+	// alertTypes are the types of alert emailed, or "all"
+	alertTypes []string
+
+	// link is the recorder's address, for links to sessions' files
+	link string
+}
+
+var email emailConfig
+
+// defaultEmailAlerts are the alerts worth an email: ones that mean a
+// recording is missing something, not clipping, which is raised often and
+// only matters to whoever's watching.
+const defaultEmailAlerts = "disk,stall,silent,schedule,fallback,hook,job"
+
+func addEmailFlags(fs *flag.FlagSet) {
+	fs.StringVar(&email.server, "smtp", "", "SMTP server to email notifications through, as host:port; port 465 uses TLS, others STARTTLS when offered (disabled if empty)")
+	fs.StringVar(&email.user, "smtp-user", "", "SMTP username")
+	fs.StringVar(&email.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&email.from, "email-from", "", "address emails are sent from (default -smtp-user)")
+	fs.Func("email-to", "addresses to email, comma-separated", func(value string) error {
+		email.to = splitList(value)
+		return nil
+	})
+	fs.BoolVar(&email.sessions, "email-sessions", true, "email a report when a session finishes")
+	email.alertTypes = splitList(defaultEmailAlerts)
+	fs.Func("email-alerts", "types of alert to email, comma-separated, or all (default "+defaultEmailAlerts+")", func(value string) error {
+		email.alertTypes = splitList(value)
+		return nil
+	})
+	fs.StringVar(&email.link, "email-link", "", "the recorder's address for links in emails, e.g. http://recorder.local:8080 (default the first -listen address)")
+}
+
+// splitList splits a comma-separated flag, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkEmailConfig checks the email flags make sense together.
+func checkEmailConfig() error {
+	if email.server == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(email.server); err != nil {
+		return fmt.Errorf("-smtp must be host:port")
+	}
+	if email.from == "" {
+		email.from = email.user
+	}
+	if email.from == "" || len(email.to) == 0 {
+		return fmt.Errorf("-smtp needs -email-from (or -smtp-user) and -email-to")
+	}
+	return nil
+}
+
+// emailsAlert reports whether alerts of a type are emailed.
+func (c *emailConfig) emailsAlert(alertType string) bool {
+	return c.server != "" && (slices.Contains(c.alertTypes, "all") || slices.Contains(c.alertTypes, alertType))
+}
+
+// send emails a plain text message to every -email-to address.
+func (c *emailConfig) send(subject, body string) error {
+	host, port, _ := net.SplitHostPort(c.server)
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.server, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", c.server)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if c.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.user, c.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, to := range c.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("%s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(c.message(subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message formats an email, quoted-printable so any text gets through.
+func (c *emailConfig) message(subject, body string) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	_, domain, _ := strings.Cut(c.from, "@")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), cmp.Or(domain, "skribbl-capture"))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return msg.Bytes()
+}
+
+// deliver sends an email in the background, reporting failures on the
+// console as about what.
+func (c *emailConfig) deliver(subject, body, what string) {
+	go func() {
+		if err := c.send(subject, body); err != nil {
+			fmt.Printf("⚠️  Failed to email %s: %v\n", what, err)
+		}
+	}()
+}
+
+// emailAlert emails an alert, or an escalation or resolution of one.
+func emailAlert(a Alert) {
+	icon := "⚠️ "
+	switch a.Event {
+	case "escalated":
+		icon = "🚨 "
+	case "resolved":
+		icon = "✅ "
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", a.Message)
+	fmt.Fprintf(&body, "Type: %s\nTime: %s\n", a.Type, a.Time.Format(time.RFC1123))
+	if a.Count > 1 {
+		fmt.Fprintf(&body, "Raised: %d times\n", a.Count)
+	}
+	if a.Device != "" {
+		fmt.Fprintf(&body, "Device: %s\n", a.Device)
+	}
+	if a.File != "" {
+		fmt.Fprintf(&body, "File: %s\n", a.File)
+	}
+	if email.link != "" {
+		fmt.Fprintf(&body, "\nRecent alerts: %s/api/alerts\n", email.link)
+	}
+	email.deliver(icon+a.Message, body.String(), "alert")
+}
+
+// emailSessionReport emails what a finished session recorded, with links to
+// its files.
+func emailSessionReport(manifest *SessionManifest) {
+	title := cmp.Or(manifest.Title, manifest.ID)
+	duration := manifest.StoppedAt.Sub(manifest.StartedAt).Round(time.Second)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s finished recording.\n\n", title)
+	fmt.Fprintf(&body, "Session: %s\nStarted: %s\nDuration: %s\n", manifest.ID, manifest.StartedAt.Local().Format(time.RFC1123), duration)
+	if len(manifest.Tags) > 0 {
+		fmt.Fprintf(&body, "Tags: %s\n", strings.Join(manifest.Tags, ", "))
+	}
+	fmt.Fprintf(&body, "\nTracks:\n")
+	for _, track := range manifest.Tracks {
+		fmt.Fprintf(&body, "- %s: %s, %s", track.Device, track.File, formatBytes(track.Size))
+		if track.DroppedFrames > 0 {
+			fmt.Fprintf(&body, ", %d frames dropped", track.DroppedFrames)
+		}
+		if email.link != "" {
+			fmt.Fprintf(&body, "\n  %s/recordings/%s", email.link, url.PathEscape(track.File))
+		}
+		body.WriteString("\n")
+	}
+	if len(manifest.Markers) > 0 {
+		fmt.Fprintf(&body, "\nMarkers: %d\n", len(manifest.Markers))
+	}
+	if len(manifest.Warnings) > 0 {
+		fmt.Fprintf(&body, "\nWarnings:\n")
+		for _, warning := range manifest.Warnings {
+			fmt.Fprintf(&body, "- %s\n", warning)
+		}
+	}
+	if email.link != "" {
+		fmt.Fprintf(&body, "\nEverything in one download: %s/api/sessions/%s/archive\n", email.link, url.PathEscape(manifest.ID))
+	}
+	subject := fmt.Sprintf("🎙️ Recorded %s (%s)", title, duration)
+	if len(manifest.Warnings) > 0 {
+		subject += " with warnings"
+	}
+	email.deliver(subject, body.String(), "session report")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// minRoundSeconds is how long a segment must have run before a round
+// boundary splits it, so a round starting just after recording does doesn't
+// leave an almost empty file behind; the session is taken as that round.
+const minRoundSeconds = 5
+
+// splitRounds makes each round of a game its own session. Set from flags in
+// web mode.
+var splitRounds bool
+
+// GameEvent is something that happened in the game being recorded, posted by
+// a browser extension or bot watching it
+type GameEvent struct {
+	Type  string `json:"type"` // "roundStart" or "roundEnd"
+	Round int    `json:"round,omitempty"`
+	Word  string `json:"word,omitempty"`
+}
+
+// Handler: POST /api/events - Feed in a game event. Round boundaries are
+// marked in the running session, or with -split-rounds, each round start
+// finishes the session and carries on recording the same devices in a new
+// one named after the round and its word.
+func handleGameEvent(w http.ResponseWriter, r *http.Request) {
+	var event GameEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	event.Word = strings.TrimSpace(event.Word)
+	if event.Type != "roundStart" && event.Type != "roundEnd" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Unknown event type: %q", event.Type))
+		return
+	}
+
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	result := map[string]interface{}{"event": event.Type}
+	if activeSession == nil {
+		result["status"] = "not recording"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	sess := activeSession
+	if event.Round == 0 {
+		event.Round = sess.round
+		if event.Type == "roundStart" {
+			event.Round++
+		}
+	}
+
+	if event.Type == "roundStart" && splitRounds && time.Since(sess.startedAt) >= minRoundSeconds*time.Second {
+		manifest, err := splitSession(r.Context(), event)
+		if err != nil {
+			writeStorageError(w, errCodeDeviceError, err)
+			return
+		}
+		result["status"] = "split"
+		result["finished"] = manifest
+		result["sessionId"] = activeSession.id
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	label := fmt.Sprintf("Round %d", event.Round)
+	switch event.Type {
+	case "roundStart":
+		sess.round, sess.word = event.Round, event.Word
+		sess.roundSegment = splitRounds
+	case "roundEnd":
+		label += " ended"
+		if sess.round == event.Round && sess.word == "" {
+			sess.word = event.Word
+		}
+	}
+	if event.Word != "" {
+		label += ": " + event.Word
+	}
+	marker := Marker{Time: time.Since(sess.startedAt).Seconds(), Label: label, Source: "event"}
+	sess.markersMu.Lock()
+	sess.markers = append(sess.markers, marker)
+	sess.markersMu.Unlock()
+	notifySessionChanged()
+
+	result["status"] = "marked"
+	result["sessionId"] = sess.id
+	result["marker"] = marker
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// splitSession starts the same devices recording a new session for the
+// round, then finishes the old one, so nothing is lost in between. Must be
+// called with recordingMutex held.
+func splitSession(ctx context.Context, event GameEvent) (*SessionManifest, error) {
+	old := activeSession
+	allDevices, err := cachedDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	var indices []int
+	for _, cap := range old.captures {
+		indices = append(indices, cap.deviceIndex)
+	}
+
+	title := fmt.Sprintf("Round %d", event.Round)
+	if event.Word != "" {
+		title += " " + event.Word
+	}
+	if old.gameTitle != "" {
+		title = old.gameTitle + " " + title
+	}
+	req := StartRecordingRequest{DeviceIndices: indices, BestEffort: true, Title: title, Tags: old.tags}
+	if _, err := startSession(ctx, allDevices, req); err != nil {
+		return nil, fmt.Errorf("failed to start round %d: %w", event.Round, err)
+	}
+	next := activeSession
+	next.gameTitle, next.round, next.word = old.gameTitle, event.Round, event.Word
+	next.roundSegment = true
+	next.preset, next.postStop = old.preset, old.postStop
+
+	manifest, err := finalizeSession(ctx, old)
+	monitoring.sync()
+	liveMix.sync()
+	notifySessionChanged()
+	if err != nil {
+		return nil, err
+	}
+	// Nothing waits for the old session, so its hooks run in the background
+	if len(old.postStop) > 0 {
+		go runPostStopHooks(old.preset, old.postStop, manifest)
+	}
+	fmt.Printf("✓ Round %d started, session %s saved\n", event.Round, old.id)
+	return manifest, nil
+}
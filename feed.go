@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// feedConfig describes the podcast feed at /feed.xml, set from flags in web
+// mode
+type feedConfig struct {
+	title       string
+	description string
+
+	// link is the address podcast apps reach the recorder at, for the
+	// episodes' download links
+	link string
+}
+
+var feed feedConfig
+
+func addFeedFlags(fs *flag.FlagSet) {
+	fs.StringVar(&feed.title, "feed-title", "skribbl-capture", "title of the podcast feed at /feed.xml")
+	fs.StringVar(&feed.description, "feed-description", "", "description of the podcast feed at /feed.xml")
+	fs.StringVar(&feed.link, "feed-link", "", "the recorder's address for links in the podcast feed, e.g. https://podcast.example.com (default the address it's fetched from)")
+}
+
+// Episode is a session published to the podcast feed, as one of its files
+type Episode struct {
+	File        string    `json:"file"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// EpisodeRequest is the request body for PUT /api/sessions/{id}/episode
+type EpisodeRequest struct {
+	File        string `json:"file"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// episodeTypes are the MIME types of the files episodes can be, by extension
+var episodeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".opus": "audio/ogg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+// RSS 2.0 with Apple's podcast extensions, which every podcast app reads
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	ITunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// publishedEpisodes lists the sessions in the feed, newest first. Archived
+// sessions are left out while their audio is away.
+func publishedEpisodes() []*SessionManifest {
+	var published []*SessionManifest
+	for _, manifest := range loadCatalog() {
+		if manifest.Episode != nil && manifest.Archived == nil {
+			published = append(published, manifest)
+		}
+	}
+	slices.SortFunc(published, func(a, b *SessionManifest) int { return b.Episode.PublishedAt.Compare(a.Episode.PublishedAt) })
+	return published
+}
+
+// feedLink is the recorder's address for links in the feed: -feed-link, or
+// where the request for it was sent.
+func feedLink(r *http.Request) string {
+	if feed.link != "" {
+		return strings.TrimSuffix(feed.link, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// episodeItem describes a published session for the feed.
+func episodeItem(manifest *SessionManifest, link string) rssItem {
+	e := manifest.Episode
+	item := rssItem{
+		Title:       e.Title,
+		Description: e.Description,
+		GUID:        rssGUID{Value: manifest.ID + "/" + e.File},
+		PubDate:     e.PublishedAt.Format(time.RFC1123Z),
+		Enclosure: rssEnclosure{
+			URL:  link + "/episodes/" + url.PathEscape(e.File),
+			Type: episodeTypes[strings.ToLower(filepath.Ext(e.File))],
+		},
+	}
+	// From -storage too, like downloads, which keeps a copy for next time
+	f, err := openRecording(e.File)
+	if err != nil {
+		// Apps take a length of 0 as unknown
+		return item
+	}
+	defer f.Close()
+	if stat, err := f.Stat(); err == nil {
+		item.Enclosure.Length = stat.Size()
+	}
+	if info, err := probeAudio(f); err == nil {
+		item.Duration = fmt.Sprint(int64(info.DurationSeconds + 0.5))
+	}
+	return item
+}
+
+// Handler: GET /feed.xml - The podcast feed of published sessions, newest
+// first
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	link := feedLink(r)
+	rss := rssFeed{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: rssChannel{
+			Title:         feed.title,
+			Link:          link,
+			Description:   cmp.Or(feed.description, feed.title),
+			LastBuildDate: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, manifest := range publishedEpisodes() {
+		rss.Channel.Items = append(rss.Channel.Items, episodeItem(manifest, link))
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(rss)
+}
+
+// Handler: GET /episodes/{file} - Download a published episode, without
+// signing in, for podcast apps
+func handleEpisodeFile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("file")
+	if !slices.ContainsFunc(publishedEpisodes(), func(m *SessionManifest) bool { return m.Episode.File == name }) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No such episode")
+		return
+	}
+	serveRecording(w, r, name)
+}
+
+// Handler: PUT /api/sessions/{id}/episode - Publish a session to the podcast
+// feed, or change its title or description, as its newest mixdown unless
+// another file is given
+func handlePublishEpisode(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	var req EpisodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+
+	episode := Episode{PublishedAt: time.Now().UTC()}
+	if manifest.Episode != nil {
+		episode = *manifest.Episode
+	}
+	if req.File != "" {
+		episode.File = req.File
+	}
+	if episode.File == "" {
+		for _, e := range manifest.Exports {
+			if e.Kind == jobMixdown {
+				episode.File = e.File
+			}
+		}
+	}
+	if episode.File == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "The session has no mixdown to publish; queue a mixdown job or give the file")
+		return
+	}
+	if !slices.Contains(manifest.files(), episode.File) || episode.File == manifest.Transcript {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, episode.File+" isn't one of the session's recordings")
+		return
+	}
+	if episodeTypes[strings.ToLower(filepath.Ext(episode.File))] == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Episodes must be MP3, Opus, FLAC or WAV files")
+		return
+	}
+	if title := strings.TrimSpace(req.Title); title != "" {
+		episode.Title = title
+	}
+	if episode.Title == "" {
+		episode.Title = cmp.Or(manifest.Title, manifest.ID)
+	}
+	if req.Description != "" {
+		episode.Description = req.Description
+	}
+
+	manifest.Episode = &episode
+	if err := writeSessionManifest(manifest); err != nil {
+		writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save session metadata: %w", err))
+		return
+	}
+	fmt.Printf("✓ Published %s to the feed as %q\n", id, episode.Title)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// Handler: DELETE /api/sessions/{id}/episode - Take a session out of the
+// podcast feed
+func handleUnpublishEpisode(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	if manifest.Episode != nil {
+		manifest.Episode = nil
+		if err := writeSessionManifest(manifest); err != nil {
+			writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save session metadata: %w", err))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
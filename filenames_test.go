@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", "USB Mic-2", "USB_Mic-2"},
+		{"japanese", "マイク", "マイク"},
+		{"chinese", "麦克风 USB", "麦克风_USB"},
+		{"korean", "마이크 (2)", "마이크__2_"},
+		{"emoji", "🎤 Mic", "__Mic"},
+		{"emoji only", "🎧🎧", "__"},
+		{"emoji with modifier", "👍🏽", "__"},
+		{"latin transliterated", "Café Müller", "Cafe_Mueller"},
+		{"combining accent dropped", "Cafe\u0301", "Cafe"},
+		{"mixed scripts", "Blue Yeti (マイク) 🎙️", "Blue_Yeti__マイク___"},
+		{"cyrillic and digits", "Микрофон 1", "Микрофон_1"},
+		{"path separators", "../a/b\\c", "___a_b_c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.in); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeASCII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"マイク", "___"},
+		{"Café 🎤", "Cafe__"},
+		{"Mic-1", "Mic-1"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeASCII(tt.in); got != tt.want {
+			t.Errorf("sanitizeASCII(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFilenameTruncation(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", strings.Repeat("a", 200), strings.Repeat("a", maxFilenamePart)},
+		{"cjk on a boundary", strings.Repeat("あ", 40), strings.Repeat("あ", 32)},
+		{"cjk across the limit", "a" + strings.Repeat("あ", 40), "a" + strings.Repeat("あ", 31)},
+		{"transliteration across the limit", strings.Repeat("a", 95) + "ÄÄ", strings.Repeat("a", 95) + "A"},
+		{"emoji", strings.Repeat("🎤", 100), strings.Repeat("_", maxFilenamePart)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFilename(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if len(got) > maxFilenamePart || !utf8.ValidString(got) {
+				t.Errorf("sanitizeFilename(%q) = %q: %d bytes, valid UTF-8 %v", tt.in, got, len(got), utf8.ValidString(got))
+			}
+		})
+	}
+}
+
+func TestUniqueFilename(t *testing.T) {
+	dir := t.TempDir()
+	for i, want := range []string{"マイク.wav", "マイク_2.wav", "マイク_3.wav"} {
+		got := uniqueFilename(dir, "マイク", ".wav")
+		if got != want {
+			t.Fatalf("name %d: uniqueFilename = %q, want %q", i+1, got, want)
+		}
+		if err := os.WriteFile(filepath.Join(dir, got), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := uniqueFilename(dir, "🎤", ".wav"); got != "🎤.wav" {
+		t.Errorf("uniqueFilename of an unused base = %q, want %q", got, "🎤.wav")
+	}
+}
+
+func TestTrackFilenameCollisions(t *testing.T) {
+	saved := outputDirectory
+	outputDirectory = t.TempDir()
+	defer func() { outputDirectory = saved }()
+
+	// Two emoji-named devices sanitize to the same name and are numbered
+	var names []string
+	for _, device := range []string{"🎤 マイク", "🎧 マイク"} {
+		name, path := trackFilename("2024-05-01_20-15-00", "ゲーム", device)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	want := []string{"2024-05-01_20-15-00_ゲーム___マイク.wav", "2024-05-01_20-15-00_ゲーム___マイク_2.wav"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("track %d: trackFilename = %q, want %q", i+1, names[i], want[i])
+		}
+	}
+}
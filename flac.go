@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A FLAC decoder covering everything the reference encoder produces: fixed
+// and LPC prediction, Rice-coded residuals, wasted bits and stereo
+// decorrelation. Checksums aren't verified.
+
+// flacDecoder reads a FLAC stream as interleaved 16-bit little-endian PCM
+type flacDecoder struct {
+	br       flacBitReader
+	info     AudioInfo
+	channels [][]int32 // decoded samples of the current frame, per channel
+	pcm      []byte    // the current frame as PCM, waiting to be read
+}
+
+// newFLACDecoder skips the metadata blocks and positions the decoder at the
+// first frame.
+func newFLACDecoder(r io.Reader, info AudioInfo) (*flacDecoder, error) {
+	d := &flacDecoder{br: flacBitReader{r: bufio.NewReaderSize(r, 64*1024)}, info: info}
+	var magic [4]byte
+	if _, err := io.ReadFull(d.br.r, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return nil, errors.New("invalid FLAC file")
+	}
+	for last := false; !last; {
+		var header [4]byte
+		if _, err := io.ReadFull(d.br.r, header[:]); err != nil {
+			return nil, fmt.Errorf("invalid FLAC metadata: %w", err)
+		}
+		last = header[0]&0x80 != 0
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if _, err := d.br.r.Discard(int(length)); err != nil {
+			return nil, fmt.Errorf("invalid FLAC metadata: %w", err)
+		}
+	}
+	return d, nil
+}
+
+func (d *flacDecoder) Read(p []byte) (int, error) {
+	for len(d.pcm) == 0 {
+		if err := d.decodeFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.pcm)
+	d.pcm = d.pcm[n:]
+	return n, nil
+}
+
+// decodeFrame decodes the next frame into d.pcm.
+func (d *flacDecoder) decodeFrame() error {
+	br := &d.br
+	br.align()
+	sync, err := br.readBits(15)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	if sync != 0x7ffc {
+		return errors.New("invalid FLAC frame: lost sync")
+	}
+	if _, err := br.readBits(1); err != nil { // blocking strategy
+		return err
+	}
+	header, err := br.readBits(16)
+	if err != nil {
+		return err
+	}
+	blockSizeCode := header >> 12
+	sampleRateCode := header >> 8 & 0xf
+	channelAssignment := header >> 4 & 0xf
+	sampleSizeCode := header >> 1 & 0x7
+
+	// The frame or sample number, UTF-8 style
+	first, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	for mask := uint64(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+		if _, err := br.readBits(8); err != nil {
+			return err
+		}
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := br.readBits(8)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, err := br.readBits(16)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return errors.New("invalid FLAC frame: reserved block size")
+	}
+	switch sampleRateCode {
+	case 12:
+		_, err = br.readBits(8)
+	case 13, 14:
+		_, err = br.readBits(16)
+	}
+	if err != nil {
+		return err
+	}
+
+	bps := d.info.BitsPerSample
+	if sampleSizeCode != 0 {
+		bps = [8]uint32{0, 8, 12, 0, 16, 20, 24, 32}[sampleSizeCode]
+		if bps == 0 {
+			return errors.New("invalid FLAC frame: reserved sample size")
+		}
+	}
+	if _, err := br.readBits(8); err != nil { // CRC-8
+		return err
+	}
+
+	numChannels := int(channelAssignment) + 1
+	if channelAssignment >= 8 {
+		numChannels = 2
+	}
+	if channelAssignment > 10 {
+		return errors.New("invalid FLAC frame: reserved channel assignment")
+	}
+	if cap(d.channels) < numChannels {
+		d.channels = make([][]int32, numChannels)
+	}
+	d.channels = d.channels[:numChannels]
+	for ch := range d.channels {
+		// The side channel carries an extra bit
+		sideBits := uint32(0)
+		if (channelAssignment == 8 && ch == 1) || (channelAssignment == 9 && ch == 0) || (channelAssignment == 10 && ch == 1) {
+			sideBits = 1
+		}
+		if cap(d.channels[ch]) < blockSize {
+			d.channels[ch] = make([]int32, blockSize)
+		}
+		d.channels[ch] = d.channels[ch][:blockSize]
+		if err := br.decodeSubframe(d.channels[ch], uint(bps+sideBits)); err != nil {
+			return err
+		}
+	}
+	br.align()
+	if _, err := br.readBits(16); err != nil { // CRC-16
+		return err
+	}
+
+	switch channelAssignment {
+	case 8: // left, side
+		for i := range blockSize {
+			d.channels[1][i] = d.channels[0][i] - d.channels[1][i]
+		}
+	case 9: // side, right
+		for i := range blockSize {
+			d.channels[0][i] += d.channels[1][i]
+		}
+	case 10: // mid, side
+		for i := range blockSize {
+			mid, side := d.channels[0][i]<<1|d.channels[1][i]&1, d.channels[1][i]
+			d.channels[0][i], d.channels[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+
+	d.pcm = d.pcm[:0]
+	for i := range blockSize {
+		for ch := range d.channels {
+			s := d.channels[ch][i]
+			if bps > 16 {
+				s >>= bps - 16
+			} else {
+				s <<= 16 - bps
+			}
+			d.pcm = binary.LittleEndian.AppendUint16(d.pcm, uint16(int16(s)))
+		}
+	}
+	return nil
+}
+
+// flacBitReader reads big-endian bit fields
+type flacBitReader struct {
+	r     *bufio.Reader
+	cache uint64
+	bits  uint
+}
+
+// readBits reads an n-bit unsigned value, n <= 56.
+func (br *flacBitReader) readBits(n uint) (uint64, error) {
+	for br.bits < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && br.bits > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		br.cache = br.cache<<8 | uint64(b)
+		br.bits += 8
+	}
+	br.bits -= n
+	v := br.cache >> br.bits & (1<<n - 1)
+	return v, nil
+}
+
+// readSigned reads an n-bit two's complement value.
+func (br *flacBitReader) readSigned(n uint) (int32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.readBits(n)
+	return int32(int64(v<<(64-n)) >> (64 - n)), err
+}
+
+// readUnary counts zero bits up to the next one bit.
+func (br *flacBitReader) readUnary() (uint64, error) {
+	var n uint64
+	for {
+		b, err := br.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+// align skips to the next byte boundary.
+func (br *flacBitReader) align() {
+	br.bits -= br.bits % 8
+}
+
+// decodeSubframe decodes one channel of a frame into samples.
+func (br *flacBitReader) decodeSubframe(samples []int32, bps uint) error {
+	header, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	kind := header >> 1 & 0x3f
+	wasted := uint(0)
+	if header&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return errors.New("invalid FLAC subframe: too many wasted bits")
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == 0:
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range samples {
+			samples[i] = v
+		}
+	case kind == 1:
+		for i := range samples {
+			if samples[i], err = br.readSigned(bps); err != nil {
+				return err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		order := int(kind & 7)
+		if err := br.decodeFixed(samples, order, bps); err != nil {
+			return err
+		}
+	case kind >= 32:
+		order := int(kind&31) + 1
+		if err := br.decodeLPC(samples, order, bps); err != nil {
+			return err
+		}
+	default:
+		return errors.New("invalid FLAC subframe: reserved type")
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// decodeFixed decodes a subframe predicted by one of the fixed polynomials.
+func (br *flacBitReader) decodeFixed(samples []int32, order int, bps uint) error {
+	if order > len(samples) {
+		return errors.New("invalid FLAC subframe: order exceeds block size")
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if samples[i], err = br.readSigned(bps); err != nil {
+			return err
+		}
+	}
+	if err := br.decodeResidual(samples, order); err != nil {
+		return err
+	}
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 1:
+			samples[i] += samples[i-1]
+		case 2:
+			samples[i] += 2*samples[i-1] - samples[i-2]
+		case 3:
+			samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+	}
+	return nil
+}
+
+// decodeLPC decodes a subframe predicted by stored LPC coefficients.
+func (br *flacBitReader) decodeLPC(samples []int32, order int, bps uint) error {
+	if order > len(samples) {
+		return errors.New("invalid FLAC subframe: order exceeds block size")
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if samples[i], err = br.readSigned(bps); err != nil {
+			return err
+		}
+	}
+	precision, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	if precision == 15 {
+		return errors.New("invalid FLAC subframe: bad LPC precision")
+	}
+	shift, err := br.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("invalid FLAC subframe: negative LPC shift")
+	}
+	coeffs := make([]int32, order)
+	for i := range coeffs {
+		if coeffs[i], err = br.readSigned(uint(precision) + 1); err != nil {
+			return err
+		}
+	}
+	if err := br.decodeResidual(samples, order); err != nil {
+		return err
+	}
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		samples[i] += int32(sum >> shift)
+	}
+	return nil
+}
+
+// decodeResidual reads the Rice-coded residual into samples[order:].
+func (br *flacBitReader) decodeResidual(samples []int32, order int) error {
+	method, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	paramBits, escape := uint(4), uint64(15)
+	switch method {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 31
+	default:
+		return errors.New("invalid FLAC residual: reserved coding method")
+	}
+	partitionOrder, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	perPartition := len(samples) >> partitionOrder
+	if perPartition < order || perPartition<<partitionOrder != len(samples) {
+		return errors.New("invalid FLAC residual: bad partition order")
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		n := perPartition
+		if p == 0 {
+			n -= order
+		}
+		param, err := br.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			rawBits, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for end := i + n; i < end; i++ {
+				if samples[i], err = br.readSigned(uint(rawBits)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for end := i + n; i < end; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.readBits(uint(param))
+			if err != nil {
+				return err
+			}
+			v := q<<param | low
+			samples[i] = int32(v>>1) ^ -int32(v&1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GainResult is what gain wrote, printed as JSON with -json
+type GainResult struct {
+	File   string  `json:"file"`
+	GainDB float64 `json:"gainDb"`
+
+	// The original's levels, which the gain was worked out from
+	PeakDBFS     float64 `json:"peakDbfs"`
+	LoudnessLUFS float64 `json:"loudnessLufs"`
+
+	ClippedSamples int `json:"clippedSamples"`
+}
+
+// runGain writes a copy of an audio file turned up or down, by a given
+// number of dB or to bring it to a loudness or peak level, with the gain the
+// recorder applies to devices while recording.
+func runGain(args []string) {
+	fs := flag.NewFlagSet("gain", flag.ExitOnError)
+	db := fs.Float64("db", 0, "gain to apply in dB, such as +6 or -3")
+	normalize := fs.String("normalize", "", "loudness or peak level to bring the file to instead, such as -16LUFS or -1dBFS")
+	outPath := fs.String("out", "", "file to write (default <name>_gain.wav or <name>_normalized.wav next to the file)")
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: skribbl-capture gain [flags] file")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	dbSet := false
+	fs.Visit(func(f *flag.Flag) { dbSet = dbSet || f.Name == "db" })
+	if fs.NArg() != 1 || dbSet == (*normalize != "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	var target float64
+	var unit string
+	if *normalize != "" {
+		var err error
+		if target, unit, err = parseNormalizeTarget(*normalize); err != nil {
+			uiWarnf("✗ %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		uiWarnf("✗ %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	pcm, err := openPCM16(f, info)
+	var levels LevelAnalysis
+	var loudness float64
+	if err == nil {
+		levels, loudness, err = measureLoudness(pcm, info)
+	}
+	if err != nil {
+		uiWarnf("✗ %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	result := GainResult{GainDB: *db, PeakDBFS: levels.PeakDBFS, LoudnessLUFS: loudness}
+	suffix := "_gain"
+	if *normalize != "" {
+		suffix = "_normalized"
+		if levels.PeakDBFS <= minLevelDBFS || loudness <= minLevelDBFS {
+			uiWarnf("✗ %s is silent, so there's nothing to normalize\n", path)
+			os.Exit(1)
+		}
+		if unit == "LUFS" {
+			result.GainDB = target - loudness
+		} else {
+			result.GainDB = target - levels.PeakDBFS
+		}
+	}
+	uiPrintf("%s: peak %.1f dBFS, loudness %.1f LUFS\n", filepath.Base(path), levels.PeakDBFS, loudness)
+
+	if *outPath == "" {
+		dir := filepath.Dir(path)
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		*outPath = filepath.Join(dir, uniqueFilename(dir, base+suffix, ".wav"))
+	}
+	result.File = filepath.Base(*outPath)
+	if pcm, err = openPCM16(f, info); err == nil {
+		result.ClippedSamples, err = writeGainedFile(*outPath, info, pcm, dbToGain(result.GainDB))
+	}
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+
+	uiPrintf("✓ %s (%+.1f dB)\n", *outPath, result.GainDB)
+	if result.ClippedSamples > 0 {
+		uiWarnf("⚠️  %d samples clipped at full scale: use a lower level\n", result.ClippedSamples)
+	}
+	printResult(result)
+}
+
+// parseNormalizeTarget parses -normalize: a level in LUFS, such as -16LUFS,
+// or a peak level in dBFS, such as -1dBFS.
+func parseNormalizeTarget(value string) (float64, string, error) {
+	s := strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+	for _, unit := range []string{"LUFS", "dBFS"} {
+		number, ok := strings.CutSuffix(s, strings.ToUpper(unit))
+		if !ok {
+			continue
+		}
+		level, err := strconv.ParseFloat(number, 64)
+		switch {
+		case err != nil:
+		case unit == "LUFS" && (level < -70 || level > -5):
+			return 0, "", errors.New("-normalize loudness must be from -70 to -5 LUFS")
+		case unit == "dBFS" && (level <= minLevelDBFS || level > 0):
+			return 0, "", errors.New("-normalize peak level must be from -96 to 0 dBFS")
+		default:
+			return level, unit, nil
+		}
+	}
+	return 0, "", fmt.Errorf("invalid -normalize level %q: use a loudness such as -16LUFS or a peak level such as -1dBFS", value)
+}
+
+// measureLoudness decodes PCM and measures its levels and integrated
+// loudness in one pass.
+func measureLoudness(pcm io.Reader, info AudioInfo) (LevelAnalysis, float64, error) {
+	levels := newLevelMeter(info.SampleRate, info.Channels)
+	loudness := newLoudnessMeter(info.SampleRate, info.Channels)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := io.ReadFull(pcm, buf)
+		levels.add(buf[:n&^1])
+		loudness.add(buf[:n&^1])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return levels.result(), loudness.result(), nil
+		}
+		if err != nil {
+			return LevelAnalysis{}, 0, err
+		}
+	}
+}
+
+// writeGainedFile writes pcm, scaled by gain, to a new WAV file at path, and
+// returns how many samples clipped.
+func writeGainedFile(path string, info AudioInfo, pcm io.Reader, gain float64) (int, error) {
+	out, err := createNewFile(path)
+	if err != nil {
+		return 0, err
+	}
+	clipped, err := writeGainedWAV(out, info, pcm, gain)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return clipped, nil
+}
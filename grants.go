@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Grant shares a session, or one of its files, with a user, or with anyone
+// who has its link, since each player usually only needs their own track
+type Grant struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId"`
+	File      string `json:"file,omitempty"` // the whole session if empty
+
+	// User is who it's shared with, or Token makes a link, /share/<token>,
+	// that works without signing in
+	User  string `json:"user,omitempty"`
+	Token string `json:"token,omitempty"`
+	Link  string `json:"link,omitempty"`
+
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// GrantRequest is the request body for POST /api/grants
+type GrantRequest struct {
+	SessionID      string `json:"sessionId"`
+	File           string `json:"file"`
+	User           string `json:"user"`
+	Link           bool   `json:"link"`
+	ExpiresInHours int    `json:"expiresInHours"` // 0 never expires
+}
+
+// grants are the shares in force, kept in the recordings folder.
+var grants struct {
+	sync.Mutex
+	list []Grant
+}
+
+func grantsPath() string {
+	return filepath.Join(outputDirectory, ".grants.json")
+}
+
+// loadGrants reads the saved grants.
+func loadGrants() error {
+	data, err := os.ReadFile(grantsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	grants.Lock()
+	defer grants.Unlock()
+	if err := json.Unmarshal(data, &grants.list); err != nil {
+		return fmt.Errorf("invalid grants file %s: %w", grantsPath(), err)
+	}
+	return nil
+}
+
+// saveGrants writes the grants. Must be called with grants locked.
+func saveGrants() error {
+	data, _ := json.MarshalIndent(grants.list, "", "  ")
+	return writeFileAtomic(grantsPath(), append(data, '\n'))
+}
+
+// activeGrants drops expired grants and returns the rest. Must be called
+// with grants locked.
+func activeGrants() []Grant {
+	now := time.Now()
+	n := len(grants.list)
+	grants.list = slices.DeleteFunc(grants.list, func(g Grant) bool { return g.ExpiresAt != nil && now.After(*g.ExpiresAt) })
+	if len(grants.list) != n {
+		if err := saveGrants(); err != nil {
+			fmt.Printf("⚠️  Failed to save grants: %v\n", err)
+		}
+	}
+	return grants.list
+}
+
+// grantedTo lists what's been shared with a user: the files of each session,
+// nil for all of them.
+func grantedTo(name string) map[string][]string {
+	grants.Lock()
+	defer grants.Unlock()
+	shared := map[string][]string{}
+	for _, g := range activeGrants() {
+		if g.User != name {
+			continue
+		}
+		files, ok := shared[g.SessionID]
+		switch {
+		case g.File == "":
+			shared[g.SessionID] = nil
+		case !ok || files != nil:
+			shared[g.SessionID] = append(files, g.File)
+		}
+	}
+	return shared
+}
+
+// sharedWithLink returns the grant with a link token, or nil.
+func sharedWithLink(token string) *Grant {
+	grants.Lock()
+	defer grants.Unlock()
+	for _, g := range activeGrants() {
+		if g.Token != "" && subtle.ConstantTimeCompare([]byte(g.Token), []byte(token)) == 1 {
+			return &g
+		}
+	}
+	return nil
+}
+
+// randomHex returns n random bytes in hex, for grant IDs and link tokens.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Handler: POST /api/grants - Share a session, or one of its files, with a
+// user or by link
+func handleCreateGrant(w http.ResponseWriter, r *http.Request) {
+	var req GrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if (req.User == "") == !req.Link {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Share with either a user or a link")
+		return
+	}
+	if req.User != "" && !slices.ContainsFunc(users, func(u User) bool { return u.Name == req.User }) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "No such user: "+req.User)
+		return
+	}
+	if req.ExpiresInHours < 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "expiresInHours can't be negative")
+		return
+	}
+	if !validSessionID(req.SessionID) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifest, err := readSessionManifest(req.SessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	if req.File != "" && !slices.Contains(manifest.files(), req.File) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No such file in the session: "+req.File)
+		return
+	}
+
+	g := Grant{ID: randomHex(4), SessionID: manifest.ID, File: req.File, User: req.User, CreatedAt: time.Now().UTC()}
+	if u := requestUser(r); u != nil {
+		g.CreatedBy = u.Name
+	}
+	if req.Link {
+		g.Token = randomHex(16)
+		g.Link = "/share/" + g.Token
+	}
+	if req.ExpiresInHours > 0 {
+		expires := g.CreatedAt.Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		g.ExpiresAt = &expires
+	}
+	grants.Lock()
+	grants.list = append(activeGrants(), g)
+	err = saveGrants()
+	grants.Unlock()
+	if err != nil {
+		writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save grants: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// Handler: GET /api/grants?sessionId= - List what's shared, for one session
+// or all of them
+func handleListGrants(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("sessionId")
+	grants.Lock()
+	list := []Grant{}
+	for _, g := range activeGrants() {
+		if sessionID == "" || g.SessionID == sessionID {
+			list = append(list, g)
+		}
+	}
+	grants.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: DELETE /api/grants/{id} - Stop sharing
+func handleDeleteGrant(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	grants.Lock()
+	defer grants.Unlock()
+	i := slices.IndexFunc(grants.list, func(g Grant) bool { return g.ID == id })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Grant not found")
+		return
+	}
+	grants.list = slices.Delete(grants.list, i, i+1)
+	if err := saveGrants(); err != nil {
+		writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save grants: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler: GET /share/{token} - Download what a link shares, without signing
+// in: the file, or the whole session as a tar archive
+func handleShareLink(w http.ResponseWriter, r *http.Request) {
+	g := sharedWithLink(r.PathValue("token"))
+	if g == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "This link has expired or been revoked")
+		return
+	}
+	if g.File != "" {
+		if !inCatalog(g.File) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Recording not found")
+			return
+		}
+		serveRecording(w, r, g.File)
+		return
+	}
+	manifest, err := readSessionManifest(g.SessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	serveSessionArchive(w, r, manifest)
+}
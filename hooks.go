@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPreStartTimeout bounds a pre-start hook, which holds up the
+	// recording starting.
+	defaultPreStartTimeout = 30 * time.Second
+
+	// defaultPostStopTimeout bounds a post-stop hook, which holds up the
+	// stop response and may be uploading or transcoding a whole session.
+	defaultPostStopTimeout = 30 * time.Minute
+)
+
+// Hook is a command a preset runs before recording starts or after it stops
+type Hook struct {
+	// Command is run directly, not through a shell. {preset}, {title} and
+	// {dir} are replaced in its arguments, and after recording {session} and
+	// {manifest}; an argument of just {files} becomes the session's tracks.
+	Command []string `json:"command"`
+
+	// Timeout in seconds, default 30 before recording and 30 minutes after
+	Timeout int `json:"timeout,omitempty"`
+
+	// Optional hooks can fail without stopping the preset: the recording
+	// starts anyway, or the remaining post-stop hooks still run
+	Optional bool `json:"optional,omitempty"`
+}
+
+// hookRun is what hooks are told about the recording they run for.
+type hookRun struct {
+	preset   string
+	title    string
+	manifest *SessionManifest // nil before recording
+}
+
+// args expands the placeholders in a hook's command.
+func (h Hook) args(run hookRun) []string {
+	dir, _ := filepath.Abs(outputDirectory)
+	pairs := []string{"{preset}", run.preset, "{title}", run.title, "{dir}", dir}
+	if run.manifest != nil {
+		manifestPath, _ := filepath.Abs(sessionManifestPath(run.manifest.ID))
+		pairs = append(pairs, "{session}", run.manifest.ID, "{manifest}", manifestPath)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	var args []string
+	for _, arg := range h.Command {
+		if arg == "{files}" && run.manifest != nil {
+			for _, track := range run.manifest.Tracks {
+				args = append(args, filepath.Join(dir, track.File))
+			}
+			continue
+		}
+		args = append(args, replacer.Replace(arg))
+	}
+	return args
+}
+
+// run runs a hook to completion, with the recording's details in the
+// environment as well.
+func (h Hook) run(run hookRun, defaultTimeout time.Duration) error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("hook has no command")
+	}
+	timeout := defaultTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := h.args(run)
+	dir, _ := filepath.Abs(outputDirectory)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"SKRIBBL_PRESET="+run.preset,
+		"SKRIBBL_TITLE="+run.title,
+		"SKRIBBL_OUTPUT_DIR="+dir,
+	)
+	if run.manifest != nil {
+		manifestPath, _ := filepath.Abs(sessionManifestPath(run.manifest.ID))
+		cmd.Env = append(cmd.Env, "SKRIBBL_SESSION_ID="+run.manifest.ID, "SKRIBBL_MANIFEST="+manifestPath)
+	}
+	// Hooks that launch a long-running program should detach it; waiting for
+	// output would otherwise wait for it to exit
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %v", args[0], timeout)
+	}
+	if err != nil {
+		if output := strings.TrimSpace(string(output)); output != "" {
+			return fmt.Errorf("%s failed: %v: %s", args[0], err, output)
+		}
+		return fmt.Errorf("%s failed: %v", args[0], err)
+	}
+	return nil
+}
+
+// runPreStartHooks runs a preset's pre-start hooks in order, returning
+// warnings for optional hooks that failed, or the first error from one that
+// wasn't.
+func runPreStartHooks(name string, preset Preset, title string) ([]string, error) {
+	var warnings []string
+	for _, hook := range preset.PreStart {
+		err := hook.run(hookRun{preset: name, title: title}, defaultPreStartTimeout)
+		if err == nil {
+			continue
+		}
+		if !hook.Optional {
+			return warnings, fmt.Errorf("pre-start hook for preset %s: %w", name, err)
+		}
+		fmt.Printf("⚠️  Pre-start hook for preset %s: %v\n", name, err)
+		warnings = append(warnings, fmt.Sprintf("pre-start hook failed: %v", err))
+	}
+	return warnings, nil
+}
+
+// HookResult is how one of a preset's post-stop hooks went, as listed in
+// the session's metadata
+type HookResult struct {
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"` // after a hook that failed
+}
+
+// runPostStopHooks runs a preset's post-stop hooks in order once its session
+// is saved, raising an alert if one fails, and lists how they went in the
+// session's metadata. Later hooks are skipped after a failure unless it was
+// optional, since they usually build on it, e.g. an upload of a transcode.
+// Hooks may call the API, so recordingMutex must not be held.
+func runPostStopHooks(name string, hooks []Hook, manifest *SessionManifest) []HookResult {
+	var results []HookResult
+	failed := false
+	for i, hook := range hooks {
+		result := HookResult{Command: strings.Join(hook.Command, " ")}
+		if failed {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+		err := hook.run(hookRun{preset: name, title: manifest.Title, manifest: manifest}, defaultPostStopTimeout)
+		if err != nil {
+			result.Error = err.Error()
+			raiseAlert(Alert{
+				Type:    "hook",
+				Key:     "hook:" + name,
+				Message: fmt.Sprintf("Post-stop hook %d of preset %s failed for session %s: %v", i+1, name, manifest.ID, err),
+			})
+			failed = !hook.Optional
+		}
+		results = append(results, result)
+	}
+	if err := updateManifest(manifest.ID, func(m *SessionManifest) { m.PostStopHooks = results }); err != nil {
+		fmt.Printf("⚠️  Failed to note post-stop hooks for session %s: %v\n", manifest.ID, err)
+	}
+	if len(hooks) > 0 && !failed {
+		fmt.Printf("✓ Post-stop hooks done for session %s\n", manifest.ID)
+		resolveAlert("hook:"+name, fmt.Sprintf("Post-stop hooks of preset %s ran for session %s", name, manifest.ID))
+	}
+	return results
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// icsHorizon is how far ahead calendar events are scheduled. Recurring
+	// events are expanded up to this point on every sync.
+	icsHorizon = 14 * 24 * time.Hour
+
+	// icsFetchTimeout bounds each calendar download.
+	icsFetchTimeout = 30 * time.Second
+
+	// maxICSSize bounds how much of a calendar is read.
+	maxICSSize = 16 << 20
+)
+
+// icsConfig subscribes to a calendar whose matching events become scheduled
+// recordings
+type icsConfig struct {
+	url      string
+	keyword  string
+	preset   string
+	interval time.Duration
+}
+
+// icsEvent is one VEVENT, before recurring events are expanded
+type icsEvent struct {
+	uid          string
+	summary      string
+	description  string
+	categories   []string
+	start        time.Time
+	end          time.Time
+	duration     time.Duration
+	rrule        map[string]string
+	exdates      []time.Time
+	recurrenceID time.Time
+	cancelled    bool
+}
+
+// icsOccurrence is a single sitting of an event
+type icsOccurrence struct {
+	event *icsEvent
+	start time.Time
+	end   time.Time
+}
+
+func addICSFlags(fs *flag.FlagSet) *icsConfig {
+	cfg := &icsConfig{}
+	fs.StringVar(&cfg.url, "ics", "", "ICS calendar URL to schedule recordings from (webcal:// works too; disabled if empty)")
+	fs.StringVar(&cfg.keyword, "ics-keyword", "skribbl", "only events mentioning this word in their title, description or categories are recorded")
+	fs.StringVar(&cfg.preset, "ics-preset", "", "preset to record calendar events with (default: the last devices recorded)")
+	fs.DurationVar(&cfg.interval, "ics-interval", 15*time.Minute, "how often the calendar is fetched")
+	return cfg
+}
+
+// followICS syncs the calendar into the schedule for the life of the process.
+func followICS(cfg *icsConfig) {
+	for {
+		if err := cfg.sync(); err != nil {
+			fmt.Printf("⚠️  Calendar sync failed: %v\n", err)
+		}
+		time.Sleep(cfg.interval)
+	}
+}
+
+// sync fetches the calendar and brings the scheduled recordings in line with
+// it. Recordings that have started are left alone, even if their event
+// changed or disappeared.
+func (cfg *icsConfig) sync() error {
+	events, err := cfg.fetch()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	wanted := map[string]*ScheduledRecording{}
+	for _, occ := range expandEvents(events, now, now.Add(icsHorizon)) {
+		if !occ.event.matches(cfg.keyword) {
+			continue
+		}
+		rec := &ScheduledRecording{
+			ID:       icsRecordingID(occ),
+			Title:    occ.event.summary,
+			Start:    occ.start,
+			End:      occ.end,
+			Preset:   cfg.preset,
+			Tags:     append([]string{occ.event.summary}, occ.event.categories...),
+			Source:   "ics",
+			EventUID: occ.event.uid,
+			Status:   scheduleStatusPending,
+		}
+		wanted[rec.ID] = rec
+	}
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	added, updated, removed := 0, 0, 0
+	scheduler.recordings = slices.DeleteFunc(scheduler.recordings, func(rec *ScheduledRecording) bool {
+		if rec.Source != "ics" {
+			return false
+		}
+		want, ok := wanted[rec.ID]
+		delete(wanted, rec.ID)
+		if rec.Status != scheduleStatusPending {
+			return false
+		}
+		if !ok {
+			removed++
+			return true
+		}
+		if rec.Title != want.Title || !rec.End.Equal(want.End) || rec.Preset != want.Preset || !slices.Equal(rec.Tags, want.Tags) {
+			rec.Title, rec.End, rec.Preset, rec.Tags = want.Title, want.End, want.Preset, want.Tags
+			updated++
+		}
+		return false
+	})
+	for _, rec := range wanted {
+		// Anything already over was either recorded or missed on a previous
+		// sync, or happened while the recorder wasn't running
+		if !rec.End.After(now) {
+			continue
+		}
+		scheduler.recordings = append(scheduler.recordings, rec)
+		added++
+	}
+	if added+updated+removed > 0 {
+		saveSchedule()
+		fmt.Printf("⏰ Calendar synced: %d added, %d updated, %d removed\n", added, updated, removed)
+	}
+	return nil
+}
+
+// fetch downloads and parses the calendar.
+func (cfg *icsConfig) fetch() ([]*icsEvent, error) {
+	url := cfg.url
+	if rest, ok := strings.CutPrefix(url, "webcal://"); ok {
+		url = "https://" + rest
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), icsFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching calendar: %s", resp.Status)
+	}
+	return parseICS(io.LimitReader(resp.Body, maxICSSize))
+}
+
+// icsRecordingID identifies an occurrence across syncs, so it's scheduled
+// once however often the calendar is fetched. A moved event gets a new ID and
+// its old slot is removed.
+func icsRecordingID(occ icsOccurrence) string {
+	sum := sha256.Sum256([]byte(occ.event.uid + "|" + occ.start.UTC().Format(time.RFC3339)))
+	return "ics-" + hex.EncodeToString(sum[:6])
+}
+
+// matches reports whether the event mentions the keyword, ignoring case.
+func (e *icsEvent) matches(keyword string) bool {
+	keyword = strings.ToLower(keyword)
+	if keyword == "" {
+		return true
+	}
+	fields := append([]string{e.summary, e.description}, e.categories...)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseICS reads the VEVENTs of an iCalendar file. All-day events are skipped,
+// since there's nothing sensible to record.
+func parseICS(r io.Reader) ([]*icsEvent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*icsEvent
+	var cur *icsEvent
+	allDay := false
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			cur, allDay = &icsEvent{}, false
+		case name == "END" && value == "VEVENT":
+			if cur != nil && !allDay && !cur.start.IsZero() {
+				events = append(events, cur)
+			}
+			cur = nil
+		case cur == nil:
+		case name == "UID":
+			cur.uid = value
+		case name == "SUMMARY":
+			cur.summary = unescapeICS(value)
+		case name == "DESCRIPTION":
+			cur.description = unescapeICS(value)
+		case name == "CATEGORIES":
+			for _, c := range splitICSList(value) {
+				cur.categories = append(cur.categories, unescapeICS(c))
+			}
+		case name == "STATUS":
+			cur.cancelled = value == "CANCELLED"
+		case name == "DTSTART":
+			if params["VALUE"] == "DATE" {
+				allDay = true
+				continue
+			}
+			cur.start, err = parseICSTime(value, params["TZID"])
+		case name == "DTEND":
+			cur.end, err = parseICSTime(value, params["TZID"])
+		case name == "DURATION":
+			cur.duration, err = parseICSDuration(value)
+		case name == "RRULE":
+			cur.rrule = map[string]string{}
+			for _, part := range strings.Split(value, ";") {
+				if k, v, ok := strings.Cut(part, "="); ok {
+					cur.rrule[k] = v
+				}
+			}
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, perr := parseICSTime(v, params["TZID"])
+				if perr == nil {
+					cur.exdates = append(cur.exdates, t)
+				}
+			}
+		case name == "RECURRENCE-ID":
+			cur.recurrenceID, err = parseICSTime(value, params["TZID"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in event %q: %w", name, cur.summary, err)
+		}
+	}
+
+	for _, e := range events {
+		if e.end.IsZero() {
+			e.end = e.start.Add(e.duration)
+		}
+	}
+	return events, nil
+}
+
+// unfoldICS splits a calendar into content lines, joining lines that were
+// folded onto a continuation line starting with a space or tab.
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxICSSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitICSLine splits "NAME;PARAM=x:value" into its name, parameters and value.
+func splitICSLine(line string) (string, map[string]string, string) {
+	// The value starts at the first colon outside a quoted parameter
+	inQuote := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuote = !inQuote
+		} else if c == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, ""
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := map[string]string{}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+// splitICSList splits a comma-separated value, leaving escaped commas alone.
+func splitICSList(value string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
+}
+
+// unescapeICS undoes the backslash escapes of a text value.
+func unescapeICS(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// parseICSTime parses a DATE-TIME in UTC ("Z"), in the named zone, or as
+// local time. Zones Go doesn't know, such as Windows zone names, are treated
+// as local time.
+func parseICSTime(value, tzid string) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	loc := time.Local
+	if tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	if len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseICSDuration parses durations such as PT1H30M or P1D.
+func parseICSDuration(value string) (time.Duration, error) {
+	rest, negative := strings.CutPrefix(value, "-")
+	rest = strings.TrimPrefix(rest, "+")
+	rest, ok := strings.CutPrefix(rest, "P")
+	if !ok {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var d time.Duration
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	num := ""
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case c == 'T':
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			n, err := strconv.Atoi(num)
+			unit, known := units[c]
+			if err != nil || !known {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			d += time.Duration(n) * unit
+			num = ""
+		}
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// expandEvents returns the occurrences of the events that overlap [from, to).
+// Recurring events support the DAILY and WEEKLY rules calendar apps create
+// for regular meetings; other rules only yield their first occurrence.
+// Rescheduled or cancelled occurrences of a series replace the original.
+func expandEvents(events []*icsEvent, from, to time.Time) []icsOccurrence {
+	overridden := map[string][]time.Time{}
+	for _, e := range events {
+		if !e.recurrenceID.IsZero() {
+			overridden[e.uid] = append(overridden[e.uid], e.recurrenceID)
+		}
+	}
+
+	var occs []icsOccurrence
+	for _, e := range events {
+		if e.cancelled {
+			continue
+		}
+		length := e.end.Sub(e.start)
+		skip := slices.Concat(e.exdates, overridden[e.uid])
+		if !e.recurrenceID.IsZero() {
+			skip = nil
+		}
+		for _, start := range e.starts(to) {
+			end := start.Add(length)
+			if !end.After(from) || slices.ContainsFunc(skip, start.Equal) {
+				continue
+			}
+			occs = append(occs, icsOccurrence{event: e, start: start, end: end})
+		}
+	}
+	return occs
+}
+
+// starts lists the start times of an event before the given time.
+func (e *icsEvent) starts(before time.Time) []time.Time {
+	if e.rrule == nil || !e.recurrenceID.IsZero() {
+		return []time.Time{e.start}
+	}
+
+	interval, _ := strconv.Atoi(e.rrule["INTERVAL"])
+	interval = max(interval, 1)
+	count, _ := strconv.Atoi(e.rrule["COUNT"])
+	var until time.Time
+	if v := e.rrule["UNTIL"]; v != "" {
+		until, _ = parseICSTime(v, "")
+	}
+
+	var days []time.Weekday
+	weekdays := map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+	for _, d := range strings.Split(e.rrule["BYDAY"], ",") {
+		if wd, ok := weekdays[d]; ok {
+			days = append(days, wd)
+		}
+	}
+
+	var step func(time.Time, int) time.Time
+	switch e.rrule["FREQ"] {
+	case "DAILY":
+		step = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n*interval) }
+	case "WEEKLY":
+		step = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n*interval) }
+		if len(days) == 0 {
+			days = []time.Weekday{e.start.Weekday()}
+		}
+	default:
+		return []time.Time{e.start}
+	}
+
+	// Weekly rules with BYDAY repeat on each listed day of every matching
+	// week, counted from the week of the first occurrence
+	weekStart := e.start.AddDate(0, 0, -int(e.start.Weekday()))
+	var starts []time.Time
+	for n := 0; ; n++ {
+		var candidates []time.Time
+		if e.rrule["FREQ"] == "WEEKLY" {
+			week := step(weekStart, n)
+			for _, d := range days {
+				candidates = append(candidates, week.AddDate(0, 0, int(d)))
+			}
+			slices.SortFunc(candidates, time.Time.Compare)
+		} else {
+			candidates = []time.Time{step(e.start, n)}
+		}
+
+		for _, t := range candidates {
+			if t.Before(e.start) {
+				continue
+			}
+			if !t.Before(before) || (!until.IsZero() && t.After(until)) || (count > 0 && len(starts) >= count) {
+				return starts
+			}
+			starts = append(starts, t)
+		}
+	}
+}
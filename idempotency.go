@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a response is remembered for replay.
+	idempotencyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLength rejects keys that are clearly not UUIDs or similar.
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is a stored response, replayed when a client retries
+// with the same Idempotency-Key.
+type idempotentResponse struct {
+	path        string
+	status      int
+	contentType string
+	body        []byte
+	created     time.Time
+	inFlight    bool
+}
+
+// idempotencyStore remembers responses by key, and with -users by the user
+// who sent it, so one user's key never replays another's response.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+var idempotencyKeys = &idempotencyStore{responses: map[string]*idempotentResponse{}}
+
+// responseRecorder buffers a response so it can be stored before sending.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header         { return r.header }
+func (r *responseRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *responseRecorder) WriteHeader(status int)      { r.status = status }
+
+// withIdempotency makes a handler safe to retry: a request carrying an
+// Idempotency-Key that was already answered gets the original response
+// replayed instead of being executed again. Server errors aren't stored, so
+// those can be retried for real.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		if u := requestUser(r); u != nil {
+			key = u.Name + "\x00" + key
+		}
+
+		store := idempotencyKeys
+		store.mu.Lock()
+		store.expire()
+		if stored, ok := store.responses[key]; ok {
+			store.mu.Unlock()
+			switch {
+			case stored.path != r.URL.Path:
+				writeError(w, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, "Idempotency-Key was already used for "+stored.path)
+			case stored.inFlight:
+				writeError(w, http.StatusConflict, errCodeIdempotencyKeyInUse, "A request with this Idempotency-Key is still in progress")
+			default:
+				w.Header().Set("Content-Type", stored.contentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.status)
+				w.Write(stored.body)
+			}
+			return
+		}
+		entry := &idempotentResponse{path: r.URL.Path, created: time.Now(), inFlight: true}
+		store.responses[key] = entry
+		store.mu.Unlock()
+
+		rec := &responseRecorder{header: w.Header(), status: http.StatusOK}
+		next(rec, r)
+
+		store.mu.Lock()
+		if rec.status >= 500 {
+			delete(store.responses, key)
+		} else {
+			entry.status = rec.status
+			entry.contentType = rec.header.Get("Content-Type")
+			entry.body = rec.body.Bytes()
+			entry.inFlight = false
+		}
+		store.mu.Unlock()
+
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// expire drops responses older than the TTL. Must be called with mu held.
+func (s *idempotencyStore) expire() {
+	for key, stored := range s.responses {
+		if !stored.inFlight && time.Since(stored.created) > idempotencyTTL {
+			delete(s.responses, key)
+		}
+	}
+}
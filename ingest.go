@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often the recordings folder is scanned for files added
+// by other tools. 0 disables the watcher. Set from flags in web mode.
+var watchInterval = 10 * time.Second
+
+// importPath is where recordings are uploaded. Uploads get their own body
+// size limit.
+const importPath = "/api/recordings/import"
+
+// ingestMu serializes ingesting, so two files can't claim the same session ID
+// or file name.
+var ingestMu sync.Mutex
+
+// importing holds uploads that have been moved into the output directory, and
+// clips being written, that aren't cataloged yet, so the watcher leaves them
+// alone. Guarded by ingestMu.
+var importing = map[string]bool{}
+
+// ingestRecording catalogs an audio file that's already in the output
+// directory as a single-track session of its own, probing its format and
+// length and measuring its checksum and peak level. Files that can't be
+// decoded are refused. The title defaults to the file name.
+func ingestRecording(name, source, title string, tags []string) (*SessionManifest, error) {
+	f, err := openRecording(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+
+	info, err := probeAudio(f)
+	if err != nil {
+		return nil, err
+	}
+	size, sum, err := checksumFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", name, err)
+	}
+
+	if title == "" {
+		title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	track := TrackInfo{
+		File:            name,
+		Device:          title,
+		Type:            "external",
+		SampleRate:      info.SampleRate,
+		Channels:        info.Channels,
+		BitsPerSample:   info.BitsPerSample,
+		Size:            size,
+		DurationSeconds: info.DurationSeconds,
+		SHA256:          sum,
+		Format:          info.Format,
+	}
+	levels, err := measureLevels(f, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	track.PeakDBFS = &levels.PeakDBFS
+
+	// The file's modification time is the best guess at when it was recorded
+	stoppedAt := stat.ModTime().UTC()
+	ingestMu.Lock()
+	defer ingestMu.Unlock()
+	manifest := &SessionManifest{
+		ID:        newIngestID(title),
+		Title:     title,
+		Tags:      tags,
+		StartedAt: stoppedAt.Add(-time.Duration(info.DurationSeconds * float64(time.Second))),
+		StoppedAt: stoppedAt,
+		Tracks:    []TrackInfo{track},
+		Source:    source,
+	}
+	if err := writeSessionManifest(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// newIngestID picks a session ID for an ingested file from its name. Must be
+// called with ingestMu held.
+func newIngestID(title string) string {
+	base := sanitizeFilename(title)
+	if base == "" {
+		base = "import"
+	}
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(sessionManifestPath(id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// watchRecordings catalogs audio files that other tools drop into the
+// recordings folder, for the life of the process. Without a native file
+// notification API in the standard library, it polls. A file is only taken
+// once its size and modification time have held still for a whole interval,
+// so one that's still being copied in isn't read half written.
+func watchRecordings() {
+	type seen struct {
+		size    int64
+		modTime time.Time
+	}
+	pending := map[string]seen{}
+	failed := map[string]seen{}
+
+	for range time.Tick(watchInterval) {
+		entries, err := os.ReadDir(outputDirectory)
+		if err != nil {
+			continue
+		}
+		known := catalogFiles()
+		ingestMu.Lock()
+		for name := range importing {
+			known[name] = true
+		}
+		ingestMu.Unlock()
+
+		next := map[string]seen{}
+		for _, entry := range entries {
+			name := entry.Name()
+			if known[name] || !entry.Type().IsRegular() || !isAudioFile(name) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			now := seen{size: info.Size(), modTime: info.ModTime()}
+			if failed[name] == now {
+				// Already reported; only retried once the file changes
+				continue
+			}
+			if pending[name] != now {
+				next[name] = now
+				continue
+			}
+
+			manifest, err := ingestRecording(name, "watched", "", nil)
+			if err != nil {
+				fmt.Printf("⚠️  Skipping %s found in %s: %v\n", name, outputDirectory, err)
+				failed[name] = now
+				continue
+			}
+			delete(failed, name)
+			fmt.Printf("✓ Added %s to the catalog as session %s (%s)\n", name, manifest.ID, formatElapsed(time.Duration(manifest.Tracks[0].DurationSeconds*float64(time.Second))))
+			queueAutoConvert(manifest)
+		}
+		pending = next
+	}
+}
+
+// Handler: POST /api/recordings/import - Upload an existing WAV or FLAC file
+// as a multipart form with a "file" part and optional "title" and "tags"
+// (repeated, or comma separated) fields. It's cataloged like a recording.
+func handleImportRecording(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Expected a multipart/form-data upload")
+		return
+	}
+
+	// The upload is streamed to a hidden temporary file next to the
+	// recordings, so it can be renamed into place once it checks out
+	tmp, err := os.CreateTemp(outputDirectory, ".import-*")
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var title, uploadName string
+	var tags []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		switch part.FormName() {
+		case "file":
+			uploadName = part.FileName()
+			if _, err := io.Copy(tmp, part); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					writeDecodeError(w, err)
+				} else {
+					writeStorageError(w, errCodeInternal, err)
+				}
+				return
+			}
+		case "title", "tags":
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+			if part.FormName() == "title" {
+				title = strings.TrimSpace(string(value))
+				continue
+			}
+			for _, tag := range strings.Split(string(value), ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	if uploadName == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "A file part is required")
+		return
+	}
+
+	manifest, err := importUpload(tmp, uploadName, title, tags)
+	if errors.Is(err, errNotAudio) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// errNotAudio is wrapped by importUpload's error when the upload isn't a
+// file it can read
+var errNotAudio = errors.New("not a supported audio file")
+
+// importUpload moves an uploaded file, written to a temporary file next to
+// the recordings, into place and catalogs it. It's named after the upload,
+// with the extension of what it actually is. tmp is closed either way.
+func importUpload(tmp *os.File, uploadName, title string, tags []string) (*SessionManifest, error) {
+	info, err := probeAudio(tmp)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("%w: %v", errNotAudio, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(uploadName), filepath.Ext(uploadName))
+	if title == "" {
+		title = base
+	}
+	if base = sanitizeFilename(base); base == "" {
+		base = "import"
+	}
+	ingestMu.Lock()
+	name := uniqueFilename(outputDirectory, base, "."+info.Format)
+	err = os.Rename(tmp.Name(), filepath.Join(outputDirectory, name))
+	if err == nil {
+		importing[name] = true
+	}
+	ingestMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := ingestRecording(name, "import", title, tags)
+	ingestMu.Lock()
+	delete(importing, name)
+	ingestMu.Unlock()
+	if err != nil {
+		os.Remove(filepath.Join(outputDirectory, name))
+		return nil, err
+	}
+
+	fmt.Printf("✓ Imported %s as session %s\n", name, manifest.ID)
+	queueAutoConvert(manifest)
+	return manifest, nil
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// jobNice is the nice value job threads and the commands they run get.
+const jobNice = 10
+
+// lowerThreadPriority lowers the calling thread's priority. On Linux nice
+// values are per thread, and processes it starts inherit it.
+func lowerThreadPriority() {
+	syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), jobNice)
+}
+
+// startLowPriority starts a command for a job. It inherits the job thread's
+// priority.
+func startLowPriority(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
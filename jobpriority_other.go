@@ -0,0 +1,24 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// jobNice is the nice value the commands jobs run get.
+const jobNice = 10
+
+// lowerThreadPriority does nothing: other systems set nice values per
+// process, and lowering the recorder's would slow the capture too.
+func lowerThreadPriority() {}
+
+// startLowPriority starts a command for a job and lowers its priority.
+func startLowPriority(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, jobNice)
+	return nil
+}
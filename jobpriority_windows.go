@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+var (
+	procGetCurrentThread  = syscall.NewLazyDLL("kernel32.dll").NewProc("GetCurrentThread")
+	procSetThreadPriority = syscall.NewLazyDLL("kernel32.dll").NewProc("SetThreadPriority")
+)
+
+const (
+	threadPriorityBelowNormal = -1
+	belowNormalPriorityClass  = 0x00004000
+)
+
+// lowerThreadPriority lowers the calling thread's priority.
+func lowerThreadPriority() {
+	thread, _, _ := procGetCurrentThread.Call()
+	priority := int32(threadPriorityBelowNormal)
+	procSetThreadPriority.Call(thread, uintptr(priority))
+}
+
+// startLowPriority starts a command for a job below normal priority.
+func startLowPriority(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+	return cmd.Start()
+}
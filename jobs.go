@@ -0,0 +1,650 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job kinds
+const (
+	jobTranscode  = "transcode"
+	jobNormalize  = "normalize"
+	jobTranscribe = "transcribe"
+	jobUpload     = "upload"
+	jobPeaks      = "peaks"
+	jobArchive    = "archive"
+	jobRestore    = "restore"
+	jobAlign      = "align"
+	jobDrift      = "drift"
+	jobSpeed      = "speed"
+	jobMixdown    = "mixdown"
+	jobExport     = "export"
+	jobCompact    = "compact"
+)
+
+// Job priorities
+const (
+	jobPriorityHigh   = "high"
+	jobPriorityNormal = "normal"
+	jobPriorityLow    = "low"
+)
+
+// Job statuses
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobPaused   = "paused" // uploads only, until resumed
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+const (
+	// maxJobAttempts is how many times a failing job is run before it's
+	// given up on.
+	maxJobAttempts = 3
+
+	// jobRetryDelay is the wait before a job's second attempt, doubled for
+	// each attempt after.
+	jobRetryDelay = 30 * time.Second
+
+	// maxFinishedJobs is how many finished jobs /api/jobs remembers.
+	maxFinishedJobs = 100
+
+	// jobPollInterval is how often idle workers look again for a job held
+	// back by a limit.
+	jobPollInterval = time.Second
+)
+
+// jobWorkers is how many jobs run at once, from -job-workers.
+var jobWorkers = 2
+
+// jobWorkersWhileRecording is how many jobs run at once while a session is
+// recording, from -job-workers-recording, so post-processing doesn't take
+// the CPU or disk from the capture. 0 holds jobs until the session stops.
+var jobWorkersWhileRecording = 1
+
+// jobLimits bounds how many jobs of a kind run at once, from -job-limit.
+var jobLimits = jobLimitList{}
+
+// jobLimitList is a repeatable kind=n flag
+type jobLimitList map[string]int
+
+func (l jobLimitList) String() string {
+	var limits []string
+	for kind, n := range l {
+		limits = append(limits, kind+"="+strconv.Itoa(n))
+	}
+	slices.Sort(limits)
+	return strings.Join(limits, ",")
+}
+
+func (l jobLimitList) Set(value string) error {
+	kind, n, ok := strings.Cut(value, "=")
+	limit, err := strconv.Atoi(n)
+	if !ok || err != nil || limit < 1 {
+		return fmt.Errorf("want kind=n with n at least 1, e.g. transcribe=1")
+	}
+	if runnerFor(kind) == nil {
+		return fmt.Errorf("unknown job kind %q", kind)
+	}
+	l[kind] = limit
+	return nil
+}
+
+func addJobFlags(fs *flag.FlagSet) {
+	fs.IntVar(&jobWorkers, "job-workers", jobWorkers, "background jobs (transcoding, transcribing, uploads, ...) run at once")
+	fs.IntVar(&jobWorkersWhileRecording, "job-workers-recording", jobWorkersWhileRecording, "background jobs run at once while recording (0 holds them until the session stops)")
+	fs.Var(jobLimits, "job-limit", "most jobs of one kind run at once, as kind=n, e.g. transcribe=1 (repeatable)")
+}
+
+// Job is a piece of post-processing run in the background: transcoding,
+// normalizing, transcribing, uploading or drawing waveform peaks for a
+// finished session, so it doesn't hold up an HTTP request.
+type Job struct {
+	ID        int64  `json:"id"`
+	Kind      string `json:"kind"`
+	SessionID string `json:"sessionId"`
+
+	// File picks one of the session's tracks; every track if empty
+	File string `json:"file,omitempty"`
+
+	// Format is what a transcode or speed job converts to
+	Format string `json:"format,omitempty"`
+
+	// Speed is how much faster a speed job plays tracks back, such as 1.5
+	Speed float64 `json:"speed,omitempty"`
+
+	// Profile is the export profile an export job follows, such as youtube
+	Profile string `json:"profile,omitempty"`
+
+	// Trim makes an align job write trimmed copies of the tracks
+	Trim bool `json:"trim,omitempty"`
+
+	// Priority is high, normal or low; higher priority jobs run first
+	Priority string `json:"priority"`
+
+	Status   string  `json:"status"`
+	Progress float64 `json:"progress"` // 0 to 1, within the current attempt
+	Attempts int     `json:"attempts"`
+
+	// Errors has each failed attempt's error, oldest first
+	Errors []string `json:"errors,omitempty"`
+
+	// Output lists the files the job made
+	Output []string `json:"output,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	// RetryAt is when a failed attempt is tried again
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+
+	cancel context.CancelFunc
+	upload *uploadProgress // of an upload's current attempt
+}
+
+// alertKey is the key of the alert raised when the job fails, which a later
+// job doing the same thing resolves by succeeding.
+func (job *Job) alertKey() string {
+	return "job:" + job.Kind + ":" + job.SessionID + ":" + job.File
+}
+
+// JobRequest is the request body for POST /api/jobs
+type JobRequest struct {
+	Kind      string  `json:"kind"`
+	SessionID string  `json:"sessionId"`
+	File      string  `json:"file"`
+	Format    string  `json:"format"`
+	Speed     float64 `json:"speed"`
+	Profile   string  `json:"profile"`
+	Trim      bool    `json:"trim"`
+	Priority  string  `json:"priority"`
+}
+
+// jobRunner does one attempt of a job, reporting progress from 0 to 1, and
+// returns the files it made.
+type jobRunner func(ctx context.Context, job Job, progress func(float64)) ([]string, error)
+
+// runnerFor returns the runner for a kind of job, or nil if there's no such
+// kind.
+func runnerFor(kind string) jobRunner {
+	switch kind {
+	case jobTranscode:
+		return runTranscode
+	case jobNormalize:
+		return runNormalize
+	case jobTranscribe:
+		return runTranscribe
+	case jobUpload:
+		return runUpload
+	case jobPeaks:
+		return runPeaks
+	case jobArchive:
+		return runArchive
+	case jobRestore:
+		return runRestore
+	case jobAlign:
+		return runAlign
+	case jobDrift:
+		return runDrift
+	case jobSpeed:
+		return runSpeed
+	case jobMixdown:
+		return runMixdown
+	case jobExport:
+		return runExport
+	case jobCompact:
+		return runCompact
+	}
+	return nil
+}
+
+// jobs is the queue of jobs, oldest first, run by the workers
+// startJobWorkers starts.
+var jobs = struct {
+	sync.Mutex
+	list   []*Job
+	lastID int64
+	wake   chan struct{}
+}{wake: make(chan struct{}, 1)}
+
+// startJobWorkers starts the workers that run queued jobs.
+func startJobWorkers() {
+	for range max(jobWorkers, 1) {
+		go jobWorker()
+	}
+}
+
+// enqueueJob checks a request and queues the job it asks for. An upload of
+// a session that's already waiting to be uploaded, or whose upload is
+// paused, returns that job rather than adding another.
+func enqueueJob(req JobRequest) (*Job, error) {
+	if req.Kind == jobSpeed {
+		req.Format = cmp.Or(req.Format, speedDefaultFormat)
+	}
+	if err := validateJob(req); err != nil {
+		return nil, err
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	for _, job := range jobs.list {
+		if job.Kind != req.Kind || job.SessionID != req.SessionID {
+			continue
+		}
+		// An upload that hasn't started yet will copy any new files too, and
+		// an archive or restore that hasn't finished covers the whole session
+		switch {
+		case req.Kind == jobUpload && (job.Status == jobQueued && job.RetryAt == nil || job.Status == jobPaused),
+			(req.Kind == jobArchive || req.Kind == jobRestore) && (job.Status == jobQueued || job.Status == jobRunning):
+			queued := *job
+			return &queued, nil
+		}
+	}
+	jobs.lastID++
+	job := &Job{
+		ID:        jobs.lastID,
+		Kind:      req.Kind,
+		SessionID: req.SessionID,
+		File:      req.File,
+		Format:    req.Format,
+		Speed:     req.Speed,
+		Profile:   req.Profile,
+		Trim:      req.Trim,
+		Priority:  cmp.Or(req.Priority, jobPriorityNormal),
+		Status:    jobQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.Kind == jobUpload {
+		job.upload = &uploadProgress{}
+	}
+	jobs.list = append(jobs.list, job)
+	select {
+	case jobs.wake <- struct{}{}:
+	default:
+	}
+	queued := *job
+	return &queued, nil
+}
+
+// validateJob checks that a job can run: its session has finished, the file
+// is one of its tracks, and what the kind needs is set up.
+func validateJob(req JobRequest) error {
+	if runnerFor(req.Kind) == nil {
+		return fmt.Errorf("kind must be transcode, normalize, transcribe, upload, peaks, archive, restore, align, drift, speed, mixdown, export or compact")
+	}
+	if !validSessionID(req.SessionID) {
+		return fmt.Errorf("invalid session ID")
+	}
+	if req.Priority != "" && priorityRank(req.Priority) < 0 {
+		return fmt.Errorf("priority must be high, normal or low")
+	}
+	manifest, err := readSessionManifest(req.SessionID)
+	if err != nil {
+		return errSessionNotFound
+	}
+	if manifest.Archived != nil && req.Kind != jobRestore && req.Kind != jobUpload {
+		return fmt.Errorf("session %s is archived; restore it first", req.SessionID)
+	}
+	if req.File != "" && (req.Kind == jobArchive || req.Kind == jobRestore || req.Kind == jobAlign || req.Kind == jobMixdown) {
+		return fmt.Errorf("%s jobs work on whole sessions, not single files", req.Kind)
+	}
+	exported := req.Kind == jobExport && slices.ContainsFunc(manifest.Exports, func(e ExportFile) bool { return e.File == req.File })
+	if req.File != "" && !exported && !slices.ContainsFunc(manifest.Tracks, func(t TrackInfo) bool { return t.File == req.File }) {
+		return fmt.Errorf("%s isn't a track of session %s", req.File, req.SessionID)
+	}
+	switch req.Kind {
+	case jobTranscode:
+		if _, ok := transcodeFormats[req.Format]; !ok {
+			return fmt.Errorf("format must be flac, mp3 or opus")
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("transcoding needs ffmpeg installed")
+		}
+	case jobCompact:
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("compacting needs ffmpeg installed")
+		}
+	case jobSpeed:
+		if !validSpeed(req.Speed) {
+			return fmt.Errorf("speed must be from %g to %g, and not 1", float64(minSpeed), float64(maxSpeed))
+		}
+		if _, ok := transcodeFormats[req.Format]; !ok {
+			return fmt.Errorf("format must be flac, mp3 or opus")
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("changing speed needs ffmpeg installed")
+		}
+	case jobTranscribe:
+		if stt.url == "" {
+			return fmt.Errorf("transcribing needs a speech-to-text service; set -stt")
+		}
+	case jobExport:
+		profiles, err := loadExportProfiles()
+		if err != nil {
+			return err
+		}
+		if _, ok := profiles[req.Profile]; !ok {
+			return fmt.Errorf("no export profile named %q; see /api/export-profiles", req.Profile)
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("exporting needs ffmpeg installed")
+		}
+	case jobMixdown:
+		if len(manifest.Tracks) == 0 {
+			return fmt.Errorf("session %s has no tracks to mix down", req.SessionID)
+		}
+	case jobAlign:
+		if len(manifest.Tracks) < 2 {
+			return fmt.Errorf("aligning needs a session with at least two tracks")
+		}
+	case jobUpload:
+		if store == nil {
+			return fmt.Errorf("uploading needs somewhere to upload to; set -storage")
+		}
+	case jobArchive, jobRestore:
+		if archiveStore == nil {
+			return fmt.Errorf("archiving needs somewhere to archive to; set -archive")
+		}
+		if req.Kind == jobRestore && manifest.Archived == nil {
+			return fmt.Errorf("session %s isn't archived", req.SessionID)
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil && req.Kind == jobArchive {
+			return fmt.Errorf("archiving needs ffmpeg installed")
+		}
+	}
+	return nil
+}
+
+// errSessionNotFound is returned for a job on a session that doesn't exist
+var errSessionNotFound = errors.New("session not found")
+
+// jobWorker runs queued jobs, waiting for retries to come due. It runs on
+// its own OS thread at low priority, as do the commands jobs run, so the
+// capture threads win whenever the CPU is busy.
+func jobWorker() {
+	runtime.LockOSThread()
+	lowerThreadPriority()
+	for {
+		recordingMutex.Lock()
+		recording := activeSession != nil
+		recordingMutex.Unlock()
+
+		job, wait := nextJob(recording)
+		if job == nil {
+			select {
+			case <-jobs.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		runJob(job)
+	}
+}
+
+// priorityRank orders priorities, highest last, or is -1 for an unknown
+// one.
+func priorityRank(priority string) int {
+	return slices.Index([]string{jobPriorityLow, jobPriorityNormal, jobPriorityHigh}, priority)
+}
+
+// nextJob marks the job to run next as running and returns it, or returns
+// how long to wait before looking again. The highest priority job that's
+// ready goes first, the oldest of those, unless the limit for its kind, or
+// for recording, is reached.
+func nextJob(recording bool) (*Job, time.Duration) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	now := time.Now()
+	wait := time.Minute
+
+	running := map[string]int{}
+	total := 0
+	for _, job := range jobs.list {
+		if job.Status == jobRunning {
+			running[job.Kind]++
+			total++
+		}
+	}
+	var next *Job
+	for _, job := range jobs.list {
+		if job.Status != jobQueued {
+			continue
+		}
+		if job.RetryAt != nil && job.RetryAt.After(now) {
+			wait = min(wait, job.RetryAt.Sub(now))
+			continue
+		}
+		if limit, ok := jobLimits[job.Kind]; ok && running[job.Kind] >= limit || recording && total >= jobWorkersWhileRecording {
+			wait = min(wait, jobPollInterval)
+			continue
+		}
+		if next == nil || priorityRank(job.Priority) > priorityRank(next.Priority) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, wait
+	}
+	started := now.UTC()
+	next.Status, next.StartedAt, next.RetryAt, next.Progress = jobRunning, &started, nil, 0
+	next.Attempts++
+	return next, 0
+}
+
+// runJob runs one attempt of a job and records how it went, queueing a
+// retry if it failed and has attempts left.
+func runJob(job *Job) {
+	ctx, cancel := context.WithCancel(withSessionSpan(context.Background(), job.SessionID))
+	defer cancel()
+	jobs.Lock()
+	job.cancel = cancel
+	snapshot := *job
+	jobs.Unlock()
+
+	ctx, s := startSpan(ctx, "job."+job.Kind, spanKindInternal)
+	s.setAttr("job.id", snapshot.ID)
+	s.setAttr("job.attempt", snapshot.Attempts)
+	s.setAttr("session.id", snapshot.SessionID)
+	if snapshot.File != "" {
+		s.setAttr("job.file", snapshot.File)
+	}
+	output, err := runnerFor(job.Kind)(ctx, snapshot, func(p float64) {
+		jobs.Lock()
+		job.Progress = min(max(p, 0), 1)
+		jobs.Unlock()
+	})
+	s.finish(err)
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	job.cancel = nil
+	job.Output = append(job.Output, output...)
+	now := time.Now().UTC()
+	switch {
+	case job.Status == jobCanceled:
+		job.FinishedAt = &now
+	case err == nil:
+		job.Status, job.Progress, job.FinishedAt = jobDone, 1, &now
+		fmt.Printf("✓ Job %d: %s of %s done\n", job.ID, job.Kind, job.SessionID)
+		resolveAlert(job.alertKey(), fmt.Sprintf("Job %d: %s of %s done", job.ID, job.Kind, job.SessionID))
+	case job.Status == jobPaused:
+		// Stopped by pausing, which doesn't count as a failed attempt
+		job.Attempts--
+	default:
+		job.Errors = append(job.Errors, err.Error())
+		if job.Attempts < maxJobAttempts {
+			retryAt := now.Add(jobRetryDelay << (job.Attempts - 1))
+			job.Status, job.RetryAt = jobQueued, &retryAt
+			fmt.Printf("⚠️  Job %d: %s of %s failed, retrying at %s: %v\n", job.ID, job.Kind, job.SessionID, retryAt.Local().Format("15:04:05"), err)
+		} else {
+			job.Status, job.FinishedAt = jobFailed, &now
+			raiseAlert(Alert{Type: "job", Key: job.alertKey(), File: job.File, Message: fmt.Sprintf("Job %d: %s of %s failed: %v", job.ID, job.Kind, job.SessionID, err)})
+		}
+	}
+	trimFinishedJobs()
+
+	// A limit may have been holding a job back
+	select {
+	case jobs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// trimFinishedJobs forgets the oldest finished jobs beyond maxFinishedJobs.
+// Must be called with jobs locked.
+func trimFinishedJobs() {
+	finished := 0
+	for _, job := range jobs.list {
+		if job.FinishedAt != nil {
+			finished++
+		}
+	}
+	jobs.list = slices.DeleteFunc(jobs.list, func(job *Job) bool {
+		if job.FinishedAt != nil && finished > maxFinishedJobs {
+			finished--
+			return true
+		}
+		return false
+	})
+}
+
+// jobList copies the jobs, oldest first.
+func jobList() []Job {
+	jobs.Lock()
+	defer jobs.Unlock()
+	list := []Job{}
+	for _, job := range jobs.list {
+		list = append(list, *job)
+	}
+	return list
+}
+
+// findJob copies the job with the given ID.
+func findJob(id int64) (Job, bool) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for _, job := range jobs.list {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Handler: GET /api/jobs - List background jobs, oldest first, optionally
+// only those with ?status= or for ?session=
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	status, sessionID := r.URL.Query().Get("status"), r.URL.Query().Get("session")
+	list := slices.DeleteFunc(jobList(), func(job Job) bool {
+		return status != "" && job.Status != status || sessionID != "" && job.SessionID != sessionID
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: POST /api/jobs - Queue a background job for a finished session
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if (req.Kind == jobArchive || req.Kind == jobRestore) && !requireRole(w, r, roleAdmin, "archive and restore sessions") {
+		return
+	}
+	if req.Kind == jobCompact && !requireRole(w, r, roleAdmin, "replace sessions' tracks") {
+		return
+	}
+
+	recordingMutex.Lock()
+	recording := activeSession != nil && activeSession.id == req.SessionID
+	recordingMutex.Unlock()
+	if recording {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Session is still recording; stop it before processing it")
+		return
+	}
+
+	job, err := enqueueJob(req)
+	if errors.Is(err, errSessionNotFound) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handler: GET /api/jobs/{id} - Get one background job
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	job, ok := findJob(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Job not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handler: DELETE /api/jobs/{id} - Cancel a queued, running or paused job
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	jobs.Lock()
+	var found *Job
+	for _, job := range jobs.list {
+		if job.ID == id {
+			found = job
+		}
+	}
+	if found == nil {
+		jobs.Unlock()
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Job not found")
+		return
+	}
+	if found.Status != jobQueued && found.Status != jobRunning && found.Status != jobPaused {
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Job has already finished")
+		return
+	}
+	job := cancelJob(found)
+	jobs.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// cancelJob cancels a queued, running or paused job, and returns it. A
+// running job is stopped, and finishes when its runner returns. Must be
+// called with jobs locked.
+func cancelJob(job *Job) Job {
+	if job.cancel != nil {
+		job.cancel()
+	} else {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+	}
+	job.Status, job.RetryAt = jobCanceled, nil
+	canceled := *job
+	trimFinishedJobs()
+	return canceled
+}
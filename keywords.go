@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// keywordRepeatWindow is how close together the same keyword only drops one
+// marker, since a phrase is often heard by more than one microphone.
+const keywordRepeatWindow = 5 * time.Second
+
+// keywords are phrases that drop a marker when they're heard in the live
+// transcript. Set from flags in web mode.
+var keywords keywordList
+
+// keywordList collects repeated -keyword flags.
+type keywordList []string
+
+func (k *keywordList) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *keywordList) Set(value string) error {
+	phrase := normalizeSpeech(value)
+	if phrase == "" {
+		return fmt.Errorf("empty keyword")
+	}
+	*k = append(*k, phrase)
+	return nil
+}
+
+// normalizeSpeech lowercases text and reduces it to words separated by
+// single spaces, so keywords match whatever punctuation the transcript has.
+func normalizeSpeech(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	return strings.Join(words, " ")
+}
+
+// spotKeywords returns a marker for each keyword said in a segment. Segments
+// are only timed as a whole, so the time is estimated from how far into the
+// text the keyword comes.
+func spotKeywords(segment TranscriptSegment) []Marker {
+	if len(keywords) == 0 {
+		return nil
+	}
+	text := normalizeSpeech(segment.Text)
+	padded := " " + text + " "
+	var markers []Marker
+	for _, keyword := range keywords {
+		i := strings.Index(padded, " "+keyword+" ")
+		if i < 0 {
+			continue
+		}
+		at := segment.Start + (segment.End-segment.Start)*float64(i)/float64(len(padded))
+		markers = append(markers, Marker{Time: roundMillis(at), Label: keyword, Source: "keyword"})
+	}
+	return markers
+}
+
+// addKeywordMarker adds a marker for a spoken keyword, unless the same one
+// was just marked. It's called by transcription workers, so it doesn't hold
+// recordingMutex while the session may be stopping.
+func (s *session) addKeywordMarker(marker Marker) {
+	s.markersMu.Lock()
+	for _, m := range s.markers {
+		if m.Source == marker.Source && m.Label == marker.Label && math.Abs(m.Time-marker.Time) < keywordRepeatWindow.Seconds() {
+			s.markersMu.Unlock()
+			return
+		}
+	}
+	s.markers = append(s.markers, marker)
+	s.markersMu.Unlock()
+
+	fmt.Printf("📍 Heard %q at %s in session %s\n", marker.Label, formatElapsed(time.Duration(marker.Time*float64(time.Second))), s.id)
+	go func() {
+		recordingMutex.Lock()
+		defer recordingMutex.Unlock()
+		notifySessionChanged()
+	}()
+}
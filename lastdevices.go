@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// lastDevicesFile is where the devices last recorded together are kept, for
+// the CLI and /api/quickstart/last to record them again.
+var lastDevicesFile = "last-devices.json"
+
+// LastDevices are the devices last recorded together
+type LastDevices struct {
+	Devices []LastDevice `json:"devices"`
+	SavedAt time.Time    `json:"savedAt"`
+}
+
+// LastDevice is one of the devices last recorded, found again by its ID, or
+// by name if the ID has changed
+type LastDevice struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// saveLastDevices remembers the devices just started.
+func saveLastDevices(devices []selectableDevice, indices []int) error {
+	last := LastDevices{SavedAt: time.Now().UTC()}
+	infos := deviceInfoList(devices)
+	for _, idx := range indices {
+		last.Devices = append(last.Devices, LastDevice{ID: infos[idx].ID, Name: infos[idx].Name, Type: infos[idx].Type})
+	}
+	data, _ := json.MarshalIndent(last, "", "  ")
+	return writeFileAtomic(lastDevicesFile, append(data, '\n'))
+}
+
+// loadLastDevices returns the devices last recorded together, or nil if
+// nothing has been.
+func loadLastDevices() (*LastDevices, error) {
+	data, err := os.ReadFile(lastDevicesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var last LastDevices
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("invalid last devices file %s: %w", lastDevicesFile, err)
+	}
+	if len(last.Devices) == 0 {
+		return nil, nil
+	}
+	return &last, nil
+}
+
+// find returns the indices of the last devices among devices, and the names
+// of any that aren't connected.
+func (last *LastDevices) find(devices []selectableDevice) ([]int, []string) {
+	var indices []int
+	var missing []string
+	for _, want := range last.Devices {
+		found := -1
+		for i, d := range devices {
+			if d.id() == want.ID {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			for i, d := range devices {
+				if d.name() == want.Name && d.typeName() == want.Type {
+					found = i
+					break
+				}
+			}
+		}
+		if found < 0 {
+			missing = append(missing, want.Name)
+			continue
+		}
+		indices = append(indices, found)
+	}
+	return indices, missing
+}
+
+// label names the last devices for a prompt, such as "Mic + Speakers
+// [Loopback]".
+func (last *LastDevices) label() string {
+	names := make([]string, len(last.Devices))
+	for i, d := range last.Devices {
+		names[i] = d.Name
+		if d.Type == "loopback" {
+			names[i] += " [Loopback]"
+		}
+	}
+	return strings.Join(names, " + ")
+}
+
+// Handler: GET /api/quickstart/last - Start recording the devices last
+// recorded together, by the CLI or the API
+func handleQuickstartLast(w http.ResponseWriter, r *http.Request) {
+	// The body is optional, so a bookmark can start it with a plain GET
+	var req QuickstartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	last, err := loadLastDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	if last == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Nothing has been recorded yet")
+		return
+	}
+
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	if activeSession != nil {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Already recording")
+		return
+	}
+	allDevices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	indices, missing := last.find(allDevices)
+	if len(missing) > 0 {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Device not found: %s", strings.Join(missing, ", ")))
+		return
+	}
+
+	results, err := startSession(r.Context(), allDevices, StartRecordingRequest{
+		DeviceIndices: indices,
+		Title:         req.Title,
+		Tags:          req.Tags,
+	})
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	writeSessionStarted(w, results)
+}
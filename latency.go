@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+const (
+	latencySampleRate  = 44100
+	latencyChirpLength = latencySampleRate / 20    // 50ms sweep
+	latencyChirpEvery  = latencySampleRate / 2     // one chirp every 500ms
+	latencySearch      = latencySampleRate * 2 / 5 // look up to 400ms after each chirp
+)
+
+// latencyReport is what a latency test measured, printed as JSON with -json
+type latencyReport struct {
+	Output   string     `json:"output"`
+	Input    string     `json:"input"`
+	ChirpsMs []*float64 `json:"chirpsMs"` // null for chirps that weren't heard
+	MedianMs float64    `json:"medianMs"`
+	MinMs    float64    `json:"minMs"`
+	MaxMs    float64    `json:"maxMs"`
+}
+
+// runLatency plays chirps on a playback device while capturing from an input
+// and reports the round-trip latency between them.
+func runLatency(args []string) {
+	fs := flag.NewFlagSet("latency", flag.ExitOnError)
+	outputIndex := fs.Int("output", -1, "playback device number (prompted if not set)")
+	inputIndex := fs.Int("input", -1, "capture device number (prompted if not set)")
+	runs := fs.Int("runs", 5, "number of chirps to measure")
+	addBackendFlag(fs)
+	addOutputFlags(fs)
+	fs.Parse(args)
+
+	uiPrintln("Skribbl Audio Capture - Latency Test")
+
+	ctx, err := initAudioContext()
+	if err != nil {
+		uiWarnf("Failed to initialize audio context: %v\n", err)
+		return
+	}
+	defer ctx.Uninit()
+
+	playbackInfos, err := ctx.Devices(malgo.Playback)
+	if err != nil {
+		uiWarnf("Failed to get playback devices: %v\n", err)
+		return
+	}
+	captureInfos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		uiWarnf("Failed to get capture devices: %v\n", err)
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	output, ok := pickLatencyDevice(reader, "Playback", playbackInfos, *outputIndex)
+	if !ok {
+		return
+	}
+	input, ok := pickLatencyDevice(reader, "Capture", captureInfos, *inputIndex)
+	if !ok {
+		return
+	}
+
+	uiPrintf("\nPlaying %d chirps on %s and listening on %s...\n", *runs, output.Name(), input.Name())
+	uiPrintln("Make sure the output can reach the input (speakers near the mic, or a loopback cable).")
+
+	chirp := makeChirp()
+	chirps := *runs
+	totalFrames := latencyChirpEvery * (chirps + 1)
+	captured := make([]int16, totalFrames)
+
+	// A duplex device gives playback and capture a shared frame clock, so the
+	// offset between what we played and what we heard is exact.
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Duplex)
+	deviceConfig.SampleRate = latencySampleRate
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = 1
+	deviceConfig.Playback.DeviceID = output.ID.Pointer()
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = 1
+	deviceConfig.Capture.DeviceID = input.ID.Pointer()
+
+	var mu sync.Mutex
+	position := 0
+	done := make(chan struct{})
+
+	onData := func(pOutput, pInput []byte, framecount uint32) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for f := 0; f < int(framecount); f++ {
+			frame := position + f
+			var out int16
+			if frame < totalFrames {
+				// Chirp i starts half a period into slot i
+				if offset := frame%latencyChirpEvery - latencyChirpEvery/2; frame < latencyChirpEvery*chirps && offset >= 0 && offset < len(chirp) {
+					out = chirp[offset]
+				}
+				if 2*f+1 < len(pInput) {
+					captured[frame] = int16(uint16(pInput[2*f]) | uint16(pInput[2*f+1])<<8)
+				}
+			}
+			if 2*f+1 < len(pOutput) {
+				pOutput[2*f] = byte(out)
+				pOutput[2*f+1] = byte(uint16(out) >> 8)
+			}
+		}
+
+		if position < totalFrames && position+int(framecount) >= totalFrames {
+			close(done)
+		}
+		position += int(framecount)
+	}
+
+	device, err := malgo.InitDevice(ctx.Context, deviceConfig, malgo.DeviceCallbacks{Data: onData})
+	if err != nil {
+		uiWarnf("Failed to initialize devices: %v\n", err)
+		return
+	}
+	defer device.Uninit()
+
+	if err := device.Start(); err != nil {
+		uiWarnf("Failed to start devices: %v\n", err)
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Duration(totalFrames)*time.Second/latencySampleRate + 5*time.Second):
+		uiWarnf("Timed out waiting for audio - is the device working?\n")
+		return
+	}
+	device.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	uiPrintln("\n=== Results ===")
+	report := latencyReport{Output: output.Name(), Input: input.Name(), ChirpsMs: []*float64{}}
+	latencies := []float64{}
+	for i := 0; i < chirps; i++ {
+		emitted := latencyChirpEvery*i + latencyChirpEvery/2
+		lag, confidence := findChirp(captured, chirp, emitted)
+		if confidence < 4 {
+			uiPrintf("Chirp %d: not detected\n", i+1)
+			report.ChirpsMs = append(report.ChirpsMs, nil)
+			continue
+		}
+		ms := float64(lag) * 1000 / latencySampleRate
+		latencies = append(latencies, ms)
+		report.ChirpsMs = append(report.ChirpsMs, &ms)
+		uiPrintf("Chirp %d: %.1f ms\n", i+1, ms)
+	}
+
+	if len(latencies) == 0 {
+		printResult(report)
+		uiWarnf("\n✗ No chirps detected. Turn up the output volume or check the routing.\n")
+		return
+	}
+
+	sort.Float64s(latencies)
+	report.MedianMs, report.MinMs, report.MaxMs = latencies[len(latencies)/2], latencies[0], latencies[len(latencies)-1]
+	uiPrintf("\n✓ Round-trip latency: %.1f ms (min %.1f, max %.1f)\n", report.MedianMs, report.MinMs, report.MaxMs)
+	printResult(report)
+}
+
+// pickLatencyDevice returns the device chosen by flag, or prompts for one.
+func pickLatencyDevice(reader *bufio.Reader, kind string, infos []malgo.DeviceInfo, index int) (malgo.DeviceInfo, bool) {
+	if index < 0 {
+		uiPrompt(fmt.Sprintf("\n=== %s Devices ===", kind))
+		for i, info := range infos {
+			uiPrompt(fmt.Sprintf("[%d] %s", i, info.Name()))
+		}
+		uiPrompt(fmt.Sprintf("\nEnter %s device number:", strings.ToLower(kind)))
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			uiWarnf("Failed to read input: %v\n", err)
+			return malgo.DeviceInfo{}, false
+		}
+		input = strings.TrimSpace(input)
+		index, err = strconv.Atoi(input)
+		if err != nil {
+			uiWarnf("That's not a valid number: %s\n", input)
+			return malgo.DeviceInfo{}, false
+		}
+	}
+	if index < 0 || index >= len(infos) {
+		uiWarnf("Invalid device! Please choose 0-%d\n", len(infos)-1)
+		return malgo.DeviceInfo{}, false
+	}
+	return infos[index], true
+}
+
+// makeChirp builds a windowed 1-8kHz sweep, which correlates sharply and
+// survives most speaker/mic frequency responses.
+func makeChirp() []int16 {
+	chirp := make([]int16, latencyChirpLength)
+	duration := float64(latencyChirpLength) / latencySampleRate
+	for i := range chirp {
+		t := float64(i) / latencySampleRate
+		freq := 1000 + 7000*t/duration/2
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(latencyChirpLength-1))
+		chirp[i] = int16(math.Sin(2*math.Pi*freq*t) * window * 16000)
+	}
+	return chirp
+}
+
+// findChirp cross-correlates the capture against the chirp in the window
+// after it was emitted. It returns the lag in frames and a confidence ratio of
+// the peak against the mean correlation.
+func findChirp(captured, chirp []int16, emitted int) (int, float64) {
+	best, bestLag, sum := 0.0, 0, 0.0
+	count := 0
+	for lag := 0; lag < latencySearch; lag++ {
+		start := emitted + lag
+		if start+len(chirp) > len(captured) {
+			break
+		}
+		corr := 0.0
+		for i, c := range chirp {
+			corr += float64(c) * float64(captured[start+i])
+		}
+		corr = math.Abs(corr)
+		sum += corr
+		count++
+		if corr > best {
+			best, bestLag = corr, lag
+		}
+	}
+	if count == 0 || sum == 0 {
+		return 0, 0
+	}
+	return bestLag, best / (sum / float64(count))
+}
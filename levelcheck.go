@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// levelCheckDuration is how long each device is listened to before a
+// level-checked start.
+const levelCheckDuration = 3 * time.Second
+
+// Level check modes for /api/start
+const (
+	levelCheckOff    = "off"
+	levelCheckWarn   = "warn"
+	levelCheckRefuse = "refuse"
+)
+
+// levelCheckMode is the level check /api/start runs when a request doesn't
+// pick one, set with -level-check.
+var levelCheckMode = levelCheckOff
+
+// LevelCheckResult is what a device sounded like in the level check before a
+// start
+type LevelCheckResult struct {
+	LevelAnalysis
+	Silent   bool   `json:"silent"`
+	Clipping bool   `json:"clipping"`
+	Error    string `json:"error,omitempty"`
+}
+
+// problem describes what's wrong with the device, or "" if nothing is.
+func (r *LevelCheckResult) problem() string {
+	switch {
+	case r.Error != "":
+		return "couldn't be checked: " + r.Error
+	case r.Silent:
+		return "is silent"
+	case r.Clipping:
+		return fmt.Sprintf("is clipping (%d samples)", r.ClippedSamples)
+	}
+	return ""
+}
+
+// validLevelCheckMode reports whether mode is a level check mode.
+func validLevelCheckMode(mode string) bool {
+	return mode == levelCheckOff || mode == levelCheckWarn || mode == levelCheckRefuse
+}
+
+// runLevelCheck listens to every device at once for levelCheckDuration and
+// reports each one's levels, by index. Loopback devices aren't called silent,
+// since they are whenever nothing plays. It must be called without
+// recordingMutex, with samplesRunning counting the check.
+func runLevelCheck(ctx context.Context, allDevices []selectableDevice, indices []int) map[int]*LevelCheckResult {
+	results := map[int]*LevelCheckResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, idx := range indices {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			result := checkLevels(ctx, allDevices[idx])
+			mu.Lock()
+			results[idx] = result
+			mu.Unlock()
+		}(idx)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkLevels records a short sample from one device and judges its levels.
+func checkLevels(ctx context.Context, selected selectableDevice) *LevelCheckResult {
+	path, err := captureSample(ctx, selected, levelCheckDuration)
+	if err != nil {
+		return &LevelCheckResult{Error: err.Error()}
+	}
+	data, err := os.ReadFile(path)
+	os.Remove(path)
+	if err != nil || len(data) < wavHeaderSize {
+		return &LevelCheckResult{Error: "failed to read sample"}
+	}
+
+	// The header was written by startCapture, so the format fields are where
+	// writeWAVHeader put them
+	sampleRate := binary.LittleEndian.Uint32(data[24:])
+	channels := uint32(binary.LittleEndian.Uint16(data[22:]))
+	result := &LevelCheckResult{LevelAnalysis: analyzeLevels(data[wavHeaderSize:], sampleRate, channels)}
+	result.Silent = !selected.isLoopback && result.PeakDBFS < silentBelowDBFS
+	result.Clipping = result.ClippedSamples > 0
+	return result
+}
+
+// levelCheckProblems lists what the level check found wrong, one line per
+// device, in request order.
+func levelCheckProblems(allDevices []selectableDevice, indices []int, checks map[int]*LevelCheckResult) []string {
+	var problems []string
+	for _, idx := range indices {
+		if problem := checks[idx].problem(); problem != "" {
+			problems = append(problems, allDevices[idx].name()+" "+problem)
+		}
+	}
+	return problems
+}
+
+// writeLevelCheckRefused responds to a start refused by the level check with
+// the error envelope and every device's levels.
+func writeLevelCheckRefused(w http.ResponseWriter, allDevices []selectableDevice, indices []int, checks map[int]*LevelCheckResult, problems []string) {
+	devices := []DeviceStartResult{}
+	for _, idx := range indices {
+		devices = append(devices, DeviceStartResult{Index: idx, Name: allDevices[idx].name(), LevelCheck: checks[idx]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": APIError{
+			Code:    errCodeLevelCheckFailed,
+			Message: "Level check failed: " + strings.Join(problems, "; "),
+		},
+		"devices": devices,
+	})
+}
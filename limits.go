@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxURLLength rejects absurdly long request URLs.
+	maxURLLength = 2048
+
+	// maxHeaderBytes caps request headers, well above what browsers send.
+	maxHeaderBytes = 64 << 10
+
+	// readHeaderTimeout stops slow clients from holding connections open.
+	readHeaderTimeout = 10 * time.Second
+
+	// limiterIdleTimeout is how long an IP's bucket is kept after its last request.
+	limiterIdleTimeout = 10 * time.Minute
+)
+
+// limitConfig holds the request limits applied to every API call.
+type limitConfig struct {
+	ratePerSecond float64
+	burst         int
+	maxBodyBytes  int64
+	trustProxy    bool
+
+	// maxImportBytes replaces maxBodyBytes for uploads to importPath
+	maxImportBytes int64
+}
+
+// addLimitFlags registers the rate and size limit flags.
+func addLimitFlags(fs *flag.FlagSet) *limitConfig {
+	cfg := &limitConfig{}
+	fs.Float64Var(&cfg.ratePerSecond, "rate-limit", 20, "requests per second allowed per client IP (0 disables)")
+	fs.IntVar(&cfg.burst, "rate-burst", 40, "requests a client may burst above the rate limit")
+	fs.Int64Var(&cfg.maxBodyBytes, "max-body-kb", 64, "maximum request body size in KB")
+	fs.Int64Var(&cfg.maxImportBytes, "max-import-mb", 2048, "maximum size of an imported recording in MB")
+	fs.BoolVar(&cfg.trustProxy, "trust-proxy", false, "use X-Forwarded-For to identify clients (only behind a trusted reverse proxy)")
+	return cfg
+}
+
+// tokenBucket is a per-client rate limiter.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter hands out tokens per client IP.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	rl := &rateLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		buckets: map[string]*tokenBucket{},
+	}
+	go rl.cleanupLoop()
+	return rl
+}
+
+// allow takes a token for the client, returning how long to wait if none are left.
+func (rl *rateLimiter) allow(client string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst}
+		rl.buckets[client] = b
+	} else {
+		b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*rl.rate)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanupLoop forgets clients that have gone quiet.
+func (rl *rateLimiter) cleanupLoop() {
+	ticker := time.NewTicker(limiterIdleTimeout)
+	defer ticker.Stop()
+	for range ticker.C {
+		rl.mu.Lock()
+		for client, b := range rl.buckets {
+			if time.Since(b.lastSeen) > limiterIdleTimeout {
+				delete(rl.buckets, client)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// clientIP identifies the caller for rate limiting.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix socket peers have no address
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowedMethods are the only HTTP methods the API uses.
+var allowedMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// limitRequests rejects abusive requests and enforces per-IP rate limits and
+// maximum body sizes, since the server may be exposed on a shared LAN.
+func limitRequests(cfg *limitConfig, next http.Handler) http.Handler {
+	var limiter *rateLimiter
+	if cfg.ratePerSecond > 0 {
+		limiter = newRateLimiter(cfg.ratePerSecond, cfg.burst)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedMethods[r.Method] {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if len(r.RequestURI) > maxURLLength {
+			writeError(w, http.StatusRequestURITooLong, errCodeURITooLong, "Request URI too long")
+			return
+		}
+
+		if limiter != nil {
+			if ok, wait := limiter.allow(clientIP(r, cfg.trustProxy)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests")
+				return
+			}
+		}
+
+		maxBody := cfg.maxBodyBytes << 10
+		if strings.HasPrefix(r.URL.Path, importPath) {
+			maxBody = cfg.maxImportBytes << 20
+		}
+		if maxBody > 0 {
+			if r.ContentLength > maxBody {
+				writeError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor passed by socket activation
+// (SD_LISTEN_FDS_START in sd-daemon.h).
+const systemdFirstFD = 3
+
+// defaultListenAddr is used when no -listen flag is given.
+const defaultListenAddr = ":8080"
+
+// listenAddrs collects repeated -listen flags.
+type listenAddrs []string
+
+func (l *listenAddrs) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenAddrs) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// openListener listens on "host:port" or "unix:/path/to.sock".
+func openListener(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove a stale socket left behind by a previous run, but never
+		// delete anything that isn't a socket.
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// systemdListeners returns the sockets passed in by systemd socket activation,
+// or nil if the process wasn't socket-activated.
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	// Don't let child processes think the sockets are meant for them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := []net.Listener{}
+	for fd := systemdFirstFD; fd < systemdFirstFD+count; fd++ {
+		file := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		l, err := net.FileListener(file)
+		// FileListener dups the descriptor, so the original can be closed
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket-activated fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenerURL describes where a listener can be reached, for the startup banner.
+func listenerURL(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return "unix:" + l.Addr().String()
+	}
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return l.Addr().String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// serveListeners serves handler on every listener until one of them fails.
+func serveListeners(handler http.Handler, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			server := &http.Server{
+				Handler:           handler,
+				ReadHeaderTimeout: readHeaderTimeout,
+				MaxHeaderBytes:    maxHeaderBytes,
+			}
+			errs <- fmt.Errorf("%s: %v", listenerURL(l), server.Serve(l))
+		}(l)
+	}
+	return <-errs
+}
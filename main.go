@@ -3,37 +3,37 @@ package main
 import (
 	"bufio"
 	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"runtime"
+	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/gen2brain/malgo"
 )
 
-// selectableDevice represents a device the user can pick, which may be
-// either a regular capture device or a loopback (playback) device.
-type selectableDevice struct {
-	info       malgo.DeviceInfo
-	isLoopback bool
-}
+// wavHeaderSize is the length of the header written by writeWAVHeader.
+const wavHeaderSize = 44
 
 // writeWAVHeader writes the WAV file header
 // sampleRate: samples per second (e.g., 44100)
 // channels: number of audio channels (1 = mono, 2 = stereo)
 // bitsPerSample: bits per sample (16 for our format)
 // dataSize: total size of audio data in bytes (0 initially, we'll update later)
-func writeWAVHeader(file *os.File, sampleRate, channels, bitsPerSample, dataSize uint32) error {
+func writeWAVHeader(file io.Writer, sampleRate, channels, bitsPerSample, dataSize uint32) error {
 	// WAV file structure:
 	// "RIFF" chunk descriptor
-	file.WriteString("RIFF")
+	io.WriteString(file, "RIFF")
 	binary.Write(file, binary.LittleEndian, uint32(36+dataSize)) // File size - 8
-	file.WriteString("WAVE")
+	io.WriteString(file, "WAVE")
 
 	// "fmt " sub-chunk (format)
-	file.WriteString("fmt ")
+	io.WriteString(file, "fmt ")
 	binary.Write(file, binary.LittleEndian, uint32(16))                          // Subchunk size
 	binary.Write(file, binary.LittleEndian, uint16(1))                           // Audio format (1 = PCM)
 	binary.Write(file, binary.LittleEndian, uint16(channels))                    // Number of channels
@@ -43,109 +43,482 @@ func writeWAVHeader(file *os.File, sampleRate, channels, bitsPerSample, dataSize
 	binary.Write(file, binary.LittleEndian, uint16(bitsPerSample))               // Bits per sample
 
 	// "data" sub-chunk
-	file.WriteString("data")
+	io.WriteString(file, "data")
 	binary.Write(file, binary.LittleEndian, dataSize) // Data size
 
 	return nil
 }
 
-// captureDevice holds all the state for a single audio capture device
-type captureDevice struct {
-	name              string
-	file              *os.File
-	filename          string
-	device            *malgo.Device
-	totalBytesWritten uint32
-}
-
 func main() {
-	// Check if web mode is requested
-	if len(os.Args) > 1 && os.Args[1] == "web" {
-		runWebServer()
-		return
+	// Check if a subcommand is requested
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "web":
+			runWebServer(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "latency":
+			runLatency(os.Args[2:])
+			return
+		case "record":
+			runRecord(os.Args[2:])
+			return
+		case "devices":
+			runDevices(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "info":
+			runInfo(os.Args[2:])
+			return
+		case "split":
+			runSplit(os.Args[2:])
+			return
+		case "merge":
+			runMerge(os.Args[2:])
+			return
+		case "gain":
+			runGain(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		}
 	}
 
 	// Run CLI mode
-	runCLI()
+	runCLI(os.Args[1:])
 }
 
-func runWebServer() {
+func runWebServer(args []string) {
+	fs := flag.NewFlagSet("web", flag.ExitOnError)
+	maxBufferMB := addMemoryFlags(fs)
+	addBackendFlag(fs)
+	admin := fs.Bool("admin", false, "expose /debug/pprof and /debug/dump for profiling")
+	otlpEndpoint := addTracingFlags(fs)
+	limits := addLimitFlags(fs)
+	obs := addOBSFlags(fs)
+	mqtt := addMQTTFlags(fs)
+	ics := addICSFlags(fs)
+	addSTTFlags(fs)
+	addJobFlags(fs)
+	addBandwidthFlags(fs)
+	addEmailFlags(fs)
+	addFeedFlags(fs)
+	addLangFlag(fs)
+	fs.BoolVar(&announceEnabled, "announce", false, "speak announcements such as \"recording started\" and alerts through the output device")
+	fs.StringVar(&ttsCommand, "tts-command", "", "command that renders {text} to the WAV file {file} for announcements (default say, espeak-ng or Windows speech)")
+	fs.IntVar(&diskAlertMB, "disk-alert-mb", diskAlertMB, "raise an alert when free space for recordings drops below this many MB while recording (0 disables)")
+	fs.StringVar(&bleepWordsFile, "bleep-words", "", "file of extra words to bleep in /api/sessions/{id}/bleep, one per line")
+	fs.BoolVar(&splitRounds, "split-rounds", false, "start a new session at each round start posted to /api/events, named after the round's word")
+	fs.DurationVar(&splitEvery, "split-every", 0, "start a new file for each track every so often while recording, gaplessly, e.g. 1h (0 records each track to one file)")
+	fs.Var(&keywords, "keyword", "phrase that drops a marker when it's heard in the live transcript, e.g. \"clip that\" (repeatable, needs -stt)")
+	fs.Var(&streams, "stream", "network audio stream to offer as a source, as name=url or url: RTSP, RTP (an .sdp file), Icecast or any HTTP stream ffmpeg plays (repeatable)")
+	var addrs listenAddrs
+	fs.StringVar(&presetsFile, "presets", presetsFile, "JSON file of named device presets for /api/quickstart")
+	fs.StringVar(&exportProfilesFile, "export-profiles", exportProfilesFile, "JSON file of export profiles beyond the built-in youtube, spotify, apple-podcasts, podcast-mono and broadcast")
+	fs.StringVar(&usersFile, "users", usersFile, "JSON file of users' tokens and roles: guest, viewer, operator or admin (anyone can do anything if it's missing)")
+	fs.BoolVar(&readOnly.enabled, "read-only", false, "only list, stream and download recordings from other machines; start, stop and change things from this one")
+	fs.StringVar(&webhookURL, "webhook", "", "URL to POST alerts to as JSON, e.g. a Discord webhook (disabled if empty)")
+	fs.StringVar(&alertRulesFile, "alert-rules", alertRulesFile, "JSON file of rules for where alerts are sent, deduplicated and escalated")
+	fs.StringVar(&summaryPeriod, "summary", summaryPeriod, "send a summary of hours recorded, talk time and disk usage to -webhook and -smtp at 9:00: off, daily or weekly (on Mondays)")
+	fs.IntVar(&clipAlertSamples, "clip-alert", clipAlertSamples, "clipped samples within -clip-window that raise an alert (0 disables)")
+	fs.DurationVar(&clipAlertWindow, "clip-window", clipAlertWindow, "window for counting clipped samples")
+	fs.StringVar(&levelCheckMode, "level-check", levelCheckMode, "listen to every device for 3 seconds before /api/start and refuse to start, or warn, if any is silent or clipping: off, warn or refuse")
+	fs.DurationVar(&silentAlertAfter, "silent-alert", silentAlertAfter, "raise an alert when a recording device delivers only digital silence for this long from its start (0 disables)")
+	fs.DurationVar(&stallTimeout, "stall-timeout", stallTimeout, "restart a recording device that delivers no audio for this long (0 disables)")
+	fs.BoolVar(&timestampUTC, "utc", false, "name sessions and files by UTC rather than local time")
+	fs.StringVar(&timestampLayout, "timestamp-format", "", "Go time layout for session and file names (default "+defaultTimestampLayout+", with a Z appended for -utc)")
+	fs.StringVar(&autoConvert, "auto-convert", "", "convert each finished session's WAV tracks: flac replaces them with FLAC once it decodes to the same audio, and flac+wav, mp3+wav or opus+wav add a copy (disabled if empty)")
+	fs.DurationVar(&watchInterval, "watch", watchInterval, "how often to scan the recordings folder for audio files added by other tools (0 disables)")
+	fs.StringVar(&scheduleFile, "schedule", scheduleFile, "JSON file of scheduled recordings")
+	fs.StringVar(&virtualOutputName, "virtual-output", "", "playback device to feed the live mix of every recording device to, for OBS or Discord to record, e.g. \"CABLE Input\" (created as a null sink on Linux if missing)")
+	fs.Float64Var(&inputVolume, "input-volume", inputVolume, "set capture devices' OS input volume to this percent and unmute them while recording, restoring them afterwards (Windows; negative leaves them alone)")
+	fs.StringVar(&deviceSettingsFile, "device-settings", deviceSettingsFile, "JSON file of per-device settings such as calibrated gain")
+	fs.StringVar(&triggersFile, "triggers", triggersFile, "JSON file mapping MIDI notes and HID keys to recorder commands")
+	storage := fs.String("storage", "", "where to keep finished recordings, served from there: a directory, s3://bucket/prefix or sftp://user@host/path (default: the recordings folder)")
+	fs.BoolVar(&keepLocalCopies, "storage-keep-local", false, "keep audio in the recordings folder after it's copied to -storage")
+	archive := fs.String("archive", "", "where to move old sessions' audio, transcoded, leaving them in the catalog to restore: a directory, s3://bucket/prefix or sftp://user@host/path (disabled if empty)")
+	fs.StringVar(&archiveFormat, "archive-format", archiveFormat, "format tracks are transcoded to when archived: flac or opus")
+	fs.DurationVar(&trashRetention, "trash-retention", trashRetention, "how long deleted sessions stay in the trash before they're deleted for good (0 keeps them until the trash is emptied)")
+	fs.DurationVar(&archiveAfter, "archive-after", 0, "archive sessions automatically once they're this old, e.g. 720h (0 only archives through /api/jobs)")
+	fs.Var(&addrs, "listen", "address to serve on: host:port or unix:/path/to.sock (repeatable, default "+defaultListenAddr+")")
+	fs.Parse(args)
+	if timestampLayout == "" {
+		timestampLayout = defaultTimestampLayout
+		if timestampUTC {
+			timestampLayout += "Z"
+		}
+	}
+	if !validLevelCheckMode(levelCheckMode) {
+		fmt.Println("-level-check must be off, warn or refuse")
+		return
+	}
+	if inputVolume > 100 {
+		fmt.Println("-input-volume must be a percentage, at most 100")
+		return
+	}
+	if summaryPeriod != "off" && summaryPeriod != "daily" && summaryPeriod != "weekly" {
+		fmt.Println("-summary must be off, daily or weekly")
+		return
+	}
+	if archiveFormat != "flac" && archiveFormat != "opus" {
+		fmt.Println("-archive-format must be flac or opus")
+		return
+	}
+	if _, ok := autoConvertPresets[autoConvert]; autoConvert != "" && !ok {
+		fmt.Printf("-auto-convert must be one of %s\n", autoConvertPresetNames())
+		return
+	}
+	if splitEvery != 0 && splitEvery < minSplitEvery {
+		fmt.Printf("-split-every must be at least %s\n", minSplitEvery)
+		return
+	}
+	readOnly.trustProxy = limits.trustProxy
+	if err := checkEmailConfig(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := loadAlertRules(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := loadUsers(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := loadGrants(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := loadCollections(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(keywords) > 0 && stt.url == "" {
+		fmt.Println("⚠️  -keyword needs live transcripts; set -stt to listen for keywords")
+	}
+	applyMemoryFlags(maxBufferMB)
+	initTracing(*otlpEndpoint)
+
+	// Sockets handed over by systemd replace the default address
+	listeners, err := systemdListeners()
+	if err != nil {
+		fmt.Printf("Failed to use socket activation: %v\n", err)
+		return
+	}
+	for _, l := range listeners {
+		defer l.Close()
+	}
+	if len(addrs) == 0 && len(listeners) == 0 {
+		addrs = listenAddrs{defaultListenAddr}
+	}
+
 	fmt.Println("🎙️  Skribbl Audio Capture - Web Mode")
 
 	if err := initWebServer(); err != nil {
 		fmt.Printf("Failed to initialize web server: %v\n", err)
 		return
 	}
-	defer malgoContext.Uninit()
+	// The context may be replaced by /api/devices/refresh, so uninit whichever is current
+	defer func() { malgoContext.Uninit() }()
+
+	startJobWorkers()
+	if *storage != "" {
+		if store, err = openStore(*storage); err != nil {
+			fmt.Printf("Failed to open storage: %v\n", err)
+			return
+		}
+		if err := initStore(); err != nil {
+			fmt.Printf("Failed to open storage: %v\n", err)
+			return
+		}
+	}
+	if *archive != "" {
+		if archiveStore, err = openStore(*archive); err != nil {
+			fmt.Printf("Failed to open archive: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Archiving sessions to %s as %s\n", archiveStore, archiveFormat)
+		if archiveAfter > 0 {
+			go archiveOldSessions()
+		}
+	}
+	if trashRetention > 0 {
+		go purgeOldTrash()
+	}
+	if autoConvert != "" {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			fmt.Printf("⚠️  -auto-convert needs ffmpeg installed; sessions won't be converted until it is\n")
+		} else {
+			fmt.Printf("✓ Converting finished sessions' WAV tracks by the %s preset\n", autoConvert)
+		}
+	}
+
+	if virtualOutputName != "" {
+		if err := liveMix.start(malgoContext.Context, virtualOutputName); err != nil {
+			fmt.Printf("Failed to open virtual output: %v\n", err)
+			return
+		}
+	}
+
+	mux := http.NewServeMux()
 
 	// Serve static files
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			http.ServeFile(w, r, "index.html")
 		} else {
-			http.NotFound(w, r)
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
 		}
 	})
 
 	// API routes
-	http.HandleFunc("/api/devices", handleListDevices)
-	http.HandleFunc("/api/status", handleStatus)
-	http.HandleFunc("/api/start", handleStartRecording)
-	http.HandleFunc("/api/stop", handleStopRecording)
-	http.HandleFunc("/api/recordings", handleListRecordings)
-	http.HandleFunc("/recordings/", handleDownloadRecording)
-
-	port := "8080"
-	fmt.Printf("\n✓ Server running at http://localhost:%s\n", port)
+	mux.HandleFunc("/api/devices", handleListDevices)
+	mux.HandleFunc("POST /api/devices/refresh", handleRefreshDevices)
+	mux.HandleFunc("POST /api/devices/{id}/sample", handleSampleDevice)
+	mux.HandleFunc("POST /api/devices/{id}/calibrate", handleCalibrateDevice)
+	mux.HandleFunc("PUT /api/devices/{id}/speaker", handleSetSpeaker)
+	mux.HandleFunc("PUT /api/devices/{id}/channels", handleSetChannels)
+	mux.HandleFunc("PUT /api/devices/{id}/tap", handleSetTap)
+	mux.HandleFunc("/api/status", handleStatus)
+	mux.HandleFunc("GET /api/me", handleGetMe)
+	mux.HandleFunc("GET /api/grants", handleListGrants)
+	mux.HandleFunc("POST /api/grants", handleCreateGrant)
+	mux.HandleFunc("DELETE /api/grants/{id}", handleDeleteGrant)
+	mux.HandleFunc("GET /share/{token}", handleShareLink)
+	mux.HandleFunc("/api/start", withIdempotency(handleStartRecording))
+	mux.HandleFunc("/api/stop", withIdempotency(handleStopRecording))
+	mux.HandleFunc("GET /api/sessions/{id}", handleGetSession)
+	mux.HandleFunc("DELETE /api/sessions/{id}", handleDeleteSession)
+	mux.HandleFunc("GET /api/trash", handleListTrash)
+	mux.HandleFunc("POST /api/trash/{id}/restore", handleRestoreTrash)
+	mux.HandleFunc("DELETE /api/trash/{id}", handlePurgeTrash)
+	mux.HandleFunc("DELETE /api/trash", handleEmptyTrash)
+	mux.HandleFunc("POST /api/sessions/{id}/finalize", withIdempotency(handleFinalizeSession))
+	mux.HandleFunc("GET /api/sessions/{id}/timeline", handleSessionTimeline)
+	mux.HandleFunc("GET /api/sessions/{id}/dropouts", handleSessionDropouts)
+	mux.HandleFunc("GET /api/sessions/{id}/segments", handleSessionSegments)
+	mux.HandleFunc("GET /api/sessions/{id}/segments/verify", handleVerifySegments)
+	mux.HandleFunc("GET /api/sessions/{id}/levels", handleSessionLevels)
+	mux.HandleFunc("GET /api/sessions/{id}/transcript", handleSessionTranscript)
+	mux.HandleFunc("GET /api/sessions/{id}/archive", handleSessionArchive)
+	mux.HandleFunc("POST /api/sessions/{id}/devices", withIdempotency(handleAddSessionDevice))
+	mux.HandleFunc("DELETE /api/sessions/{id}/devices/{index}", handleRemoveSessionDevice)
+	mux.HandleFunc("POST /api/sessions/{id}/devices/{index}/mute", handleMuteSessionDevice(true))
+	mux.HandleFunc("POST /api/sessions/{id}/devices/{index}/unmute", handleMuteSessionDevice(false))
+	mux.HandleFunc("POST /api/sessions/{id}/markers", handleAddMarker)
+	mux.HandleFunc("POST /api/sessions/{id}/clips", handleCreateClips)
+	mux.HandleFunc("POST /api/sessions/{id}/bleep", handleBleepSession)
+	mux.HandleFunc("PUT /api/sessions/{id}/star", handleStarSession(true))
+	mux.HandleFunc("DELETE /api/sessions/{id}/star", handleStarSession(false))
+	mux.HandleFunc("PUT /api/sessions/{id}/episode", handlePublishEpisode)
+	mux.HandleFunc("DELETE /api/sessions/{id}/episode", handleUnpublishEpisode)
+	mux.HandleFunc("GET /feed.xml", handleFeed)
+	mux.HandleFunc("GET /episodes/{file}", handleEpisodeFile)
+	mux.HandleFunc("POST /api/events", handleGameEvent)
+	mux.HandleFunc("POST /api/announce", handleAnnounce)
+	mux.HandleFunc("GET /api/presets", handleListPresets)
+	mux.HandleFunc("GET /api/export-profiles", handleListExportProfiles)
+	mux.HandleFunc("GET /api/schedule", handleListSchedule)
+	mux.HandleFunc("POST /api/schedule", handleAddSchedule)
+	mux.HandleFunc("DELETE /api/schedule/{id}", handleDeleteSchedule)
+	mux.HandleFunc("GET /api/streamdeck", handleStreamDeck)
+	mux.HandleFunc("GET /api/transcript/stream", handleTranscriptStream)
+	mux.HandleFunc("/api/quickstart/{preset}", handleQuickstart)
+	mux.HandleFunc("/api/quickstart/last", handleQuickstartLast)
+	mux.HandleFunc("GET /api/monitor", handleMonitorStatus)
+	mux.HandleFunc("POST /api/monitor", handleSetMonitor)
+	mux.HandleFunc("POST /api/monitor/solo", handleSoloMonitor)
+	mux.HandleFunc("DELETE /api/monitor/solo", handleUnsoloMonitor)
+	mux.HandleFunc("GET /api/jobs", handleListJobs)
+	mux.HandleFunc("POST /api/jobs", handleCreateJob)
+	mux.HandleFunc("GET /api/jobs/{id}", handleGetJob)
+	mux.HandleFunc("DELETE /api/jobs/{id}", handleCancelJob)
+	mux.HandleFunc("GET /api/uploads", handleListUploads)
+	mux.HandleFunc("GET /api/uploads/{id}", handleGetUpload)
+	mux.HandleFunc("POST /api/uploads/{id}/pause", handlePauseUpload)
+	mux.HandleFunc("POST /api/uploads/{id}/resume", handleResumeUpload)
+	mux.HandleFunc("DELETE /api/uploads/{id}", handleCancelUpload)
+	mux.HandleFunc("GET /api/export/catalog", handleExportCatalog)
+	mux.HandleFunc("GET /api/collections", handleListCollections)
+	mux.HandleFunc("POST /api/collections", handleCreateCollection)
+	mux.HandleFunc("GET /api/collections/{id}", handleGetCollection)
+	mux.HandleFunc("PATCH /api/collections/{id}", handleRenameCollection)
+	mux.HandleFunc("DELETE /api/collections/{id}", handleDeleteCollection)
+	mux.HandleFunc("POST /api/collections/{id}/sessions", handleAddToCollection)
+	mux.HandleFunc("DELETE /api/collections/{id}/sessions/{sessionId}", handleRemoveFromCollection)
+	mux.HandleFunc("GET /api/summary", handleGetSummary)
+	mux.HandleFunc("POST /api/summary", handleSendSummary)
+	mux.HandleFunc("/api/recordings", handleListRecordings)
+	mux.HandleFunc("/recordings/", handleDownloadRecording)
+	mux.HandleFunc("POST "+importPath, handleImportRecording)
+	mux.HandleFunc("OPTIONS "+tusPath, handleTusOptions(limits.maxImportBytes<<20))
+	mux.HandleFunc("POST "+tusPath, handleTusCreate(limits.maxImportBytes<<20))
+	mux.HandleFunc("HEAD "+tusPath+"/{id}", handleTusHead)
+	mux.HandleFunc("PATCH "+tusPath+"/{id}", handleTusPatch)
+	mux.HandleFunc("DELETE "+tusPath+"/{id}", handleTusDelete)
+	mux.HandleFunc("GET /api/alerts", handleListAlerts)
+	mux.HandleFunc("GET /api/alerts/stream", handleAlertStream)
+	mux.HandleFunc("GET /api/alerts/open", handleListOpenAlerts)
+	mux.HandleFunc("DELETE /api/alerts/open/{key}", handleResolveAlert)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	if *admin {
+		registerAdminRoutes(mux)
+	}
+
+	for _, addr := range addrs {
+		l, err := openListener(addr)
+		if err != nil {
+			fmt.Printf("Failed to listen on %s: %v\n", addr, err)
+			return
+		}
+		defer l.Close()
+		listeners = append(listeners, l)
+	}
+
+	fmt.Println()
+	for _, l := range listeners {
+		fmt.Printf("✓ Server running at %s\n", listenerURL(l))
+	}
+	if *admin {
+		fmt.Println("✓ Admin endpoints enabled at /debug/pprof/")
+	}
+	if len(users) > 0 {
+		fmt.Printf("✓ %d user(s) can sign in with tokens from %s\n", len(users), usersFile)
+	}
+	if readOnly.enabled {
+		fmt.Println("✓ Read-only for other machines; control it from this one")
+	}
+	if email.server != "" {
+		if u := listenerURL(listeners[0]); email.link == "" && strings.HasPrefix(u, "http") {
+			email.link = u
+		}
+		fmt.Printf("✓ Emailing notifications to %s via %s\n", strings.Join(email.to, ", "), email.server)
+	}
+	if stallTimeout > 0 {
+		go runWatchdog()
+	}
+	if watchInterval > 0 {
+		go watchRecordings()
+	}
+	if diskAlertMB > 0 {
+		go watchDiskSpace()
+	}
+	if len(alertRules) > 0 {
+		fmt.Printf("✓ Loaded %d alert rule(s) from %s\n", len(alertRules), alertRulesFile)
+		for _, warning := range alertRuleWarnings() {
+			fmt.Printf("⚠️  %s\n", warning)
+		}
+	}
+	if escalates() {
+		go runAlertEscalation()
+	}
+	if summaryPeriod != "off" {
+		if webhookURL == "" && email.server == "" {
+			fmt.Println("⚠️  -summary needs somewhere to send summaries; set -webhook or -smtp")
+		}
+		go runSummaries()
+	}
+	if announceEnabled {
+		fmt.Println("✓ Announcements on")
+		go runAnnouncer()
+		go watchForAnnouncements()
+	}
+	if err := loadSchedule(); err != nil {
+		fmt.Printf("⚠️  Schedule disabled: %v\n", err)
+	} else {
+		if pending := pendingScheduled(); pending > 0 {
+			fmt.Printf("✓ %d scheduled recording(s) pending\n", pending)
+		}
+		go runScheduler()
+		if ics.url != "" {
+			fmt.Printf("✓ Scheduling calendar events mentioning %q from %s\n", ics.keyword, ics.url)
+			go followICS(ics)
+		}
+	}
+	if watched, err := startTriggers(); err != nil {
+		fmt.Printf("⚠️  Triggers disabled: %v\n", err)
+	} else if watched > 0 {
+		fmt.Printf("✓ Listening for triggers on %d device(s)\n", watched)
+	}
+	if obs.url != "" {
+		fmt.Printf("✓ Following OBS %s output at %s\n", obs.follow, obs.url)
+		go followOBS(obs)
+	}
+	if mqtt.url != "" {
+		fmt.Printf("✓ Publishing to MQTT broker at %s under %s/\n", mqtt.url, mqtt.topic)
+		go followMQTT(mqtt)
+	}
 	fmt.Println("✓ Open your browser to start recording!")
 	fmt.Println("\nPress Ctrl+C to stop the server")
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		fmt.Printf("Failed to start server: %v\n", err)
+	if err := serveListeners(traceHTTP(mux, localize(limitRequests(limits, authenticate(mux, limitToReadOnly(mux))))), listeners); err != nil {
+		fmt.Printf("Server stopped: %v\n", err)
 	}
 }
 
-func runCLI() {
-	fmt.Println("Skribbl Audio Capture")
+func runCLI(args []string) {
+	fs := flag.NewFlagSet("skribbl-capture", flag.ExitOnError)
+	maxBufferMB := addMemoryFlags(fs)
+	addBackendFlag(fs)
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	devicesFlag := fs.String("devices", "", "device number(s) to capture from, comma-separated, instead of being asked")
+	toStdout := fs.Bool("stdout", false, "write the audio to standard output, mixed if several devices are picked, instead of saving files; needs no input, and stops on Ctrl+C")
+	stdoutFormat := fs.String("stdout-format", "wav", "format of -stdout audio: wav, or raw for headerless 16-bit little-endian PCM")
+	title := fs.String("title", "", "recording title, added to the file names instead of being asked")
+	fs.Parse(args)
+	applyMemoryFlags(maxBufferMB)
+
+	// With -stdout, standard output carries the audio, so everything else
+	// the CLI prints goes to standard error instead
+	stdout := os.Stdout
+	if *toStdout {
+		if *stdoutFormat != "wav" && *stdoutFormat != "raw" {
+			fmt.Fprintf(os.Stderr, "Unknown -stdout-format %q: use wav or raw\n", *stdoutFormat)
+			return
+		}
+		os.Stdout = os.Stderr
+	}
+
+	uiPrintln("Skribbl Audio Capture")
 
 	// Step 1: Initialize the malgo context
-	// This sets up the audio backend for your platform (CoreAudio on Mac, WASAPI on Windows)
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	// This sets up the audio backend for your platform (CoreAudio on Mac, WASAPI on Windows) or the one picked with -backend
+	ctx, err := initAudioContext()
 	if err != nil {
-		fmt.Printf("Failed to initialize audio context: %v\n", err)
+		uiWarnf("Failed to initialize audio context: %v\n", err)
 		return
 	}
 	defer ctx.Uninit()
 
-	fmt.Println("Audio context initialized successfully!")
-
-	// Step 2: List all available audio devices
-	fmt.Println("\n=== Available Devices ===")
+	uiPrintln("Audio context initialized successfully!")
+
+	// Step 2: List all available audio devices, as part of the question
+	// if the user is going to be asked to pick some, unless they're picked
+	// from the interactive list instead
+	asking := *devicesFlag == "" && !*toStdout
+	picking := asking && canPick()
+	list := uiPrintln
+	if picking {
+		list = func(string) {}
+	} else if asking {
+		list = uiPrompt
+	}
+	list("\n=== Available Devices ===")
 
 	// Build a unified list of selectable devices
-	allDevices := []selectableDevice{}
-
-	// Get capture devices (microphones, virtual inputs)
-	captureInfos, err := ctx.Devices(malgo.Capture)
+	// (capture devices, plus playback devices as loopback sources where the backend supports it)
+	allDevices, err := listSelectableDevices(ctx.Context)
 	if err != nil {
-		fmt.Printf("Failed to get capture devices: %v\n", err)
+		uiWarnf("Failed to list devices: %v\n", err)
 		return
 	}
-	for _, info := range captureInfos {
-		allDevices = append(allDevices, selectableDevice{info: info, isLoopback: false})
-	}
-
-	// On Windows, also list playback devices as loopback sources (system audio)
-	if runtime.GOOS == "windows" {
-		playbackInfos, err := ctx.Devices(malgo.Playback)
-		if err != nil {
-			fmt.Printf("Failed to get playback devices: %v\n", err)
-			return
-		}
-		for _, info := range playbackInfos {
-			allDevices = append(allDevices, selectableDevice{info: info, isLoopback: true})
-		}
-	}
 
 	// Print all devices
 	for i, d := range allDevices {
@@ -153,16 +526,80 @@ func runCLI() {
 		if d.isLoopback {
 			label = " [Loopback]"
 		}
-		fmt.Printf("[%d] %s%s\n", i, d.info.Name(), label)
+		list(fmt.Sprintf("[%d] %s%s", i, d.info.Name(), label))
+		uiVerbosef("    ID %s\n", d.info.ID.String())
+	}
+
+	// The devices last recorded together are offered again, if they're all
+	// still connected
+	var reuse []int
+	var reuseLabel string
+	if asking {
+		last, err := loadLastDevices()
+		if err != nil {
+			uiWarnf("⚠️  %v\n", err)
+		} else if last != nil {
+			if indices, missing := last.find(allDevices); len(missing) == 0 {
+				reuse, reuseLabel = indices, last.label()
+			}
+		}
 	}
 
-	// Step 3: Ask user to select devices (comma-separated for multiple)
-	fmt.Println("\nEnter device number(s) to capture from (comma-separated for multiple, e.g., 1,2):")
+	// Step 3: Ask user to select devices (comma-separated for multiple),
+	// unless -devices picked them. Streaming to standard output never asks,
+	// recording the default capture device if nothing was picked.
 	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Printf("Failed to read input: %v\n", err)
-		return
+	input := *devicesFlag
+	if input == "" && *toStdout {
+		idx, ok := findDefaultCaptureDevice(allDevices)
+		if !ok {
+			uiWarnf("No default capture device: pick one with -devices\n")
+			return
+		}
+		input = strconv.Itoa(idx)
+	}
+	if picking {
+		labels := make([]string, len(allDevices))
+		for i, d := range allDevices {
+			labels[i] = d.info.Name()
+			if d.isLoopback {
+				labels[i] += " [Loopback]"
+			}
+		}
+		uiPrompt("\nPick the devices to capture from:")
+		if reuse != nil {
+			uiPrompt(trf(uiLang, "Press Enter to reuse the last selection (%s)", reuseLabel))
+		}
+		picked, err := pickDevices(reader, labels, reuse)
+		if err == errPickerCancelled {
+			return
+		}
+		if err != nil {
+			uiWarnf("Failed to read input: %v\n", err)
+			return
+		}
+		indices := make([]string, len(picked))
+		for i, idx := range picked {
+			indices[i] = strconv.Itoa(idx)
+		}
+		input = strings.Join(indices, ",")
+	} else if asking {
+		uiPrompt("\nEnter device number(s) to capture from (comma-separated for multiple, e.g., 1,2):")
+		if reuse != nil {
+			uiPrompt(trf(uiLang, "or press Enter to reuse the last selection (%s)", reuseLabel))
+		}
+		input, err = reader.ReadString('\n')
+		if err != nil {
+			uiWarnf("Failed to read input: %v\n", err)
+			return
+		}
+		if strings.TrimSpace(input) == "" && reuse != nil {
+			indices := make([]string, len(reuse))
+			for i, idx := range reuse {
+				indices[i] = strconv.Itoa(idx)
+			}
+			input = strings.Join(indices, ",")
+		}
 	}
 
 	// Clean up the input (removes the newline character and any spaces)
@@ -175,35 +612,61 @@ func runCLI() {
 		part = strings.TrimSpace(part)
 		deviceIndex, err := strconv.Atoi(part)
 		if err != nil {
-			fmt.Printf("That's not a valid number: %s\n", part)
+			uiWarnf("That's not a valid number: %s\n", part)
 			return
 		}
 		if deviceIndex < 0 || deviceIndex >= len(allDevices) {
-			fmt.Printf("Invalid device! Please choose 0-%d\n", len(allDevices)-1)
+			uiWarnf("Invalid device! Please choose 0-%d\n", len(allDevices)-1)
 			return
 		}
 		selectedIndices = append(selectedIndices, deviceIndex)
 	}
 
 	if len(selectedIndices) == 0 {
-		fmt.Println("No devices selected!")
+		uiWarnf("No devices selected!\n")
 		return
 	}
 
+	// A title makes the files easier to tell apart than by device alone
+	if asking && *title == "" {
+		uiPrompt("\nTitle (optional, press Enter to skip):")
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			uiWarnf("Failed to read input: %v\n", err)
+			return
+		}
+		*title = strings.TrimSpace(line)
+	}
+
 	// Step 4: Set up capture for each selected device
 	captures := []*captureDevice{}
 
-	for _, idx := range selectedIndices {
+	var mixer *stdoutMixer
+	if *toStdout {
+		mixer, err = newStdoutMixer(stdout, len(selectedIndices), 44100, *stdoutFormat == "raw")
+		if err != nil {
+			uiWarnf("%v\n", err)
+			return
+		}
+	}
+
+	for i, idx := range selectedIndices {
 		selected := allDevices[idx]
 		deviceInfo := selected.info
 		deviceName := deviceInfo.Name()
-		fmt.Printf("\nSetting up: %s\n", deviceName)
-
-		// Create a safe filename from the device name (replace spaces with underscores)
-		safeFilename := strings.ReplaceAll(strings.ToLower(deviceName), " ", "_") + ".wav"
+		uiPrintf("\nSetting up: %s\n", deviceName)
+
+		// Create a safe filename from the device name (replace spaces with underscores),
+		// after the title if there is one, numbered rather than overwriting an
+		// earlier recording
+		base := strings.ReplaceAll(strings.ToLower(deviceName), " ", "_")
+		if *title != "" {
+			base = sanitizeFilename(*title) + "_" + base
+		}
+		safeFilename := uniqueFilename(".", base, ".wav")
 
 		// Configure the audio capture settings
-		// Use Loopback mode for playback devices on Windows, Capture for regular mics
+		// Use Loopback mode for playback devices, Capture for regular mics
 		deviceType := malgo.Capture
 		if selected.isLoopback {
 			deviceType = malgo.Loopback
@@ -213,77 +676,151 @@ func runCLI() {
 		deviceConfig.Capture.Channels = 1             // Mono (single channel audio)
 		deviceConfig.SampleRate = 44100               // 44,100 samples per second (CD quality)
 		deviceConfig.Capture.DeviceID = deviceInfo.ID.Pointer()
+		uiVerbosef("    %d Hz, %d channel(s), 16-bit\n", deviceConfig.SampleRate, deviceConfig.Capture.Channels)
 
-		// Create a file for this device
-		outputFile, err := os.Create(safeFilename)
-		if err != nil {
-			fmt.Printf("Failed to create output file for %s: %v\n", deviceName, err)
-			return
-		}
+		// Create a file for this device, unless it's streamed to standard output
+		var outputFile *os.File
+		destination := "standard output"
+		if mixer == nil {
+			outputFile, err = createNewFile(safeFilename)
+			if err != nil {
+				uiWarnf("Failed to create output file for %s: %v\n", deviceName, err)
+				return
+			}
 
-		// Write the WAV header (with dataSize = 0 for now, we'll update it later)
-		err = writeWAVHeader(outputFile, deviceConfig.SampleRate, uint32(deviceConfig.Capture.Channels), 16, 0)
-		if err != nil {
-			fmt.Printf("Failed to write WAV header for %s: %v\n", deviceName, err)
-			return
+			// Write the WAV header (with dataSize = 0 for now, we'll update it later)
+			err = writeWAVHeader(outputFile, deviceConfig.SampleRate, uint32(deviceConfig.Capture.Channels), 16, 0)
+			if err != nil {
+				uiWarnf("Failed to write WAV header for %s: %v\n", deviceName, err)
+				return
+			}
+			destination = safeFilename
 		}
 
 		// Create a captureDevice to track this device's state
-		cap := &captureDevice{
-			name: deviceName,
-			file: outputFile,
+		// Each device gets its own queue and writer for its own file, or
+		// its own input to the stdout mixer
+		cap := newCaptureDevice(deviceName, outputFile, safeFilename, deviceConfig.SampleRate, deviceConfig.Capture.Channels)
+		if mixer != nil {
+			cap.sink = mixer.input(i)
 		}
 		captures = append(captures, cap)
 
-		// Define the callback for this device
-		// Each device gets its own callback that writes to its own file
-		onRecvFrames := func(pSample2, pSample []byte, framecount uint32) {
-			n, err := cap.file.Write(pSample)
-			if err != nil {
-				fmt.Printf("Error writing audio data for %s: %v\n", cap.name, err)
-			}
-			cap.totalBytesWritten += uint32(n)
-		}
-
 		// Initialize the device with our config and callback
 		device, err := malgo.InitDevice(ctx.Context, deviceConfig, malgo.DeviceCallbacks{
-			Data: onRecvFrames,
+			Data: cap.onData,
 		})
 		if err != nil {
-			fmt.Printf("Failed to initialize device %s: %v\n", deviceName, err)
+			uiWarnf("Failed to initialize device %s: %v\n", deviceName, err)
 			return
 		}
 		cap.device = device
 
-		fmt.Printf("✓ %s → %s\n", deviceName, safeFilename)
+		uiPrintf("✓ %s → %s\n", deviceName, destination)
+	}
+
+	// Ctrl+C from here on stops recording the same way as Enter, so the
+	// files are finished properly
+	var interrupt <-chan os.Signal
+	if mixer != nil {
+		interrupt = interrupted(syscall.SIGPIPE)
+	} else {
+		interrupt = interrupted()
 	}
 
 	// Step 5: Start all devices
 	for _, cap := range captures {
+		cap.keepTime()
 		err := cap.device.Start()
 		if err != nil {
-			fmt.Printf("Failed to start device %s: %v\n", cap.name, err)
+			uiWarnf("Failed to start device %s: %v\n", cap.name, err)
 			return
 		}
-		fmt.Printf("🎙️  Started recording: %s\n", cap.name)
+		uiPrintf("🎙️  Started recording: %s\n", cap.name)
+	}
+	if err := saveLastDevices(allDevices, selectedIndices); err != nil {
+		uiWarnf("⚠️  Failed to remember the devices recorded: %v\n", err)
 	}
 
-	fmt.Println("\nPress Enter to stop recording...")
-	reader.ReadString('\n')
+	stopProgress := make(chan struct{})
+	go reportProgress(captures, stopProgress)
+	if mixer != nil {
+		// Standard input may be the end of another pipe, so the stream
+		// stops on Ctrl+C, or when whatever reads standard output goes away
+		uiPrompt("\nPress Ctrl+C to stop streaming...")
+		select {
+		case <-interrupt:
+		case <-mixer.failed:
+		}
+	} else {
+		uiPrompt("\nPress Enter or Ctrl+C to stop recording...")
+		enter := make(chan struct{})
+		go func() {
+			reader.ReadString('\n')
+			close(enter)
+		}()
+		select {
+		case <-enter:
+		case <-interrupt:
+		}
+	}
+	close(stopProgress)
 
-	fmt.Println("\nRecording stopped!")
+	uiPrintln("\nRecording stopped!")
 
-	// Step 6: Clean up - stop devices, update WAV headers, close files
+	// Step 6: Clean up - stop devices, flush queues, update WAV headers, close files
+	tracks := []TrackInfo{}
 	for _, cap := range captures {
-		cap.device.Uninit()
+		cap.finish()
+		track, err := trackInfo(cap)
+		if err != nil && mixer == nil {
+			uiWarnf("⚠️  %v\n", err)
+		}
+		if mixer != nil {
+			// Nothing was saved to describe
+			track.File, track.Size, track.SHA256 = "", 0, ""
+		}
+		tracks = append(tracks, track)
 
-		// Go back to the beginning of the file and rewrite the header with correct size
-		cap.file.Seek(0, 0)
-		writeWAVHeader(cap.file, 44100, 1, 16, cap.totalBytesWritten)
-		cap.file.Close()
+		if mixer != nil {
+			uiPrintf("✓ Streamed %s (%d bytes of audio)\n", cap.name, cap.totalBytesWritten.Load())
+		} else {
+			uiPrintf("✓ Saved %s (%d bytes of audio)\n", cap.name, cap.totalBytesWritten.Load())
+		}
+		if dropped := cap.droppedFrames.Load(); dropped > 0 {
+			uiWarnf("⚠️  %s dropped %d frames (%d bytes) because the buffer limit was reached\n", cap.name, dropped, cap.droppedBytes.Load())
+		}
+	}
 
-		fmt.Printf("✓ Saved %s (%d bytes of audio)\n", cap.name, cap.totalBytesWritten)
+	result := map[string]interface{}{"tracks": tracks}
+	if *title != "" {
+		result["title"] = *title
 	}
+	printResult(result)
+	if mixer != nil && mixer.err != nil {
+		uiWarnf("⚠️  Standard output closed: %v\n", mixer.err)
+		return
+	}
+	if mixer == nil {
+		uiPrintln("✓ All recordings saved!")
+	}
+}
 
-	fmt.Println("✓ All recordings saved!")
+// interrupted returns a channel that gets the first Ctrl+C, or any of the
+// other signals, so a recording can be stopped and its files finished. A
+// second Ctrl+C quits at once, in case finishing them hangs.
+func interrupted(signals ...os.Signal) <-chan os.Signal {
+	first := make(chan os.Signal, 1)
+	received := make(chan os.Signal, 2)
+	signal.Notify(received, append([]os.Signal{os.Interrupt}, signals...)...)
+	go func() {
+		first <- <-received
+		for sig := range received {
+			if sig == os.Interrupt {
+				uiWarnf("\n⚠️  Quitting without finishing the recordings\n")
+				os.Exit(130)
+			}
+		}
+	}()
+	return first
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+// defaultMemoryBudgetMB is how much audio may sit in capture queues, across
+// all devices, before new frames start being dropped.
+const defaultMemoryBudgetMB = 64
+
+// chunkSize is the size of each pooled audio buffer. Callback buffers larger
+// than this are split across several chunks.
+const chunkSize = 4096
+
+// audioChunk is a fixed-size buffer that carries audio from a callback to a
+// writer. Chunks are recycled through the budget's free list so the callback
+// doesn't allocate once the pool has warmed up.
+type audioChunk struct {
+	buf [chunkSize]byte
+	n   int
+
+	// Audio lost just before this chunk: a stall in the device's callbacks,
+	// frames dropped because the queue was full, and silence to write in
+	// place of audio lost while the watchdog restarted the device
+	gap     time.Duration
+	dropped uint64
+	fill    time.Duration
+
+	// silence is how long a loopback device sent nothing before this chunk
+	// because nothing was playing, written as silence
+	silence time.Duration
+}
+
+// memoryBudget caps the bytes buffered between the audio callbacks and the
+// file writers. Each device is also limited to a fair share of the budget so
+// one stalled writer can't starve the others.
+type memoryBudget struct {
+	mu        sync.Mutex
+	limit     int64
+	used      int64
+	devices   int
+	allocated int
+	free      chan *audioChunk
+}
+
+func newMemoryBudget(limitBytes int64) *memoryBudget {
+	maxChunks := int(limitBytes / chunkSize)
+	if maxChunks < 1 {
+		maxChunks = 1
+	}
+	return &memoryBudget{
+		limit: int64(maxChunks) * chunkSize,
+		free:  make(chan *audioChunk, maxChunks),
+	}
+}
+
+// register adds a device to the budget, shrinking every device's share.
+func (b *memoryBudget) register() {
+	b.mu.Lock()
+	b.devices++
+	b.mu.Unlock()
+}
+
+// unregister removes a device from the budget.
+func (b *memoryBudget) unregister() {
+	b.mu.Lock()
+	if b.devices > 0 {
+		b.devices--
+	}
+	b.mu.Unlock()
+}
+
+// get claims a chunk for a device currently holding deviceUsed bytes. It
+// returns nil if either the global limit or the device's share would be
+// exceeded, in which case the caller should drop the frames.
+func (b *memoryBudget) get(deviceUsed int64) *audioChunk {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	share := b.limit
+	if b.devices > 1 {
+		share = b.limit / int64(b.devices)
+	}
+	if b.used+chunkSize > b.limit || deviceUsed+chunkSize > share {
+		return nil
+	}
+
+	var chunk *audioChunk
+	select {
+	case chunk = <-b.free:
+	default:
+		if b.allocated == cap(b.free) {
+			return nil
+		}
+		b.allocated++
+		chunk = new(audioChunk)
+	}
+	b.used += chunkSize
+	return chunk
+}
+
+// put returns a chunk to the pool once it has been written out.
+func (b *memoryBudget) put(chunk *audioChunk) {
+	chunk.n = 0
+	b.mu.Lock()
+	b.used -= chunkSize
+	b.mu.Unlock()
+	b.free <- chunk
+}
+
+// inUse reports how many bytes are currently buffered.
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// captureBudget is shared by every capture device in the process.
+var captureBudget = newMemoryBudget(defaultMemoryBudgetMB << 20)
+
+// addMemoryFlags registers the buffering flags shared by CLI and web mode.
+func addMemoryFlags(fs *flag.FlagSet) *int {
+	return fs.Int("max-buffer-mb", defaultMemoryBudgetMB, "maximum MB of audio buffered across all devices before frames are dropped")
+}
+
+// applyMemoryFlags resizes the shared budget after flags have been parsed.
+func applyMemoryFlags(maxBufferMB *int) {
+	if *maxBufferMB > 0 {
+		captureBudget = newMemoryBudget(int64(*maxBufferMB) << 20)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// MergeResult is what merge wrote, printed as JSON with -json
+type MergeResult struct {
+	File             string       `json:"file"`
+	SampleRate       uint32       `json:"sampleRate"`
+	Channels         uint32       `json:"channels"`
+	DurationSeconds  float64      `json:"durationSeconds"`
+	CrossfadeSeconds float64      `json:"crossfadeSeconds,omitempty"`
+	Inputs           []MergeInput `json:"inputs"`
+}
+
+// MergeInput is one of the files merged, as it was before converting
+type MergeInput struct {
+	File string `json:"file"`
+	AudioInfo
+}
+
+// upmixReader copies mono 16-bit PCM to every channel.
+type upmixReader struct {
+	src      io.Reader
+	channels int
+	buf      []byte
+}
+
+func (u *upmixReader) Read(p []byte) (int, error) {
+	frames := len(p) / (2 * u.channels)
+	if frames == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	if cap(u.buf) < frames*2 {
+		u.buf = make([]byte, frames*2)
+	}
+	n, err := io.ReadFull(u.src, u.buf[:frames*2])
+	n &^= 1
+	for i := 0; i < n/2; i++ {
+		for c := 0; c < u.channels; c++ {
+			copy(p[(i*u.channels+c)*2:], u.buf[i*2:i*2+2])
+		}
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n * u.channels, err
+}
+
+// runMerge joins audio files end to end into one 16-bit WAV file, at the
+// first one's sample rate and channels, converting the others to match, with
+// optional crossfades between them.
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	crossfade := fs.Duration("crossfade", 0, "overlap each file with the next by this long, fading one out as the other fades in, such as 500ms")
+	rate := fs.Uint("rate", 0, "sample rate to write, resampling files recorded at others (default the first file's)")
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: skribbl-capture merge [flags] out.wav in.wav in.wav...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 3 || *crossfade < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	outPath, inPaths := fs.Arg(0), fs.Args()[1:]
+
+	result := MergeResult{File: filepath.Base(outPath), SampleRate: uint32(*rate), CrossfadeSeconds: crossfade.Seconds()}
+	var parts []io.Reader
+	for _, path := range inPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			uiWarnf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		info, err := probeAudio(f)
+		if err != nil {
+			uiWarnf("✗ %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		if result.Channels == 0 {
+			result.Channels = info.Channels
+		}
+		if result.SampleRate == 0 {
+			result.SampleRate = info.SampleRate
+		}
+		pcm, err := openMergePCM(f, info, result.SampleRate, result.Channels)
+		if err != nil {
+			uiWarnf("✗ %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		if info.SampleRate != result.SampleRate {
+			uiPrintf("Resampling %s from %d Hz to %d Hz\n", filepath.Base(path), info.SampleRate, result.SampleRate)
+		}
+		result.Inputs = append(result.Inputs, MergeInput{File: path, AudioInfo: info})
+		parts = append(parts, pcm)
+	}
+
+	fadeFrames := int(math.Round(crossfade.Seconds() * float64(result.SampleRate)))
+	frames, err := writeMergedWAV(outPath, result.SampleRate, result.Channels, parts, fadeFrames)
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	result.DurationSeconds = float64(frames) / float64(result.SampleRate)
+	uiPrintf("✓ Merged %d files into %s (%s, %d Hz, %d channel(s))\n", len(inPaths), outPath, subtitleTime(result.DurationSeconds, "."), result.SampleRate, result.Channels)
+	printResult(result)
+}
+
+// openMergePCM opens a file as 16-bit PCM at rate with channels, down- or
+// upmixing mono and resampling it as needed.
+func openMergePCM(f *os.File, info AudioInfo, rate, channels uint32) (io.Reader, error) {
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.Channels == channels:
+	case channels == 1:
+		pcm = newMonoReader(pcm, info.Channels)
+	case info.Channels == 1:
+		pcm = &upmixReader{src: pcm, channels: int(channels)}
+	default:
+		return nil, fmt.Errorf("can't merge %d channels with %d; only mono converts to and from other layouts", info.Channels, channels)
+	}
+	if info.SampleRate == rate {
+		return pcm, nil
+	}
+	return newResampler(pcm, channels, float64(info.SampleRate)/float64(rate))
+}
+
+// writeMergedWAV writes parts one after the other to a new WAV file, each
+// faded into the next over fadeFrames, and returns how many frames it wrote.
+func writeMergedWAV(path string, rate, channels uint32, parts []io.Reader, fadeFrames int) (int64, error) {
+	out, err := createNewFile(path)
+	if err != nil {
+		return 0, err
+	}
+	fail := func(err error) (int64, error) {
+		out.Close()
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := writeWAVHeader(out, rate, channels, 16, 0); err != nil {
+		return fail(err)
+	}
+	w := bufio.NewWriterSize(out, 64*1024)
+	samples, err := mergePCM(w, parts, int(channels), fadeFrames)
+	if err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	dataSize := samples * 2
+	if dataSize > math.MaxUint32-wavHeaderSize {
+		return fail(errors.New("too long for a WAV file"))
+	}
+
+	// Go back and fill in the size, as when finishing a recording
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	if err := writeWAVHeader(out, rate, channels, 16, uint32(dataSize)); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return samples / int64(channels), nil
+}
+
+// mergePCM copies 16-bit PCM parts to w one after the other. With fadeFrames,
+// the end of each part is held back and mixed into the start of the next
+// with an equal-power crossfade, so the result is shorter by the overlaps. It
+// returns how many samples it wrote.
+func mergePCM(w io.Writer, parts []io.Reader, channels, fadeFrames int) (int64, error) {
+	var written int64
+	write := func(samples []int16) error {
+		written += int64(len(samples))
+		return binary.Write(w, binary.LittleEndian, samples)
+	}
+	read := func(r io.Reader, buf []byte) ([]int16, error) {
+		n, err := io.ReadFull(r, buf)
+		n -= n % (2 * channels)
+		samples := make([]int16, n/2)
+		binary.Decode(buf[:n], binary.LittleEndian, samples)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return samples, err
+	}
+
+	hold := fadeFrames * channels
+	buf := make([]byte, 64*1024)
+	var tail []int16 // the end of the last part, held back to fade
+	for i, part := range parts {
+		var pending []int16
+		if len(tail) > 0 {
+			head, err := read(part, make([]byte, len(tail)*2))
+			if err != nil && err != io.EOF {
+				return written, err
+			}
+			// A part shorter than the fade fades for as long as it lasts
+			n := min(len(head), len(tail)) / channels
+			start := len(tail) - n*channels
+			if err := write(tail[:start]); err != nil {
+				return written, err
+			}
+			mixed := make([]int16, n*channels)
+			for f := 0; f < n; f++ {
+				g := (float64(f) + 0.5) / float64(n) * math.Pi / 2
+				for c := 0; c < channels; c++ {
+					v := float64(tail[start+f*channels+c])*math.Cos(g) + float64(head[f*channels+c])*math.Sin(g)
+					mixed[f*channels+c] = int16(max(min(math.Round(v), math.MaxInt16), math.MinInt16))
+				}
+			}
+			if err := write(mixed); err != nil {
+				return written, err
+			}
+			pending = head[n*channels:]
+		}
+
+		// The last part has nothing to fade into, so none of it is held
+		keep := hold
+		if i == len(parts)-1 {
+			keep = 0
+		}
+		for {
+			samples, err := read(part, buf)
+			if err != nil && err != io.EOF {
+				return written, err
+			}
+			pending = append(pending, samples...)
+			if len(pending) > keep {
+				if err := write(pending[:len(pending)-keep]); err != nil {
+					return written, err
+				}
+				pending = append([]int16(nil), pending[len(pending)-keep:]...)
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+		tail = pending
+	}
+	return written, write(tail)
+}
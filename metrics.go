@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resourceSampleInterval is how often CPU and write throughput are sampled.
+const resourceSampleInterval = 2 * time.Second
+
+// bytesWrittenTotal counts audio bytes written to disk by every capture device.
+var bytesWrittenTotal atomic.Uint64
+
+// ResourceUsage reports how hard the process is working, so users can tell
+// when the machine can't keep up before dropouts start
+type ResourceUsage struct {
+	CPUPercent       float64 `json:"cpuPercent"`
+	RSSBytes         uint64  `json:"rssBytes"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	QueueBytes       int64   `json:"queueBytes"`
+	QueueLimitBytes  int64   `json:"queueLimitBytes"`
+}
+
+// DeviceStats reports the capture pipeline state of one recording device
+type DeviceStats struct {
+	Name          string  `json:"name"`
+	BytesWritten  uint32  `json:"bytesWritten"`
+	QueueBytes    int64   `json:"queueBytes"`
+	DroppedFrames uint64  `json:"droppedFrames"`
+	Muted         bool    `json:"muted"`
+	LevelDBFS     float64 `json:"levelDbfs"`
+}
+
+// resourceSampler turns cumulative counters into rates.
+type resourceSampler struct {
+	mu         sync.Mutex
+	lastWall   time.Time
+	lastCPU    time.Duration
+	lastBytes  uint64
+	cpuPercent float64
+	writeRate  float64
+}
+
+var resources = &resourceSampler{}
+
+// start samples in the background until the process exits.
+func (s *resourceSampler) start() {
+	s.sample()
+	go func() {
+		ticker := time.NewTicker(resourceSampleInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.sample()
+		}
+	}()
+}
+
+func (s *resourceSampler) sample() {
+	now := time.Now()
+	cpu, err := processCPUTime()
+	if err != nil {
+		return
+	}
+	written := bytesWrittenTotal.Load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lastWall.IsZero() {
+		wall := now.Sub(s.lastWall).Seconds()
+		if wall > 0 {
+			s.cpuPercent = (cpu - s.lastCPU).Seconds() / wall * 100
+			s.writeRate = float64(written-s.lastBytes) / wall
+		}
+	}
+	s.lastWall, s.lastCPU, s.lastBytes = now, cpu, written
+}
+
+// usage returns the latest sampled rates alongside live queue figures.
+func (s *resourceSampler) usage() ResourceUsage {
+	s.mu.Lock()
+	usage := ResourceUsage{
+		CPUPercent:       s.cpuPercent,
+		WriteBytesPerSec: s.writeRate,
+	}
+	s.mu.Unlock()
+
+	usage.RSSBytes, _ = processRSS()
+	usage.QueueBytes = captureBudget.inUse()
+	usage.QueueLimitBytes = captureBudget.limit
+	return usage
+}
+
+// deviceStats snapshots the pipeline counters of a set of captures.
+func deviceStats(captures []*captureDevice) []DeviceStats {
+	stats := []DeviceStats{}
+	for _, cap := range captures {
+		stats = append(stats, DeviceStats{
+			Name:          cap.name,
+			BytesWritten:  cap.totalBytesWritten.Load(),
+			QueueBytes:    cap.queuedBytes.Load(),
+			DroppedFrames: cap.droppedFrames.Load(),
+			Muted:         cap.muted.Load(),
+			LevelDBFS:     cap.levelDBFS(),
+		})
+	}
+	return stats
+}
+
+// Handler: GET /metrics - Prometheus text format metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	recording := activeSession != nil
+	stats := []DeviceStats{}
+	if recording {
+		stats = deviceStats(activeSession.captures)
+	}
+	recordingMutex.Unlock()
+
+	usage := resources.usage()
+	cpu, _ := processCPUTime()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	recordingValue := 0
+	if recording {
+		recordingValue = 1
+	}
+	fmt.Fprintf(w, "# HELP skribbl_recording Whether a recording is in progress.\n# TYPE skribbl_recording gauge\nskribbl_recording %d\n", recordingValue)
+	fmt.Fprintf(w, "# HELP process_cpu_seconds_total Total user and system CPU time spent in seconds.\n# TYPE process_cpu_seconds_total counter\nprocess_cpu_seconds_total %g\n", cpu.Seconds())
+	fmt.Fprintf(w, "# HELP skribbl_cpu_percent Process CPU usage over the last sample interval, in percent of one core.\n# TYPE skribbl_cpu_percent gauge\nskribbl_cpu_percent %g\n", usage.CPUPercent)
+	fmt.Fprintf(w, "# HELP process_resident_memory_bytes Resident memory size in bytes.\n# TYPE process_resident_memory_bytes gauge\nprocess_resident_memory_bytes %d\n", usage.RSSBytes)
+	fmt.Fprintf(w, "# HELP skribbl_written_bytes_total Audio bytes written to disk.\n# TYPE skribbl_written_bytes_total counter\nskribbl_written_bytes_total %d\n", bytesWrittenTotal.Load())
+	fmt.Fprintf(w, "# HELP skribbl_write_bytes_per_second Audio write throughput over the last sample interval.\n# TYPE skribbl_write_bytes_per_second gauge\nskribbl_write_bytes_per_second %g\n", usage.WriteBytesPerSec)
+	fmt.Fprintf(w, "# HELP skribbl_queue_bytes Audio bytes waiting to be written.\n# TYPE skribbl_queue_bytes gauge\nskribbl_queue_bytes %d\n", usage.QueueBytes)
+	fmt.Fprintf(w, "# HELP skribbl_queue_limit_bytes Maximum audio bytes that may wait to be written.\n# TYPE skribbl_queue_limit_bytes gauge\nskribbl_queue_limit_bytes %d\n", usage.QueueLimitBytes)
+
+	fmt.Fprintf(w, "# HELP skribbl_device_queue_bytes Audio bytes waiting to be written, per device.\n# TYPE skribbl_device_queue_bytes gauge\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "skribbl_device_queue_bytes{device=%q} %d\n", s.Name, s.QueueBytes)
+	}
+	fmt.Fprintf(w, "# HELP skribbl_device_dropped_frames_total Frames dropped because the buffer limit was reached, per device.\n# TYPE skribbl_device_dropped_frames_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "skribbl_device_dropped_frames_total{device=%q} %d\n", s.Name, s.DroppedFrames)
+	}
+}
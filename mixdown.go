@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// monoReader folds 16-bit PCM with any number of channels to mono, by
+// averaging them.
+type monoReader struct {
+	src      io.Reader
+	channels int
+	frames   []byte
+}
+
+func newMonoReader(pcm io.Reader, channels uint32) io.Reader {
+	if channels == 1 {
+		return pcm
+	}
+	return &monoReader{src: pcm, channels: int(channels)}
+}
+
+func (m *monoReader) Read(p []byte) (int, error) {
+	want := len(p) / 2 * m.channels * 2
+	if want == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	if cap(m.frames) < want {
+		m.frames = make([]byte, want)
+	}
+	n, err := io.ReadFull(m.src, m.frames[:want])
+	frames := n / (m.channels * 2)
+	for f := range frames {
+		var sum int32
+		for c := range m.channels {
+			i := (f*m.channels + c) * 2
+			sum += int32(int16(uint16(m.frames[i]) | uint16(m.frames[i+1])<<8))
+		}
+		s := uint16(int16(sum / int32(m.channels)))
+		p[f*2], p[f*2+1] = byte(s), byte(s>>8)
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if frames == 0 && err == nil {
+		err = io.EOF
+	}
+	return frames * 2, err
+}
+
+// mixReader sums mono 16-bit PCM streams, which may end at different times,
+// into one, clamped to full scale.
+type mixReader struct {
+	inputs []io.Reader
+	done   []bool
+	buf    []byte
+	sum    []int32
+}
+
+func (m *mixReader) Read(p []byte) (int, error) {
+	p = p[:len(p)&^1]
+	if len(m.buf) < len(p) {
+		m.buf = make([]byte, len(p))
+		m.sum = make([]int32, len(p)/2)
+	}
+	clear(m.sum)
+	longest := 0
+	for i, in := range m.inputs {
+		if m.done[i] {
+			continue
+		}
+		n, err := io.ReadFull(in, m.buf[:len(p)])
+		n &^= 1
+		for j := 0; j < n; j += 2 {
+			m.sum[j/2] += int32(int16(uint16(m.buf[j]) | uint16(m.buf[j+1])<<8))
+		}
+		longest = max(longest, n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			m.done[i] = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if longest == 0 {
+		return 0, io.EOF
+	}
+	for j := 0; j < longest; j += 2 {
+		s := uint16(int16(min(max(m.sum[j/2], -32768), 32767)))
+		p[j], p[j+1] = byte(s), byte(s>>8)
+	}
+	return longest, nil
+}
+
+// openMonoPCM opens an audio file as mono 16-bit PCM at rate, resampling it
+// if it was recorded at another.
+func openMonoPCM(f *os.File, rate uint32) (io.Reader, error) {
+	info, err := probeAudio(f)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return nil, err
+	}
+	mono := newMonoReader(pcm, info.Channels)
+	if info.SampleRate == rate {
+		return mono, nil
+	}
+	return newResampler(mono, 1, float64(info.SampleRate)/float64(rate))
+}
+
+// presetBookends returns the intro and outro of the preset a session was
+// started from, if it has them.
+func presetBookends(manifest *SessionManifest) (string, string, error) {
+	if manifest.Preset == "" {
+		return "", "", nil
+	}
+	presets, err := loadPresets()
+	if err != nil {
+		return "", "", err
+	}
+	preset, ok := presets[manifest.Preset]
+	if !ok {
+		fmt.Printf("⚠️  Preset %q of %s is gone; mixing down without an intro or outro\n", manifest.Preset, manifest.ID)
+		return "", "", nil
+	}
+	return preset.Intro, preset.Outro, nil
+}
+
+// runMixdown mixes every track of a session down to one mono file,
+// <session>_mixdown.wav, at the first track's sample rate. If the session
+// was started from a preset with an intro or outro, they're played before
+// and after it, making a file ready to publish.
+func runMixdown(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	intro, outro, err := presetBookends(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	rate := manifest.Tracks[0].SampleRate
+	mix := &mixReader{done: make([]bool, len(manifest.Tracks))}
+	for i, track := range manifest.Tracks {
+		// Every track starts at the start of the session, so the first
+		// runs about as long as the mix and stands for its progress
+		report := func(float64) {}
+		if i == 0 {
+			report = progress
+		}
+		f, info, pcm, err := openTrackPCM(ctx, track.File, report, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		var in io.Reader = newMonoReader(pcm, info.Channels)
+		if info.SampleRate != rate {
+			if in, err = newResampler(in, 1, float64(info.SampleRate)/float64(rate)); err != nil {
+				return nil, fmt.Errorf("%s: %w", track.File, err)
+			}
+		}
+		mix.inputs = append(mix.inputs, in)
+	}
+
+	bookend := func(path string) (io.Reader, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the preset's intro or outro: %w", err)
+		}
+		files = append(files, f)
+		pcm, err := openMonoPCM(f, rate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return pcm, nil
+	}
+	parts := []io.Reader{mix}
+	if intro != "" {
+		pcm, err := bookend(intro)
+		if err != nil {
+			return nil, err
+		}
+		parts = append([]io.Reader{pcm}, parts...)
+	}
+	if outro != "" {
+		pcm, err := bookend(outro)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, pcm)
+	}
+
+	name, release, err := writeGainedTrack(manifest.ID+"_mixdown", AudioInfo{SampleRate: rate, Channels: 1}, io.MultiReader(parts...), 1)
+	if err != nil {
+		return nil, err
+	}
+	if intro != "" || outro != "" {
+		fmt.Printf("✓ Mixed down %s with the %s preset's intro and outro\n", manifest.ID, manifest.Preset)
+	}
+	return addExports(job.SessionID, []ExportFile{{Kind: jobMixdown, File: name}}, []func(){release})
+}
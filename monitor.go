@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gen2brain/malgo"
+)
+
+// monitorBufferFrames is how much captured audio each device may have waiting
+// for the monitor output. Anything beyond this is too late to be worth
+// hearing, so it's dropped rather than adding latency.
+const monitorBufferFrames = 8192
+
+// noSolo means every recording device is heard on the monitor.
+const noSolo = -1
+
+// sampleRing is a single-producer, single-consumer queue of samples between a
+// capture callback and the monitor's playback callback. Neither side blocks
+// or allocates.
+type sampleRing struct {
+	buf   [monitorBufferFrames]int16
+	read  atomic.Uint64
+	write atomic.Uint64
+}
+
+// push queues little-endian S16 frames of channels interleaved channels,
+// downmixed to mono for the outputs fed from the ring, dropping whatever
+// doesn't fit.
+func (r *sampleRing) push(pcm []byte, channels int) {
+	w := r.write.Load()
+	free := monitorBufferFrames - (w - r.read.Load())
+	frame := channels * 2
+	for i := 0; i+frame <= len(pcm) && free > 0; i += frame {
+		var sum int32
+		for ch := range channels {
+			sum += int32(int16(uint16(pcm[i+ch*2]) | uint16(pcm[i+ch*2+1])<<8))
+		}
+		r.buf[w%monitorBufferFrames] = int16(sum / int32(channels))
+		w++
+		free--
+	}
+	r.write.Store(w)
+}
+
+// mixInto adds up to len(mix) queued samples into mix.
+func (r *sampleRing) mixInto(mix []int32) {
+	rd := r.read.Load()
+	available := r.write.Load() - rd
+	for i := 0; i < len(mix) && available > 0; i++ {
+		mix[i] += int32(r.buf[rd%monitorBufferFrames])
+		rd++
+		available--
+	}
+	r.read.Store(rd)
+}
+
+// reset discards anything queued. Only safe while neither side is running.
+func (r *sampleRing) reset() {
+	r.read.Store(r.write.Load())
+}
+
+// monitor plays the recording devices back through an output device, so you
+// can hear what is being captured.
+type monitor struct {
+	mu     sync.Mutex
+	device *malgo.Device
+	solo   int
+
+	// sources is read by the playback callback, so it's swapped atomically
+	sources atomic.Pointer[[]*captureDevice]
+	mix     []int32
+}
+
+// MonitorStatus is the current monitoring state
+type MonitorStatus struct {
+	Enabled bool `json:"enabled"`
+	Solo    *int `json:"solo"`
+}
+
+// MonitorRequest is the request body for turning monitoring on or off
+type MonitorRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SoloRequest is the request body for soloing a device
+type SoloRequest struct {
+	DeviceIndex *int `json:"deviceIndex"`
+}
+
+var monitoring = &monitor{solo: noSolo}
+
+// start opens the default output device and begins playing captured audio.
+func (m *monitor) start(ctx malgo.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.device != nil {
+		return nil
+	}
+
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = 1
+	deviceConfig.SampleRate = 44100
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{Data: m.onData})
+	if err != nil {
+		return fmt.Errorf("failed to initialize monitor output: %v", err)
+	}
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		return fmt.Errorf("failed to start monitor output: %v", err)
+	}
+	m.device = device
+	m.syncLocked()
+	return nil
+}
+
+// stop closes the output device.
+func (m *monitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.device == nil {
+		return
+	}
+	m.device.Uninit()
+	m.device = nil
+	m.syncLocked()
+}
+
+// setSolo makes only one device audible, or every device with noSolo.
+func (m *monitor) setSolo(index int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.solo = index
+	m.syncLocked()
+}
+
+// status reports whether monitoring is on and which device is soloed.
+func (m *monitor) status() MonitorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := MonitorStatus{Enabled: m.device != nil}
+	if m.solo != noSolo {
+		solo := m.solo
+		status.Solo = &solo
+	}
+	return status
+}
+
+// sync points the monitor at the active session's devices. Must be called
+// with recordingMutex held whenever the session's devices change.
+func (m *monitor) sync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncLocked()
+}
+
+func (m *monitor) syncLocked() {
+	var captures []*captureDevice
+	if activeSession != nil {
+		captures = activeSession.captures
+	}
+
+	sources := []*captureDevice{}
+	for _, cap := range captures {
+		audible := m.device != nil && (m.solo == noSolo || m.solo == cap.deviceIndex)
+		if !audible {
+			cap.monitored.Store(false)
+			continue
+		}
+		if !cap.monitored.Load() {
+			// Nothing is reading or writing the ring yet, so stale audio
+			// from an earlier listen can be thrown away
+			cap.monitorRing.reset()
+		}
+		sources = append(sources, cap)
+	}
+	m.sources.Store(&sources)
+	for _, cap := range sources {
+		cap.monitored.Store(true)
+	}
+}
+
+// onData is the playback callback: it mixes every audible device's queued
+// samples into the output.
+func (m *monitor) onData(pOutput, pInput []byte, framecount uint32) {
+	frames := int(framecount)
+	if cap(m.mix) < frames {
+		m.mix = make([]int32, frames)
+	}
+	mix := m.mix[:frames]
+	clear(mix)
+
+	if sources := m.sources.Load(); sources != nil {
+		for _, source := range *sources {
+			source.monitorRing.mixInto(mix)
+		}
+	}
+
+	for i, sample := range mix {
+		sample = max(min(sample, 32767), -32768)
+		if 2*i+1 < len(pOutput) {
+			pOutput[2*i] = byte(sample)
+			pOutput[2*i+1] = byte(uint16(sample) >> 8)
+		}
+	}
+}
+
+// Handler: GET /api/monitor - Get the monitoring state
+func handleMonitorStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(monitoring.status())
+}
+
+// Handler: POST /api/monitor - Turn monitoring on or off
+func handleSetMonitor(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	var req MonitorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.Enabled {
+		if err := monitoring.start(malgoContext.Context); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeDeviceError, err.Error())
+			return
+		}
+	} else {
+		monitoring.stop()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(monitoring.status())
+}
+
+// Handler: POST /api/monitor/solo - Hear only one device on the monitor
+func handleSoloMonitor(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	var req SoloRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.DeviceIndex == nil {
+		writeError(w, http.StatusBadRequest, errCodeNoDevices, "No device selected")
+		return
+	}
+	devices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if *req.DeviceIndex < 0 || *req.DeviceIndex >= len(devices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", *req.DeviceIndex))
+		return
+	}
+
+	monitoring.setSolo(*req.DeviceIndex)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(monitoring.status())
+}
+
+// Handler: DELETE /api/monitor/solo - Hear every device on the monitor again
+func handleUnsoloMonitor(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	monitoring.setSolo(noSolo)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(monitoring.status())
+}
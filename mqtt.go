@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 client: QoS 0 publish and subscribe, retained
+// messages and a last will. That's enough for Home Assistant without pulling
+// in a dependency.
+
+const (
+	// mqttReconnectDelay is how long to wait before reconnecting to the broker.
+	mqttReconnectDelay = 5 * time.Second
+
+	// mqttDialTimeout bounds connecting and the CONNECT/CONNACK exchange.
+	mqttDialTimeout = 5 * time.Second
+
+	// mqttMaxPacketBytes caps incoming packets; commands are tiny.
+	mqttMaxPacketBytes = 64 << 10
+
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPubAck     = 0x40
+	mqttSubscribe  = 0x82
+	mqttSubAck     = 0x90
+	mqttPingResp   = 0xD0
+	mqttDisconnect = 0xE0
+)
+
+// mqttConfig holds the MQTT integration flags.
+type mqttConfig struct {
+	url             string
+	user            string
+	password        string
+	topic           string
+	discoveryPrefix string
+	interval        time.Duration
+}
+
+// mqttConn is one broker connection. Writes may come from several goroutines.
+type mqttConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// mqttMessage is a PUBLISH received from the broker.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// MQTTLevels is published to <topic>/levels while recording
+type MQTTLevels struct {
+	PeakDBFS float64       `json:"peakDbfs"` // loudest device
+	Devices  []DeviceLevel `json:"devices"`
+}
+
+// DeviceLevel is the metered level of one recording device
+type DeviceLevel struct {
+	Name      string  `json:"name"`
+	LevelDBFS float64 `json:"levelDbfs"`
+	Muted     bool    `json:"muted"`
+}
+
+// addMQTTFlags registers the MQTT integration flags.
+func addMQTTFlags(fs *flag.FlagSet) *mqttConfig {
+	cfg := &mqttConfig{}
+	fs.StringVar(&cfg.url, "mqtt", "", "MQTT broker to publish to, e.g. tcp://localhost:1883 (disabled if empty)")
+	fs.StringVar(&cfg.user, "mqtt-user", "", "MQTT username")
+	fs.StringVar(&cfg.password, "mqtt-password", "", "MQTT password")
+	fs.StringVar(&cfg.topic, "mqtt-topic", "skribbl", "base topic for state and commands")
+	fs.StringVar(&cfg.discoveryPrefix, "mqtt-discovery-prefix", "homeassistant", "Home Assistant discovery prefix (disabled if empty)")
+	fs.DurationVar(&cfg.interval, "mqtt-interval", 5*time.Second, "how often levels and disk space are published")
+	return cfg
+}
+
+// followMQTT keeps a broker connection open for the life of the process,
+// reconnecting whenever it drops.
+func followMQTT(cfg *mqttConfig) {
+	for {
+		err := cfg.run()
+		fmt.Printf("⚠️  MQTT connection lost: %v (retrying in %s)\n", err, mqttReconnectDelay)
+		time.Sleep(mqttReconnectDelay)
+	}
+}
+
+// topicFor returns a topic under the base topic.
+func (cfg *mqttConfig) topicFor(name string) string {
+	return cfg.topic + "/" + name
+}
+
+// run connects to the broker, announces the recorder to Home Assistant and
+// publishes state until the connection drops.
+func (cfg *mqttConfig) run() error {
+	keepAlive := max(2*cfg.interval, 30*time.Second)
+	conn, err := dialMQTT(cfg, keepAlive)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	commandTopic, recordTopic := cfg.topicFor("command"), cfg.topicFor("record/set")
+	if err := conn.subscribe(1, commandTopic, recordTopic); err != nil {
+		return err
+	}
+	if err := conn.publish(cfg.topicFor("availability"), []byte("online"), true); err != nil {
+		return err
+	}
+	if cfg.discoveryPrefix != "" {
+		for topic, config := range cfg.discoveryConfigs() {
+			payload, _ := json.Marshal(config)
+			if err := conn.publish(topic, payload, true); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("✓ Connected to MQTT broker at %s\n", cfg.url)
+
+	done := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			switch msg.topic {
+			case commandTopic:
+				go runMQTTCommand(parseMQTTCommand(msg.payload))
+			case recordTopic:
+				switch strings.ToUpper(strings.TrimSpace(string(msg.payload))) {
+				case "ON":
+					go runMQTTCommand(RemoteCommand{Action: "start"})
+				case "OFF":
+					go runMQTTCommand(RemoteCommand{Action: "stop"})
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+	lastAlert := latestAlertID()
+	for {
+		state, changed := streamDeckState()
+		payload := "idle"
+		if state.IsRecording {
+			payload = "recording"
+		}
+		if err := conn.publish(cfg.topicFor("state"), []byte(payload), true); err != nil {
+			return err
+		}
+		if err := cfg.publishStatus(conn); err != nil {
+			return err
+		}
+		pending, alerted := alertsSince(lastAlert)
+		for _, a := range pending {
+			payload, _ := json.Marshal(a)
+			if err := conn.publish(cfg.topicFor("alert"), payload, false); err != nil {
+				return err
+			}
+			lastAlert = a.ID
+		}
+		select {
+		case err := <-done:
+			return err
+		case <-changed:
+		case <-alerted:
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishStatus publishes the current levels and free disk space.
+func (cfg *mqttConfig) publishStatus(conn *mqttConn) error {
+	recordingMutex.Lock()
+	var stats []DeviceStats
+	if activeSession != nil {
+		stats = deviceStats(activeSession.captures)
+	}
+	recordingMutex.Unlock()
+
+	levels := MQTTLevels{PeakDBFS: minLevelDBFS, Devices: []DeviceLevel{}}
+	for _, s := range stats {
+		levels.Devices = append(levels.Devices, DeviceLevel{Name: s.Name, LevelDBFS: s.LevelDBFS, Muted: s.Muted})
+		levels.PeakDBFS = max(levels.PeakDBFS, s.LevelDBFS)
+	}
+	payload, _ := json.Marshal(levels)
+	if err := conn.publish(cfg.topicFor("levels"), payload, false); err != nil {
+		return err
+	}
+
+	free, err := diskFree(outputDirectory)
+	if err != nil {
+		return nil
+	}
+	payload, _ = json.Marshal(map[string]uint64{"freeBytes": free})
+	return conn.publish(cfg.topicFor("disk"), payload, false)
+}
+
+// discoveryConfigs returns the Home Assistant discovery messages, keyed by
+// topic: a recording sensor and switch, a marker button, and level and disk
+// space sensors, grouped under one device.
+func (cfg *mqttConfig) discoveryConfigs() map[string]map[string]interface{} {
+	node := strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(cfg.topic)
+	device := map[string]interface{}{
+		"identifiers":  []string{"skribbl_capture_" + node},
+		"name":         "Skribbl Capture",
+		"manufacturer": "Skribbl",
+	}
+	entity := func(component, object string, fields map[string]interface{}) (string, map[string]interface{}) {
+		fields["unique_id"] = node + "_" + object
+		fields["availability_topic"] = cfg.topicFor("availability")
+		fields["device"] = device
+		return fmt.Sprintf("%s/%s/%s/%s/config", cfg.discoveryPrefix, component, node, object), fields
+	}
+
+	configs := map[string]map[string]interface{}{}
+	add := func(topic string, fields map[string]interface{}) { configs[topic] = fields }
+	add(entity("binary_sensor", "recording", map[string]interface{}{
+		"name":        "Recording",
+		"state_topic": cfg.topicFor("state"),
+		"payload_on":  "recording",
+		"payload_off": "idle",
+		"icon":        "mdi:record-rec",
+	}))
+	add(entity("switch", "record", map[string]interface{}{
+		"name":          "Record",
+		"state_topic":   cfg.topicFor("state"),
+		"command_topic": cfg.topicFor("record/set"),
+		"state_on":      "recording",
+		"state_off":     "idle",
+		"payload_on":    "ON",
+		"payload_off":   "OFF",
+		"icon":          "mdi:microphone",
+	}))
+	add(entity("button", "marker", map[string]interface{}{
+		"name":          "Add marker",
+		"command_topic": cfg.topicFor("command"),
+		"payload_press": "marker",
+		"icon":          "mdi:map-marker",
+	}))
+	add(entity("sensor", "level", map[string]interface{}{
+		"name":                "Level",
+		"state_topic":         cfg.topicFor("levels"),
+		"value_template":      "{{ value_json.peakDbfs }}",
+		"unit_of_measurement": "dB",
+		"state_class":         "measurement",
+		"icon":                "mdi:volume-high",
+	}))
+	add(entity("sensor", "disk_free", map[string]interface{}{
+		"name":                "Disk free",
+		"state_topic":         cfg.topicFor("disk"),
+		"value_template":      "{{ (value_json.freeBytes / 1000000000) | round(1) }}",
+		"unit_of_measurement": "GB",
+		"device_class":        "data_size",
+		"state_class":         "measurement",
+	}))
+	return configs
+}
+
+// parseMQTTCommand accepts either a bare action such as "toggle" or a JSON
+// RemoteCommand.
+func parseMQTTCommand(payload []byte) RemoteCommand {
+	var cmd RemoteCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		cmd = RemoteCommand{Action: strings.TrimSpace(string(payload))}
+	}
+	return cmd
+}
+
+// runMQTTCommand runs a command received over MQTT and reports the outcome.
+func runMQTTCommand(cmd RemoteCommand) {
+	result := runCommand(context.Background(), cmd)
+	if result.Status >= 400 {
+		fmt.Printf("⚠️  MQTT command %s failed: %s\n", cmd.Action, result.Response)
+		return
+	}
+	fmt.Printf("🎛️  MQTT command %s\n", cmd.Action)
+}
+
+// dialMQTT connects to the broker and completes the CONNECT handshake, with a
+// retained "offline" last will on the availability topic.
+func dialMQTT(cfg *mqttConfig, keepAlive time.Duration) (*mqttConn, error) {
+	u, err := url.Parse(cfg.url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return nil, fmt.Errorf("unsupported MQTT scheme %q (only tcp:// is supported)", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1883")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, mqttDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttConn{conn: conn, br: bufio.NewReader(conn)}
+
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, will, retained will
+	var payload []byte
+	payload = appendMQTTString(payload, "skribbl-capture-"+strings.ReplaceAll(cfg.topic, "/", "-"))
+	payload = appendMQTTString(payload, cfg.topicFor("availability"))
+	payload = appendMQTTString(payload, "offline")
+	if cfg.user != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, cfg.user)
+		if cfg.password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, cfg.password)
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(min(keepAlive/time.Second, 0xFFFF)))
+	body = append(body, payload...)
+
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if err := c.writePacket(mqttConnect, body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	kind, ack, err := c.readPacket()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if kind&0xF0 != mqttConnAck || len(ack) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected reply to MQTT connect")
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused connection: %s", mqttConnectError(ack[1]))
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// mqttConnectError describes a CONNACK return code.
+func mqttConnectError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// appendMQTTString appends a length-prefixed UTF-8 string.
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// writePacket sends one packet with its fixed header.
+func (c *mqttConn) writePacket(kind byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	packet := []byte{kind}
+	// Remaining length is a base-128 varint
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// readPacket reads one packet, returning its first header byte and body.
+func (c *mqttConn) readPacket() (byte, []byte, error) {
+	kind, err := c.br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := c.br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		}
+	}
+	if length > mqttMaxPacketBytes {
+		return 0, nil, fmt.Errorf("MQTT packet too large: %d bytes", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.br, body); err != nil {
+		return 0, nil, err
+	}
+	return kind, body, nil
+}
+
+// readMessage returns the next PUBLISH, acknowledging QoS 1 deliveries and
+// skipping acknowledgements and ping responses.
+func (c *mqttConn) readMessage() (mqttMessage, error) {
+	for {
+		kind, body, err := c.readPacket()
+		if err != nil {
+			return mqttMessage{}, err
+		}
+		switch kind & 0xF0 {
+		case mqttPublish:
+			if len(body) < 2 {
+				return mqttMessage{}, errors.New("malformed MQTT publish")
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+n {
+				return mqttMessage{}, errors.New("malformed MQTT publish")
+			}
+			msg := mqttMessage{topic: string(body[2 : 2+n])}
+			rest := body[2+n:]
+			if qos := (kind >> 1) & 0x03; qos > 0 {
+				if len(rest) < 2 {
+					return mqttMessage{}, errors.New("malformed MQTT publish")
+				}
+				if qos == 1 {
+					c.writePacket(mqttPubAck, rest[:2])
+				}
+				rest = rest[2:]
+			}
+			msg.payload = rest
+			return msg, nil
+		case mqttSubAck:
+			if len(body) > 2 && body[2] == 0x80 {
+				return mqttMessage{}, errors.New("MQTT broker refused the command subscription")
+			}
+		case mqttPingResp, mqttPubAck:
+		default:
+			return mqttMessage{}, fmt.Errorf("unexpected MQTT packet type %d", kind>>4)
+		}
+	}
+}
+
+// subscribe asks for QoS 0 delivery of the given topics.
+func (c *mqttConn) subscribe(packetID uint16, topics ...string) error {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	for _, topic := range topics {
+		body = appendMQTTString(body, topic)
+		body = append(body, 0)
+	}
+	return c.writePacket(mqttSubscribe, body)
+}
+
+// publish sends a QoS 0 message.
+func (c *mqttConn) publish(topic string, payload []byte, retain bool) error {
+	kind := byte(mqttPublish)
+	if retain {
+		kind |= 0x01
+	}
+	return c.writePacket(kind, append(appendMQTTString(nil, topic), payload...))
+}
+
+// close disconnects cleanly, so the broker doesn't send the last will.
+func (c *mqttConn) close() {
+	c.writePacket(mqttDisconnect, nil)
+	c.conn.Close()
+}
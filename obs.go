@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// obsReconnectDelay is how long to wait before reconnecting to OBS.
+	obsReconnectDelay = 5 * time.Second
+
+	// obsDialTimeout bounds connecting and the opening handshake.
+	obsDialTimeout = 5 * time.Second
+
+	// obs-websocket v5 opcodes and the event subscription for output changes
+	obsOpHello           = 0
+	obsOpIdentify        = 1
+	obsOpIdentified      = 2
+	obsOpEvent           = 5
+	obsOpRequest         = 6
+	obsOpRequestResponse = 7
+	obsSubscribeOutputs  = 1 << 6
+
+	obsOutputStarted = "OBS_WEBSOCKET_OUTPUT_STARTED"
+	obsOutputStopped = "OBS_WEBSOCKET_OUTPUT_STOPPED"
+)
+
+// obsConfig holds the OBS integration flags.
+type obsConfig struct {
+	url      string
+	password string
+	follow   string
+	preset   string
+}
+
+// OBSSync records how a session lines up with the OBS output it followed
+type OBSSync struct {
+	Output     string `json:"output"` // "record" or "stream"
+	OutputPath string `json:"outputPath,omitempty"`
+
+	// AudioOffsetSeconds is how long after the OBS output started the audio
+	// starts. Place the audio this far into the video timeline.
+	AudioOffsetSeconds float64 `json:"audioOffsetSeconds"`
+}
+
+// obsMessage is the envelope of every obs-websocket message
+type obsMessage struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+// addOBSFlags registers the OBS integration flags.
+func addOBSFlags(fs *flag.FlagSet) *obsConfig {
+	cfg := &obsConfig{}
+	fs.StringVar(&cfg.url, "obs", "", "obs-websocket URL to follow, e.g. ws://localhost:4455 (disabled if empty)")
+	fs.StringVar(&cfg.password, "obs-password", "", "obs-websocket password")
+	fs.StringVar(&cfg.follow, "obs-follow", "record", `OBS output to follow: "record", "stream" or "both"`)
+	fs.StringVar(&cfg.preset, "obs-preset", "", "preset to record when OBS starts (default: the last devices recorded)")
+	return cfg
+}
+
+// obsAuth answers an obs-websocket authentication challenge.
+func obsAuth(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// followOBS keeps a connection to OBS open for the life of the process,
+// reconnecting whenever it drops.
+func followOBS(cfg *obsConfig) {
+	for {
+		err := cfg.run()
+		fmt.Printf("⚠️  OBS connection lost: %v (retrying in %s)\n", err, obsReconnectDelay)
+		time.Sleep(obsReconnectDelay)
+	}
+}
+
+// run connects to OBS and mirrors its output state until the connection drops.
+func (cfg *obsConfig) run() error {
+	conn, err := dialWebSocket(cfg.url, obsDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err := cfg.identify(conn); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Connected to OBS at %s\n", cfg.url)
+
+	// Sessions this connection started, so only those are stopped with OBS
+	started := map[string]string{}
+
+	for {
+		data, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+		var msg obsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Op {
+		case obsOpEvent:
+			var event struct {
+				EventType string `json:"eventType"`
+				EventData struct {
+					OutputState string `json:"outputState"`
+					OutputPath  string `json:"outputPath"`
+				} `json:"eventData"`
+			}
+			if err := json.Unmarshal(msg.D, &event); err != nil {
+				continue
+			}
+			output := ""
+			switch event.EventType {
+			case "RecordStateChanged":
+				output = "record"
+			case "StreamStateChanged":
+				output = "stream"
+			}
+			if output == "" || (cfg.follow != "both" && cfg.follow != output) {
+				continue
+			}
+
+			switch event.EventData.OutputState {
+			case obsOutputStarted:
+				sessionID, ok := cfg.startSession(output, event.EventData.OutputPath)
+				if !ok {
+					continue
+				}
+				started[output] = sessionID
+				// Ask how long the output has been running to work out the offset
+				request := fmt.Sprintf(`{"op":%d,"d":{"requestType":%q,"requestId":%q}}`,
+					obsOpRequest, obsStatusRequest(output), sessionID)
+				conn.writeText([]byte(request))
+			case obsOutputStopped:
+				if sessionID, ok := started[output]; ok {
+					delete(started, output)
+					cfg.stopSession(sessionID, event.EventData.OutputPath)
+				}
+			}
+
+		case obsOpRequestResponse:
+			var response struct {
+				RequestID     string `json:"requestId"`
+				RequestStatus struct {
+					Result bool `json:"result"`
+				} `json:"requestStatus"`
+				ResponseData struct {
+					OutputDuration float64 `json:"outputDuration"`
+				} `json:"responseData"`
+			}
+			if err := json.Unmarshal(msg.D, &response); err != nil || !response.RequestStatus.Result {
+				continue
+			}
+			videoStart := time.Now().Add(-time.Duration(response.ResponseData.OutputDuration) * time.Millisecond)
+			recordOBSOffset(response.RequestID, videoStart)
+		}
+	}
+}
+
+// obsStatusRequest names the request that reports an output's duration.
+func obsStatusRequest(output string) string {
+	if output == "stream" {
+		return "GetStreamStatus"
+	}
+	return "GetRecordStatus"
+}
+
+// identify performs the obs-websocket Hello/Identify exchange.
+func (cfg *obsConfig) identify(conn *wsConn) error {
+	data, err := conn.readMessage()
+	if err != nil {
+		return err
+	}
+	var hello struct {
+		Op int `json:"op"`
+		D  struct {
+			Authentication *struct {
+				Challenge string `json:"challenge"`
+				Salt      string `json:"salt"`
+			} `json:"authentication"`
+		} `json:"d"`
+	}
+	if err := json.Unmarshal(data, &hello); err != nil || hello.Op != obsOpHello {
+		return fmt.Errorf("unexpected greeting from OBS")
+	}
+
+	identify := map[string]interface{}{
+		"rpcVersion":         1,
+		"eventSubscriptions": obsSubscribeOutputs,
+	}
+	if auth := hello.D.Authentication; auth != nil {
+		if cfg.password == "" {
+			return fmt.Errorf("OBS requires a password (-obs-password)")
+		}
+		identify["authentication"] = obsAuth(cfg.password, auth.Salt, auth.Challenge)
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"op": obsOpIdentify, "d": identify})
+	if err := conn.writeText(payload); err != nil {
+		return err
+	}
+
+	data, err = conn.readMessage()
+	if err != nil {
+		return fmt.Errorf("OBS rejected identification: %v", err)
+	}
+	var identified obsMessage
+	if err := json.Unmarshal(data, &identified); err != nil || identified.Op != obsOpIdentified {
+		return fmt.Errorf("OBS rejected identification")
+	}
+	return nil
+}
+
+// startSession starts recording alongside an OBS output. It leaves an
+// existing recording alone, since that wasn't started for OBS.
+func (cfg *obsConfig) startSession(output, outputPath string) (string, bool) {
+	result := runCommand(context.Background(), RemoteCommand{Action: "start", Preset: cfg.preset})
+	if result.Status != http.StatusOK {
+		fmt.Printf("⚠️  OBS started %sing but audio capture didn't start: %s\n", output, result.Response)
+		return "", false
+	}
+
+	var started struct {
+		SessionID string `json:"sessionId"`
+	}
+	json.Unmarshal(result.Response, &started)
+
+	recordingMutex.Lock()
+	if activeSession != nil && activeSession.id == started.SessionID {
+		activeSession.obs = &OBSSync{Output: output, OutputPath: outputPath}
+	}
+	recordingMutex.Unlock()
+
+	fmt.Printf("🎙️  OBS started %sing, recording session %s\n", output, started.SessionID)
+	return started.SessionID, true
+}
+
+// stopSession stops the session started for an OBS output.
+func (cfg *obsConfig) stopSession(sessionID, outputPath string) {
+	recordingMutex.Lock()
+	if activeSession == nil || activeSession.id != sessionID {
+		recordingMutex.Unlock()
+		return
+	}
+	if activeSession.obs != nil && outputPath != "" {
+		activeSession.obs.OutputPath = outputPath
+	}
+	recordingMutex.Unlock()
+
+	status, response := callAPI(context.Background(), handleFinalizeSession, http.MethodPost, nil, map[string]string{"id": sessionID})
+	if status != http.StatusOK {
+		fmt.Printf("⚠️  Failed to stop session %s with OBS: %s\n", sessionID, response)
+		return
+	}
+	fmt.Printf("✓ OBS stopped, session %s saved\n", sessionID)
+}
+
+// recordOBSOffset notes how far into the OBS output a session's audio starts.
+func recordOBSOffset(sessionID string, videoStart time.Time) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if activeSession == nil || activeSession.id != sessionID || activeSession.obs == nil {
+		return
+	}
+	activeSession.obs.AudioOffsetSeconds = activeSession.startedAt.Sub(videoStart).Seconds()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// plainOutput makes the CLI print steady lines without emoji or decoration,
+// which screen readers read cleanly. Set with -plain.
+var plainOutput bool
+
+// plainProgressInterval is how often a recording is reported on with -plain,
+// far enough apart for a screen reader to finish one report before the next.
+const plainProgressInterval = 30 * time.Second
+
+// How much the CLI's commands print, from -quiet, -verbose and -json
+const (
+	outputNormal  = "normal"
+	outputQuiet   = "quiet"   // only warnings and errors
+	outputVerbose = "verbose" // details such as device IDs and formats too
+	outputJSON    = "json"    // only the result, as JSON, on standard output
+)
+
+var outputMode = outputNormal
+
+func addOutputFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&plainOutput, "plain", false, "print plain lines without emoji or decoration, and report on recordings every 30s, for screen readers")
+	for _, mode := range []struct{ name, usage string }{
+		{outputQuiet, "print only warnings and errors"},
+		{outputVerbose, "print details such as device IDs and formats too"},
+		{outputJSON, "print only the result, as JSON on standard output, with warnings and prompts on standard error"},
+	} {
+		fs.BoolFunc(mode.name, mode.usage, func(value string) error {
+			on, err := strconv.ParseBool(value)
+			if on {
+				outputMode = mode.name
+			} else if outputMode == mode.name {
+				outputMode = outputNormal
+			}
+			return err
+		})
+	}
+}
+
+// plainText takes the emoji and decoration out of a message for -plain.
+func plainText(msg string) string {
+	if !plainOutput {
+		return msg
+	}
+	return strings.NewReplacer(
+		"✓ ", "",
+		"✗ ", tr(uiLang, "Error: "),
+		"⚠️  ", tr(uiLang, "Warning: "),
+		"🎙️  ", "",
+		" → ", ": ",
+		"=== ", "",
+		" ===", "",
+	).Replace(msg)
+}
+
+// uiPrintf prints a message to the person at the terminal, translated and,
+// with -plain, undecorated. -quiet and -json leave it out.
+func uiPrintf(format string, args ...interface{}) {
+	if outputMode == outputQuiet || outputMode == outputJSON {
+		return
+	}
+	fmt.Print(plainText(trf(uiLang, format, args...)))
+}
+
+// uiPrintln prints a line to the person at the terminal, like uiPrintf.
+func uiPrintln(msg string) {
+	uiPrintf("%s\n", tr(uiLang, msg))
+}
+
+// uiVerbosef prints a detail only -verbose asks for.
+func uiVerbosef(format string, args ...interface{}) {
+	if outputMode == outputVerbose {
+		fmt.Print(plainText(trf(uiLang, format, args...)))
+	}
+}
+
+// uiWarnf prints a warning or error, which is never left out. With -json it
+// goes to standard error, keeping standard output to the result.
+func uiWarnf(format string, args ...interface{}) {
+	out := os.Stdout
+	if outputMode == outputJSON {
+		out = os.Stderr
+	}
+	fmt.Fprint(out, plainText(trf(uiLang, format, args...)))
+}
+
+// uiPrompt asks the person at the terminal for something. It's always shown,
+// on standard error when -quiet or -json keep standard output for scripts.
+func uiPrompt(msg string) {
+	fmt.Fprintln(promptOutput(), plainText(tr(uiLang, msg)))
+}
+
+// promptOutput is where the person at the terminal is asked things.
+func promptOutput() *os.File {
+	if outputMode == outputQuiet || outputMode == outputJSON {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printResult prints what a command did as JSON, with -json.
+func printResult(result interface{}) {
+	if outputMode != outputJSON {
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
+
+// statusInterval is how often the status line is redrawn while the CLI
+// records.
+const statusInterval = time.Second
+
+// reportProgress reports on captures until stop is closed: with -plain, a
+// line every plainProgressInterval saying how long they've been recording
+// and how much each has written, and otherwise, in a terminal, a status line
+// redrawn in place with each one's peak level too. -quiet and -json leave it
+// out.
+func reportProgress(captures []*captureDevice, stop <-chan struct{}) {
+	if outputMode == outputQuiet || outputMode == outputJSON {
+		return
+	}
+	if !plainOutput && !isTerminal(os.Stdout) {
+		// Redrawing in place needs a terminal; a log would get every frame
+		return
+	}
+	interval := statusInterval
+	if plainOutput {
+		interval = plainProgressInterval
+	}
+	started := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	width := 0
+	for {
+		select {
+		case <-stop:
+			// Enter, or the message saying it's stopped, starts a new line
+			// below the last status
+			return
+		case <-ticker.C:
+		}
+		elapsed := time.Since(started).Round(time.Second)
+		if plainOutput {
+			written := make([]string, len(captures))
+			for i, cap := range captures {
+				written[i] = fmt.Sprintf("%s %.1f MB", cap.name, float64(cap.totalBytesWritten.Load())/1e6)
+			}
+			uiPrintf("Recording: %d min %d sec, %s\n", int(elapsed.Minutes()), int(elapsed.Seconds())%60, strings.Join(written, ", "))
+			continue
+		}
+
+		line := "⏺ " + formatElapsed(elapsed)
+		for _, cap := range captures {
+			line += fmt.Sprintf(" | %s %.1f MB %s %.0f dB", cap.name, float64(cap.totalBytesWritten.Load())/1e6, tr(uiLang, "peak"), cap.levelDBFS())
+		}
+		// Spaces rather than an escape code clear what's left of a longer
+		// line, which every console understands
+		n := utf8.RuneCountInString(line)
+		fmt.Printf("\r%s%s", line, strings.Repeat(" ", max(width-n, 0)))
+		width = max(width, n)
+	}
+}
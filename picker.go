@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// pickerHeight is how many devices the picker shows at once; longer lists
+// scroll.
+const pickerHeight = 10
+
+// errPickerCancelled is returned when the picker is left with Ctrl+C.
+var errPickerCancelled = errors.New("cancelled")
+
+// canPick reports whether the device picker can be used: when the person at
+// the terminal is being asked, rather than a script, and isn't using -plain,
+// whose numbered prompt screen readers follow better.
+func canPick() bool {
+	return !plainOutput && isTerminal(os.Stdin) && isTerminal(promptOutput())
+}
+
+// fuzzyMatch reports whether every character of filter appears in name, in
+// order, ignoring case, so "usbmic" finds "USB Microphone".
+func fuzzyMatch(name, filter string) bool {
+	name = strings.ToLower(name)
+	for _, r := range strings.ToLower(filter) {
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(string(r)):]
+	}
+	return true
+}
+
+// devicePicker is the state of the interactive device list
+type devicePicker struct {
+	labels   []string
+	selected []bool
+	filter   string
+	visible  []int // indices into labels matching the filter
+	cursor   int   // position in visible
+	top      int   // first position in visible shown
+	drawn    int   // lines drawn last time, to draw over
+}
+
+func (p *devicePicker) applyFilter() {
+	p.visible = p.visible[:0]
+	for i, label := range p.labels {
+		if fuzzyMatch(label, p.filter) {
+			p.visible = append(p.visible, i)
+		}
+	}
+	p.cursor, p.top = 0, 0
+}
+
+func (p *devicePicker) move(by int) {
+	if len(p.visible) == 0 {
+		return
+	}
+	p.cursor = min(max(p.cursor+by, 0), len(p.visible)-1)
+	if p.cursor < p.top {
+		p.top = p.cursor
+	} else if p.cursor >= p.top+pickerHeight {
+		p.top = p.cursor - pickerHeight + 1
+	}
+}
+
+// draw redraws the picker over what it drew last time.
+func (p *devicePicker) draw(out io.Writer) {
+	var b strings.Builder
+	if p.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawn)
+	}
+	b.WriteString("\r\x1b[J")
+	fmt.Fprintf(&b, "%s %s\r\n", tr(uiLang, "Filter:"), p.filter)
+	lines := 1
+	for pos := p.top; pos < len(p.visible) && pos < p.top+pickerHeight; pos++ {
+		i := p.visible[pos]
+		pointer, box := "  ", "[ ]"
+		if pos == p.cursor {
+			pointer = "> "
+		}
+		if p.selected[i] {
+			box = "[x]"
+		}
+		fmt.Fprintf(&b, "%s%s %s\r\n", pointer, box, p.labels[i])
+		lines++
+	}
+	if len(p.visible) == 0 {
+		fmt.Fprintf(&b, "  %s\r\n", tr(uiLang, "(no devices match)"))
+		lines++
+	}
+	fmt.Fprintf(&b, "%s\r\n", tr(uiLang, "↑/↓ move, Space select, type to filter, Enter record, Ctrl+C quit"))
+	lines++
+	io.WriteString(out, b.String())
+	p.drawn = lines
+}
+
+// clear takes the picker off the screen.
+func (p *devicePicker) clear(out io.Writer) {
+	if p.drawn > 0 {
+		fmt.Fprintf(out, "\x1b[%dA\r\x1b[J", p.drawn)
+	}
+}
+
+// pickDevices shows labels in a list to pick from with the arrow keys and
+// Space, narrowed down by typing, and returns the indices picked when Enter
+// is pressed, or the highlighted one if none were. The preselected ones start
+// out picked. It needs a terminal.
+func pickDevices(reader *bufio.Reader, labels []string, preselected []int) ([]int, error) {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	out := promptOutput()
+	p := &devicePicker{labels: labels, selected: make([]bool, len(labels))}
+	for _, i := range preselected {
+		p.selected[i] = true
+	}
+	p.applyFilter()
+	defer p.clear(out)
+	for {
+		p.draw(out)
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return nil, err
+		}
+		switch r {
+		case 3: // Ctrl+C
+			return nil, errPickerCancelled
+		case '\r', '\n':
+			var picked []int
+			for i, on := range p.selected {
+				if on {
+					picked = append(picked, i)
+				}
+			}
+			if len(picked) == 0 && len(p.visible) > 0 {
+				picked = []int{p.visible[p.cursor]}
+			}
+			if len(picked) > 0 {
+				return picked, nil
+			}
+		case ' ':
+			if len(p.visible) > 0 {
+				i := p.visible[p.cursor]
+				p.selected[i] = !p.selected[i]
+			}
+		case 127, 8: // Backspace
+			if p.filter != "" {
+				filter := []rune(p.filter)
+				p.filter = string(filter[:len(filter)-1])
+				p.applyFilter()
+			}
+		case 21: // Ctrl+U
+			p.filter = ""
+			p.applyFilter()
+		case '\x1b':
+			// Arrow keys arrive as ESC [ A and so on, or ESC O A
+			if next, _ := reader.ReadByte(); next != '[' && next != 'O' {
+				continue
+			}
+			switch key, _ := reader.ReadByte(); key {
+			case 'A':
+				p.move(-1)
+			case 'B':
+				p.move(1)
+			case '5', '6': // Page Up and Down, ESC [ 5 ~
+				reader.ReadByte()
+				if key == '5' {
+					p.move(-pickerHeight)
+				} else {
+					p.move(pickerHeight)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				p.filter += string(r)
+				p.applyFilter()
+			}
+		}
+	}
+}
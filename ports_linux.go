@@ -0,0 +1,105 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// portListTimeout bounds listing PipeWire's ports.
+	portListTimeout = 2 * time.Second
+
+	// portLinkTimeout is how long to wait for a new pw-record node to
+	// appear so the port can be linked to it.
+	portLinkTimeout = 3 * time.Second
+)
+
+// portNodes numbers the pw-record nodes this process creates.
+var portNodes atomic.Int64
+
+// listPortSources lists PipeWire's output ports, which include those of JACK
+// clients running on PipeWire, so a single application's output can be
+// recorded on its own. None are listed without pw-link.
+func listPortSources() []selectableDevice {
+	if _, err := exec.LookPath("pw-link"); err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), portListTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "pw-link", "--output").Output()
+	if err != nil {
+		fmt.Printf("⚠️  Failed to list PipeWire ports: %v\n", err)
+		return nil
+	}
+
+	var ports []selectableDevice
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			ports = append(ports, selectableDevice{port: line})
+		}
+	}
+	return ports
+}
+
+// recordPort records the capture's PipeWire port by starting pw-record as a
+// capture stream that isn't connected to anything, writing raw PCM to
+// stdout, and linking the port to it.
+func (c *captureDevice) recordPort() error {
+	node := fmt.Sprintf("skribbl-capture-%d-%d", os.Getpid(), portNodes.Add(1))
+	cmd := exec.Command("pw-record",
+		"--rate", strconv.Itoa(int(c.sampleRate)),
+		"--channels", strconv.Itoa(int(c.channels)),
+		"--channel-map", "mono",
+		"--format", "s16",
+		"--properties", fmt.Sprintf("{ node.name = %q node.autoconnect = false }", node),
+		"-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start pw-record: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.readPCM(stdout)
+	}()
+	stop := func() {
+		cmd.Process.Kill()
+		<-done
+		cmd.Wait()
+	}
+
+	// The node shows up a moment after pw-record starts
+	var linkErr error
+	for deadline := time.Now().Add(portLinkTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var output []byte
+		output, linkErr = exec.Command("pw-link", c.source.port, node+":input_MONO").CombinedOutput()
+		if linkErr == nil {
+			c.stopSource = stop
+			return nil
+		}
+		linkErr = fmt.Errorf("%v: %s", linkErr, strings.TrimSpace(string(output)))
+		select {
+		case <-done:
+			stop()
+			return fmt.Errorf("pw-record exited: %s", strings.TrimSpace(stderr.String()))
+		default:
+		}
+	}
+	stop()
+	return fmt.Errorf("failed to link the port: %w", linkErr)
+}
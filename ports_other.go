@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// listPortSources lists nothing: port sources need PipeWire, on Linux.
+func listPortSources() []selectableDevice {
+	return nil
+}
+
+// recordPort isn't available without PipeWire.
+func (c *captureDevice) recordPort() error {
+	return fmt.Errorf("port sources need PipeWire, on Linux")
+}
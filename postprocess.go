@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// normalizeTargetDBFS is the peak level normalize jobs bring tracks to.
+	normalizeTargetDBFS = -1.0
+
+	// peaksPerPixel is how many frames each point of a waveform covers,
+	// peaks.js's default zoom.
+	peaksPerPixel = 512
+)
+
+// transcodeFormat is a format transcode jobs convert to with ffmpeg
+type transcodeFormat struct {
+	ext  string
+	args []string
+}
+
+// transcodeFormats are the formats transcode jobs offer, by name
+var transcodeFormats = map[string]transcodeFormat{
+	"flac": {".flac", []string{"-c:a", "flac"}},
+	"mp3":  {".mp3", []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+	"opus": {".opus", []string{"-c:a", "libopus", "-b:a", "96k"}},
+}
+
+// jobTracks lists the tracks a job works on.
+func jobTracks(job Job) ([]TrackInfo, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	var tracks []TrackInfo
+	for _, track := range manifest.Tracks {
+		if job.File == "" || track.File == job.File {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks, nil
+}
+
+// updateManifest changes a finished session's metadata and saves it.
+func updateManifest(id string, change func(*SessionManifest)) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		return fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	change(manifest)
+	return writeSessionManifest(manifest)
+}
+
+// addExports lists files made from a session's tracks in its metadata, then
+// lets the watcher see them.
+func addExports(id string, exports []ExportFile, releases []func()) ([]string, error) {
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	var names []string
+	for _, e := range exports {
+		names = append(names, e.File)
+	}
+	err := updateManifest(id, func(m *SessionManifest) { m.Exports = append(m.Exports, exports...) })
+	if err != nil {
+		for _, name := range names {
+			os.Remove(filepath.Join(outputDirectory, name))
+		}
+		return nil, err
+	}
+	return names, nil
+}
+
+// trackBase is a track's file name without its extension.
+func trackBase(track TrackInfo) string {
+	return strings.TrimSuffix(track.File, filepath.Ext(track.File))
+}
+
+// progressReader reports how far through total bytes its reads have got.
+type progressReader struct {
+	ctx    context.Context
+	r      io.Reader
+	read   int64
+	total  int64
+	report func(float64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.total > 0 {
+		p.report(float64(p.read) / float64(p.total))
+	}
+	return n, err
+}
+
+// openTrackPCM opens a track as 16-bit PCM, reading through a
+// progressReader that reports its share of a job's progress: the part from
+// done to done+share.
+func openTrackPCM(ctx context.Context, name string, progress func(float64), done, share float64) (*os.File, AudioInfo, io.Reader, error) {
+	f, err := openRecording(name)
+	if err != nil {
+		return nil, AudioInfo{}, nil, err
+	}
+	info, err := probeAudio(f)
+	if err != nil {
+		f.Close()
+		return nil, AudioInfo{}, nil, err
+	}
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		f.Close()
+		return nil, AudioInfo{}, nil, err
+	}
+	total := int64(info.DurationSeconds * float64(info.SampleRate*info.Channels*2))
+	return f, info, &progressReader{ctx: ctx, r: pcm, total: total, report: func(p float64) { progress(done + p*share) }}, nil
+}
+
+// runTranscode converts tracks to another format with ffmpeg.
+func runTranscode(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	format := transcodeFormats[job.Format]
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	for i, track := range tracks {
+		in, err := openRecording(track.File)
+		if err != nil {
+			return fail(err)
+		}
+		in.Close()
+		name, out, release, err := createExportFile(trackBase(track), format.ext)
+		if err != nil {
+			return fail(err)
+		}
+		out.Close()
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobTranscode, File: name, Device: track.Device, Source: track.File})
+
+		err = ffmpegTranscode(ctx, in.Name(), filepath.Join(outputDirectory, name), format, track, func(p float64) {
+			progress((float64(i) + p) / float64(len(tracks)))
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return addExports(job.SessionID, exports, releases)
+}
+
+// ffmpegTranscode converts the track at in to format at out, following its
+// progress through -progress.
+func ffmpegTranscode(ctx context.Context, in, out string, format transcodeFormat, track TrackInfo, progress func(float64)) (err error) {
+	_, s := startSpan(ctx, "ffmpeg.transcode", spanKindInternal)
+	s.setAttr("file.name", track.File)
+	s.setAttr("ffmpeg.output", filepath.Base(out))
+	defer func() { s.finish(err) }()
+
+	args := append([]string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-i", in}, format.args...)
+	args = append(args, "-progress", "pipe:1", out)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := startLowPriority(cmd); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// out_time_us is in microseconds, as is out_time_ms despite its name
+		value, ok := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if us, err := strconv.ParseFloat(value, 64); ok && err == nil && track.DurationSeconds > 0 {
+			progress(min(us/1e6/track.DurationSeconds, 1))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed on %s: %s", track.File, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// runNormalize copies tracks with their peaks brought to
+// normalizeTargetDBFS, measuring them in a first pass and applying the gain
+// in a second. Silent tracks are left alone.
+func runNormalize(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	share := 0.5 / float64(len(tracks))
+	for i, track := range tracks {
+		done := float64(i) / float64(len(tracks))
+		f, info, pcm, err := openTrackPCM(ctx, track.File, progress, done, share)
+		if err != nil {
+			return fail(err)
+		}
+		levels, err := meterPCM(pcm, info)
+		f.Close()
+		if err != nil {
+			return fail(err)
+		}
+		peak := levels.PeakDBFS
+		if peak <= minLevelDBFS {
+			continue
+		}
+
+		f, info, pcm, err = openTrackPCM(ctx, track.File, progress, done+share, share)
+		if err != nil {
+			return fail(err)
+		}
+		name, release, err := writeGainedTrack(trackBase(track)+"_normalized", info, pcm, dbToGain(normalizeTargetDBFS-peak))
+		f.Close()
+		if err != nil {
+			return fail(err)
+		}
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobNormalize, File: name, Device: track.Device, Source: track.File})
+	}
+	return addExports(job.SessionID, exports, releases)
+}
+
+// writeGainedTrack writes pcm, scaled by gain, to a new WAV file named after
+// base.
+func writeGainedTrack(base string, info AudioInfo, pcm io.Reader, gain float64) (string, func(), error) {
+	name, out, release, err := createExportFile(base, ".wav")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := writeGainedWAV(out, info, pcm, gain); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		release()
+		return "", nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		release()
+		return "", nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return name, release, nil
+}
+
+// writeGainedWAV writes pcm, scaled by gain, to out as a 16-bit WAV file,
+// and returns how many samples clipped.
+func writeGainedWAV(out *os.File, info AudioInfo, pcm io.Reader, gain float64) (int, error) {
+	if err := writeWAVHeader(out, info.SampleRate, info.Channels, 16, 0); err != nil {
+		return 0, err
+	}
+	var written uint32
+	clipped := 0
+	buf := make([]byte, 64*1024)
+	for {
+		n, readErr := io.ReadFull(pcm, buf)
+		n &^= 1
+		clipped += applyGain(buf[:n], gain)
+		if _, err := out.Write(buf[:n]); err != nil {
+			return clipped, err
+		}
+		written += uint32(n)
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return clipped, readErr
+		}
+	}
+
+	// Go back and fill in the size, as when finishing a recording
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return clipped, err
+	}
+	return clipped, writeWAVHeader(out, info.SampleRate, info.Channels, 16, written)
+}
+
+// Waveform is a track's waveform in the JSON format of BBC's audiowaveform,
+// which peaks.js and wavesurfer.js draw: the lowest and highest 8-bit sample
+// of every channel across each stretch of peaksPerPixel frames, in pairs
+type Waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      uint32 `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// runPeaks saves each track's waveform as <track>.peaks.json, for editors
+// and the web UI to draw without reading the whole file.
+func runPeaks(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	for i, track := range tracks {
+		f, info, pcm, err := openTrackPCM(ctx, track.File, progress, float64(i)/float64(len(tracks)), 1/float64(len(tracks)))
+		if err != nil {
+			return fail(err)
+		}
+		waveform, err := readWaveform(pcm, info)
+		f.Close()
+		if err != nil {
+			return fail(err)
+		}
+
+		name, out, release, err := createExportFile(trackBase(track)+".peaks", ".json")
+		if err != nil {
+			return fail(err)
+		}
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobPeaks, File: name, Device: track.Device, Source: track.File})
+		err = json.NewEncoder(out).Encode(waveform)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to write %s: %w", name, err))
+		}
+	}
+	return addExports(job.SessionID, exports, releases)
+}
+
+// readWaveform reads a track's PCM into its waveform.
+func readWaveform(pcm io.Reader, info AudioInfo) (*Waveform, error) {
+	waveform := &Waveform{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      info.SampleRate,
+		SamplesPerPixel: peaksPerPixel,
+		Bits:            8,
+		Data:            []int8{},
+	}
+	frameBytes := int(info.Channels) * 2
+	reader := bufio.NewReaderSize(pcm, 64*1024)
+	frame := make([]byte, frameBytes)
+	lo, hi, frames := int16(0), int16(0), 0
+	for {
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		for c := 0; c < frameBytes; c += 2 {
+			v := int16(uint16(frame[c]) | uint16(frame[c+1])<<8)
+			if frames == 0 && c == 0 {
+				lo, hi = v, v
+			}
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		frames++
+		if frames == peaksPerPixel {
+			waveform.Data = append(waveform.Data, int8(lo>>8), int8(hi>>8))
+			frames = 0
+		}
+	}
+	if frames > 0 {
+		waveform.Data = append(waveform.Data, int8(lo>>8), int8(hi>>8))
+	}
+	waveform.Length = len(waveform.Data) / 2
+	return waveform, nil
+}
+
+// runTranscribe transcribes finished tracks through the -stt service, a
+// chunk at a time as the live transcript does, and saves the session's
+// transcript. Transcribing one track replaces just that track's segments.
+func runTranscribe(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var segments []TranscriptSegment
+	if job.File != "" {
+		existing, _ := readTranscript(job.SessionID)
+		for _, s := range existing {
+			if s.File != job.File {
+				segments = append(segments, s)
+			}
+		}
+	}
+
+	live := &liveTranscript{session: &session{id: job.SessionID}}
+	for i, track := range tracks {
+		f, info, pcm, err := openTrackPCM(ctx, track.File, progress, float64(i)/float64(len(tracks)), 1/float64(len(tracks)))
+		if err != nil {
+			return nil, err
+		}
+		t := &transcriber{
+			live:       live,
+			device:     track.Device,
+			speaker:    track.Speaker,
+			file:       track.File,
+			sampleRate: info.SampleRate,
+			channels:   info.Channels,
+		}
+		trackSegments, err := t.transcribeAll(pcm)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to transcribe %s: %w", track.File, err)
+		}
+		segments = append(segments, trackSegments...)
+	}
+
+	sortSegments(segments)
+	for i := range segments {
+		segments[i].SessionID = ""
+	}
+	if segments == nil {
+		segments = []TranscriptSegment{}
+	}
+	data, _ := json.MarshalIndent(segments, "", "  ")
+	if err := writeFileAtomic(transcriptPath(job.SessionID), append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write transcript: %w", err)
+	}
+	name := filepath.Base(transcriptPath(job.SessionID))
+	if err := updateManifest(job.SessionID, func(m *SessionManifest) { m.Transcript = name }); err != nil {
+		return nil, err
+	}
+	return []string{name}, nil
+}
+
+// transcribeAll transcribes a whole track, cutting chunks at quiet moments
+// as add does, and skipping silent ones.
+func (t *transcriber) transcribeAll(pcm io.Reader) ([]TranscriptSegment, error) {
+	chunkBytes := int(stt.chunk.Seconds()*float64(t.sampleRate)) * int(t.channels) * 2
+	var segments []TranscriptSegment
+	buf := make([]byte, chunkBytes)
+	for {
+		n, readErr := io.ReadFull(pcm, buf)
+		t.buf = append(t.buf, buf[:n&^1]...)
+		last := readErr == io.EOF || readErr == io.ErrUnexpectedEOF
+		if readErr != nil && !last {
+			return nil, readErr
+		}
+		for len(t.buf) >= chunkBytes || last && len(t.buf) > 0 {
+			cut := len(t.buf)
+			if cut >= chunkBytes {
+				cut = t.quietCut(chunkBytes)
+			}
+			job := transcriptJob{pcm: t.buf[:cut], start: t.bufStart}
+			t.bufStart += float64(cut) / t.bytesPerSecond()
+			if analyzeLevels(job.pcm, t.sampleRate, t.channels).PeakDBFS >= sttSilenceDBFS {
+				chunk, err := t.transcribe(job)
+				if err != nil {
+					return nil, err
+				}
+				segments = append(segments, chunk...)
+			}
+			t.buf = append([]byte(nil), t.buf[cut:]...)
+		}
+		if last {
+			return segments, nil
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+)
+
+// presetsFile is where named recording presets are read from. It's re-read on
+// every request, so edits take effect without a restart.
+var presetsFile = "presets.json"
+
+// Preset is a named set of devices that can be recorded with one request
+type Preset struct {
+	// Devices are matched by name, since indices change as hardware comes and goes
+	Devices    []string `json:"devices"`
+	BestEffort bool     `json:"bestEffort"`
+
+	// FallbackToDefault records the system default capture device in place
+	// of a named device that isn't connected, rather than failing
+	FallbackToDefault bool `json:"fallbackToDefault"`
+
+	// PreStart hooks run before recording starts, e.g. to set the input
+	// volume or launch a virtual cable, and PostStop hooks once the session
+	// is saved, e.g. to transcode or upload it
+	PreStart []Hook `json:"preStart,omitempty"`
+	PostStop []Hook `json:"postStop,omitempty"`
+
+	// Intro and Outro are audio files played before and after the mixdown
+	// of a session started from the preset, such as a podcast's theme
+	Intro string `json:"intro,omitempty"`
+	Outro string `json:"outro,omitempty"`
+}
+
+// QuickstartRequest is the optional body of /api/quickstart/{preset}
+type QuickstartRequest struct {
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+// loadPresets reads the presets file. A missing file means no presets.
+func loadPresets() (map[string]Preset, error) {
+	presets := map[string]Preset{}
+	data, err := os.ReadFile(presetsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return presets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("invalid presets file %s: %w", presetsFile, err)
+	}
+	return presets, nil
+}
+
+// findDeviceByName returns the index of the first device with the given name.
+func findDeviceByName(devices []selectableDevice, name string) (int, bool) {
+	for i, d := range devices {
+		if d.name() == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// findDefaultCaptureDevice returns the index of the system default capture
+// device, if the backend reports one.
+func findDefaultCaptureDevice(devices []selectableDevice) (int, bool) {
+	for i, d := range devices {
+		if !d.isLoopback && d.info.IsDefault != 0 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Handler: GET /api/presets - List the configured presets
+func handleListPresets(w http.ResponseWriter, r *http.Request) {
+	presets, err := loadPresets()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	names := []string{}
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []map[string]interface{}{}
+	for _, name := range names {
+		list = append(list, map[string]interface{}{
+			"name":              name,
+			"devices":           presets[name].Devices,
+			"bestEffort":        presets[name].BestEffort,
+			"fallbackToDefault": presets[name].FallbackToDefault,
+			"preStart":          presets[name].PreStart,
+			"postStop":          presets[name].PostStop,
+			"intro":             presets[name].Intro,
+			"outro":             presets[name].Outro,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: GET /api/quickstart/{preset} - Start recording a preset's devices
+// in one request, for bookmarks and Stream Deck buttons
+func handleQuickstart(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	recording := activeSession != nil
+	recordingMutex.Unlock()
+	if recording {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Already recording")
+		return
+	}
+
+	// The body is optional, so a bookmark can start a preset with a plain GET
+	var req QuickstartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+
+	presets, err := loadPresets()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	name := r.PathValue("preset")
+	preset, ok := presets[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Unknown preset: %s", name))
+		return
+	}
+	if len(preset.Devices) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeNoDevices, fmt.Sprintf("Preset %s has no devices", name))
+		return
+	}
+
+	// Pre-start hooks run before taking the lock, so a slow one doesn't hold
+	// up the rest of the API
+	warnings, err := runPreStartHooks(name, preset, req.Title)
+	if err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+		writeError(w, http.StatusBadGateway, errCodeHookFailed, err.Error())
+		return
+	}
+
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	if activeSession != nil {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Already recording")
+		return
+	}
+	if len(preset.PreStart) > 0 {
+		// A hook may have added a device, such as a virtual cable
+		if _, err := refreshDevices(); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+			return
+		}
+	}
+	allDevices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+
+	indices := []int{}
+	missing := []DeviceStartResult{}
+	fallbacks := map[int]string{} // default device index -> the device it stands in for
+	for _, deviceName := range preset.Devices {
+		idx, found := findDeviceByName(allDevices, deviceName)
+		if found {
+			resolveAlert("fallback:"+deviceName, fmt.Sprintf("%s is connected again", deviceName))
+		}
+		if !found && preset.FallbackToDefault {
+			if idx, found = findDefaultCaptureDevice(allDevices); found {
+				defaultName := allDevices[idx].name()
+				if slices.Contains(indices, idx) {
+					warnings = append(warnings, fmt.Sprintf("%s not found; the default device %s is already recording", deviceName, defaultName))
+					continue
+				}
+				fallbacks[idx] = deviceName
+				warnings = append(warnings, fmt.Sprintf("%s not found; recording the default device %s instead", deviceName, defaultName))
+			}
+		}
+		if !found {
+			if !preset.BestEffort {
+				writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Device not found: %s", deviceName))
+				return
+			}
+			missing = append(missing, DeviceStartResult{Index: -1, Name: deviceName, Error: "device not found"})
+			continue
+		}
+		indices = append(indices, idx)
+	}
+	if len(indices) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("None of preset %s's devices are connected", name))
+		return
+	}
+
+	results, err := startSession(r.Context(), allDevices, StartRecordingRequest{
+		DeviceIndices: indices,
+		BestEffort:    preset.BestEffort,
+		Title:         req.Title,
+		Tags:          req.Tags,
+	})
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	for i, result := range results {
+		if deviceName, ok := fallbacks[result.Index]; ok && result.Started {
+			results[i].FallbackFor = deviceName
+			raiseAlert(Alert{
+				Type:    "fallback",
+				Device:  deviceName,
+				File:    result.File,
+				Message: fmt.Sprintf("%s not found; recording the default device %s instead", deviceName, result.Name),
+			})
+		}
+	}
+	activeSession.warnings = warnings
+	activeSession.preset = name
+	activeSession.postStop = preset.PostStop
+	writeSessionStarted(w, append(results, missing...))
+}
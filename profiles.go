@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// exportProfilesFile is where export profiles beyond the built-in ones are
+// read from. Like the presets file, it's re-read whenever it's needed.
+var exportProfilesFile = "export-profiles.json"
+
+// ExportProfile is a named set of export settings matching what a platform
+// asks for, so exporting for it is one job
+type ExportProfile struct {
+	Format     string `json:"format"` // mp3, aac, opus, flac or wav
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	Bitrate    string `json:"bitrate,omitempty"` // e.g. 320k, for lossy formats
+
+	// LoudnessLUFS is the integrated loudness to normalize to, and
+	// TruePeakDB the ceiling for peaks; 0 leaves the level alone
+	LoudnessLUFS float64 `json:"loudnessLufs,omitempty"`
+	TruePeakDB   float64 `json:"truePeakDb,omitempty"`
+
+	// Metadata are tags written into the file, in which {title} (the
+	// episode's title, if it's published, or the session's), {session},
+	// {date} and {device} are replaced
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// exportCodecs are the formats export profiles can use: their extension and
+// ffmpeg encoder
+var exportCodecs = map[string]struct{ ext, codec string }{
+	"mp3":  {".mp3", "libmp3lame"},
+	"aac":  {".m4a", "aac"},
+	"opus": {".opus", "libopus"},
+	"flac": {".flac", "flac"},
+	"wav":  {".wav", "pcm_s16le"},
+}
+
+// defaultExportMetadata is written when a profile doesn't say what to tag.
+var defaultExportMetadata = map[string]string{"title": "{title}", "date": "{date}"}
+
+// builtinExportProfiles follow each platform's published loudness and
+// format recommendations.
+var builtinExportProfiles = map[string]ExportProfile{
+	"youtube":        {Format: "aac", SampleRate: 48000, Channels: 2, Bitrate: "384k", LoudnessLUFS: -14, TruePeakDB: -1},
+	"spotify":        {Format: "mp3", SampleRate: 44100, Channels: 2, Bitrate: "320k", LoudnessLUFS: -14, TruePeakDB: -1},
+	"apple-podcasts": {Format: "mp3", SampleRate: 44100, Channels: 2, Bitrate: "192k", LoudnessLUFS: -16, TruePeakDB: -1},
+	"podcast-mono":   {Format: "mp3", SampleRate: 44100, Channels: 1, Bitrate: "96k", LoudnessLUFS: -19, TruePeakDB: -1},
+	"broadcast":      {Format: "wav", SampleRate: 48000, Channels: 2, LoudnessLUFS: -23, TruePeakDB: -1},
+}
+
+// loadExportProfiles returns the built-in profiles along with those in the
+// profiles file, which replace built-in ones of the same name.
+func loadExportProfiles() (map[string]ExportProfile, error) {
+	profiles := map[string]ExportProfile{}
+	for name, p := range builtinExportProfiles {
+		profiles[name] = p
+	}
+	data, err := os.ReadFile(exportProfilesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]ExportProfile
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid export profiles file %s: %w", exportProfilesFile, err)
+	}
+	for name, p := range custom {
+		if _, ok := exportCodecs[p.Format]; !ok {
+			return nil, fmt.Errorf("export profile %s: format must be mp3, aac, opus, flac or wav", name)
+		}
+		if p.LoudnessLUFS != 0 && (p.LoudnessLUFS < -70 || p.LoudnessLUFS > -5) {
+			return nil, fmt.Errorf("export profile %s: loudnessLufs must be from -70 to -5", name)
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// ffmpegArgs are the output options that make ffmpeg write a file to the
+// profile, tagged with tags.
+func (p ExportProfile) ffmpegArgs(tags *strings.Replacer) []string {
+	var args []string
+	if p.LoudnessLUFS != 0 {
+		// loudnorm works at 192 kHz, so the rate is always set after it
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=11", p.LoudnessLUFS, cmp.Or(p.TruePeakDB, -1)))
+	}
+	if p.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+	}
+	if p.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.Channels))
+	}
+	args = append(args, "-c:a", exportCodecs[p.Format].codec)
+	if p.Bitrate != "" && p.Format != "flac" && p.Format != "wav" {
+		args = append(args, "-b:a", p.Bitrate)
+	}
+	metadata := p.Metadata
+	if metadata == nil {
+		metadata = defaultExportMetadata
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags.Replace(metadata[key]))
+	}
+	return args
+}
+
+// exportSources picks what an export job exports: the file it names, or
+// else the session's newest mixdown, or else every track.
+func exportSources(job Job) ([]TrackInfo, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	var picked *ExportFile
+	for i, e := range manifest.Exports {
+		if (job.File == "" && e.Kind == jobMixdown) || (job.File != "" && e.File == job.File) {
+			picked = &manifest.Exports[i]
+		}
+	}
+	if picked == nil {
+		return jobTracks(job)
+	}
+	// The progress comes from ffmpeg in seconds, so it needs the length
+	source := TrackInfo{File: picked.File, Device: picked.Device}
+	if f, err := openRecording(picked.File); err == nil {
+		if info, err := probeAudio(f); err == nil {
+			source.DurationSeconds = info.DurationSeconds
+		}
+		f.Close()
+	}
+	return []TrackInfo{source}, nil
+}
+
+// runExport writes copies of a session's mixdown or tracks to an export
+// profile, as <file>_<profile>, with ffmpeg.
+func runExport(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	profiles, err := loadExportProfiles()
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := profiles[job.Profile]
+	if !ok {
+		return nil, fmt.Errorf("export profile %s is gone", job.Profile)
+	}
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	title := cmp.Or(manifest.Title, manifest.ID)
+	if manifest.Episode != nil {
+		title = manifest.Episode.Title
+	}
+	sources, err := exportSources(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	for i, source := range sources {
+		in, err := openRecording(source.File)
+		if err != nil {
+			return fail(err)
+		}
+		in.Close()
+		ext := exportCodecs[profile.Format].ext
+		name, out, release, err := createExportFile(trackBase(source)+"_"+sanitizeFilename(job.Profile), ext)
+		if err != nil {
+			return fail(err)
+		}
+		out.Close()
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobExport, File: name, Device: source.Device, Source: source.File})
+
+		tags := strings.NewReplacer(
+			"{title}", title,
+			"{session}", manifest.ID,
+			"{date}", manifest.StartedAt.Format("2006-01-02"),
+			"{device}", source.Device,
+		)
+		format := transcodeFormat{ext: ext, args: profile.ffmpegArgs(tags)}
+		err = ffmpegTranscode(ctx, in.Name(), filepath.Join(outputDirectory, name), format, source, func(p float64) {
+			progress((float64(i) + p) / float64(len(sources)))
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return addExports(job.SessionID, exports, releases)
+}
+
+// Handler: GET /api/export-profiles - List the export profiles, built-in
+// and from the profiles file
+func handleListExportProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := loadExportProfiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	list := []map[string]interface{}{}
+	for _, name := range names {
+		p := profiles[name]
+		if p.Metadata == nil {
+			p.Metadata = defaultExportMetadata
+		}
+		_, builtin := builtinExportProfiles[name]
+		list = append(list, map[string]interface{}{"name": name, "builtin": builtin, "profile": p})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// readOnly keeps the API to listing, streaming and downloading for everyone
+// but this machine, from -read-only, so the archive can be browsed around the
+// house without anyone starting, stopping or deleting anything from there.
+var readOnly struct {
+	enabled    bool
+	trustProxy bool // identify clients by X-Forwarded-For, from -trust-proxy
+}
+
+// controlPaths change things whatever the method, such as a bookmark that
+// starts a preset with a plain GET, or the Stream Deck socket's commands.
+var controlPaths = []string{"/api/start", "/api/stop", "/api/quickstart/", "/api/streamdeck"}
+
+// readOnlyFor reports whether a request may only look, not change anything.
+func readOnlyFor(r *http.Request) bool {
+	return readOnly.enabled && !isLocalRequest(r, readOnly.trustProxy)
+}
+
+// isLocalRequest reports whether a request comes from this machine, over
+// loopback or a unix socket.
+func isLocalRequest(r *http.Request, trustProxy bool) bool {
+	ip := clientIP(r, trustProxy)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().IsLoopback()
+	}
+	// Unix socket peers have no address, and are always on this machine
+	return ip == "" || ip == "@"
+}
+
+// changesSomething reports whether a request would start, stop, change or
+// delete anything.
+func changesSomething(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		return true
+	}
+	for _, path := range controlPaths {
+		if r.URL.Path == path || strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitToReadOnly refuses requests that change anything from other machines
+// in read-only mode.
+func limitToReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyFor(r) && changesSomething(r) {
+			writeError(w, http.StatusForbidden, errCodeReadOnly, "The recorder is read-only from here; start, stop and change things on the machine it runs on")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// pipeFormats are the raw sample formats record --stdin accepts, as the WAV
+// bits per sample and format tag they decode like.
+var pipeFormats = map[string]struct {
+	bits      uint32
+	formatTag uint16
+}{
+	"u8":    {8, wavFormatPCM},
+	"s16le": {16, wavFormatPCM},
+	"s24le": {24, wavFormatPCM},
+	"s32le": {32, wavFormatPCM},
+	"f32le": {32, wavFormatFloat},
+	"f64le": {64, wavFormatFloat},
+}
+
+// runRecord records audio that isn't from an audio device, raw PCM piped to
+// standard input, into a session with the same track file and metadata as
+// web mode.
+func runRecord(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	stdin := fs.Bool("stdin", false, "record raw PCM piped to standard input, e.g. from parec or ffmpeg")
+	format := fs.String("format", "s16le", "sample format of the piped audio: u8, s16le, s24le, s32le, f32le or f64le")
+	rate := fs.Int("rate", 44100, "sample rate of the piped audio")
+	channels := fs.Int("channels", 1, "channels of the piped audio")
+	name := fs.String("name", "stdin", "source name, used in the track's file name and metadata")
+	title := fs.String("title", "", "session title, added to the file name and metadata")
+	duration := fs.Duration("duration", 0, "stop after this long (default: when the input ends or on Ctrl+C)")
+	fs.StringVar(&outputDirectory, "output", outputDirectory, "directory to save the recording in")
+	maxBufferMB := addMemoryFlags(fs)
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Parse(args)
+	applyMemoryFlags(maxBufferMB)
+
+	if !*stdin {
+		uiWarnf("record needs a source: pass --stdin and pipe raw PCM in\n")
+		return
+	}
+	pipeFormat, ok := pipeFormats[strings.ToLower(*format)]
+	if !ok {
+		uiWarnf("Unknown format %q: use u8, s16le, s24le, s32le, f32le or f64le\n", *format)
+		return
+	}
+	if *rate < 1000 || *rate > 384000 || *channels < 1 || *channels > maxInputChannels {
+		uiWarnf("-rate must be 1000-384000 and -channels 1-32\n")
+		return
+	}
+	if err := os.MkdirAll(outputDirectory, 0755); err != nil {
+		uiWarnf("Failed to create output directory: %v\n", err)
+		return
+	}
+
+	var in io.Reader = os.Stdin
+	if *format != "s16le" {
+		converter, err := newWAVConverter(os.Stdin, AudioInfo{BitsPerSample: pipeFormat.bits, formatTag: pipeFormat.formatTag})
+		if err != nil {
+			uiWarnf("Failed to read %s: %v\n", *format, err)
+			return
+		}
+		in = converter
+	}
+
+	recordingMutex.Lock()
+	startedAt := time.Now()
+	id := newSessionID(startedAt)
+	recordingMutex.Unlock()
+	filename, fullPath := trackFilename(id, *title, *name)
+	f, err := createNewFile(fullPath)
+	if err != nil {
+		uiWarnf("Failed to create %s: %v\n", filename, err)
+		return
+	}
+	if err := writeWAVHeader(f, uint32(*rate), uint32(*channels), 16, 0); err != nil {
+		uiWarnf("Failed to write WAV header: %v\n", err)
+		return
+	}
+	cap := newCaptureDevice(*name, f, fullPath, uint32(*rate), uint32(*channels))
+	cap.untimed = true
+
+	var system atomic.Pointer[SystemSnapshot]
+	go func() {
+		system.Store(takeSystemSnapshot(nil))
+	}()
+
+	uiPrintf("🎙️  Recording %s from standard input → %s\n", *format, filename)
+	uiVerbosef("    %d Hz, %d channel(s), saved as 16-bit\n", *rate, *channels)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cap.readPCM(in)
+	}()
+
+	interrupt := interrupted()
+	var timeout <-chan time.Time
+	if *duration > 0 {
+		timeout = time.After(*duration)
+	}
+	stopProgress := make(chan struct{})
+	go reportProgress([]*captureDevice{cap}, stopProgress)
+	select {
+	case <-done:
+	case <-interrupt:
+	case <-timeout:
+	}
+	close(stopProgress)
+	// Standard input can't be interrupted, so a read still waiting on it is
+	// let go of rather than waited for
+	cap.detachSource()
+	cap.finish()
+
+	track, err := trackInfo(cap)
+	if err != nil {
+		uiWarnf("⚠️  %v\n", err)
+	}
+	track.Type = "stdin"
+	manifest := &SessionManifest{
+		ID:        id,
+		Title:     *title,
+		StartedAt: startedAt.UTC(),
+		StoppedAt: time.Now().UTC(),
+		Tracks:    []TrackInfo{track},
+		System:    system.Load(),
+	}
+	if err := writeSessionManifest(manifest); err != nil {
+		uiWarnf("⚠️  %v\n", err)
+	}
+	uiPrintf("✓ Saved %s (%.1fs)\n", filename, track.DurationSeconds)
+	uiVerbosef("    %s, %d bytes, SHA-256 %s\n", fullPath, track.Size, track.SHA256)
+	printResult(manifest)
+}
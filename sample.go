@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultSampleSeconds is how long a sample clip lasts unless ?seconds= is given.
+	defaultSampleSeconds = 3
+
+	// maxSampleSeconds caps sample clips; they're held up in a single request.
+	maxSampleSeconds = 30
+)
+
+// samplesRunning counts sample clips being recorded, which keep the malgo
+// context in use like a session does. Guarded by recordingMutex.
+var samplesRunning int
+
+// sampleDevice looks up the device named by the {id} path value and parses
+// ?seconds=, writing an error response if either is invalid. On success the
+// device is counted in samplesRunning and release must be called when done.
+func sampleDevice(w http.ResponseWriter, r *http.Request) (selected selectableDevice, d time.Duration, release func(), ok bool) {
+	idx, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, "Invalid device index")
+		return
+	}
+	seconds := float64(defaultSampleSeconds)
+	if value := r.URL.Query().Get("seconds"); value != "" {
+		seconds, err = strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 || seconds > maxSampleSeconds {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("seconds must be between 0 and %d", maxSampleSeconds))
+			return
+		}
+	}
+
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	allDevices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if idx < 0 || idx >= len(allDevices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
+		return
+	}
+
+	samplesRunning++
+	release = func() {
+		recordingMutex.Lock()
+		samplesRunning--
+		recordingMutex.Unlock()
+	}
+	return allDevices[idx], time.Duration(seconds * float64(time.Second)), release, true
+}
+
+// captureSample records d of audio from a device into a temporary WAV file
+// and returns its path. The caller removes the file.
+func captureSample(ctx context.Context, selected selectableDevice, d time.Duration) (string, error) {
+	f, err := os.CreateTemp("", "skribbl-sample-*.wav")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	path := f.Name()
+	f.Close()
+	// startCapture creates the file itself and won't overwrite one, so only
+	// the unique name is kept
+	os.Remove(path)
+
+	cap, err := startCapture(ctx, selected, path, 0)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		cap.finish()
+		return path, nil
+	case <-ctx.Done():
+		cap.discard()
+		return "", ctx.Err()
+	}
+}
+
+// Handler: POST /api/devices/{id}/sample?seconds=3 - Record a short clip from
+// a device and return it as a WAV, for a "test your mic" button
+func handleSampleDevice(w http.ResponseWriter, r *http.Request) {
+	selected, d, release, ok := sampleDevice(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	path, err := captureSample(r.Context(), selected, d)
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to read sample")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to read sample")
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Disposition", "inline; filename=sample.wav")
+	io.Copy(w, f)
+}
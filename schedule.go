@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// scheduleTick is how often the scheduler checks the clock. It checks
+	// wall-clock time rather than sleeping until the next start, since
+	// timers don't advance while a laptop is asleep.
+	scheduleTick = time.Second
+
+	// scheduleProbeLead is how long before a start its devices are probed.
+	scheduleProbeLead = time.Minute
+
+	// scheduleRetryDelay is the wait between attempts to start a recording.
+	scheduleRetryDelay = 10 * time.Second
+
+	// scheduleMaxAttempts is how many times a start is tried before giving up.
+	scheduleMaxAttempts = 6
+
+	// resumeThreshold is how far the wall clock must jump ahead of the
+	// monotonic clock between ticks to count as waking from sleep.
+	resumeThreshold = 5 * time.Second
+)
+
+// Scheduled recording states.
+const (
+	scheduleStatusPending   = "pending"
+	scheduleStatusProbed    = "probed"
+	scheduleStatusRecording = "recording"
+	scheduleStatusDone      = "done"
+	scheduleStatusFailed    = "failed"
+	scheduleStatusMissed    = "missed"
+)
+
+// scheduleFile is where scheduled recordings are kept, along with their
+// outcome.
+var scheduleFile = "schedule.json"
+
+// ScheduledRecording starts and stops a recording at set times
+type ScheduledRecording struct {
+	ID     string    `json:"id"`
+	Title  string    `json:"title,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Preset string    `json:"preset,omitempty"` // default: the last devices recorded
+	Tags   []string  `json:"tags,omitempty"`
+
+	// Recordings imported from a calendar are matched up by their event on
+	// each sync
+	Source   string `json:"source,omitempty"` // "ics" for calendar events
+	EventUID string `json:"eventUid,omitempty"`
+
+	Status    string   `json:"status"`
+	SessionID string   `json:"sessionId,omitempty"`
+	Attempts  int      `json:"attempts,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Error     string   `json:"error,omitempty"`
+
+	// nextAttempt is when a failed start is retried
+	nextAttempt time.Time
+}
+
+// ScheduleRequest is the body of POST /api/schedule
+type ScheduleRequest struct {
+	Title  string    `json:"title"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Preset string    `json:"preset"`
+	Tags   []string  `json:"tags"`
+}
+
+// scheduler holds the scheduled recordings. The file is only read at startup,
+// since the scheduler keeps writing progress back to it.
+var scheduler = struct {
+	mu         sync.Mutex
+	recordings []*ScheduledRecording
+}{}
+
+// loadSchedule reads the schedule file. A missing file means nothing is scheduled.
+func loadSchedule() error {
+	data, err := os.ReadFile(scheduleFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var recordings []*ScheduledRecording
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return fmt.Errorf("invalid schedule file %s: %w", scheduleFile, err)
+	}
+
+	scheduler.mu.Lock()
+	scheduler.recordings = recordings
+	scheduler.mu.Unlock()
+	return nil
+}
+
+// saveSchedule writes the schedule file. Must be called with scheduler.mu held.
+func saveSchedule() {
+	data, _ := json.MarshalIndent(scheduler.recordings, "", "  ")
+	if err := writeFileAtomic(scheduleFile, append(data, '\n')); err != nil {
+		fmt.Printf("⚠️  Failed to save schedule: %v\n", err)
+	}
+}
+
+// pendingScheduled counts recordings that haven't started yet.
+func pendingScheduled() int {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	n := 0
+	for _, rec := range scheduler.recordings {
+		if rec.Status == scheduleStatusPending || rec.Status == scheduleStatusProbed {
+			n++
+		}
+	}
+	return n
+}
+
+// runScheduler starts and stops scheduled recordings for the life of the process.
+func runScheduler() {
+	last := time.Now()
+	for now := range time.Tick(scheduleTick) {
+		// The monotonic clock stops while asleep, but the wall clock doesn't
+		slept := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+		last = now
+		if slept > resumeThreshold {
+			fmt.Printf("⏰ Resumed after %s asleep, reinitializing audio\n", slept.Round(time.Second))
+			reinitializeAudio()
+		}
+
+		scheduler.mu.Lock()
+		changed := false
+		for _, rec := range scheduler.recordings {
+			if rec.step(now) {
+				changed = true
+			}
+		}
+		if changed {
+			saveSchedule()
+		}
+		scheduler.mu.Unlock()
+	}
+}
+
+// reinitializeAudio refreshes the malgo context and device list, which picks
+// the audio subsystem back up after a suspend. It's skipped while recording.
+func reinitializeAudio() {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if _, err := refreshDevices(); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+}
+
+// step moves a scheduled recording along at the given time and reports
+// whether anything changed. Must be called with scheduler.mu held.
+func (rec *ScheduledRecording) step(now time.Time) bool {
+	switch rec.Status {
+	case scheduleStatusPending, scheduleStatusProbed:
+		if !now.Before(rec.End) && rec.Attempts > 0 {
+			rec.Status = scheduleStatusFailed
+			rec.alert("%s never started: %s", rec.name(), rec.Error)
+			return true
+		}
+		if !now.Before(rec.End) {
+			rec.Status = scheduleStatusMissed
+			rec.alert("%s was missed: the recorder wasn't running at %s", rec.name(), rec.Start.Format(time.Kitchen))
+			return true
+		}
+		if rec.Status == scheduleStatusPending && !now.Before(rec.Start.Add(-scheduleProbeLead)) {
+			rec.probe()
+			rec.Status = scheduleStatusProbed
+			return true
+		}
+		if !now.Before(rec.Start) && !now.Before(rec.nextAttempt) {
+			rec.start(now)
+			return true
+		}
+
+	case scheduleStatusRecording:
+		recordingMutex.Lock()
+		running := activeSession != nil && activeSession.id == rec.SessionID
+		recordingMutex.Unlock()
+		if !running {
+			// Stopped by hand, which is fine
+			rec.Status = scheduleStatusDone
+			return true
+		}
+		if !now.Before(rec.End) {
+			rec.stop()
+			return true
+		}
+	}
+	return false
+}
+
+// name is how a scheduled recording is referred to in messages.
+func (rec *ScheduledRecording) name() string {
+	if rec.Title != "" {
+		return fmt.Sprintf("Scheduled recording %q", rec.Title)
+	}
+	return "Scheduled recording " + rec.ID
+}
+
+// alert raises a schedule alert about this recording. They share a key, so
+// the next scheduled recording to start resolves them.
+func (rec *ScheduledRecording) alert(format string, args ...interface{}) {
+	raiseAlert(Alert{Type: "schedule", Message: fmt.Sprintf(format, args...)})
+}
+
+// probe wakes the audio subsystem ahead of a start and checks that the
+// preset's devices are there, so problems show up while there's time to
+// fix them.
+func (rec *ScheduledRecording) probe() {
+	reinitializeAudio()
+	if rec.Preset == "" {
+		return
+	}
+
+	presets, err := loadPresets()
+	if err != nil {
+		rec.Warnings = append(rec.Warnings, err.Error())
+		return
+	}
+	preset, ok := presets[rec.Preset]
+	if !ok {
+		rec.Warnings = append(rec.Warnings, fmt.Sprintf("Unknown preset: %s", rec.Preset))
+		rec.alert("%s uses unknown preset %s", rec.name(), rec.Preset)
+		return
+	}
+
+	recordingMutex.Lock()
+	allDevices, err := cachedDevices()
+	recordingMutex.Unlock()
+	if err != nil {
+		rec.Warnings = append(rec.Warnings, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	for _, deviceName := range preset.Devices {
+		if _, found := findDeviceByName(allDevices, deviceName); !found {
+			rec.Warnings = append(rec.Warnings, fmt.Sprintf("Device not found a minute before start: %s", deviceName))
+			rec.alert("%s starts in a minute but %s isn't connected", rec.name(), deviceName)
+		}
+	}
+}
+
+// start tries to start the recording, scheduling a retry if it fails.
+func (rec *ScheduledRecording) start(now time.Time) {
+	rec.Attempts++
+	if rec.Attempts > 1 {
+		// The audio subsystem may not have been ready yet
+		reinitializeAudio()
+	}
+
+	result := runCommand(context.Background(), RemoteCommand{Action: "start", Preset: rec.Preset, Title: rec.Title, Tags: rec.Tags})
+	if result.Status == http.StatusOK {
+		var started struct {
+			SessionID string `json:"sessionId"`
+		}
+		json.Unmarshal(result.Response, &started)
+		rec.Status = scheduleStatusRecording
+		rec.SessionID = started.SessionID
+		rec.Error = ""
+		fmt.Printf("🎙️  %s started, recording session %s\n", rec.name(), rec.SessionID)
+		resolveAlert("schedule", fmt.Sprintf("%s started, so scheduled recordings are working again", rec.name()))
+		return
+	}
+
+	rec.Error = string(result.Response)
+	if rec.Attempts >= scheduleMaxAttempts {
+		rec.Status = scheduleStatusFailed
+		rec.alert("%s failed to start after %d attempts: %s", rec.name(), rec.Attempts, rec.Error)
+		return
+	}
+	rec.nextAttempt = now.Add(scheduleRetryDelay)
+	fmt.Printf("⚠️  %s failed to start (retrying in %s): %s\n", rec.name(), scheduleRetryDelay, rec.Error)
+}
+
+// stop finalizes the session the recording started.
+func (rec *ScheduledRecording) stop() {
+	status, response := callAPI(context.Background(), handleFinalizeSession, http.MethodPost, nil, map[string]string{"id": rec.SessionID})
+	rec.Status = scheduleStatusDone
+	if status != http.StatusOK {
+		rec.Error = string(response)
+		rec.alert("%s failed to stop cleanly: %s", rec.name(), response)
+		return
+	}
+	fmt.Printf("✓ %s finished, session %s saved\n", rec.name(), rec.SessionID)
+}
+
+// newScheduleID returns a random ID for a scheduled recording.
+func newScheduleID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Handler: GET /api/schedule - List scheduled recordings, soonest first
+func handleListSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduler.mu.Lock()
+	list := []ScheduledRecording{}
+	for _, rec := range scheduler.recordings {
+		list = append(list, *rec)
+	}
+	scheduler.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b ScheduledRecording) int { return a.Start.Compare(b.Start) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: POST /api/schedule - Schedule a recording
+func handleAddSchedule(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Start.IsZero() || req.End.IsZero() || !req.End.After(req.Start) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "start and end are required, and end must be after start")
+		return
+	}
+	if !req.End.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "end is in the past")
+		return
+	}
+
+	rec := &ScheduledRecording{
+		ID:     newScheduleID(),
+		Title:  req.Title,
+		Start:  req.Start,
+		End:    req.End,
+		Preset: req.Preset,
+		Tags:   req.Tags,
+		Status: scheduleStatusPending,
+	}
+	scheduler.mu.Lock()
+	scheduler.recordings = append(scheduler.recordings, rec)
+	saveSchedule()
+	scheduler.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rec)
+}
+
+// Handler: DELETE /api/schedule/{id} - Cancel a scheduled recording. A
+// recording it already started keeps going.
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	i := slices.IndexFunc(scheduler.recordings, func(rec *ScheduledRecording) bool { return rec.ID == id })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Scheduled recording not found")
+		return
+	}
+	scheduler.recordings = slices.Delete(scheduler.recordings, i, i+1)
+	saveSchedule()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "schedule deleted"})
+}
+
+// scheduledEnd returns when the scheduled recording that started a session
+// is due to stop.
+func scheduledEnd(sessionID string) (time.Time, bool) {
+	if sessionID == "" {
+		return time.Time{}, false
+	}
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	for _, rec := range scheduler.recordings {
+		if rec.Status == scheduleStatusRecording && rec.SessionID == sessionID {
+			return rec.End, true
+		}
+	}
+	return time.Time{}, false
+}
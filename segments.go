@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// splitEvery starts a new file for each track every so often while recording,
+// from -split-every, so no file grows past what WAV can hold or what's easy
+// to move around. The split is gapless: each file starts with the sample
+// after the last one's. 0 records each track to one file.
+var splitEvery time.Duration
+
+// minSplitEvery is the shortest -split-every accepted.
+const minSplitEvery = time.Minute
+
+// TrackSegment is one of the files a track split by -split-every was
+// recorded to, in order
+type TrackSegment struct {
+	File       string `json:"file"`
+	StartFrame uint64 `json:"startFrame"` // the track's first frame in the file, from 0
+	Frames     uint64 `json:"frames"`
+	Size       int64  `json:"size,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+}
+
+// SegmentCheck is what reading a split track's files back found
+type SegmentCheck struct {
+	File     string   `json:"file"`
+	Device   string   `json:"device,omitempty"`
+	Segments int      `json:"segments"`
+	Frames   uint64   `json:"frames"` // read back, across every file
+	SHA256   string   `json:"sha256"` // of the files' audio, end to end
+	Gapless  bool     `json:"gapless"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// startSegments makes the capture split its track every splitEvery, hashing
+// its audio as it's written so the files can be checked against it. It must
+// be called before anything is written.
+func (c *captureDevice) startSegments() {
+	frameBytes := uint64(c.channels) * 2
+	c.segmentBytes = uint64(splitEvery.Seconds()*float64(c.sampleRate)) * frameBytes
+	c.streamHash = sha256.New()
+	c.segmentPath = c.filename
+}
+
+// write writes audio to the sink or the file, moving on to the next file at
+// exactly the boundary when the track is split.
+func (c *captureDevice) write(p []byte) (int, error) {
+	if c.segmentBytes == 0 || c.sink != nil {
+		n, err := c.out().Write(p)
+		c.fileBytes += uint64(n)
+		return n, err
+	}
+	written := 0
+	for len(p) > 0 {
+		if c.fileBytes >= c.segmentBytes {
+			if err := c.nextSegment(); err != nil {
+				return written, err
+			}
+		}
+		n, err := c.file.Write(p[:min(uint64(len(p)), c.segmentBytes-c.fileBytes)])
+		c.streamHash.Write(p[:n])
+		c.fileBytes += uint64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// nextSegment finishes the file being written and opens the next,
+// <track>_part2.wav and so on.
+func (c *captureDevice) nextSegment() error {
+	base := strings.TrimSuffix(filepath.Base(c.filename), filepath.Ext(c.filename))
+	name := uniqueFilename(outputDirectory, fmt.Sprintf("%s_part%d", base, len(c.segmentList())+2), ".wav")
+	path := filepath.Join(outputDirectory, name)
+	f, err := createNewFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if err := writeWAVHeader(f, c.sampleRate, c.channels, 16, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write WAV header: %w", err)
+	}
+	c.closeFile()
+	c.segmentsMu.Lock()
+	c.file, c.segmentPath = f, path
+	c.segmentsMu.Unlock()
+	c.fileBytes = 0
+	return nil
+}
+
+// closeFile rewrites the header of the file being written with its final
+// size and closes it, noting it as a segment when the track is split.
+func (c *captureDevice) closeFile() {
+	c.file.Seek(0, 0)
+	writeWAVHeader(c.file, c.sampleRate, c.channels, 16, uint32(c.fileBytes))
+	c.file.Close()
+	if c.segmentBytes == 0 {
+		return
+	}
+	frameBytes := uint64(c.channels) * 2
+	c.segmentsMu.Lock()
+	defer c.segmentsMu.Unlock()
+	start := uint64(0)
+	if n := len(c.segments); n > 0 {
+		start = c.segments[n-1].StartFrame + c.segments[n-1].Frames
+	}
+	c.segments = append(c.segments, TrackSegment{File: filepath.Base(c.segmentPath), StartFrame: start, Frames: c.fileBytes / frameBytes})
+}
+
+// segmentList returns the files the track has finished so far.
+func (c *captureDevice) segmentList() []TrackSegment {
+	c.segmentsMu.Lock()
+	defer c.segmentsMu.Unlock()
+	return append([]TrackSegment(nil), c.segments...)
+}
+
+// segmentFiles lists every file the track is being recorded to, finished or
+// not.
+func (c *captureDevice) segmentFiles() []string {
+	c.segmentsMu.Lock()
+	defer c.segmentsMu.Unlock()
+	files := []string{filepath.Base(c.filename)}
+	for _, s := range c.segments[min(1, len(c.segments)):] {
+		files = append(files, s.File)
+	}
+	if current := filepath.Base(c.segmentPath); c.segmentPath != "" && !slices.Contains(files, current) {
+		files = append(files, current)
+	}
+	return files
+}
+
+// segmentInfo fills in a finished track's segments, checksummed, and the
+// hash of its audio as it was recorded. A track that never reached a second
+// file is left alone.
+func segmentInfo(cap *captureDevice, track *TrackInfo) error {
+	segments := cap.segmentList()
+	if len(segments) < 2 {
+		return nil
+	}
+	frames := uint64(0)
+	for i := range segments {
+		size, sum, err := checksumFile(filepath.Join(outputDirectory, segments[i].File))
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s: %w", segments[i].File, err)
+		}
+		segments[i].Size, segments[i].SHA256 = size, sum
+		frames += segments[i].Frames
+	}
+	track.Segments = segments
+	track.StreamSHA256 = hex.EncodeToString(cap.streamHash.Sum(nil))
+	track.DurationSeconds = float64(frames) / float64(cap.sampleRate)
+	return nil
+}
+
+// checkSegments reads a split track's files back in order, checking each
+// starts where the last ended and holds the frames it should, and hashes
+// their audio end to end to compare with what was recorded.
+func checkSegments(track TrackInfo) SegmentCheck {
+	check := SegmentCheck{File: track.File, Device: track.Device, Segments: len(track.Segments), Gapless: true}
+	hash := sha256.New()
+	problem := func(format string, args ...interface{}) {
+		check.Problems = append(check.Problems, fmt.Sprintf(format, args...))
+		check.Gapless = false
+	}
+	for _, segment := range track.Segments {
+		if segment.StartFrame != check.Frames {
+			problem("%s starts at frame %d, but the files before it end at frame %d", segment.File, segment.StartFrame, check.Frames)
+		}
+		frames, err := hashSegment(hash, segment.File, track.Channels)
+		if err != nil {
+			problem("%s: %v", segment.File, err)
+			continue
+		}
+		if frames != segment.Frames {
+			problem("%s holds %d frames, not the %d recorded", segment.File, frames, segment.Frames)
+		}
+		check.Frames += frames
+	}
+	check.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if check.SHA256 != track.StreamSHA256 {
+		problem("the files' audio doesn't match what was recorded")
+	}
+	return check
+}
+
+// hashSegment adds the audio of one of a track's files to a hash, and
+// returns how many frames it holds.
+func hashSegment(hash hash.Hash, name string, channels uint32) (uint64, error) {
+	f, err := openRecording(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err != nil {
+		return 0, err
+	}
+	if info.Format != "wav" || info.formatTag != wavFormatPCM || info.BitsPerSample != 16 || info.Channels != channels {
+		return 0, fmt.Errorf("not 16-bit PCM with %d channels", channels)
+	}
+	n, err := io.Copy(hash, io.NewSectionReader(f, info.dataOffset, info.dataSize))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(n) / (uint64(channels) * 2), nil
+}
+
+// Handler: GET /api/sessions/{id}/segments - List where each track of a
+// session recorded with -split-every was split, as it's recorded too
+func handleSessionSegments(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	type trackSegments struct {
+		File     string         `json:"file"`
+		Device   string         `json:"device,omitempty"`
+		Segments []TrackSegment `json:"segments"`
+		Current  string         `json:"current,omitempty"` // the file being recorded to
+	}
+	result := []trackSegments{}
+	live := false
+	recordingMutex.Lock()
+	if activeSession != nil && activeSession.id == id {
+		live = true
+		for _, track := range activeSession.removed {
+			result = append(result, trackSegments{File: track.File, Device: track.Device, Segments: track.Segments})
+		}
+		for _, cap := range activeSession.captures {
+			files := cap.segmentFiles()
+			result = append(result, trackSegments{File: files[0], Device: cap.name, Segments: cap.segmentList(), Current: files[len(files)-1]})
+		}
+	}
+	recordingMutex.Unlock()
+
+	if !live {
+		manifest, err := readSessionManifest(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+			return
+		}
+		for _, track := range manifest.Tracks {
+			result = append(result, trackSegments{File: track.File, Device: track.Device, Segments: track.Segments})
+		}
+	}
+	for i := range result {
+		if result[i].Segments == nil {
+			result[i].Segments = []TrackSegment{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"tracks":    result,
+	})
+}
+
+// Handler: GET /api/sessions/{id}/segments/verify - Read a finished session's
+// split tracks back and check that their files, end to end, are exactly the
+// audio that was recorded
+func handleVerifySegments(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	checks := []SegmentCheck{}
+	verified := true
+	for _, track := range manifest.Tracks {
+		if len(track.Segments) == 0 {
+			continue
+		}
+		check := checkSegments(track)
+		verified = verified && check.Gapless
+		checks = append(checks, check)
+	}
+	if len(checks) == 0 {
+		writeError(w, http.StatusConflict, errCodeConflict, "None of the session's tracks were split")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessionId": id,
+		"verified":  verified,
+		"tracks":    checks,
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	segmentTestRate     = 100 // frames a second, so a 1s split is 100 frames
+	segmentTestChannels = 2
+	segmentTestFrames   = 1037 // ten full segments and part of an eleventh
+
+	segmentTestTrack = "2024-05-01_20-15-00_Mic.wav"
+	segmentTestPart2 = "2024-05-01_20-15-00_Mic_part2.wav"
+)
+
+// recordSegments records a numbered sequence of stereo frames through the
+// capture writer with a 1s split, in writes that straddle the boundaries,
+// and returns the finished track and the audio written.
+func recordSegments(t *testing.T) (TrackInfo, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	savedDir, savedSplit := outputDirectory, splitEvery
+	outputDirectory, splitEvery = dir, time.Second
+	t.Cleanup(func() { outputDirectory, splitEvery = savedDir, savedSplit })
+
+	path := filepath.Join(dir, segmentTestTrack)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeWAVHeader(f, segmentTestRate, segmentTestChannels, 16, 0); err != nil {
+		t.Fatal(err)
+	}
+	cap := &captureDevice{name: "Mic", file: f, filename: path, sampleRate: segmentTestRate, channels: segmentTestChannels}
+	cap.startSegments()
+
+	// Every frame is different, so a lost or repeated one shows
+	var audio []byte
+	for i := range segmentTestFrames {
+		audio = binary.LittleEndian.AppendUint16(audio, uint16(i))
+		audio = binary.LittleEndian.AppendUint16(audio, uint16(^i))
+	}
+	for rest, size := audio, 0; len(rest) > 0; rest = rest[size:] {
+		size = min(len(rest), 4*37)
+		if n, err := cap.write(rest[:size]); err != nil || n != size {
+			t.Fatalf("write: wrote %d of %d bytes: %v", n, size, err)
+		}
+	}
+	cap.closeFile()
+
+	track := TrackInfo{File: filepath.Base(path), Device: cap.name, Channels: segmentTestChannels}
+	if err := segmentInfo(cap, &track); err != nil {
+		t.Fatal(err)
+	}
+	return track, audio
+}
+
+func TestSegmentsAreGapless(t *testing.T) {
+	track, audio := recordSegments(t)
+
+	if want := segmentTestFrames/segmentTestRate + 1; len(track.Segments) != want {
+		t.Fatalf("split into %d segments, want %d", len(track.Segments), want)
+	}
+	if track.Segments[0].File != track.File || track.Segments[1].File != segmentTestPart2 {
+		t.Errorf("segments named %s and %s", track.Segments[0].File, track.Segments[1].File)
+	}
+
+	// Read back end to end, the files hold exactly the frames written
+	var read []byte
+	next := uint64(0)
+	for i, segment := range track.Segments {
+		if segment.StartFrame != next {
+			t.Errorf("segment %d starts at frame %d, want %d", i+1, segment.StartFrame, next)
+		}
+		if i < len(track.Segments)-1 && segment.Frames != segmentTestRate {
+			t.Errorf("segment %d holds %d frames, want %d", i+1, segment.Frames, segmentTestRate)
+		}
+		next += segment.Frames
+
+		f, err := os.Open(filepath.Join(outputDirectory, segment.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := probeAudio(f)
+		if err != nil {
+			t.Fatalf("%s: %v", segment.File, err)
+		}
+		data := make([]byte, info.dataSize)
+		_, err = f.ReadAt(data, info.dataOffset)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(data)) != segment.Frames*segmentTestChannels*2 {
+			t.Errorf("%s holds %d bytes of audio for %d frames", segment.File, len(data), segment.Frames)
+		}
+		read = append(read, data...)
+	}
+	if next != segmentTestFrames {
+		t.Errorf("segments hold %d frames, want %d", next, segmentTestFrames)
+	}
+	if !bytes.Equal(read, audio) {
+		t.Error("the segments' audio end to end isn't what was written")
+	}
+
+	check := checkSegments(track)
+	if !check.Gapless || len(check.Problems) > 0 || check.Frames != segmentTestFrames || check.SHA256 != track.StreamSHA256 {
+		t.Errorf("checkSegments = %+v, want gapless", check)
+	}
+}
+
+func TestCheckSegmentsFindsProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		spoil   func(t *testing.T, track *TrackInfo)
+		problem string
+	}{
+		{
+			name: "shifted start",
+			spoil: func(t *testing.T, track *TrackInfo) {
+				track.Segments[3].StartFrame++
+			},
+			problem: "starts at frame",
+		},
+		{
+			name: "truncated",
+			spoil: func(t *testing.T, track *TrackInfo) {
+				path := filepath.Join(outputDirectory, track.Segments[2].File)
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(path, info.Size()-10*segmentTestChannels*2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "holds 90 frames, not the 100 recorded",
+		},
+		{
+			name: "tampered",
+			spoil: func(t *testing.T, track *TrackInfo) {
+				path := filepath.Join(outputDirectory, track.Segments[5].File)
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xFF
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "doesn't match what was recorded",
+		},
+		{
+			name: "missing",
+			spoil: func(t *testing.T, track *TrackInfo) {
+				if err := os.Remove(filepath.Join(outputDirectory, track.Segments[1].File)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: segmentTestPart2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, _ := recordSegments(t)
+			tt.spoil(t, &track)
+			check := checkSegments(track)
+			if check.Gapless {
+				t.Fatal("checkSegments found the segments gapless")
+			}
+			if !strings.Contains(strings.Join(check.Problems, "\n"), tt.problem) {
+				t.Errorf("problems %q don't mention %q", check.Problems, tt.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session is one recording run: the devices started together by /api/start.
+type session struct {
+	id        string
+	startedAt time.Time
+	captures  []*captureDevice
+
+	// removed holds the tracks of devices taken out of the session early.
+	removed []TrackInfo
+	obs     *OBSSync
+
+	// markers has a lock of its own, since keyword markers are added by
+	// transcription workers that stopping the session waits for
+	markersMu sync.Mutex
+	markers   []Marker
+
+	// warnings note anything that didn't go to plan, such as a preset
+	// device replaced by the default device
+	warnings []string
+
+	title string
+	tags  []string
+
+	// gameTitle is the title the game was started with, which sessions
+	// split off for each round carry on. round and word are the game's
+	// current round, from events, and roundSegment is set when the session
+	// records just that round.
+	gameTitle    string
+	round        int
+	word         string
+	roundSegment bool
+
+	// transcript is filled in while recording when live transcripts are on
+	transcript *liveTranscript
+
+	// preset is the preset the session was started from, whose post-stop
+	// hooks run once it's saved
+	preset   string
+	postStop []Hook
+
+	// system is the system snapshot, stored once it's been taken
+	system atomic.Pointer[SystemSnapshot]
+}
+
+// activeSession is the recording in progress, or nil. Guarded by recordingMutex.
+var activeSession *session
+
+// sessionChanged is closed and replaced whenever the active session starts,
+// stops or changes, so push clients can wait for it. Guarded by recordingMutex.
+var sessionChanged = make(chan struct{})
+
+// notifySessionChanged wakes everyone waiting on sessionChanged. Must be
+// called with recordingMutex held.
+func notifySessionChanged() {
+	close(sessionChanged)
+	sessionChanged = make(chan struct{})
+}
+
+// SessionManifest is the metadata sidecar written next to a session's
+// recordings, and the response to a finished /api/stop
+type SessionManifest struct {
+	ID        string      `json:"id"`
+	Title     string      `json:"title,omitempty"`
+	Tags      []string    `json:"tags,omitempty"`
+	Starred   bool        `json:"starred,omitempty"` // kept from -archive-after
+	StartedAt time.Time   `json:"startedAt"`
+	StoppedAt time.Time   `json:"stoppedAt"`
+	Preset    string      `json:"preset,omitempty"` // the preset it was started from
+	Tracks    []TrackInfo `json:"tracks"`
+	Markers   []Marker    `json:"markers,omitempty"`
+	OBS       *OBSSync    `json:"obs,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+
+	// Source is set for files the recorder didn't capture itself: "watched"
+	// for files found in the recordings folder, "import" for uploads
+	Source string `json:"source,omitempty"`
+
+	// Transcript names the file the live transcript was saved to
+	Transcript string `json:"transcript,omitempty"`
+
+	// Clips and Exports are made from the session after it's finished
+	Clips   []ClipInfo   `json:"clips,omitempty"`
+	Exports []ExportFile `json:"exports,omitempty"`
+
+	// Round and Word are the game round the session recorded, when it was
+	// split by round
+	Round int    `json:"round,omitempty"`
+	Word  string `json:"word,omitempty"`
+
+	// System describes the system the session was recorded on
+	System *SystemSnapshot `json:"system,omitempty"`
+
+	// Archived is set while the session's audio is in -archive rather than
+	// the recordings folder or -storage, and RestoredAt once it's been
+	// brought back
+	Archived   *ArchiveInfo `json:"archived,omitempty"`
+	RestoredAt *time.Time   `json:"restoredAt,omitempty"`
+
+	// Alignment is where an align job found the sync event in each track
+	Alignment *Alignment `json:"alignment,omitempty"`
+
+	// Episode is set while the session is published to the podcast feed
+	Episode *Episode `json:"episode,omitempty"`
+
+	// Deleted is set while the session is in the trash
+	Deleted *DeleteInfo `json:"deleted,omitempty"`
+
+	// PostStopHooks is how the preset's post-stop hooks went
+	PostStopHooks []HookResult `json:"postStopHooks,omitempty"`
+}
+
+// ArchiveInfo describes where an archived session's audio went
+type ArchiveInfo struct {
+	At       time.Time `json:"at"`
+	Location string    `json:"location"`
+	Format   string    `json:"format"` // what the tracks were transcoded to
+	Files    []string  `json:"files"`  // the audio files in the archive
+}
+
+// Marker flags a moment in a session, in seconds from its start
+type Marker struct {
+	Time  float64 `json:"time"`
+	Label string  `json:"label,omitempty"`
+
+	// Source is "keyword" for markers dropped by a spoken keyword
+	Source string `json:"source,omitempty"`
+}
+
+// ExportFile is a processed copy of one of a session's tracks
+type ExportFile struct {
+	Kind   string `json:"kind"` // "bleep", or the job that made it: "transcode", "normalize" or "peaks"
+	File   string `json:"file"`
+	Device string `json:"device,omitempty"`
+	Source string `json:"source,omitempty"` // the track it was made from
+}
+
+// TrackInfo describes one finalized recording file
+type TrackInfo struct {
+	File              string      `json:"file"`
+	Device            string      `json:"device"`
+	Speaker           string      `json:"speaker,omitempty"`
+	Type              string      `json:"type"` // "capture", "loopback", "port", "asio", "stream", "stdin" or "external"
+	StartOffset       float64     `json:"startOffsetSeconds,omitempty"`
+	SampleRate        uint32      `json:"sampleRate"`
+	Channels          uint32      `json:"channels"`
+	BitsPerSample     uint32      `json:"bitsPerSample"`
+	Size              int64       `json:"size"`
+	DurationSeconds   float64     `json:"durationSeconds"`
+	SHA256            string      `json:"sha256"`
+	DroppedFrames     uint64      `json:"droppedFrames"`
+	GainDB            float64     `json:"gainDb,omitempty"`
+	InputChannels     []int       `json:"inputChannels,omitempty"`     // interface inputs recorded, from 1
+	InputVolume       *OSVolume   `json:"inputVolume,omitempty"`       // OS input volume recorded at
+	InputVolumeBefore *OSVolume   `json:"inputVolumeBefore,omitempty"` // before -input-volume set it
+	Mutes             []MuteRange `json:"mutes,omitempty"`
+	Dropouts          []Dropout   `json:"dropouts,omitempty"`
+
+	// MeasuredSampleRate is the rate the device actually delivered against
+	// the system clock, and DriftPPM how far that is from SampleRate, for
+	// tracks that recorded long enough to tell
+	MeasuredSampleRate float64 `json:"measuredSampleRate,omitempty"`
+	DriftPPM           float64 `json:"driftPpm,omitempty"`
+
+	// Segments lists the files a track split by -split-every was recorded
+	// to, the first being File, and StreamSHA256 is the hash of its audio
+	// across all of them, as it was recorded
+	Segments     []TrackSegment `json:"segments,omitempty"`
+	StreamSHA256 string         `json:"streamSha256,omitempty"`
+
+	// Format and PeakDBFS are recorded for ingested files
+	Format   string   `json:"format,omitempty"`
+	PeakDBFS *float64 `json:"peakDbfs,omitempty"`
+}
+
+// MuteRange is a stretch of a track that was replaced with silence, in
+// seconds from the start of the session
+type MuteRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// defaultTimestampLayout names sessions and their files by start time.
+const defaultTimestampLayout = "2006-01-02_15-04-05"
+
+// File name timestamp settings, set from flags in web mode. Local time is
+// friendlier, but repeats an hour when the clocks go back, so only UTC names
+// are guaranteed to sort in recording order.
+var (
+	timestampLayout = defaultTimestampLayout
+	timestampUTC    bool
+)
+
+// formatTimestamp formats a session's start time for its ID and file names.
+func formatTimestamp(t time.Time) string {
+	if timestampUTC {
+		t = t.UTC()
+	}
+	return sanitizeFilename(t.Format(timestampLayout))
+}
+
+// newSessionID names a session after its start time. A session started in the
+// same second as an earlier one gets a sequence number, as in
+// 2024-05-01_20-15-00-2, so the two never share files. Must be called with
+// recordingMutex held.
+func newSessionID(startedAt time.Time) string {
+	base := formatTimestamp(startedAt)
+	id := base
+	for n := 2; sessionIDTaken(id); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+// sessionIDTaken reports whether a session with this ID is running, left
+// anything in the output directory, or is in the trash.
+func sessionIDTaken(id string) bool {
+	if activeSession != nil && activeSession.id == id || inTrash(id) {
+		return true
+	}
+	if _, err := os.Stat(sessionManifestPath(id)); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(outputDirectory, id+"_*"))
+	return len(matches) > 0
+}
+
+// trackFilename picks the file name for a device's track in a session,
+// numbering it if another track already has the name, e.g. for two identical
+// microphones. It returns the name and the full path.
+func trackFilename(id, title, deviceName string) (string, string) {
+	base := id
+	if title != "" {
+		base += "_" + sanitizeFilename(title)
+	}
+	name := uniqueFilename(outputDirectory, base+"_"+sanitizeFilename(deviceName), ".wav")
+	return name, filepath.Join(outputDirectory, name)
+}
+
+// uniqueFilename returns base+ext, or base_2+ext, base_3+ext and so on, for
+// the first name not yet used in dir.
+func uniqueFilename(dir, base, ext string) string {
+	name := base + ext
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+}
+
+// createNewFile creates a recording file, failing rather than truncating one
+// that already exists.
+func createNewFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+}
+
+// sessionManifestPath is where a session's sidecar is stored.
+func sessionManifestPath(id string) string {
+	return filepath.Join(outputDirectory, id+".json")
+}
+
+// finalizeSession stops every device in the session, rewrites the WAV
+// headers, checksums the files and writes the metadata sidecar. It only
+// returns once everything is on disk.
+func finalizeSession(ctx context.Context, sess *session) (*SessionManifest, error) {
+	manifest := &SessionManifest{
+		ID:        sess.id,
+		Title:     sess.title,
+		Tags:      sess.tags,
+		StartedAt: sess.startedAt.UTC(),
+		Tracks:    append([]TrackInfo{}, sess.removed...),
+		OBS:       sess.obs,
+		Preset:    sess.preset,
+		Warnings:  sess.warnings,
+		System:    sess.system.Load(),
+	}
+	if sess.roundSegment {
+		manifest.Round, manifest.Word = sess.round, sess.word
+	}
+
+	var firstErr error
+	for _, cap := range sess.captures {
+		track, err := finalizeTrack(ctx, sess, cap)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		manifest.Tracks = append(manifest.Tracks, track)
+	}
+	manifest.StoppedAt = time.Now().UTC()
+
+	if sess.transcript != nil {
+		file, err := saveTranscript(sess.transcript)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		manifest.Transcript = file
+	}
+	manifest.Markers = sess.markerList()
+
+	if err := writeSessionManifest(manifest); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	var files []string
+	for _, track := range manifest.Tracks {
+		files = append(files, track.File)
+	}
+	forgetSessionAlerts(files)
+	queueAutoConvert(manifest)
+	if email.server != "" && email.sessions {
+		emailSessionReport(manifest)
+	}
+	return manifest, firstErr
+}
+
+// finalizeTrack stops one capture and describes its finished file.
+func finalizeTrack(ctx context.Context, sess *session, cap *captureDevice) (TrackInfo, error) {
+	_, s := startSpan(ctx, "capture.finalize", spanKindInternal)
+	s.setAttr("device.name", cap.name)
+	sess.setMuted(cap, false)
+	cap.finish()
+	if t := cap.transcriber.Load(); t != nil {
+		t.close()
+	}
+	s.setAttr("audio.bytes", cap.totalBytesWritten.Load())
+
+	track, err := trackInfo(cap)
+	s.finish(err)
+	return track, err
+}
+
+// trackInfo describes a finished capture's file.
+func trackInfo(cap *captureDevice) (TrackInfo, error) {
+	track := TrackInfo{
+		File:              filepath.Base(cap.filename),
+		Device:            cap.name,
+		Speaker:           cap.speaker,
+		Type:              "capture",
+		SampleRate:        cap.sampleRate,
+		Channels:          cap.channels,
+		BitsPerSample:     16,
+		StartOffset:       cap.startOffset.Seconds(),
+		DroppedFrames:     cap.droppedFrames.Load(),
+		GainDB:            cap.gainDB,
+		InputVolume:       cap.osVolume,
+		InputVolumeBefore: cap.osVolumeBefore,
+		Mutes:             cap.mutes,
+		Dropouts:          cap.dropoutList(),
+	}
+	if cap.isLoopback {
+		track.Type = "loopback"
+	} else if cap.source.port != "" {
+		track.Type = "port"
+	} else if cap.source.asio != "" {
+		track.Type = "asio"
+	} else if cap.source.stream != nil {
+		track.Type = "stream"
+	}
+	for _, ch := range cap.pick {
+		track.InputChannels = append(track.InputChannels, ch+1)
+	}
+	if !cap.untimed {
+		track.MeasuredSampleRate, track.DriftPPM = cap.drift.measuredRate(cap.sampleRate)
+	}
+	bytesPerSecond := float64(cap.sampleRate * cap.channels * 2)
+	track.DurationSeconds = float64(cap.totalBytesWritten.Load()) / bytesPerSecond
+
+	size, sum, err := checksumFile(cap.filename)
+	if err != nil {
+		return track, fmt.Errorf("failed to checksum %s: %w", track.File, err)
+	}
+	track.Size = size
+	track.SHA256 = sum
+	return track, segmentInfo(cap, &track)
+}
+
+// checksumFile returns a file's size and SHA-256.
+func checksumFile(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeFileAtomic replaces a file via a temporary file, so a crash never
+// leaves it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeSessionManifest saves a session's metadata sidecar, and with
+// -storage, queues a job to copy the session's files to the store.
+func writeSessionManifest(manifest *SessionManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written atomically, since background jobs read it while others update it
+	if err := writeFileAtomic(sessionManifestPath(manifest.ID), data); err != nil {
+		return fmt.Errorf("failed to write session metadata: %w", err)
+	}
+	if store != nil {
+		queueUpload(manifest.ID)
+	}
+	return nil
+}
+
+// manifestMu serializes changes to finished sessions' metadata sidecars, so
+// two at once, such as starring a session while a clip is added, don't
+// overwrite each other, and moves of sessions to and from the trash. It's
+// held only to read, change and write the sidecar: audio is cut or encoded
+// before taking it, so long exports don't hold up other changes.
+var manifestMu sync.Mutex
+
+// readSessionManifest loads a finished session's metadata sidecar.
+func readSessionManifest(id string) (*SessionManifest, error) {
+	data, err := os.ReadFile(sessionManifestPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var manifest SessionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// validSessionID reports whether id could name a session, so it can be used
+// safely in a file path.
+func validSessionID(id string) bool {
+	return id != "" && id == sanitizeFilename(id)
+}
+
+// Handler: GET /api/sessions/{id} - Get a finished session's metadata
+func handleGetSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// Handler: POST /api/sessions/{id}/finalize - Stop a session and wait for its
+// files to be finalized. Finalizing an already finished session returns its
+// stored metadata, so retries are safe.
+func handleFinalizeSession(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	id := r.PathValue("id")
+	if activeSession != nil && activeSession.id == id {
+		stopActiveSession(w, r)
+		return
+	}
+	recordingMutex.Unlock()
+
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	writeSessionStopped(w, manifest)
+}
+
+// stopActiveSession finalizes the active session, runs its preset's
+// post-stop hooks and writes its metadata as the response. Must be called
+// with recordingMutex held, which it releases before the hooks run.
+func stopActiveSession(w http.ResponseWriter, r *http.Request) {
+	ctx, sessionSpan := startSpan(r.Context(), "session.stop", spanKindInternal)
+	sessionSpan.setAttr("session.id", activeSession.id)
+
+	sess := activeSession
+	manifest, err := finalizeSession(ctx, sess)
+	activeSession = nil
+	monitoring.sync()
+	liveMix.sync()
+	notifySessionChanged()
+	recordingMutex.Unlock()
+	if err == nil && len(sess.postStop) > 0 {
+		manifest.PostStopHooks = runPostStopHooks(sess.preset, sess.postStop, manifest)
+	}
+	sessionSpan.finish(err)
+
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	writeSessionStopped(w, manifest)
+}
+
+// writeSessionStopped responds with a finished session's metadata.
+func writeSessionStopped(w http.ResponseWriter, manifest *SessionManifest) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "recording stopped",
+		"session": manifest,
+	})
+}
+
+// AddDeviceRequest is the request body for adding a device to a session
+type AddDeviceRequest struct {
+	DeviceIndex *int `json:"deviceIndex"`
+}
+
+// runningSession returns the active session if it has the given ID, writing
+// an error response otherwise. Must be called with recordingMutex held.
+func runningSession(w http.ResponseWriter, id string) *session {
+	if activeSession != nil && activeSession.id == id {
+		return activeSession
+	}
+	if validSessionID(id) {
+		if _, err := os.Stat(sessionManifestPath(id)); err == nil {
+			writeError(w, http.StatusConflict, errCodeNotRecording, "Session has already stopped")
+			return nil
+		}
+	}
+	writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+	return nil
+}
+
+// sessionCapture finds the capture recording a device index in a session.
+func (s *session) sessionCapture(index int) *captureDevice {
+	for _, cap := range s.captures {
+		if cap.deviceIndex == index {
+			return cap
+		}
+	}
+	return nil
+}
+
+// runningSessionDevice looks up the {id} session and the capture of its
+// {index} device, writing an error response if either isn't recording. Must
+// be called with recordingMutex held.
+func runningSessionDevice(w http.ResponseWriter, r *http.Request) (*session, *captureDevice) {
+	sess := runningSession(w, r.PathValue("id"))
+	if sess == nil {
+		return nil, nil
+	}
+
+	idx, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid device index")
+		return nil, nil
+	}
+	cap := sess.sessionCapture(idx)
+	if cap == nil {
+		writeError(w, http.StatusNotFound, errCodeDeviceNotFound, fmt.Sprintf("Device %d is not recording in this session", idx))
+		return nil, nil
+	}
+	return sess, cap
+}
+
+// trackPath picks a file for a device joining the session, adding a suffix
+// if the device already recorded a track earlier in the session.
+func (s *session) trackPath(deviceName string) (string, string) {
+	return trackFilename(s.id, s.title, deviceName)
+}
+
+// Handler: POST /api/sessions/{id}/devices - Add a device to a running session
+func handleAddSessionDevice(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	sess := runningSession(w, r.PathValue("id"))
+	if sess == nil {
+		return
+	}
+
+	var req AddDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.DeviceIndex == nil {
+		writeError(w, http.StatusBadRequest, errCodeNoDevices, "No device selected")
+		return
+	}
+	idx := *req.DeviceIndex
+
+	allDevices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if idx < 0 || idx >= len(allDevices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
+		return
+	}
+	if sess.sessionCapture(idx) != nil {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Device is already recording in this session")
+		return
+	}
+
+	selected := allDevices[idx]
+	filename, fullPath := sess.trackPath(selected.name())
+
+	ctx, s := startSpan(r.Context(), "session.add_device", spanKindInternal)
+	s.setAttr("session.id", sess.id)
+	cap, err := startCapture(ctx, selected, fullPath, time.Since(sess.startedAt))
+	s.finish(err)
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	cap.deviceIndex = idx
+	cap.inSession.Store(true)
+	sess.transcript.attach(cap)
+	sess.captures = append(sess.captures, cap)
+	monitoring.sync()
+	liveMix.sync()
+	notifySessionChanged()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "device added",
+		"device": DeviceStartResult{
+			Index:   idx,
+			Name:    cap.name,
+			Started: true,
+			File:    filename,
+		},
+		"startOffsetSeconds": cap.startOffset.Seconds(),
+	})
+}
+
+// Handler: DELETE /api/sessions/{id}/devices/{index} - Stop one device and
+// finalize its track while the rest of the session keeps recording
+func handleRemoveSessionDevice(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	sess, cap := runningSessionDevice(w, r)
+	if cap == nil {
+		return
+	}
+
+	track, err := finalizeTrack(r.Context(), sess, cap)
+	sess.captures = slices.DeleteFunc(sess.captures, func(c *captureDevice) bool { return c == cap })
+	monitoring.sync()
+	liveMix.sync()
+	notifySessionChanged()
+	sess.removed = append(sess.removed, track)
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "device removed",
+		"track":  track,
+	})
+}
+
+// setMuted mutes or unmutes a capture, recording when the mute started and
+// ended. Must be called with recordingMutex held.
+func (s *session) setMuted(cap *captureDevice, muted bool) {
+	if cap.muted.Load() == muted {
+		return
+	}
+	offset := time.Since(s.startedAt).Seconds()
+	if muted {
+		cap.mutes = append(cap.mutes, MuteRange{Start: offset})
+	} else {
+		cap.mutes[len(cap.mutes)-1].End = offset
+	}
+	cap.muted.Store(muted)
+	notifySessionChanged()
+}
+
+// handleMuteSessionDevice returns a handler that mutes or unmutes a device.
+//
+// Handler: POST /api/sessions/{id}/devices/{index}/mute - Replace a device's audio with silence
+// Handler: POST /api/sessions/{id}/devices/{index}/unmute - Resume recording a muted device
+func handleMuteSessionDevice(muted bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recordingMutex.Lock()
+		defer recordingMutex.Unlock()
+
+		sess, cap := runningSessionDevice(w, r)
+		if cap == nil {
+			return
+		}
+
+		sess.setMuted(cap, muted)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device": cap.deviceIndex,
+			"muted":  muted,
+		})
+	}
+}
+
+// MarkerRequest is the request body for adding a marker
+type MarkerRequest struct {
+	Label string `json:"label"`
+}
+
+// markerList returns the session's markers in time order.
+func (s *session) markerList() []Marker {
+	s.markersMu.Lock()
+	defer s.markersMu.Unlock()
+	markers := slices.Clone(s.markers)
+	slices.SortStableFunc(markers, func(a, b Marker) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+	return markers
+}
+
+// Handler: POST /api/sessions/{id}/markers - Mark the current moment in a session
+func handleAddMarker(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	sess := runningSession(w, r.PathValue("id"))
+	if sess == nil {
+		return
+	}
+
+	// The body is optional: an empty request drops an unlabeled marker
+	var req MarkerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+
+	marker := Marker{Time: time.Since(sess.startedAt).Seconds(), Label: req.Label}
+	sess.markersMu.Lock()
+	sess.markers = append(sess.markers, marker)
+	sess.markersMu.Unlock()
+	notifySessionChanged()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(marker)
+}
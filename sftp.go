@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sftpStore stores recordings on an SFTP server through the sftp command, in
+// batch mode, so it authenticates with ssh keys or the agent just like ssh
+// does and never prompts for a password.
+type sftpStore struct {
+	target string // user@host
+	port   string
+	dir    string
+}
+
+// newSFTPStore parses sftp://user@host[:port]/path.
+func newSFTPStore(spec string) (*sftpStore, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SFTP location %q, want sftp://user@host/path", spec)
+	}
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return nil, fmt.Errorf("SFTP storage needs the sftp command")
+	}
+	s := &sftpStore{target: u.Hostname(), port: u.Port(), dir: strings.TrimSuffix(u.Path, "/")}
+	if u.User != nil {
+		s.target = u.User.Username() + "@" + s.target
+	}
+	if s.dir == "" {
+		s.dir = "."
+	}
+	return s, nil
+}
+
+func (s *sftpStore) String() string {
+	host := s.target
+	if s.port != "" {
+		host += ":" + s.port
+	}
+	return "sftp://" + host + "/" + strings.TrimPrefix(s.dir, "/")
+}
+
+// put uploads to a temporary name and renames it into place, so a reader
+// never sees half a file. sftp paces the upload itself, to -upload-limit as
+// it was when the file started, and can't be followed, so t hears of the
+// whole file once it's sent; sftp is killed if t's context is done first.
+func (s *sftpStore) put(name, local string, t *transfer) error {
+	ctx := context.Background()
+	if t != nil {
+		ctx = t.ctx
+	}
+	remote := path.Join(s.dir, name)
+	var args []string
+	if rate := uploadLimit.rate(); rate > 0 {
+		// sftp's limit is in Kbit/s
+		args = []string{"-l", strconv.FormatInt(max(rate*8/1024, 1), 10)}
+	}
+	_, err := s.run(ctx, args,
+		"put "+sftpQuote(local)+" "+sftpQuote(remote+".part"),
+		"rename "+sftpQuote(remote+".part")+" "+sftpQuote(remote),
+	)
+	if err == nil && t != nil && t.sent != nil {
+		if info, statErr := os.Stat(local); statErr == nil {
+			t.sent(int(info.Size()))
+		}
+	}
+	return err
+}
+
+func (s *sftpStore) fetch(name, local string) error {
+	_, err := s.run(context.Background(), nil, "get "+sftpQuote(path.Join(s.dir, name))+" "+sftpQuote(local))
+	return err
+}
+
+func (s *sftpStore) remove(name string) error {
+	_, err := s.run(context.Background(), nil, "rm "+sftpQuote(path.Join(s.dir, name)))
+	return err
+}
+
+// list parses a long listing of the directory: permissions, links, owner,
+// group, size, date and name.
+func (s *sftpStore) list() ([]storedFile, error) {
+	out, err := s.run(context.Background(), nil, "ls -l "+sftpQuote(s.dir))
+	if err != nil {
+		return nil, err
+	}
+	var files []storedFile
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := path.Base(strings.Join(fields[8:], " "))
+		if strings.HasSuffix(name, ".part") {
+			continue
+		}
+		files = append(files, storedFile{Name: name, Size: size, ModTime: parseListingTime(fields[5], fields[6], fields[7])})
+	}
+	return files, nil
+}
+
+// parseListingTime parses ls's date, which has the time of day for recent
+// files and the year for older ones.
+func parseListingTime(month, day, timeOrYear string) time.Time {
+	now := time.Now()
+	if t, err := time.ParseInLocation("Jan 2 2006", month+" "+day+" "+timeOrYear, time.Local); err == nil {
+		return t
+	}
+	t, err := time.ParseInLocation("Jan 2 15:04", month+" "+day+" "+timeOrYear, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 0, 1)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// run runs commands in one sftp session, with any extra sftp options in
+// args, failing on the first that fails. sftp is killed once ctx is done.
+func (s *sftpStore) run(ctx context.Context, args []string, commands ...string) (string, error) {
+	args = append(args, "-q", "-b", "-")
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	cmd := exec.CommandContext(ctx, "sftp", append(args, s.target)...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("sftp: %s", msg)
+		}
+		return "", fmt.Errorf("sftp: %v", err)
+	}
+	return stdout.String(), nil
+}
+
+// sftpQuote quotes a path for an sftp batch command.
+func sftpQuote(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
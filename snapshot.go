@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotTimeout bounds each command run for a system snapshot.
+const snapshotTimeout = 5 * time.Second
+
+// SystemSnapshot describes the system a session was recorded on, saved in
+// its metadata to help answer "why was this track silent?" afterwards. It's
+// taken as the session starts, and only has what the platform can tell
+// without special permissions.
+type SystemSnapshot struct {
+	OS        string `json:"os"`
+	OSVersion string `json:"osVersion,omitempty"`
+	Recorder  string `json:"recorder"`
+
+	// Backends are the audio backends -backend asked for, if any
+	Backends []string `json:"backends,omitempty"`
+
+	// SoundServer is PulseAudio or PipeWire and its version, on Linux
+	SoundServer string `json:"soundServer,omitempty"`
+
+	// Drivers lists sound driver versions: ALSA's on Linux, each media
+	// device's driver on Windows
+	Drivers []string `json:"drivers,omitempty"`
+
+	// AudioApps are the applications playing or recording audio, where the
+	// sound server lists them
+	AudioApps []string `json:"audioApps,omitempty"`
+
+	// Devices are the sources that could be recorded
+	Devices []SnapshotDevice `json:"devices"`
+}
+
+// SnapshotDevice is one source in a system snapshot
+type SnapshotDevice struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default bool   `json:"default,omitempty"`
+}
+
+// takeSystemSnapshot describes the system and the devices on offer. It runs
+// a few commands, so it's called off the request path.
+func takeSystemSnapshot(devices []selectableDevice) *SystemSnapshot {
+	snapshot := &SystemSnapshot{
+		OS:       runtime.GOOS + "/" + runtime.GOARCH,
+		Recorder: recorderVersion(),
+		Devices:  []SnapshotDevice{},
+	}
+	if len(audioBackends) > 0 {
+		snapshot.Backends = strings.Split(backendList{}.String(), ",")
+	}
+	for _, info := range deviceInfoList(devices) {
+		snapshot.Devices = append(snapshot.Devices, SnapshotDevice{
+			Name:    info.Name,
+			Type:    info.Type,
+			Default: devices[info.Index].isAudioDevice() && devices[info.Index].info.IsDefault != 0,
+		})
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		snapshot.OSVersion = linuxVersion()
+		if version := firstLine(readFileString("/proc/asound/version")); version != "" {
+			snapshot.Drivers = append(snapshot.Drivers, version)
+		}
+		info := commandOutput("pactl", "info")
+		name, version := fieldValue(info, "Server Name:"), fieldValue(info, "Server Version:")
+		snapshot.SoundServer = strings.TrimSpace(name + " " + version)
+		snapshot.AudioApps = append(pulseApps("sink-inputs", "playing"), pulseApps("source-outputs", "recording")...)
+	case "darwin":
+		snapshot.OSVersion = "macOS " + strings.TrimSpace(commandOutput("sw_vers", "-productVersion"))
+	case "windows":
+		snapshot.OSVersion = strings.TrimSpace(commandOutput("cmd", "/c", "ver"))
+		drivers := commandOutput("powershell", "-NoProfile", "-Command",
+			"Get-CimInstance Win32_PnPSignedDriver -Filter \"DeviceClass='MEDIA'\" | ForEach-Object { $_.DeviceName + ' ' + $_.DriverVersion }")
+		for _, line := range strings.Split(drivers, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				snapshot.Drivers = append(snapshot.Drivers, line)
+			}
+		}
+	}
+	return snapshot
+}
+
+// recorderVersion is the recorder's module version, or VCS revision for a
+// development build, and the Go version it was built with.
+func recorderVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "skribbl-capture " + runtime.Version()
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && (version == "" || version == "(devel)") {
+			version = setting.Value[:min(12, len(setting.Value))]
+		}
+	}
+	return strings.TrimSpace("skribbl-capture " + version + " " + info.GoVersion)
+}
+
+// linuxVersion is the distribution's name and the kernel release.
+func linuxVersion() string {
+	name := "Linux"
+	scanner := bufio.NewScanner(strings.NewReader(readFileString("/etc/os-release")))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			name = strings.Trim(value, `"`)
+		}
+	}
+	return strings.TrimSpace(name + " " + firstLine(readFileString("/proc/sys/kernel/osrelease")))
+}
+
+// pulseApps lists the applications with a PulseAudio/PipeWire stream of the
+// given kind, each noted as doing what.
+func pulseApps(kind, doing string) []string {
+	var apps []string
+	scanner := bufio.NewScanner(strings.NewReader(commandOutput("pactl", "list", kind)))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "application.name = ")
+		if !ok {
+			continue
+		}
+		app := strings.Trim(value, `"`) + " (" + doing + ")"
+		if !slices.Contains(apps, app) {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// commandOutput runs a command for its output, or "" if it can't be run.
+func commandOutput(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// readFileString reads a small file, or "" if it can't be read.
+func readFileString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// firstLine is the first line of s, trimmed.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}
+
+// fieldValue finds the line of s starting with label and returns the rest.
+func fieldValue(s, label string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), label); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
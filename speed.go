@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minSpeed and maxSpeed bound what a speed job can play tracks back at.
+	// Past them, time-stretching smears speech too much to follow.
+	minSpeed = 0.5
+	maxSpeed = 4
+
+	// speedDefaultFormat is what a speed job writes without a format: small,
+	// since the copies are for listening through rather than editing
+	speedDefaultFormat = "opus"
+)
+
+// atempoFilter is the ffmpeg filter that changes tempo by speed without
+// changing pitch. Older ffmpegs take at most 2x per atempo, so faster speeds
+// are chained.
+func atempoFilter(speed float64) string {
+	var stages []string
+	for speed > 2 {
+		stages = append(stages, "atempo=2")
+		speed /= 2
+	}
+	stages = append(stages, "atempo="+strconv.FormatFloat(speed, 'f', -1, 64))
+	return strings.Join(stages, ",")
+}
+
+// speedSuffix names a copy played at speed, such as _1.5x.
+func speedSuffix(speed float64) string {
+	return "_" + strconv.FormatFloat(speed, 'f', -1, 64) + "x"
+}
+
+// validSpeed reports whether a speed job can play tracks back at speed.
+func validSpeed(speed float64) bool {
+	return speed >= minSpeed && speed <= maxSpeed && speed != 1 && !math.IsNaN(speed)
+}
+
+// runSpeed writes copies of tracks sped up or slowed down with their pitch
+// kept, as <track>_<speed>x in the job's format, for listening back through
+// long sessions quickly.
+func runSpeed(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	format := transcodeFormats[job.Format]
+	format.args = append([]string{"-af", atempoFilter(job.Speed)}, format.args...)
+	tracks, err := jobTracks(job)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportFile
+	var releases []func()
+	fail := func(err error) ([]string, error) {
+		for _, release := range releases {
+			release()
+		}
+		for _, e := range exports {
+			os.Remove(filepath.Join(outputDirectory, e.File))
+		}
+		return nil, err
+	}
+	for i, track := range tracks {
+		in, err := openRecording(track.File)
+		if err != nil {
+			return fail(err)
+		}
+		in.Close()
+		name, out, release, err := createExportFile(trackBase(track)+speedSuffix(job.Speed), format.ext)
+		if err != nil {
+			return fail(err)
+		}
+		out.Close()
+		releases = append(releases, release)
+		exports = append(exports, ExportFile{Kind: jobSpeed, File: name, Device: track.Device, Source: track.File})
+
+		// ffmpeg reports progress in output time, which is shorter or longer
+		// than the track by the speed
+		played := track
+		played.DurationSeconds /= job.Speed
+		err = ffmpegTranscode(ctx, in.Name(), filepath.Join(outputDirectory, name), format, played, func(p float64) {
+			progress((float64(i) + p) / float64(len(tracks)))
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return addExports(job.SessionID, exports, releases)
+}
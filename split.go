@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitWindow is the stretch of audio -by-silence measures the level of at a
+// time.
+const splitWindow = 50 * time.Millisecond
+
+// SplitPart is one of the files split cut a recording into
+type SplitPart struct {
+	File            string  `json:"file"`
+	StartSeconds    float64 `json:"startSeconds"` // where it was in the original
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// Session is the session written for the part, when the original was
+	// a session's track
+	Session string `json:"session,omitempty"`
+}
+
+// riffChunk is a chunk to write into a WAV file
+type riffChunk struct {
+	id   string
+	body []byte
+}
+
+// runSplit cuts a WAV recording into parts at given times, or in the middle
+// of its silences, leaving the original as it is. Each part keeps the
+// original's format and metadata, and if the original is a session's track,
+// gets a session of its own with the markers and transcript that fall in it.
+func runSplit(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	at := fs.String("at", "", "times to split at, comma-separated, such as 10m,25m30s")
+	bySilence := fs.Bool("by-silence", false, "split in the middle of each silence instead of at given times")
+	threshold := fs.Float64("silence-threshold", -50, "level in dBFS below which -by-silence counts audio as silent")
+	minSilence := fs.Duration("silence-min", 2*time.Second, "shortest silence -by-silence splits at")
+	fs.StringVar(&outputDirectory, "output", outputDirectory, "directory of the sessions whose metadata is carried over to the parts")
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: skribbl-capture split [flags] file")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*at == "") == !*bySilence {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	info, err := probeAudio(f)
+	if err == nil && (info.Format != "wav" || info.formatTag != wavFormatPCM) {
+		err = errors.New("only PCM WAV files can be split")
+	}
+	if err != nil {
+		uiWarnf("✗ %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	var cuts []float64
+	if *at != "" {
+		cuts, err = parseSplitTimes(*at, info.DurationSeconds)
+	} else {
+		cuts, err = silenceCuts(f, info, *threshold, *minSilence)
+		if err == nil && len(cuts) == 0 {
+			err = fmt.Errorf("no silences of %s or longer below %g dBFS to split at", *minSilence, *threshold)
+		}
+	}
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+
+	parts, err := splitWAV(path, f, info, cuts)
+	if err != nil {
+		uiWarnf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	if manifest, track, ok := sessionOfTrack(path); ok {
+		for i := range parts {
+			id, err := partSession(manifest, track, &parts[i], i+1, filepath.Dir(path))
+			if err != nil {
+				uiWarnf("⚠️  %s: %v\n", parts[i].File, err)
+				continue
+			}
+			parts[i].Session = id
+		}
+	}
+
+	for _, part := range parts {
+		uiPrintf("✓ %s (%s from %s)\n", part.File, subtitleTime(part.DurationSeconds, "."), subtitleTime(part.StartSeconds, "."))
+		if part.Session != "" {
+			uiVerbosef("    session %s\n", part.Session)
+		}
+	}
+	printResult(map[string]interface{}{"parts": parts})
+}
+
+// parseSplitTimes parses -at: durations such as 10m or 25m30s, or plain
+// seconds, in order and within the recording.
+func parseSplitTimes(value string, duration float64) ([]float64, error) {
+	var cuts []float64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		seconds, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			d, derr := time.ParseDuration(field)
+			if derr != nil {
+				return nil, fmt.Errorf("invalid -at time %q: use durations such as 10m or 25m30s", field)
+			}
+			seconds = d.Seconds()
+		}
+		if seconds <= 0 || seconds >= duration {
+			return nil, fmt.Errorf("-at time %s isn't within the recording's %s", field, subtitleTime(duration, "."))
+		}
+		if len(cuts) > 0 && seconds <= cuts[len(cuts)-1] {
+			return nil, errors.New("-at times must be in order")
+		}
+		cuts = append(cuts, seconds)
+	}
+	return cuts, nil
+}
+
+// silenceCuts finds the silences of at least minSilence between the sound,
+// and returns the middle of each one. Silence at the very start or end is
+// left where it is.
+func silenceCuts(f *os.File, info AudioInfo, thresholdDB float64, minSilence time.Duration) ([]float64, error) {
+	pcm, err := openPCM16(f, info)
+	if err != nil {
+		return nil, err
+	}
+	window := make([]byte, int(float64(info.SampleRate)*splitWindow.Seconds())*int(info.Channels)*2)
+	minWindows := int(minSilence / splitWindow)
+	var cuts []float64
+	heardSound := false
+	silentFrom, silent := 0, 0
+	for n := 0; ; n++ {
+		read, err := io.ReadFull(pcm, window)
+		if read == 0 {
+			break
+		}
+		peak := 0
+		for i := 0; i+1 < read; i += 2 {
+			sample := int(int16(binary.LittleEndian.Uint16(window[i:])))
+			peak = max(peak, sample, -sample)
+		}
+		if toDBFS(float64(peak)/32768) < thresholdDB {
+			if silent == 0 {
+				silentFrom = n
+			}
+			silent++
+		} else {
+			if heardSound && silent >= minWindows {
+				middle := float64(silentFrom) + float64(silent)/2
+				cuts = append(cuts, middle*splitWindow.Seconds())
+			}
+			heardSound, silent = true, 0
+		}
+		if err != nil {
+			break
+		}
+	}
+	return cuts, nil
+}
+
+// splitWAV writes the parts of a WAV file between the cuts, in seconds, next
+// to it as <name>_part1.wav and so on. Each keeps the original's format,
+// bext and INFO chunks, with the bext time reference moved on to where the
+// part starts, and the cue points that fall in it.
+func splitWAV(path string, f *os.File, info AudioInfo, cuts []float64) ([]SplitPart, error) {
+	inspected, err := inspectAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := int64(info.Channels * info.BitsPerSample / 8)
+	totalFrames := info.dataSize / frameSize
+
+	var formatBody, bextBody, infoBody []byte
+	for _, c := range inspected.Chunks {
+		if c.Size > maxMetadataChunk {
+			continue
+		}
+		body := make([]byte, c.Size)
+		if _, err := f.ReadAt(body, c.Offset+8); err != nil {
+			continue
+		}
+		switch {
+		case c.ID == "fmt " && formatBody == nil:
+			formatBody = body
+		case c.ID == "bext" && len(body) >= 602:
+			bextBody = body
+		case c.ID == "LIST" && strings.HasPrefix(string(body), "INFO"):
+			infoBody = body
+		}
+	}
+
+	bounds := []int64{0}
+	for _, cut := range cuts {
+		frame := int64(math.Round(cut * float64(info.SampleRate)))
+		if frame > bounds[len(bounds)-1] && frame < totalFrames {
+			bounds = append(bounds, frame)
+		}
+	}
+	bounds = append(bounds, totalFrames)
+
+	dir := filepath.Dir(path)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var parts []SplitPart
+	for i := 0; i+1 < len(bounds); i++ {
+		start, frames := bounds[i], bounds[i+1]-bounds[i]
+		part := SplitPart{
+			File:            uniqueFilename(dir, fmt.Sprintf("%s_part%d", base, i+1), ".wav"),
+			StartSeconds:    float64(start) / float64(info.SampleRate),
+			DurationSeconds: float64(frames) / float64(info.SampleRate),
+		}
+
+		before := []riffChunk{{"fmt ", formatBody}}
+		if bextBody != nil {
+			bext := append([]byte(nil), bextBody...)
+			reference := binary.LittleEndian.Uint64(bext[338:])
+			binary.LittleEndian.PutUint64(bext[338:], reference+uint64(start))
+			before = append(before, riffChunk{"bext", bext})
+		}
+		if infoBody != nil {
+			before = append(before, riffChunk{"LIST", infoBody})
+		}
+		after := cueChunks(inspected.Cues, info.SampleRate, start, frames)
+
+		data := io.NewSectionReader(f, info.dataOffset+start*frameSize, frames*frameSize)
+		if err := writeWAVFile(filepath.Join(dir, part.File), before, data, frames*frameSize, after); err != nil {
+			for _, written := range parts {
+				os.Remove(filepath.Join(dir, written.File))
+			}
+			return nil, fmt.Errorf("failed to write %s: %w", part.File, err)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// cueChunks are the cue and LIST adtl chunks for the cue points between
+// start and start+frames, moved to be from start.
+func cueChunks(cues []WAVCue, sampleRate uint32, start, frames int64) []riffChunk {
+	var cue, adtl []byte
+	count := uint32(0)
+	for _, c := range cues {
+		if int64(c.Sample) < start || int64(c.Sample) >= start+frames {
+			continue
+		}
+		count++
+		point := make([]byte, 24)
+		binary.LittleEndian.PutUint32(point[0:], c.ID)
+		copy(point[8:], "data")
+		binary.LittleEndian.PutUint32(point[20:], uint32(int64(c.Sample)-start))
+		cue = append(cue, point...)
+
+		if c.Label != "" {
+			adtl = appendRIFFChunk(adtl, "labl", append(binary.LittleEndian.AppendUint32(nil, c.ID), c.Label+"\x00"...))
+		}
+		if c.Note != "" {
+			adtl = appendRIFFChunk(adtl, "note", append(binary.LittleEndian.AppendUint32(nil, c.ID), c.Note+"\x00"...))
+		}
+		if c.LengthSeconds > 0 {
+			ltxt := binary.LittleEndian.AppendUint32(nil, c.ID)
+			ltxt = binary.LittleEndian.AppendUint32(ltxt, uint32(math.Round(c.LengthSeconds*float64(sampleRate))))
+			ltxt = append(ltxt, "rgn \x00\x00\x00\x00\x00\x00\x00\x00"...)
+			adtl = appendRIFFChunk(adtl, "ltxt", ltxt)
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	chunks := []riffChunk{{"cue ", append(binary.LittleEndian.AppendUint32(nil, count), cue...)}}
+	if adtl != nil {
+		chunks = append(chunks, riffChunk{"LIST", append([]byte("adtl"), adtl...)})
+	}
+	return chunks
+}
+
+// appendRIFFChunk appends a chunk's header, body and padding to b.
+func appendRIFFChunk(b []byte, id string, body []byte) []byte {
+	b = append(b, id...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)))
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// writeWAVFile writes a WAV file of the chunks before the audio, dataSize
+// bytes of audio from data, and the chunks after it, with every size filled
+// in.
+func writeWAVFile(path string, before []riffChunk, data io.Reader, dataSize int64, after []riffChunk) error {
+	var head, tail []byte
+	for _, c := range before {
+		head = appendRIFFChunk(head, c.id, c.body)
+	}
+	for _, c := range after {
+		tail = appendRIFFChunk(tail, c.id, c.body)
+	}
+	riffSize := 4 + int64(len(head)) + 8 + dataSize + dataSize%2 + int64(len(tail))
+	if riffSize > math.MaxUint32 {
+		return errors.New("too large for a WAV file")
+	}
+
+	out, err := createNewFile(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	w.WriteString("RIFF")
+	binary.Write(w, binary.LittleEndian, uint32(riffSize))
+	w.WriteString("WAVE")
+	w.Write(head)
+	w.WriteString("data")
+	binary.Write(w, binary.LittleEndian, uint32(dataSize))
+	if _, err := io.CopyN(w, data, dataSize); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	if dataSize%2 == 1 {
+		w.WriteByte(0)
+	}
+	w.Write(tail)
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// sessionOfTrack finds the session a file in the output directory is a track
+// of.
+func sessionOfTrack(path string) (*SessionManifest, TrackInfo, bool) {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, TrackInfo{}, false
+	}
+	if out, err := filepath.Abs(outputDirectory); err != nil || out != dir {
+		return nil, TrackInfo{}, false
+	}
+	name := filepath.Base(path)
+	for _, manifest := range loadCatalog() {
+		for _, track := range manifest.Tracks {
+			if track.File == name {
+				return manifest, track, true
+			}
+		}
+	}
+	return nil, TrackInfo{}, false
+}
+
+// partSession writes a session for the numbered part of a session's track,
+// titled after it, with the markers and transcript that fall in the part moved to
+// be from its start.
+func partSession(manifest *SessionManifest, track TrackInfo, part *SplitPart, number int, dir string) (string, error) {
+	size, sum, err := checksumFile(filepath.Join(dir, part.File))
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum: %w", err)
+	}
+	base := fmt.Sprintf("%s_part%d", manifest.ID, number)
+	id := base
+	for n := 2; sessionIDTaken(id); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	// Markers and transcripts are timed from the session's start, where the
+	// track may not have started
+	from := track.StartOffset + part.StartSeconds
+	to := from + part.DurationSeconds
+	started := manifest.StartedAt.Add(time.Duration(from * float64(time.Second)))
+	title := cmp.Or(manifest.Title, manifest.ID)
+	partManifest := &SessionManifest{
+		ID:        id,
+		Title:     fmt.Sprintf("%s (part %d)", title, number),
+		Tags:      manifest.Tags,
+		StartedAt: started,
+		StoppedAt: started.Add(time.Duration(part.DurationSeconds * float64(time.Second))),
+		Preset:    manifest.Preset,
+		Source:    manifest.Source,
+		System:    manifest.System,
+		Tracks: []TrackInfo{{
+			File:            part.File,
+			Device:          track.Device,
+			Speaker:         track.Speaker,
+			Type:            track.Type,
+			SampleRate:      track.SampleRate,
+			Channels:        track.Channels,
+			BitsPerSample:   track.BitsPerSample,
+			Size:            size,
+			DurationSeconds: part.DurationSeconds,
+			SHA256:          sum,
+			GainDB:          track.GainDB,
+			InputChannels:   track.InputChannels,
+			Format:          track.Format,
+		}},
+	}
+	for _, m := range manifest.Markers {
+		if m.Time >= from && m.Time < to {
+			m.Time -= from
+			partManifest.Markers = append(partManifest.Markers, m)
+		}
+	}
+
+	if segments, err := readTranscript(manifest.ID); err == nil {
+		var kept []TranscriptSegment
+		for _, s := range segments {
+			if s.File != track.File || s.Start < from || s.Start >= to {
+				continue
+			}
+			s.File, s.Start, s.End = part.File, s.Start-from, s.End-from
+			for i := range s.Words {
+				s.Words[i].Start -= from
+				s.Words[i].End -= from
+			}
+			kept = append(kept, s)
+		}
+		if kept != nil {
+			data, _ := json.MarshalIndent(kept, "", "  ")
+			if err := writeFileAtomic(transcriptPath(id), append(data, '\n')); err != nil {
+				return "", fmt.Errorf("failed to write transcript: %w", err)
+			}
+			partManifest.Transcript = filepath.Base(transcriptPath(id))
+		}
+	}
+	return id, writeSessionManifest(partManifest)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Handler: PUT|DELETE /api/sessions/{id}/star - Star a session, or unstar
+// it. Starred sessions are easy to find with ?starred=true, and are never
+// archived by -archive-after.
+func handleStarSession(starred bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !validSessionID(id) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+			return
+		}
+		manifestMu.Lock()
+		defer manifestMu.Unlock()
+		manifest, err := readSessionManifest(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+			return
+		}
+		if manifest.Starred != starred {
+			manifest.Starred = starred
+			if err := writeSessionManifest(manifest); err != nil {
+				writeStorageError(w, errCodeInternal, fmt.Errorf("failed to save session metadata: %w", err))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// starredFilter reads ?starred=, returning nil if it's not given.
+func starredFilter(r *http.Request) (*bool, error) {
+	value := r.URL.Query().Get("starred")
+	if value == "" {
+		return nil, nil
+	}
+	starred, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("starred must be true or false")
+	}
+	return &starred, nil
+}
+
+// starredFiles lists the files of starred sessions.
+func starredFiles() map[string]bool {
+	files := map[string]bool{}
+	for _, manifest := range loadCatalog() {
+		if !manifest.Starred {
+			continue
+		}
+		for _, name := range manifest.files() {
+			files[name] = true
+		}
+	}
+	return files
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxMixLag is how far ahead of the first capture the others may get before
+// their oldest audio is dropped, so a device with a faster clock can't grow
+// the mixer's buffers forever.
+const maxMixLag = time.Second
+
+// stdoutMixer writes the CLI's captures to standard output as one mono
+// stream, mixing them when there are several. The first capture paces the
+// output: each of its writes is mixed with whatever the others have sent
+// since, and silence where they're behind.
+type stdoutMixer struct {
+	mu      sync.Mutex
+	out     io.Writer
+	pending [][]byte
+	mixed   []byte
+	maxLag  int
+
+	// failed is closed when standard output can't be written any more,
+	// usually because the program reading it has exited
+	failed chan struct{}
+	err    error
+}
+
+// newStdoutMixer starts a stream of inputs captures to out, with a WAV header
+// unless raw is set. The header's sizes are the largest possible, as the
+// length isn't known in advance; ffmpeg and sox read such a stream to the end.
+func newStdoutMixer(out io.Writer, inputs int, sampleRate uint32, raw bool) (*stdoutMixer, error) {
+	if !raw {
+		if err := writeWAVHeader(out, sampleRate, 1, 16, math.MaxUint32-36); err != nil {
+			return nil, fmt.Errorf("failed to write WAV header: %w", err)
+		}
+	}
+	return &stdoutMixer{
+		out:     out,
+		pending: make([][]byte, inputs),
+		maxLag:  int(maxMixLag.Seconds()*float64(sampleRate)) * 2,
+		failed:  make(chan struct{}),
+	}, nil
+}
+
+// input returns the writer for the i'th capture, to use as its sink.
+func (m *stdoutMixer) input(i int) io.Writer {
+	return mixerInput{m, i}
+}
+
+// mixerInput is one capture's way into the mixer
+type mixerInput struct {
+	m *stdoutMixer
+	i int
+}
+
+// Write takes S16 mono audio from a capture. Once standard output has failed
+// the audio is thrown away, so the capture's writer doesn't report an error
+// for every chunk while the CLI stops.
+func (in mixerInput) Write(p []byte) (int, error) {
+	m := in.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return len(p), nil
+	}
+
+	if in.i > 0 {
+		m.pending[in.i] = append(m.pending[in.i], p...)
+		if excess := len(m.pending[in.i]) - m.maxLag; excess > 0 {
+			m.pending[in.i] = append(m.pending[in.i][:0], m.pending[in.i][(excess+1)&^1:]...)
+		}
+		return len(p), nil
+	}
+
+	m.mixed = append(m.mixed[:0], p...)
+	for j := 1; j < len(m.pending); j++ {
+		n := min(len(m.pending[j]), len(m.mixed)) &^ 1
+		for k := 0; k < n; k += 2 {
+			sum := int32(int16(uint16(m.mixed[k])|uint16(m.mixed[k+1])<<8)) +
+				int32(int16(uint16(m.pending[j][k])|uint16(m.pending[j][k+1])<<8))
+			sum = max(min(sum, math.MaxInt16), math.MinInt16)
+			m.mixed[k], m.mixed[k+1] = byte(sum), byte(sum>>8)
+		}
+		m.pending[j] = append(m.pending[j][:0], m.pending[j][n:]...)
+	}
+	if _, err := m.out.Write(m.mixed); err != nil {
+		m.err = err
+		close(m.failed)
+	}
+	return len(p), nil
+}
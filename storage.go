@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingStore holds finished recordings somewhere other than the output
+// directory: another directory, an S3 bucket or an SFTP server. Sessions are
+// still recorded to the output directory, which only stages them until
+// they're stored.
+type recordingStore interface {
+	// put stores the local file at path as name, following t if it isn't
+	// nil
+	put(name, path string, t *transfer) error
+
+	// fetch copies the stored file name to the local path
+	fetch(name, path string) error
+
+	// list describes every stored file
+	list() ([]storedFile, error)
+
+	// remove deletes the stored file name
+	remove(name string) error
+
+	String() string
+}
+
+// storedFile is one file in a recordingStore
+type storedFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// store is where finished recordings go, from -storage. Nil keeps them in
+// the output directory.
+var store recordingStore
+
+// keepLocalCopies keeps audio in the output directory after it's stored,
+// from -storage-keep-local.
+var keepLocalCopies bool
+
+// stored tracks what's already in the store, by name, with its size, so
+// files aren't uploaded twice.
+var stored = struct {
+	sync.Mutex
+	files map[string]int64
+}{files: map[string]int64{}}
+
+// storeMutex lets one session's files be stored at a time.
+var storeMutex sync.Mutex
+
+// transfer follows a file being put in a store: it's abandoned once ctx is
+// done, and sent, if set, is told of every piece read from the file.
+type transfer struct {
+	ctx  context.Context
+	sent func(n int)
+}
+
+// reader follows reads from r. A nil transfer doesn't.
+func (t *transfer) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &transferReader{r: r, t: t}
+}
+
+type transferReader struct {
+	r io.Reader
+	t *transfer
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	if err := r.t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 && r.t.sent != nil {
+		r.t.sent(n)
+	}
+	return n, err
+}
+
+// openStore opens the store spec describes: s3://bucket/prefix,
+// sftp://user@host[:port]/path, or a directory.
+func openStore(spec string) (recordingStore, error) {
+	switch {
+	case strings.HasPrefix(spec, "s3://"):
+		return newS3Store(spec)
+	case strings.HasPrefix(spec, "sftp://"):
+		return newSFTPStore(spec)
+	}
+	dir := strings.TrimPrefix(spec, "file://")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	return localStore{dir: dir}, nil
+}
+
+// initStore lists what's in the store and copies down the metadata sidecars
+// the output directory is missing, so a fresh container's catalog has every
+// stored session. Sessions a previous run didn't finish storing are queued
+// for upload.
+func initStore() error {
+	files, err := store.list()
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", store, err)
+	}
+	sidecars := 0
+	stored.Lock()
+	for _, f := range files {
+		stored.files[f.Name] = f.Size
+	}
+	stored.Unlock()
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name, ".json")
+		if !ok || !validSessionID(id) {
+			continue
+		}
+		path := sessionManifestPath(id)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := fetchFile(store, f.Name, path); err != nil {
+			return fmt.Errorf("failed to fetch %s: %v", f.Name, err)
+		}
+		sidecars++
+	}
+	fmt.Printf("✓ Storing recordings in %s (%d files, %d sessions fetched)\n", store, len(files), sidecars)
+
+	for _, manifest := range loadCatalog() {
+		if !isStored(manifest.ID+".json") || slices.ContainsFunc(manifest.files(), func(name string) bool { return !isStored(name) }) {
+			queueUpload(manifest.ID)
+		}
+	}
+	return nil
+}
+
+// storeSession copies a session's files to the store, then its sidecar, so
+// a stored sidecar never names a file that isn't stored, and returns what it
+// copied, telling up how it's going. Stored audio is removed from the output
+// directory unless -storage-keep-local is set. JSON files such as the
+// sidecar and transcript are small and read from there, so they're always
+// kept.
+func storeSession(ctx context.Context, id string, files []string, up *uploadProgress, progress func(float64)) ([]string, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	files = append(files, id+".json")
+	toCopy := func(name string) bool {
+		return name == id+".json" || !isStored(name)
+	}
+	count, total := 0, int64(0)
+	for _, name := range files {
+		if info, err := os.Stat(filepath.Join(outputDirectory, name)); err == nil && toCopy(name) {
+			count++
+			total += info.Size()
+		}
+	}
+	up.begin(count, total)
+
+	var copied []string
+	for i, name := range files {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		progress(float64(i) / float64(len(files)))
+		path := filepath.Join(outputDirectory, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			// Already stored and removed
+			continue
+		}
+		if toCopy(name) {
+			up.startFile(name)
+			_, s := startSpan(ctx, "store.put", spanKindInternal)
+			s.setAttr("file.name", name)
+			s.setAttr("file.size", info.Size())
+			s.setAttr("store", store.String())
+			err := store.put(name, path, &transfer{ctx: ctx, sent: up.add})
+			s.finish(err)
+			if err != nil {
+				if ctx.Err() != nil {
+					return copied, ctx.Err()
+				}
+				return copied, fmt.Errorf("failed to store %s in %s: %v", name, store, err)
+			}
+			up.finishFile()
+			stored.Lock()
+			stored.files[name] = info.Size()
+			stored.Unlock()
+			copied = append(copied, name)
+		}
+		if !keepLocalCopies && !strings.HasSuffix(name, ".json") {
+			os.Remove(path)
+		}
+	}
+	return copied, nil
+}
+
+// queueUpload queues a job to copy a session's files to the store.
+func queueUpload(id string) {
+	if _, err := enqueueJob(JobRequest{Kind: jobUpload, SessionID: id}); err != nil {
+		fmt.Printf("⚠️  Failed to queue upload of %s: %v\n", id, err)
+	}
+}
+
+// isStored reports whether the file in the output directory called name is
+// in the store, or has already been moved there.
+func isStored(name string) bool {
+	info, err := os.Stat(filepath.Join(outputDirectory, name))
+	if err != nil {
+		return true
+	}
+	stored.Lock()
+	defer stored.Unlock()
+	size, ok := stored.files[name]
+	return ok && size == info.Size()
+}
+
+// fetchFile copies a file in s to path, through a temporary file so an
+// interrupted copy isn't mistaken for the whole file.
+func fetchFile(s recordingStore, name, path string) error {
+	tmp := path + ".part"
+	if err := s.fetch(name, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fetchCached opens a stored file through a cache in the temporary
+// directory, fetching it the first time it's asked for.
+func fetchCached(name string) (*os.File, error) {
+	stored.Lock()
+	_, ok := stored.files[name]
+	stored.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if err := os.MkdirAll(storeCacheDir(), 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(storeCacheDir(), name)
+	if f, err := os.Open(path); err == nil {
+		return f, nil
+	}
+	if err := fetchFile(store, name, path); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from %s: %v", name, store, err)
+	}
+	return os.Open(path)
+}
+
+// storeCacheDir is where fetchCached keeps stored files.
+func storeCacheDir() string {
+	return filepath.Join(os.TempDir(), "skribbl-capture-cache")
+}
+
+// unstore deletes a file from the store and the cache of it.
+func unstore(name string) error {
+	stored.Lock()
+	_, ok := stored.files[name]
+	delete(stored.files, name)
+	stored.Unlock()
+	os.Remove(filepath.Join(storeCacheDir(), name))
+	if !ok {
+		return nil
+	}
+	if err := store.remove(name); err != nil {
+		return fmt.Errorf("failed to remove %s from %s: %v", name, store, err)
+	}
+	return nil
+}
+
+// storedAudio describes the stored audio files, for the recordings list.
+func storedAudio() []storedFile {
+	if store == nil {
+		return nil
+	}
+	files, err := store.list()
+	if err != nil {
+		fmt.Printf("⚠️  Failed to list %s: %v\n", store, err)
+		return nil
+	}
+	var audio []storedFile
+	for _, f := range files {
+		if isAudioFile(f.Name) {
+			audio = append(audio, f)
+		}
+	}
+	return audio
+}
+
+// localStore stores recordings in another directory, such as a network
+// share, with the output directory on fast local disk.
+type localStore struct {
+	dir string
+}
+
+func (s localStore) String() string { return s.dir }
+
+func (s localStore) put(name, path string, t *transfer) error {
+	dest := filepath.Join(s.dir, name)
+	if err := copyFile(path, dest+".part", uploadLimit, t); err != nil {
+		os.Remove(dest + ".part")
+		return err
+	}
+	return os.Rename(dest+".part", dest)
+}
+
+func (s localStore) fetch(name, path string) error {
+	return copyFile(filepath.Join(s.dir, name), path, nil, nil)
+}
+
+func (s localStore) remove(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+func (s localStore) list() ([]storedFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []storedFile
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		files = append(files, storedFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// copyFile copies the file at src to dst, replacing it, paced by limit and
+// followed by t if they aren't nil.
+func copyFile(src, dst string, limit *bandwidthLimit, t *transfer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, t.reader(limit.reader(in))); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runUpload copies a session's files to the store.
+func runUpload(ctx context.Context, job Job, progress func(float64)) ([]string, error) {
+	manifest, err := readSessionManifest(job.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+	return storeSession(ctx, manifest.ID, manifest.files(), job.upload, progress)
+}
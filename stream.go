@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamStartTimeout is how long a network stream has to deliver its first
+// audio before starting it is given up on.
+const streamStartTimeout = 10 * time.Second
+
+// streams are the network streams offered as sources, from repeated -stream
+// flags.
+var streams streamList
+
+// streamSource is a network audio stream recorded like a device
+type streamSource struct {
+	Name string
+	URL  string
+}
+
+// streamList collects repeated -stream flags, each "name=url" or just a URL.
+type streamList []streamSource
+
+func (l *streamList) String() string {
+	var urls []string
+	for _, s := range *l {
+		urls = append(urls, s.Name+"="+s.URL)
+	}
+	return strings.Join(urls, ",")
+}
+
+func (l *streamList) Set(value string) error {
+	source := streamSource{URL: value}
+	// A name comes before an = that isn't part of the URL
+	if i := strings.Index(value, "="); i > 0 && !strings.Contains(value[:i], "://") {
+		source.Name, source.URL = strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	}
+	u, err := url.Parse(source.URL)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("invalid stream URL %q", source.URL)
+	}
+	if source.Name == "" {
+		source.Name = u.Host + u.Path
+	}
+	*l = append(*l, source)
+	return nil
+}
+
+// streamSources lists the -stream sources.
+func streamSources() []selectableDevice {
+	var sources []selectableDevice
+	for i := range streams {
+		sources = append(sources, selectableDevice{stream: &streams[i]})
+	}
+	return sources
+}
+
+// recordStream records the capture's network stream by having ffmpeg decode
+// it to raw PCM on stdout. When starting, it waits for audio to arrive, so a
+// stream that can't be reached fails the start like a missing device would.
+func (c *captureDevice) recordStream(waitForAudio bool) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("network streams need ffmpeg installed")
+	}
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error"}
+	switch {
+	case strings.HasPrefix(c.source.stream.URL, "rtsp://"):
+		args = append(args, "-rtsp_transport", "tcp")
+	case strings.HasSuffix(c.source.stream.URL, ".sdp"):
+		// RTP with a dynamic payload type is described by an SDP file
+		args = append(args, "-protocol_whitelist", "file,http,https,tcp,udp,rtp")
+	}
+	// With -stream-limit, HTTP streams are fetched here and paced on their
+	// way to ffmpeg
+	input := c.source.stream.URL
+	var body io.ReadCloser
+	if streamLimitKB > 0 && (strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")) {
+		resp, err := openLimitedStream(input)
+		if err != nil {
+			return err
+		}
+		body, input = resp, "pipe:0"
+	}
+	args = append(args, "-i", input, "-vn",
+		"-ac", strconv.Itoa(int(c.channels)), "-ar", strconv.Itoa(int(c.sampleRate)),
+		"-f", "s16le", "-")
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if body != nil {
+		cmd.Stdin = (&bandwidthLimit{rate: func() int64 { return streamLimitKB << 10 }}).reader(body)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return err
+	}
+	started := c.lastCallback.Load()
+	if err := cmd.Start(); err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.readPCM(stdout)
+	}()
+	stop := func() {
+		cmd.Process.Kill()
+		if body != nil {
+			// Wait waits for stdin to be copied, which a stalled stream
+			// would hold up
+			body.Close()
+		}
+		<-done
+		cmd.Wait()
+	}
+
+	if !waitForAudio {
+		c.stopSource = stop
+		return nil
+	}
+
+	// Wait for the first audio, which moves lastCallback on
+	deadline := time.Now().Add(streamStartTimeout)
+	for c.lastCallback.Load() == started {
+		select {
+		case <-done:
+			stop()
+			return fmt.Errorf("ffmpeg exited: %s", strings.TrimSpace(stderr.String()))
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return fmt.Errorf("no audio from %s within %v", c.source.stream.Name, streamStartTimeout)
+		}
+	}
+	c.stopSource = stop
+	return nil
+}
+
+// openLimitedStream starts downloading an HTTP stream, giving up if it
+// hasn't answered within streamStartTimeout.
+func openLimitedStream(streamURL string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(streamStartTimeout, cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !timer.Stop() && err == nil {
+		resp.Body.Close()
+		err = context.Canceled
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open %s: %v", streamURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to open %s: %s", streamURL, resp.Status)
+	}
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+// cancelOnClose cancels a request's context when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamDeckTick is how often state is pushed so keys can show elapsed time.
+const streamDeckTick = time.Second
+
+// StreamDeckState is pushed whenever the recording changes and every second
+// while connected, with everything a key needs to draw itself
+type StreamDeckState struct {
+	Event          string `json:"event"` // always "state"
+	IsRecording    bool   `json:"isRecording"`
+	SessionID      string `json:"sessionId,omitempty"`
+	ElapsedSeconds int    `json:"elapsedSeconds"`
+	Elapsed        string `json:"elapsed"` // e.g. "12:34", for the key title
+	Devices        int    `json:"devices"`
+	Markers        int    `json:"markers"`
+}
+
+// formatElapsed renders a duration as M:SS, or H:MM:SS past an hour.
+func formatElapsed(d time.Duration) string {
+	s := int(d.Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// streamDeckState snapshots the recording state, along with the channel that
+// will be closed when it next changes.
+func streamDeckState() (StreamDeckState, <-chan struct{}) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	state := StreamDeckState{Event: "state", Elapsed: formatElapsed(0)}
+	if activeSession != nil {
+		elapsed := time.Since(activeSession.startedAt)
+		state.IsRecording = true
+		state.SessionID = activeSession.id
+		state.ElapsedSeconds = int(elapsed.Seconds())
+		state.Elapsed = formatElapsed(elapsed)
+		state.Devices = len(activeSession.captures)
+		state.Markers = len(activeSession.markerList())
+	}
+	return state, sessionChanged
+}
+
+// Handler: GET /api/streamdeck - WebSocket for Stream Deck plugins. Pushes
+// state on every change and once a second, along with any alerts, and accepts
+// toggle/start/stop/marker commands.
+func handleStreamDeck(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	// The request context ends when the handler returns, which is too soon to
+	// notice a hijacked connection dropping, so the reader signals instead
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var cmd RemoteCommand
+			var result CommandResult
+			if err := json.Unmarshal(message, &cmd); err != nil {
+				result = CommandResult{Event: "result"}
+				result.Status, result.Response = callAPI(context.Background(), apiError(http.StatusBadRequest, errCodeInvalidRequest, "Invalid command"), http.MethodPost, nil, nil)
+			} else {
+				result = runCommand(context.Background(), cmd)
+			}
+			data, _ := json.Marshal(result)
+			if err := conn.writeText(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamDeckTick)
+	defer ticker.Stop()
+	lastAlert := latestAlertID()
+	for {
+		state, changed := streamDeckState()
+		data, _ := json.Marshal(state)
+		if err := conn.writeText(data); err != nil {
+			return
+		}
+		pending, alerted := alertsSince(lastAlert)
+		for _, a := range pending {
+			data, _ := json.Marshal(a)
+			if err := conn.writeText(data); err != nil {
+				return
+			}
+			lastAlert = a.ID
+		}
+		select {
+		case <-done:
+			return
+		case <-changed:
+		case <-alerted:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// summaryHour is the local hour summaries are sent at. Weekly ones go
+	// out on Mondays.
+	summaryHour = 9
+
+	// summaryTick is how often the clock is checked for the next summary,
+	// rather than sleeping until it, which a laptop's sleep would delay.
+	summaryTick = time.Minute
+
+	// maxSummaryHistory is how many summaries' disk usage is remembered for
+	// the trend.
+	maxSummaryHistory = 52
+)
+
+// summaryPeriod is how often a summary is sent, from -summary: "off",
+// "daily" or "weekly".
+var summaryPeriod = "off"
+
+// Summary looks back over a period of recording, for the household to see
+// how much was recorded and when it's time to clean up
+type Summary struct {
+	Event         string     `json:"event"` // always "summary"
+	From          time.Time  `json:"from"`
+	To            time.Time  `json:"to"`
+	Sessions      int        `json:"sessions"`
+	HoursRecorded float64    `json:"hoursRecorded"`
+	RecordedBytes int64      `json:"recordedBytes"`
+	TalkTime      []TalkTime `json:"talkTime"` // per device, from transcripts
+	Disk          DiskUsage  `json:"disk"`
+	Message       string     `json:"message"`
+
+	// Content repeats Message so a Discord webhook shows it as-is
+	Content string `json:"content"`
+}
+
+// DiskUsage is how much space recordings take and how fast that's growing
+type DiskUsage struct {
+	UsedBytes   int64  `json:"usedBytes"`             // by the recordings folder
+	StoredBytes int64  `json:"storedBytes,omitempty"` // in -storage
+	FreeBytes   uint64 `json:"freeBytes"`
+
+	// ChangeBytes is the change in UsedBytes since the last summary, and
+	// DaysUntilFull how long the free space lasts if it keeps growing at
+	// the rate it has over the summaries remembered
+	ChangeBytes   *int64   `json:"changeBytes,omitempty"`
+	DaysUntilFull *float64 `json:"daysUntilFull,omitempty"`
+
+	// History is the usage each earlier summary saw, oldest first
+	History []DiskSample `json:"history"`
+}
+
+// DiskSample is the disk usage at one summary
+type DiskSample struct {
+	Time        time.Time `json:"time"`
+	UsedBytes   int64     `json:"usedBytes"`
+	StoredBytes int64     `json:"storedBytes,omitempty"`
+	FreeBytes   uint64    `json:"freeBytes"`
+}
+
+// summaryHistoryPath is where the disk usage of sent summaries is kept.
+func summaryHistoryPath() string {
+	return filepath.Join(outputDirectory, ".summary-history.json")
+}
+
+func readSummaryHistory() []DiskSample {
+	history := []DiskSample{}
+	if data, err := os.ReadFile(summaryHistoryPath()); err == nil {
+		json.Unmarshal(data, &history)
+	}
+	return history
+}
+
+// summaryLength is how far back a summary for the period looks.
+func summaryLength(period string) time.Duration {
+	if period == "daily" {
+		return 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+// nextSummaryTime is when the next summary is due after now.
+func nextSummaryTime(period string, now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), summaryHour, 0, 0, 0, now.Location())
+	for !next.After(now) || period == "weekly" && next.Weekday() != time.Monday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// buildSummary summarizes the sessions started between from and to, and the
+// disk usage now.
+func buildSummary(from, to time.Time) Summary {
+	summary := Summary{Event: "summary", From: from, To: to}
+	var seconds float64
+	var talk [][]TalkTime
+	for _, manifest := range loadCatalog() {
+		if manifest.StartedAt.Before(from) || !manifest.StartedAt.Before(to) {
+			continue
+		}
+		stats := sessionStats(manifest)
+		summary.Sessions++
+		seconds += stats.DurationSeconds
+		summary.RecordedBytes += stats.SizeBytes
+		if segments, err := readTranscript(manifest.ID); err == nil {
+			talk = append(talk, talkTime(segments, true))
+		}
+	}
+	summary.HoursRecorded = roundMillis(seconds / 3600)
+	summary.TalkTime = sumTalkTime(talk)
+
+	disk := &summary.Disk
+	disk.UsedBytes = folderSize(outputDirectory)
+	if store != nil {
+		stored.Lock()
+		for _, size := range stored.files {
+			disk.StoredBytes += size
+		}
+		stored.Unlock()
+	}
+	disk.FreeBytes, _ = diskFree(outputDirectory)
+	disk.History = readSummaryHistory()
+	if n := len(disk.History); n > 0 {
+		last, first := disk.History[n-1], disk.History[0]
+		change := disk.UsedBytes - last.UsedBytes
+		disk.ChangeBytes = &change
+		// Less than a day is too little to go on
+		if days := to.Sub(first.Time).Hours() / 24; days >= 1 && disk.UsedBytes > first.UsedBytes {
+			left := float64(disk.FreeBytes) / (float64(disk.UsedBytes-first.UsedBytes) / days)
+			left = float64(int(left*10)) / 10
+			disk.DaysUntilFull = &left
+		}
+	}
+	summary.Message = summaryMessage(summary)
+	summary.Content = summary.Message
+	return summary
+}
+
+// folderSize adds up the files in a directory, not counting subdirectories.
+func folderSize(dir string) int64 {
+	entries, _ := os.ReadDir(dir)
+	var size int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// summaryMessage describes a summary in a few lines.
+func summaryMessage(s Summary) string {
+	var b strings.Builder
+	sessions := fmt.Sprintf("%d sessions", s.Sessions)
+	if s.Sessions == 1 {
+		sessions = "1 session"
+	}
+	fmt.Fprintf(&b, "📊 Recording summary for %s to %s: %s, %.1f hours recorded (%s)",
+		s.From.Format("Jan 2"), s.To.Add(-time.Minute).Format("Jan 2"), sessions, s.HoursRecorded, formatBytes(s.RecordedBytes))
+	if len(s.TalkTime) > 0 {
+		var talk []string
+		for _, t := range s.TalkTime {
+			minutes := int(t.Seconds / 60)
+			talk = append(talk, fmt.Sprintf("%s %dh %02dm (%.0f%%)", t.Device, minutes/60, minutes%60, t.Share*100))
+		}
+		fmt.Fprintf(&b, "\nTalk time: %s", strings.Join(talk, ", "))
+	}
+	fmt.Fprintf(&b, "\nRecordings use %s", formatBytes(s.Disk.UsedBytes))
+	if s.Disk.ChangeBytes != nil {
+		sign := "+"
+		if *s.Disk.ChangeBytes < 0 {
+			sign = "-"
+		}
+		fmt.Fprintf(&b, " (%s%s since the last summary)", sign, formatBytes(max(*s.Disk.ChangeBytes, -*s.Disk.ChangeBytes)))
+	}
+	if s.Disk.StoredBytes > 0 {
+		fmt.Fprintf(&b, ", with %s in %s", formatBytes(s.Disk.StoredBytes), store)
+	}
+	fmt.Fprintf(&b, ", and %s is free", formatBytes(int64(s.Disk.FreeBytes)))
+	if s.Disk.DaysUntilFull != nil {
+		fmt.Fprintf(&b, ", enough for about %.0f more days at this rate", *s.Disk.DaysUntilFull)
+		if *s.Disk.DaysUntilFull < 30 {
+			b.WriteString(". ⚠️ Time to clean up recordings")
+		}
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+// formatBytes formats a size in KB, MB or GB.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
+
+// sendSummary delivers a summary and remembers its disk usage for the next
+// one's trend.
+func sendSummary(summary Summary) error {
+	if webhookURL == "" && email.server == "" {
+		return errNoSummaryDestination
+	}
+	fmt.Println(summary.Message)
+	history := append(summary.Disk.History, DiskSample{
+		Time:        summary.To,
+		UsedBytes:   summary.Disk.UsedBytes,
+		StoredBytes: summary.Disk.StoredBytes,
+		FreeBytes:   summary.Disk.FreeBytes,
+	})
+	if len(history) > maxSummaryHistory {
+		history = history[len(history)-maxSummaryHistory:]
+	}
+	data, _ := json.Marshal(history)
+	if err := writeFileAtomic(summaryHistoryPath(), data); err != nil {
+		fmt.Printf("⚠️  Failed to save summary history: %v\n", err)
+	}
+	if email.server != "" {
+		subject := fmt.Sprintf("📊 Recording summary for %s to %s", summary.From.Format("Jan 2"), summary.To.Add(-time.Minute).Format("Jan 2"))
+		email.deliver(subject, summary.Message, "summary")
+	}
+	if webhookURL == "" {
+		return nil
+	}
+	return deliverWebhook(summary, "summary")
+}
+
+// errNoSummaryDestination is returned when there's nowhere to send a
+// summary
+var errNoSummaryDestination = errors.New("nowhere to send summaries; set -webhook or -smtp")
+
+// runSummaries sends a summary at summaryHour every day or week.
+func runSummaries() {
+	next := nextSummaryTime(summaryPeriod, time.Now())
+	for range time.Tick(summaryTick) {
+		now := time.Now()
+		if now.Before(next) {
+			continue
+		}
+		sendSummary(buildSummary(now.Add(-summaryLength(summaryPeriod)), now))
+		next = nextSummaryTime(summaryPeriod, now)
+	}
+}
+
+// summaryRange reads ?days=, how far back a summary looks, defaulting to a
+// week.
+func summaryRange(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now()
+	days := 7
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 366 {
+			return time.Time{}, time.Time{}, fmt.Errorf("days must be between 1 and 366")
+		}
+		days = n
+	}
+	return to.AddDate(0, 0, -days), to, nil
+}
+
+// Handler: GET /api/summary?days=7 - Preview the summary of the last days
+func handleGetSummary(w http.ResponseWriter, r *http.Request) {
+	from, to, err := summaryRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildSummary(from, to))
+}
+
+// Handler: POST /api/summary?days=7 - Send the summary of the last days now
+func handleSendSummary(w http.ResponseWriter, r *http.Request) {
+	from, to, err := summaryRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	summary := buildSummary(from, to)
+	if err := sendSummary(summary); err != nil {
+		if errors.Is(err, errNoSummaryDestination) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusBadGateway, errCodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tapQueueLength is how many chunks a tap reader can fall behind by before
+// chunks are dropped for it.
+const tapQueueLength = 64
+
+// audioTap offers a device's live audio, as the track's raw 16-bit PCM, on a
+// unix socket or named pipe set in its settings, so other programs can
+// process it while it's recorded. Readers get audio from when they connect,
+// and one that can't keep up misses chunks rather than holding up the
+// recording.
+type audioTap struct {
+	addr     string
+	listener io.Closer
+	refs     int // guarded by tapsMu
+
+	mu      sync.Mutex
+	readers map[*tapReader]struct{}
+}
+
+// tapReader is a connection to a socket tap, or a tap's named pipe
+type tapReader struct {
+	w     io.WriteCloser
+	queue chan []byte
+}
+
+// taps are the open taps by address, shared by the captures using them.
+var (
+	tapsMu sync.Mutex
+	taps   = map[string]*audioTap{}
+)
+
+// openTap opens the tap at addr, "unix:/path/to.sock" or a named pipe's path,
+// or shares it if another capture has it open, as the old and new tracks of
+// a split session do for a moment, so readers stay connected across it.
+func openTap(addr string) (*audioTap, error) {
+	tapsMu.Lock()
+	defer tapsMu.Unlock()
+	if t := taps[addr]; t != nil {
+		t.refs++
+		return t, nil
+	}
+
+	t := &audioTap{addr: addr, refs: 1, readers: map[*tapReader]struct{}{}}
+	if strings.HasPrefix(addr, "unix:") {
+		l, err := openListener(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		t.listener = l
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				t.add(conn)
+			}
+		}()
+	} else {
+		f, err := openFIFO(addr)
+		if err != nil {
+			return nil, err
+		}
+		t.add(f)
+	}
+	taps[addr] = t
+	return t, nil
+}
+
+// add starts sending audio to a reader.
+func (t *audioTap) add(w io.WriteCloser) {
+	r := &tapReader{w: w, queue: make(chan []byte, tapQueueLength)}
+	t.mu.Lock()
+	t.readers[r] = struct{}{}
+	t.mu.Unlock()
+	go func() {
+		for buf := range r.queue {
+			if _, err := w.Write(buf); err != nil {
+				t.remove(r)
+			}
+		}
+	}()
+}
+
+// remove stops sending audio to a reader and closes it.
+func (t *audioTap) remove(r *tapReader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.readers[r]; ok {
+		delete(t.readers, r)
+		close(r.queue)
+		r.w.Close()
+	}
+}
+
+// send queues a chunk of audio for every reader.
+func (t *audioTap) send(pcm []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.readers) == 0 {
+		return
+	}
+	buf := append([]byte(nil), pcm...)
+	for r := range t.readers {
+		select {
+		case r.queue <- buf:
+		default:
+		}
+	}
+}
+
+// close lets go of the tap, closing it and its readers once no capture is
+// using it. A named pipe is left in place for the next recording.
+func (t *audioTap) close() {
+	tapsMu.Lock()
+	t.refs--
+	if t.refs > 0 {
+		tapsMu.Unlock()
+		return
+	}
+	delete(taps, t.addr)
+	tapsMu.Unlock()
+
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Lock()
+	readers := make([]*tapReader, 0, len(t.readers))
+	for r := range t.readers {
+		readers = append(readers, r)
+	}
+	t.mu.Unlock()
+	for _, r := range readers {
+		t.remove(r)
+	}
+}
+
+// TapRequest is the request body for setting where a device's live audio is
+// offered
+type TapRequest struct {
+	Tap string `json:"tap"`
+}
+
+// Handler: PUT /api/devices/{id}/tap - Offer a device's live audio while it
+// records on a unix socket ("unix:/path/to.sock") or a named pipe (a path,
+// created if missing), besides its file. An empty tap turns it off. Takes
+// effect the next time the device starts recording.
+func handleSetTap(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, "Invalid device index")
+		return
+	}
+	var req TapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	tap := strings.TrimSpace(req.Tap)
+	if tap == "unix:" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "A unix: tap needs a socket path")
+		return
+	}
+
+	recordingMutex.Lock()
+	allDevices, err := cachedDevices()
+	recordingMutex.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
+	}
+	if idx < 0 || idx >= len(allDevices) {
+		writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
+		return
+	}
+	name := allDevices[idx].name()
+
+	if err := updateDeviceSettings(name, func(s *DeviceSettings) { s.Tap = tap }); err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to save device settings: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deviceIndex": idx,
+		"device":      name,
+		"tap":         tap,
+	})
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// openFIFO opens the named pipe at path for writing, creating it if needed.
+// It's opened for reading too, so the open doesn't wait for a reader and
+// writes don't fail while there is none, and without blocking, so a write
+// waiting on a full pipe can be interrupted by closing it.
+func openFIFO(path string) (*os.File, error) {
+	if err := syscall.Mkfifo(path, 0644); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("failed to create named pipe %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and isn't a named pipe", path)
+	}
+	return os.OpenFile(path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+}
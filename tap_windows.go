@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// openFIFO isn't available on Windows, where taps are unix sockets.
+func openFIFO(path string) (*os.File, error) {
+	return nil, fmt.Errorf("named pipe taps aren't supported on Windows; use a unix:path socket")
+}
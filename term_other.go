@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// isTerminal can't tell on this platform, so the CLI asks for numbers.
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+// makeRaw isn't implemented on this platform.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, fmt.Errorf("raw terminal mode is not available on %s", runtime.GOOS)
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleMode")
+	procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+)
+
+// Console modes from the Windows SDK
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+func getConsoleMode(f *os.File) (uint32, error) {
+	var mode uint32
+	if ok, _, err := procGetConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode))); ok == 0 {
+		return 0, err
+	}
+	return mode, nil
+}
+
+func setConsoleMode(f *os.File, mode uint32) error {
+	if ok, _, err := procSetConsoleMode.Call(f.Fd(), uintptr(mode)); ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a console.
+func isTerminal(f *os.File) bool {
+	_, err := getConsoleMode(f)
+	return err == nil
+}
+
+// makeRaw puts the console into raw mode, passing each key through as it's
+// pressed without echoing it, Ctrl+C included, with arrow keys as escape
+// sequences like other terminals send. Standard output is made to follow
+// escape sequences too. It returns a function that puts both back.
+func makeRaw(f *os.File) (func(), error) {
+	old, err := getConsoleMode(f)
+	if err != nil {
+		return nil, err
+	}
+	raw := old&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(f, raw); err != nil {
+		return nil, err
+	}
+	oldOut, outErr := getConsoleMode(os.Stdout)
+	if outErr == nil {
+		setConsoleMode(os.Stdout, oldOut|enableVirtualTerminalProcessing)
+	}
+	return func() {
+		setConsoleMode(f, old)
+		if outErr == nil {
+			setConsoleMode(os.Stdout, oldOut)
+		}
+	}, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimelineFPS is the frame rate used for timecodes unless ?fps= is given.
+const defaultTimelineFPS = 30
+
+// timelineOffset is where a session's tracks start on the video timeline, in
+// seconds. Every track is padded to start with the session, so only a
+// followed OBS output moves them.
+func timelineOffset(manifest *SessionManifest) float64 {
+	if manifest.OBS != nil {
+		return manifest.OBS.AudioOffsetSeconds
+	}
+	return 0
+}
+
+// timecode formats seconds as a non-drop-frame HH:MM:SS:FF timecode.
+func timecode(seconds float64, fps int) string {
+	frames := int(math.Round(seconds * float64(fps)))
+	if frames < 0 {
+		frames = 0
+	}
+	ff := frames % fps
+	total := frames / fps
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", total/3600, total/60%60, total%60, ff)
+}
+
+// writeTimelineCSV lists each track's wall-clock start and timeline offset.
+func writeTimelineCSV(w io.Writer, manifest *SessionManifest) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"file", "device", "start_time", "timeline_offset_seconds", "duration_seconds", "sample_rate", "channels"})
+	start := manifest.StartedAt.Format(time.RFC3339Nano)
+	offset := strconv.FormatFloat(timelineOffset(manifest), 'f', 6, 64)
+	for _, track := range manifest.Tracks {
+		out.Write([]string{
+			track.File,
+			track.Device,
+			start,
+			offset,
+			strconv.FormatFloat(track.DurationSeconds, 'f', 6, 64),
+			strconv.FormatUint(uint64(track.SampleRate), 10),
+			strconv.FormatUint(uint64(track.Channels), 10),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeTimelineEDL writes a CMX 3600 edit decision list with one audio event
+// per track.
+func writeTimelineEDL(w io.Writer, manifest *SessionManifest, fps int) error {
+	fmt.Fprintf(w, "TITLE: %s\nFCM: NON-DROP FRAME\n\n", manifest.ID)
+	offset := timelineOffset(manifest)
+	for i, track := range manifest.Tracks {
+		channel := "A"
+		if i > 0 {
+			channel = fmt.Sprintf("A%d", i+1)
+		}
+		reel := sanitizeASCII(track.Device)
+		if len(reel) > 8 {
+			reel = reel[:8]
+		}
+		fmt.Fprintf(w, "%03d  %-8s %-5s C        %s %s %s %s\n",
+			i+1, reel, channel,
+			timecode(0, fps), timecode(track.DurationSeconds, fps),
+			timecode(offset, fps), timecode(offset+track.DurationSeconds, fps))
+		if _, err := fmt.Fprintf(w, "* FROM CLIP NAME: %s\n\n", track.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FCPXML elements, just enough for a sequence of connected audio clips.
+type fcpxmlDocument struct {
+	XMLName   xml.Name        `xml:"fcpxml"`
+	Version   string          `xml:"version,attr"`
+	Resources fcpxmlResources `xml:"resources"`
+	Event     fcpxmlEvent     `xml:"library>event"`
+}
+
+type fcpxmlResources struct {
+	Format fcpxmlFormat  `xml:"format"`
+	Assets []fcpxmlAsset `xml:"asset"`
+}
+
+type fcpxmlFormat struct {
+	ID            string `xml:"id,attr"`
+	FrameDuration string `xml:"frameDuration,attr"`
+}
+
+type fcpxmlAsset struct {
+	ID            string `xml:"id,attr"`
+	Name          string `xml:"name,attr"`
+	Start         string `xml:"start,attr"`
+	Duration      string `xml:"duration,attr"`
+	HasAudio      string `xml:"hasAudio,attr"`
+	AudioSources  string `xml:"audioSources,attr"`
+	AudioChannels uint32 `xml:"audioChannels,attr"`
+	AudioRate     uint32 `xml:"audioRate,attr"`
+	MediaRep      struct {
+		Kind string `xml:"kind,attr"`
+		Src  string `xml:"src,attr"`
+	} `xml:"media-rep"`
+}
+
+type fcpxmlEvent struct {
+	Name    string        `xml:"name,attr"`
+	Project fcpxmlProject `xml:"project"`
+}
+
+type fcpxmlProject struct {
+	Name     string         `xml:"name,attr"`
+	Sequence fcpxmlSequence `xml:"sequence"`
+}
+
+type fcpxmlSequence struct {
+	Format   string    `xml:"format,attr"`
+	Duration string    `xml:"duration,attr"`
+	Gap      fcpxmlGap `xml:"spine>gap"`
+}
+
+type fcpxmlGap struct {
+	Name     string       `xml:"name,attr"`
+	Offset   string       `xml:"offset,attr"`
+	Duration string       `xml:"duration,attr"`
+	Clips    []fcpxmlClip `xml:"asset-clip"`
+}
+
+type fcpxmlClip struct {
+	Ref      string `xml:"ref,attr"`
+	Lane     int    `xml:"lane,attr"`
+	Offset   string `xml:"offset,attr"`
+	Duration string `xml:"duration,attr"`
+	Name     string `xml:"name,attr"`
+}
+
+// writeTimelineFCPXML writes an FCPXML project with each track on its own
+// lane under an empty gap, placed at the session's timeline offset.
+func writeTimelineFCPXML(w io.Writer, manifest *SessionManifest, fps int) error {
+	// Final Cut wants clip times on frame boundaries
+	frames := func(seconds float64) int { return int(math.Round(seconds * float64(fps))) }
+	rational := func(n int) string { return fmt.Sprintf("%d/%ds", n, fps) }
+
+	offset := frames(timelineOffset(manifest))
+	total := offset
+	doc := fcpxmlDocument{Version: "1.9"}
+	doc.Resources.Format = fcpxmlFormat{ID: "r0", FrameDuration: fmt.Sprintf("1/%ds", fps)}
+
+	var clips []fcpxmlClip
+	for i, track := range manifest.Tracks {
+		abs, err := filepath.Abs(filepath.Join(outputDirectory, track.File))
+		if err != nil {
+			return err
+		}
+		samples := int(math.Round(track.DurationSeconds * float64(track.SampleRate)))
+		asset := fcpxmlAsset{
+			ID:            fmt.Sprintf("r%d", i+1),
+			Name:          track.File,
+			Start:         "0s",
+			Duration:      fmt.Sprintf("%d/%ds", samples, track.SampleRate),
+			HasAudio:      "1",
+			AudioSources:  "1",
+			AudioChannels: track.Channels,
+			AudioRate:     track.SampleRate,
+		}
+		asset.MediaRep.Kind = "original-media"
+		// Windows paths need a leading slash to form file:///C:/...
+		src := filepath.ToSlash(abs)
+		if !strings.HasPrefix(src, "/") {
+			src = "/" + src
+		}
+		asset.MediaRep.Src = (&url.URL{Scheme: "file", Path: src}).String()
+		doc.Resources.Assets = append(doc.Resources.Assets, asset)
+
+		duration := frames(track.DurationSeconds)
+		clips = append(clips, fcpxmlClip{
+			Ref:      asset.ID,
+			Lane:     -(i + 1),
+			Offset:   rational(offset),
+			Duration: rational(duration),
+			Name:     track.Device,
+		})
+		total = max(total, offset+duration)
+	}
+
+	doc.Event = fcpxmlEvent{
+		Name: manifest.ID,
+		Project: fcpxmlProject{
+			Name: manifest.ID,
+			Sequence: fcpxmlSequence{
+				Format:   "r0",
+				Duration: rational(total),
+				Gap:      fcpxmlGap{Name: "Gap", Offset: "0s", Duration: rational(total), Clips: clips},
+			},
+		},
+	}
+
+	io.WriteString(w, xml.Header+"<!DOCTYPE fcpxml>\n")
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Handler: GET /api/sessions/{id}/timeline?format=csv|edl|fcpxml&fps=30 -
+// Export track positions for lining the audio up in a video editor
+func handleSessionTimeline(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+
+	fps := defaultTimelineFPS
+	if value := r.URL.Query().Get("fps"); value != "" {
+		fps, err = strconv.Atoi(value)
+		if err != nil || fps < 1 || fps > 120 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "fps must be between 1 and 120")
+			return
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	var write func(io.Writer) error
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+		write = func(w io.Writer) error { return writeTimelineCSV(w, manifest) }
+	case "edl":
+		contentType = "text/plain"
+		write = func(w io.Writer) error { return writeTimelineEDL(w, manifest, fps) }
+	case "fcpxml":
+		contentType = "application/xml"
+		write = func(w io.Writer) error { return writeTimelineFCPXML(w, manifest, fps) }
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "format must be csv, edl or fcpxml")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", id, format))
+	write(w)
+}
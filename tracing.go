@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds and status codes from the OTLP trace protobuf.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+const (
+	traceBatchSize     = 256
+	traceFlushInterval = 5 * time.Second
+
+	// sessionSpanTTL is how long after a session starts its later work,
+	// such as jobs and uploads, joins the session's trace.
+	sessionSpanTTL = 24 * time.Hour
+)
+
+// span is a single timed operation, exported to an OTLP/HTTP collector. A nil
+// *span is valid and does nothing, so call sites don't need to check whether
+// tracing is enabled.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	err      error
+}
+
+type spanContextKey struct{}
+
+// tracer batches finished spans and posts them to the collector.
+type tracer struct {
+	endpoint    string
+	serviceName string
+	spans       chan *span
+	client      *http.Client
+}
+
+// activeTracer is nil unless an OTLP endpoint was configured.
+var activeTracer *tracer
+
+// addTracingFlags registers the OTLP exporter flag.
+func addTracingFlags(fs *flag.FlagSet) *string {
+	return fs.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector to send traces to, e.g. http://localhost:4318")
+}
+
+// initTracing starts exporting spans if an endpoint was given.
+func initTracing(endpoint string) {
+	if endpoint == "" {
+		return
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "skribbl-capture"
+	}
+	activeTracer = &tracer{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		spans:       make(chan *span, traceBatchSize*4),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	go activeTracer.exportLoop()
+	fmt.Printf("✓ Exporting traces to %s\n", activeTracer.endpoint)
+}
+
+// startSpan begins a span as a child of any span in ctx.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if activeTracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: map[string]any{}}
+	rand.Read(s.spanID[:])
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// sessionSpans holds the trace and span IDs of each session's session.start
+// span, so work done on the session after it stops, such as jobs and uploads,
+// joins the same trace. Entries are dropped after sessionSpanTTL, and sessions
+// from before a restart aren't known; work on those gets traces of its own.
+var sessionSpans = struct {
+	sync.Mutex
+	spans map[string]sessionSpan
+}{spans: map[string]sessionSpan{}}
+
+// sessionSpan is a session's span, as remembered in sessionSpans.
+type sessionSpan struct {
+	parent  *span
+	created time.Time
+}
+
+// rememberSessionSpan keeps a session's span for withSessionSpan.
+func rememberSessionSpan(id string, s *span) {
+	if s == nil {
+		return
+	}
+	sessionSpans.Lock()
+	defer sessionSpans.Unlock()
+	for other, remembered := range sessionSpans.spans {
+		if time.Since(remembered.created) > sessionSpanTTL {
+			delete(sessionSpans.spans, other)
+		}
+	}
+	sessionSpans.spans[id] = sessionSpan{parent: &span{traceID: s.traceID, spanID: s.spanID}, created: time.Now()}
+}
+
+// withSessionSpan makes the session's span, if it's known, the parent of
+// spans started from ctx.
+func withSessionSpan(ctx context.Context, id string) context.Context {
+	sessionSpans.Lock()
+	defer sessionSpans.Unlock()
+	remembered, ok := sessionSpans.spans[id]
+	if !ok {
+		return ctx
+	}
+	if time.Since(remembered.created) > sessionSpanTTL {
+		delete(sessionSpans.spans, id)
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, remembered.parent)
+}
+
+// setAttr records a string, int, float or bool attribute on the span.
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, marking it failed if err is non-nil.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	select {
+	case activeTracer.spans <- s:
+	default:
+		// Drop the span rather than block a request on a slow collector
+	}
+}
+
+// parseTraceparent adopts a W3C trace context header as the parent span.
+func parseTraceparent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	parent := &span{}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, parent)
+}
+
+// statusRecorder captures the response status for tracing and logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// traceHTTP wraps every request in a server span, named after the mux route
+// it matches. The route is looked up rather than read from the request
+// afterwards, since middleware in between hands the mux a copy.
+func traceHTTP(mux *http.ServeMux, next http.Handler) http.Handler {
+	if activeTracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := parseTraceparent(r.Context(), r.Header.Get("traceparent"))
+		ctx, s := startSpan(ctx, r.Method+" "+r.URL.Path, spanKindServer)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(rec, r)
+
+		// Name the span after the route pattern to keep cardinality low
+		if _, pattern := mux.Handler(r); strings.Contains(pattern, " ") {
+			s.name = pattern
+		} else if pattern != "" {
+			s.name = r.Method + " " + pattern
+		}
+		s.setAttr("http.request.method", r.Method)
+		s.setAttr("url.path", r.URL.Path)
+		s.setAttr("http.response.status_code", rec.status)
+		var err error
+		if rec.status >= 500 {
+			err = fmt.Errorf("%s", http.StatusText(rec.status))
+		}
+		s.finish(err)
+	})
+}
+
+// exportLoop sends spans in batches until the process exits.
+func (t *tracer) exportLoop() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := []*span{}
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.export(batch); err != nil {
+			fmt.Printf("Failed to export traces: %v\n", err)
+		}
+		batch = []*span{}
+	}
+}
+
+// export posts a batch of spans using the OTLP/HTTP JSON encoding.
+func (t *tracer) export(batch []*span) error {
+	spans := []map[string]any{}
+	for _, s := range batch {
+		encoded := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            map[string]any{"code": spanStatusOK},
+		}
+		if s.parentID != [8]byte{} {
+			encoded["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			encoded["status"] = map[string]any{"code": spanStatusError, "message": s.err.Error()}
+		}
+		spans = append(spans, encoded)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": t.serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "skribbl-capture"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts a map to OTLP's typed key/value list.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	list := []map[string]any{}
+	for key, value := range attrs {
+		var v map[string]any
+		switch value := value.(type) {
+		case string:
+			v = map[string]any{"stringValue": value}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		case uint32:
+			v = map[string]any{"intValue": strconv.FormatUint(uint64(value), 10)}
+		case float64:
+			v = map[string]any{"doubleValue": value}
+		case bool:
+			v = map[string]any{"boolValue": value}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+		list = append(list, map[string]any{"key": key, "value": v})
+	}
+	return list
+}
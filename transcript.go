@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sttTimeout bounds each transcription request.
+	sttTimeout = 2 * time.Minute
+
+	// transcriptFlushTimeout is how long stopping a session waits for the
+	// last chunks to be transcribed before saving what it has.
+	transcriptFlushTimeout = 30 * time.Second
+
+	// sttSilenceDBFS is the peak under which a chunk isn't sent at all.
+	sttSilenceDBFS = -50.0
+)
+
+// sttConfig points at a speech-to-text service speaking the OpenAI
+// transcription API, which Whisper servers such as faster-whisper-server and
+// whisper.cpp's server mimic. Set from flags in web mode.
+var stt struct {
+	url      string
+	key      string
+	model    string
+	language string
+	chunk    time.Duration
+}
+
+// TranscriptSegment is a stretch of speech on one track, timed in seconds
+// from the start of the session
+type TranscriptSegment struct {
+	Event     string  `json:"event,omitempty"` // "transcript" when streamed
+	SessionID string  `json:"sessionId,omitempty"`
+	Device    string  `json:"device"`
+	Speaker   string  `json:"speaker,omitempty"`
+	File      string  `json:"file"`
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`
+	Text      string  `json:"text"`
+
+	// Words are timed individually when the service supports it
+	Words []TranscriptWord `json:"words,omitempty"`
+}
+
+// TranscriptWord is one word of a segment and when it was said
+type TranscriptWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// liveTranscript collects a running session's transcript as chunks come
+// back from the service. It has its own lock, since capture writers feed it.
+type liveTranscript struct {
+	session  *session
+	mu       sync.Mutex
+	segments []TranscriptSegment
+	changed  chan struct{}
+	pending  sync.WaitGroup
+}
+
+// transcriber buffers one track's audio and sends it off a chunk at a time.
+// add is called by the capture writer; a worker goroutine does the requests
+// in order.
+type transcriber struct {
+	live       *liveTranscript
+	device     string
+	speaker    string
+	file       string
+	sampleRate uint32
+	channels   uint32
+	buf        []byte
+	bufStart   float64 // position of buf in the track, in seconds
+	started    bool
+	jobs       chan transcriptJob
+}
+
+// sttSegment is a segment of a verbose_json transcription response, timed
+// from the start of the chunk
+type sttSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type transcriptJob struct {
+	pcm   []byte
+	start float64
+}
+
+func addSTTFlags(fs *flag.FlagSet) {
+	fs.StringVar(&stt.url, "stt", "", "OpenAI-compatible transcription endpoint for live transcripts, e.g. http://localhost:8000/v1/audio/transcriptions (disabled if empty)")
+	fs.StringVar(&stt.key, "stt-key", "", "API key for -stt")
+	fs.StringVar(&stt.model, "stt-model", "whisper-1", "transcription model")
+	fs.StringVar(&stt.language, "stt-language", "", "language code to transcribe, e.g. en (detected if empty)")
+	fs.DurationVar(&stt.chunk, "stt-chunk", 15*time.Second, "how much audio is sent per request; shorter is more live, longer is more accurate")
+}
+
+func newLiveTranscript(sess *session) *liveTranscript {
+	if stt.url == "" {
+		return nil
+	}
+	return &liveTranscript{session: sess, changed: make(chan struct{})}
+}
+
+// attach starts transcribing a capture. Nothing happens when transcription is
+// off.
+func (lt *liveTranscript) attach(cap *captureDevice) {
+	if lt == nil {
+		return
+	}
+	t := &transcriber{
+		live:       lt,
+		device:     cap.name,
+		speaker:    cap.speaker,
+		file:       filepath.Base(cap.filename),
+		sampleRate: cap.sampleRate,
+		channels:   cap.channels,
+		jobs:       make(chan transcriptJob, 16),
+	}
+	lt.pending.Add(1)
+	go t.work()
+	cap.transcriber.Store(t)
+}
+
+// since returns the segments after the first n, with the channel closed when
+// more arrive.
+func (lt *liveTranscript) since(n int) ([]TranscriptSegment, <-chan struct{}) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if n > len(lt.segments) {
+		n = 0
+	}
+	return slices.Clone(lt.segments[n:]), lt.changed
+}
+
+// wait blocks until every track's last chunk has been transcribed, or the
+// timeout passes, and returns the transcript in time order.
+func (lt *liveTranscript) wait(timeout time.Duration) []TranscriptSegment {
+	done := make(chan struct{})
+	go func() {
+		lt.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Printf("⚠️  Gave up waiting for the transcript of %s; saving what's done\n", lt.session.id)
+	}
+
+	segments, _ := lt.since(0)
+	sortSegments(segments)
+	return segments
+}
+
+func (t *transcriber) bytesPerSecond() float64 {
+	return float64(t.sampleRate * t.channels * 2)
+}
+
+// add buffers audio from the writer, handing off a chunk whenever enough has
+// built up. written is how much of the track was on disk before pcm, which
+// places it in the session.
+func (t *transcriber) add(pcm []byte, written uint32) {
+	if !t.started {
+		t.bufStart, t.started = float64(written)/t.bytesPerSecond(), true
+	}
+	t.buf = append(t.buf, pcm...)
+	chunkBytes := int(stt.chunk.Seconds()*float64(t.sampleRate)) * int(t.channels) * 2
+	if len(t.buf) < chunkBytes {
+		return
+	}
+	cut := t.quietCut(chunkBytes)
+	t.send(t.buf[:cut])
+	t.buf = append([]byte(nil), t.buf[cut:]...)
+}
+
+// quietCut picks where to end a chunk: the quietest 100ms in its last
+// quarter, so words are less likely to be split between requests.
+func (t *transcriber) quietCut(chunkBytes int) int {
+	frame := int(t.channels) * 2
+	window := int(float64(t.sampleRate)*0.1) * frame
+	best, bestEnergy := chunkBytes, math.Inf(1)
+	for end := chunkBytes * 3 / 4; end+window <= chunkBytes; end += window {
+		var energy float64
+		for i := end; i+1 < end+window; i += 2 {
+			v := float64(int16(uint16(t.buf[i]) | uint16(t.buf[i+1])<<8))
+			energy += v * v
+		}
+		if energy < bestEnergy {
+			best, bestEnergy = end+window/2/frame*frame, energy
+		}
+	}
+	return best
+}
+
+// send queues a chunk and moves the buffer's position past it.
+func (t *transcriber) send(pcm []byte) {
+	job := transcriptJob{pcm: slices.Clone(pcm), start: t.bufStart}
+	t.bufStart += float64(len(pcm)) / t.bytesPerSecond()
+	t.jobs <- job
+}
+
+// close sends whatever audio is left and lets the worker finish. It's called
+// once the capture's writer has stopped.
+func (t *transcriber) close() {
+	if len(t.buf) > 0 {
+		t.send(t.buf)
+		t.buf = nil
+	}
+	close(t.jobs)
+}
+
+// work transcribes chunks in order until the transcriber is closed.
+func (t *transcriber) work() {
+	defer t.live.pending.Done()
+	for job := range t.jobs {
+		if analyzeLevels(job.pcm, t.sampleRate, t.channels).PeakDBFS < sttSilenceDBFS {
+			continue
+		}
+		segments, err := t.transcribe(job)
+		if err != nil {
+			fmt.Printf("⚠️  Transcription failed for %s at %s: %v\n", t.device, formatElapsed(time.Duration(job.start*float64(time.Second))), err)
+			continue
+		}
+
+		lt := t.live
+		lt.mu.Lock()
+		lt.segments = append(lt.segments, segments...)
+		close(lt.changed)
+		lt.changed = make(chan struct{})
+		lt.mu.Unlock()
+
+		for _, s := range segments {
+			for _, marker := range spotKeywords(s) {
+				lt.session.addKeywordMarker(marker)
+			}
+		}
+	}
+}
+
+// transcribe sends one chunk to the service as a WAV file.
+func (t *transcriber) transcribe(job transcriptJob) ([]TranscriptSegment, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "chunk.wav")
+	writeWAVHeader(part, t.sampleRate, t.channels, 16, uint32(len(job.pcm)))
+	part.Write(job.pcm)
+	form.WriteField("model", stt.model)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("timestamp_granularities[]", "segment")
+	form.WriteField("timestamp_granularities[]", "word")
+	if stt.language != "" {
+		form.WriteField("language", stt.language)
+	}
+	form.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sttTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stt.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if stt.key != "" {
+		req.Header.Set("Authorization", "Bearer "+stt.key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Text     string           `json:"text"`
+		Segments []sttSegment     `json:"segments"`
+		Words    []TranscriptWord `json:"words"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	// Services that don't time segments get one for the whole chunk
+	duration := float64(len(job.pcm)) / t.bytesPerSecond()
+	if len(result.Segments) == 0 && strings.TrimSpace(result.Text) != "" {
+		result.Segments = []sttSegment{{End: duration, Text: result.Text}}
+	}
+	var segments []TranscriptSegment
+	for i, s := range result.Segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		segment := TranscriptSegment{
+			SessionID: t.live.session.id,
+			Device:    t.device,
+			Speaker:   t.speaker,
+			File:      t.file,
+			Start:     roundMillis(job.start + s.Start),
+			End:       roundMillis(job.start + min(s.End, duration)),
+			Text:      text,
+		}
+		// Words come as one list for the chunk, and go with the segment
+		// they start in
+		last := i == len(result.Segments)-1
+		for _, w := range result.Words {
+			if w.Start >= s.Start && (w.Start < s.End || last) {
+				segment.Words = append(segment.Words, TranscriptWord{
+					Word:  strings.TrimSpace(w.Word),
+					Start: roundMillis(job.start + w.Start),
+					End:   roundMillis(job.start + min(w.End, duration)),
+				})
+			}
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// roundMillis rounds a time in seconds to the millisecond.
+func roundMillis(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
+
+// transcriptPath is where a finished session's transcript is saved.
+func transcriptPath(id string) string {
+	return filepath.Join(outputDirectory, id+".transcript.json")
+}
+
+// saveTranscript waits for the session's transcript to finish and saves it
+// next to the recordings, returning the file name.
+func saveTranscript(lt *liveTranscript) (string, error) {
+	segments := lt.wait(transcriptFlushTimeout)
+	for i := range segments {
+		segments[i].SessionID = ""
+	}
+	if segments == nil {
+		segments = []TranscriptSegment{}
+	}
+	data, _ := json.MarshalIndent(segments, "", "  ")
+	if err := writeFileAtomic(transcriptPath(lt.session.id), append(data, '\n')); err != nil {
+		return "", fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return filepath.Base(transcriptPath(lt.session.id)), nil
+}
+
+// readTranscript loads a finished session's transcript.
+func readTranscript(id string) ([]TranscriptSegment, error) {
+	data, err := os.ReadFile(transcriptPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var segments []TranscriptSegment
+	if err := json.Unmarshal(data, &segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// Handler: GET /api/sessions/{id}/transcript?format=json - The transcript so
+// far of a running session, or the saved one of a finished session, merged
+// across tracks in time order with each segment labelled by speaker. Also
+// exported as txt, srt or vtt.
+func handleSessionTranscript(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	var contentType string
+	var write func(io.Writer, string, []TranscriptSegment) error
+	switch format {
+	case "json":
+	case "txt":
+		contentType, write = "text/plain; charset=utf-8", writeTranscriptText
+	case "srt":
+		contentType, write = "application/x-subrip", writeTranscriptSRT
+	case "vtt":
+		contentType, write = "text/vtt", writeTranscriptVTT
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "format must be json, txt, srt or vtt")
+		return
+	}
+
+	var segments []TranscriptSegment
+	var title string
+	recordingMutex.Lock()
+	var live *liveTranscript
+	if activeSession != nil && activeSession.id == id {
+		live, title = activeSession.transcript, activeSession.title
+	}
+	recordingMutex.Unlock()
+
+	if live != nil {
+		segments, _ = live.since(0)
+	} else {
+		var err error
+		segments, err = readTranscript(id)
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "No transcript for this session")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		if manifest, err := readSessionManifest(id); err == nil {
+			labelSpeakers(segments, manifest.Tracks)
+			title = manifest.Title
+		}
+	}
+	labelSpeakers(segments, nil)
+	sortSegments(segments)
+	if segments == nil {
+		segments = []TranscriptSegment{}
+	}
+
+	if write != nil {
+		filename := id
+		if title != "" {
+			filename += "_" + sanitizeFilename(title)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", filename, format))
+		write(w, title, segments)
+		return
+	}
+	response := map[string]interface{}{
+		"sessionId": id,
+		"segments":  segments,
+	}
+	if title != "" {
+		response["title"] = title
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// labelSpeakers fills in the speaker of segments that don't have one: from
+// the track's speaker when the session recorded one, otherwise the device's
+// speaker in the current settings, otherwise the device name.
+func labelSpeakers(segments []TranscriptSegment, tracks []TrackInfo) {
+	if tracks != nil {
+		speakers := map[string]string{}
+		for _, track := range tracks {
+			speakers[track.File] = track.Speaker
+		}
+		for i := range segments {
+			if segments[i].Speaker == "" {
+				segments[i].Speaker = speakers[segments[i].File]
+			}
+		}
+		return
+	}
+
+	settings, _ := loadDeviceSettings()
+	for i := range segments {
+		if segments[i].Speaker == "" {
+			segments[i].Speaker = settings[segments[i].Device].Speaker
+		}
+		if segments[i].Speaker == "" {
+			segments[i].Speaker = segments[i].Device
+		}
+	}
+}
+
+// sortSegments puts segments from every track into time order.
+func sortSegments(segments []TranscriptSegment) {
+	slices.SortStableFunc(segments, func(a, b TranscriptSegment) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+}
+
+// writeTranscriptText writes a transcript as lines of "[M:SS] Speaker: text",
+// under the title if there is one.
+func writeTranscriptText(w io.Writer, title string, segments []TranscriptSegment) error {
+	if title != "" {
+		if _, err := fmt.Fprintf(w, "%s\n\n", title); err != nil {
+			return err
+		}
+	}
+	for _, s := range segments {
+		start := time.Duration(s.Start * float64(time.Second))
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", formatElapsed(start), s.Speaker, s.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTranscriptSRT writes a transcript as SubRip subtitles, which have
+// nowhere to put the title.
+func writeTranscriptSRT(w io.Writer, title string, segments []TranscriptSegment) error {
+	for i, s := range segments {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s: %s\n\n", i+1, subtitleTime(s.Start, ","), subtitleTime(s.End, ","), s.Speaker, s.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTranscriptVTT writes a transcript as WebVTT, with the speaker as a
+// voice span so players can style each person, and the title in the header.
+func writeTranscriptVTT(w io.Writer, title string, segments []TranscriptSegment) error {
+	header := "WEBVTT"
+	if title != "" {
+		// The header is a single line
+		header += " - " + strings.Join(strings.Fields(title), " ")
+	}
+	if _, err := io.WriteString(w, header+"\n\n"); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := fmt.Fprintf(w, "%s --> %s\n<v %s>%s\n\n", subtitleTime(s.Start, "."), subtitleTime(s.End, "."), s.Speaker, s.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtitleTime renders seconds as HH:MM:SS followed by the separator and
+// milliseconds.
+func subtitleTime(seconds float64, sep string) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// Handler: GET /api/transcript/stream - WebSocket pushing transcript
+// segments of the running session as they're transcribed, starting with
+// what's already been said. It follows on to the next session.
+func handleTranscriptStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := conn.readMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var current *liveTranscript
+	sent := 0
+	for {
+		recordingMutex.Lock()
+		var live *liveTranscript
+		if activeSession != nil {
+			live = activeSession.transcript
+		}
+		sessionChange := sessionChanged
+		recordingMutex.Unlock()
+
+		if live != current {
+			current, sent = live, 0
+		}
+		var more <-chan struct{}
+		if current != nil {
+			var segments []TranscriptSegment
+			segments, more = current.since(sent)
+			for _, s := range segments {
+				s.Event = "transcript"
+				data, _ := json.Marshal(s)
+				if err := conn.writeText(data); err != nil {
+					return
+				}
+			}
+			sent += len(segments)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-sessionChange:
+		case <-more:
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// trashRetention is how long deleted sessions are kept in the trash before
+// they're deleted for good, from -trash-retention. 0 keeps them until the
+// trash is emptied.
+var trashRetention = 30 * 24 * time.Hour
+
+// trashCheckInterval is how often the trash is checked against
+// trashRetention.
+const trashCheckInterval = time.Hour
+
+// DeleteInfo says when a session in the trash was deleted, and by whom
+type DeleteInfo struct {
+	At time.Time `json:"at"`
+	By string    `json:"by,omitempty"`
+}
+
+// TrashedSession describes a session in the trash for GET /api/trash
+type TrashedSession struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title,omitempty"`
+	StartedAt time.Time  `json:"startedAt"`
+	DeletedAt time.Time  `json:"deletedAt"`
+	DeletedBy string     `json:"deletedBy,omitempty"`
+	PurgeAt   *time.Time `json:"purgeAt,omitempty"` // when it's deleted for good
+	Files     []string   `json:"files"`
+	SizeBytes int64      `json:"sizeBytes"` // of the tracks
+}
+
+// trashDir is where a deleted session's files are kept, in a hidden folder
+// of the recordings folder, or the trash itself if id is empty.
+func trashDir(id string) string {
+	return filepath.Join(outputDirectory, ".trash", id)
+}
+
+// inTrash reports whether a session with the ID is in the trash.
+func inTrash(id string) bool {
+	_, err := os.Stat(trashDir(id))
+	return err == nil
+}
+
+// readTrashedManifest loads the metadata of a session in the trash.
+func readTrashedManifest(id string) (*SessionManifest, error) {
+	data, err := os.ReadFile(filepath.Join(trashDir(id), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest SessionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// trashedSessions lists the sessions in the trash, most recently deleted
+// first.
+func trashedSessions() []*SessionManifest {
+	entries, _ := os.ReadDir(trashDir(""))
+	var manifests []*SessionManifest
+	for _, entry := range entries {
+		if !entry.IsDir() || !validSessionID(entry.Name()) {
+			continue
+		}
+		manifest, err := readTrashedManifest(entry.Name())
+		if err != nil || manifest.Deleted == nil {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	slices.SortFunc(manifests, func(a, b *SessionManifest) int { return b.Deleted.At.Compare(a.Deleted.At) })
+	return manifests
+}
+
+// sessionBusy reports whether jobs are queued, running or paused on a
+// session.
+func sessionBusy(id string) bool {
+	return slices.ContainsFunc(jobList(), func(job Job) bool {
+		return job.SessionID == id && (job.Status == jobQueued || job.Status == jobRunning || job.Status == jobPaused)
+	})
+}
+
+// trashSession moves a finished session's files and sidecar to the trash.
+// Audio that's only in -storage stays there, out of the catalog, until the
+// session is restored or purged. Must be called with manifestMu held.
+func trashSession(manifest *SessionManifest, by string) error {
+	dir := trashDir(manifest.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create trash folder: %w", err)
+	}
+	manifest.Deleted = &DeleteInfo{At: time.Now().UTC(), By: by}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, manifest.ID+".json"), data); err != nil {
+		return fmt.Errorf("failed to write session metadata: %w", err)
+	}
+	// Audio first, so the watcher never finds a track without its sidecar
+	for _, name := range manifest.files() {
+		err := os.Rename(filepath.Join(outputDirectory, name), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move %s to the trash: %w", name, err)
+		}
+	}
+	if err := os.Remove(sessionManifestPath(manifest.ID)); err != nil {
+		return fmt.Errorf("failed to remove session metadata: %w", err)
+	}
+	// Otherwise a fresh container would fetch the sidecar back from -storage
+	if store != nil {
+		if err := unstore(manifest.ID + ".json"); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		}
+	}
+	return nil
+}
+
+// restoreSession moves a session's files back from the trash. Must be called
+// with manifestMu held.
+func restoreSession(manifest *SessionManifest) error {
+	dir := trashDir(manifest.ID)
+	manifest.Deleted = nil
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The sidecar first, so the watcher never finds a track without it
+	if err := writeFileAtomic(sessionManifestPath(manifest.ID), data); err != nil {
+		return fmt.Errorf("failed to write session metadata: %w", err)
+	}
+	for _, name := range manifest.files() {
+		err := os.Rename(filepath.Join(dir, name), filepath.Join(outputDirectory, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move %s back from the trash: %w", name, err)
+		}
+	}
+	if store != nil {
+		queueUpload(manifest.ID)
+	}
+	return os.RemoveAll(dir)
+}
+
+// purgeSession deletes a session in the trash for good, along with its
+// audio in -storage, its shares and its place in collections. A copy in
+// -archive is kept.
+func purgeSession(manifest *SessionManifest) error {
+	if err := os.RemoveAll(trashDir(manifest.ID)); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", manifest.ID, err)
+	}
+	if store != nil {
+		for _, name := range manifest.files() {
+			if err := unstore(name); err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+			}
+		}
+	}
+	forgetSession(manifest.ID)
+	return nil
+}
+
+// forgetSession drops a deleted session's grants and takes it out of
+// collections.
+func forgetSession(id string) {
+	grants.Lock()
+	n := len(grants.list)
+	grants.list = slices.DeleteFunc(grants.list, func(g Grant) bool { return g.SessionID == id })
+	if len(grants.list) != n {
+		if err := saveGrants(); err != nil {
+			fmt.Printf("⚠️  Failed to save grants: %v\n", err)
+		}
+	}
+	grants.Unlock()
+
+	collections.Lock()
+	changed := false
+	for i := range collections.list {
+		if c := &collections.list[i]; slices.Contains(c.Sessions, id) {
+			c.Sessions = slices.DeleteFunc(c.Sessions, func(s string) bool { return s == id })
+			changed = true
+		}
+	}
+	if changed {
+		if err := saveCollections(); err != nil {
+			fmt.Printf("⚠️  Failed to save collections: %v\n", err)
+		}
+	}
+	collections.Unlock()
+}
+
+// purgeAt is when a session in the trash will be deleted for good, or nil
+// if it's kept until the trash is emptied.
+func purgeAt(manifest *SessionManifest) *time.Time {
+	if trashRetention <= 0 {
+		return nil
+	}
+	at := manifest.Deleted.At.Add(trashRetention)
+	return &at
+}
+
+// purgeOldTrash deletes sessions that have been in the trash longer than
+// trashRetention, every trashCheckInterval.
+func purgeOldTrash() {
+	for {
+		for _, manifest := range trashedSessions() {
+			if at := purgeAt(manifest); at == nil || time.Now().Before(*at) {
+				continue
+			}
+			manifestMu.Lock()
+			err := purgeSession(manifest)
+			manifestMu.Unlock()
+			if err != nil {
+				fmt.Printf("⚠️  Failed to empty the trash: %v\n", err)
+				continue
+			}
+			fmt.Printf("✓ Deleted %s from the trash for good\n", manifest.ID)
+		}
+		time.Sleep(trashCheckInterval)
+	}
+}
+
+// Handler: DELETE /api/sessions/{id} - Move a finished session to the
+// trash, where it can be restored from until it's purged
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readSessionManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	if sessionBusy(id) {
+		writeError(w, http.StatusConflict, errCodeConflict, "Jobs are queued or running on the session; wait for them or cancel them first")
+		return
+	}
+	by := ""
+	if u := requestUser(r); u != nil {
+		by = u.Name
+	}
+	if err := trashSession(manifest, by); err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	forgetSessionAlerts(manifest.files())
+	fmt.Printf("✓ Moved %s to the trash\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler: GET /api/trash - List deleted sessions, most recently deleted
+// first
+func handleListTrash(w http.ResponseWriter, r *http.Request) {
+	list := []TrashedSession{}
+	for _, manifest := range trashedSessions() {
+		item := TrashedSession{
+			ID:        manifest.ID,
+			Title:     manifest.Title,
+			StartedAt: manifest.StartedAt,
+			DeletedAt: manifest.Deleted.At,
+			DeletedBy: manifest.Deleted.By,
+			PurgeAt:   purgeAt(manifest),
+			Files:     manifest.files(),
+		}
+		for _, track := range manifest.Tracks {
+			item.SizeBytes += track.Size
+		}
+		list = append(list, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: POST /api/trash/{id}/restore - Put a deleted session back
+func handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readTrashedManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not in the trash")
+		return
+	}
+	for _, name := range append(manifest.files(), id+".json") {
+		if _, err := os.Stat(filepath.Join(outputDirectory, name)); err == nil {
+			writeError(w, http.StatusConflict, errCodeConflict, fmt.Sprintf("Another %s is in the recordings folder; move it before restoring", name))
+			return
+		}
+	}
+	if err := restoreSession(manifest); err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	fmt.Printf("✓ Restored %s from the trash\n", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// Handler: DELETE /api/trash/{id} - Delete a session in the trash for good
+func handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validSessionID(id) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid session ID")
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := readTrashedManifest(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Session not in the trash")
+		return
+	}
+	if err := purgeSession(manifest); err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	fmt.Printf("✓ Deleted %s for good\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler: DELETE /api/trash - Empty the trash, deleting every session in it
+// for good
+func handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	purged := []string{}
+	for _, manifest := range trashedSessions() {
+		if err := purgeSession(manifest); err != nil {
+			writeStorageError(w, errCodeInternal, err)
+			return
+		}
+		purged = append(purged, manifest.ID)
+	}
+	if len(purged) > 0 {
+		fmt.Printf("✓ Emptied the trash (%d sessions)\n", len(purged))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deleted": purged})
+}
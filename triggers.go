@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// triggerRetryDelay is how long to wait before reopening an unplugged device.
+const triggerRetryDelay = 5 * time.Second
+
+// triggersFile maps hardware buttons to recorder commands.
+var triggersFile = "triggers.json"
+
+// Trigger maps a MIDI note/controller or an HID key to a command
+type Trigger struct {
+	Type   string `json:"type"`   // "midi" or "hid"
+	Device string `json:"device"` // e.g. /dev/snd/midiC1D0 or /dev/input/by-id/...-event-kbd
+
+	// MIDI triggers fire on a note-on for Note, or a controller value of 64
+	// or more for CC (a sustain pedal is CC 64). HID triggers fire when Key
+	// (a Linux key code, e.g. 30 for A) is pressed.
+	Note *int `json:"note,omitempty"`
+	CC   *int `json:"cc,omitempty"`
+	Key  *int `json:"key,omitempty"`
+
+	Action string `json:"action"` // "toggle", "start", "stop" or "marker"
+	Preset string `json:"preset,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+// triggerEvent is a press read from an input device.
+type triggerEvent struct {
+	note, cc, key int // -1 when not applicable
+}
+
+// loadTriggers reads the triggers file. A missing file means no triggers.
+func loadTriggers() ([]Trigger, error) {
+	data, err := os.ReadFile(triggersFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var triggers []Trigger
+	if err := json.Unmarshal(data, &triggers); err != nil {
+		return nil, fmt.Errorf("invalid triggers file %s: %w", triggersFile, err)
+	}
+	for _, t := range triggers {
+		if t.Type != "midi" && t.Type != "hid" {
+			return nil, fmt.Errorf("trigger for %s: type must be \"midi\" or \"hid\"", t.Device)
+		}
+		if t.Device == "" {
+			return nil, fmt.Errorf("trigger is missing a device")
+		}
+	}
+	return triggers, nil
+}
+
+// startTriggers opens every device named in the triggers file and listens for
+// presses in the background. It returns the number of devices watched.
+func startTriggers() (int, error) {
+	triggers, err := loadTriggers()
+	if err != nil {
+		return 0, err
+	}
+
+	type source struct{ kind, device string }
+	bySource := map[source][]Trigger{}
+	for _, t := range triggers {
+		key := source{t.Type, t.Device}
+		bySource[key] = append(bySource[key], t)
+	}
+	for src, ts := range bySource {
+		go watchTriggerDevice(src.kind, src.device, ts)
+	}
+	return len(bySource), nil
+}
+
+// watchTriggerDevice reads presses from one device for the life of the
+// process, reopening it if it's unplugged.
+func watchTriggerDevice(kind, device string, triggers []Trigger) {
+	for {
+		err := readTriggerDevice(kind, device, func(ev triggerEvent) {
+			for _, t := range triggers {
+				if t.matches(ev) {
+					fireTrigger(t)
+				}
+			}
+		})
+		fmt.Printf("⚠️  Trigger device %s unavailable: %v (retrying in %s)\n", device, err, triggerRetryDelay)
+		time.Sleep(triggerRetryDelay)
+	}
+}
+
+// readTriggerDevice opens a device and passes its presses to fn until it fails.
+func readTriggerDevice(kind, device string, fn func(triggerEvent)) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if kind == "hid" {
+		return readHIDEvents(f, fn)
+	}
+	return readMIDIEvents(bufio.NewReader(f), fn)
+}
+
+// matches reports whether a press should fire this trigger.
+func (t Trigger) matches(ev triggerEvent) bool {
+	switch {
+	case t.Note != nil:
+		return ev.note == *t.Note
+	case t.CC != nil:
+		return ev.cc == *t.CC
+	case t.Key != nil:
+		return ev.key == *t.Key
+	}
+	return false
+}
+
+// fireTrigger runs a trigger's command and reports the outcome.
+func fireTrigger(t Trigger) {
+	result := runCommand(context.Background(), RemoteCommand{Action: t.Action, Preset: t.Preset, Label: t.Label})
+	if result.Status >= 400 {
+		fmt.Printf("⚠️  Trigger %s on %s failed: %s\n", t.Action, t.Device, result.Response)
+		return
+	}
+	fmt.Printf("🎛️  Trigger %s on %s\n", t.Action, t.Device)
+}
+
+// readMIDIEvents parses a raw MIDI byte stream, reporting note-ons and
+// controllers pushed past halfway. Running status, realtime bytes and SysEx
+// are handled so they don't confuse the parser.
+func readMIDIEvents(r io.ByteReader, fn func(triggerEvent)) error {
+	var status byte
+	var data []byte
+	inSysEx := false
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case b >= 0xF8:
+			// Realtime messages can appear anywhere and carry no data
+			continue
+		case b == 0xF0:
+			inSysEx = true
+			continue
+		case b == 0xF7:
+			inSysEx = false
+			continue
+		case inSysEx:
+			continue
+		case b >= 0x80:
+			status = b
+			data = data[:0]
+			continue
+		}
+
+		data = append(data, b)
+		if len(data) < midiDataLength(status) {
+			continue
+		}
+
+		switch status & 0xF0 {
+		case 0x90:
+			if data[1] > 0 {
+				fn(triggerEvent{note: int(data[0]), cc: -1, key: -1})
+			}
+		case 0xB0:
+			if data[1] >= 64 {
+				fn(triggerEvent{note: -1, cc: int(data[0]), key: -1})
+			}
+		}
+		data = data[:0]
+	}
+}
+
+// midiDataLength is how many data bytes follow a status byte.
+func midiDataLength(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 1
+	case 0xF0:
+		switch status {
+		case 0xF1, 0xF3:
+			return 1
+		case 0xF2:
+			return 2
+		}
+		return 0
+	}
+	return 2
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+)
+
+// Linux input_event: a timeval followed by type, code and value.
+const (
+	evKey        = 0x01
+	keyPressed   = 1
+	timevalBytes = 2 * int(unsafe.Sizeof(uintptr(0)))
+	eventBytes   = timevalBytes + 8
+)
+
+// readHIDEvents reads key presses from a Linux evdev device
+// (/dev/input/event*), such as a USB footswitch that acts as a keyboard.
+func readHIDEvents(r io.Reader, fn func(triggerEvent)) error {
+	buf := make([]byte, eventBytes)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		evType := binary.NativeEndian.Uint16(buf[timevalBytes:])
+		code := binary.NativeEndian.Uint16(buf[timevalBytes+2:])
+		value := int32(binary.NativeEndian.Uint32(buf[timevalBytes+4:]))
+		if evType == evKey && value == keyPressed {
+			fn(triggerEvent{note: -1, cc: -1, key: int(code)})
+		}
+	}
+}
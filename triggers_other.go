@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// readHIDEvents is only implemented for Linux evdev devices.
+func readHIDEvents(r io.Reader, fn func(triggerEvent)) error {
+	return fmt.Errorf("HID triggers are not supported on %s", runtime.GOOS)
+}
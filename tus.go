@@ -0,0 +1,352 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tusPath is where resumable imports are created, following the tus
+// protocol so any tus client (tus-js-client, Uppy, tusd's CLI...) can pick
+// an interrupted upload up where it stopped.
+const tusPath = importPath + "/tus"
+
+const (
+	tusVersion = "1.0.0"
+
+	// tusExpiry is how long an unfinished upload is kept.
+	tusExpiry = 24 * time.Hour
+)
+
+// tusUpload describes a resumable import. It's saved next to the data as
+// .upload-<id>.json, so uploads survive a server restart.
+type tusUpload struct {
+	ID       string    `json:"id"`
+	Length   int64     `json:"length"`
+	Filename string    `json:"filename"`
+	Title    string    `json:"title,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Created  time.Time `json:"created"`
+
+	// SessionID is set once the upload has been imported
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+// tusMu guards uploads' descriptions and tusBusy.
+var tusMu sync.Mutex
+
+// tusBusy holds the uploads a PATCH is appending to, so two can't append at
+// the same offset. Guarded by tusMu.
+var tusBusy = map[string]bool{}
+
+// tusDataPath and tusInfoPath are where an upload's data and description
+// are kept. The leading dot keeps them out of the catalog and the watcher.
+func tusDataPath(id string) string { return filepath.Join(outputDirectory, ".upload-"+id) }
+func tusInfoPath(id string) string { return filepath.Join(outputDirectory, ".upload-"+id+".json") }
+
+// readTusUpload loads an upload's description.
+func readTusUpload(id string) (*tusUpload, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(tusInfoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if time.Since(upload.Created) > tusExpiry {
+		return nil, os.ErrNotExist
+	}
+	return &upload, nil
+}
+
+func (u *tusUpload) save() error {
+	data, _ := json.Marshal(u)
+	return writeFileAtomic(tusInfoPath(u.ID), data)
+}
+
+// remove deletes the upload's files.
+func (u *tusUpload) remove() {
+	os.Remove(tusDataPath(u.ID))
+	os.Remove(tusInfoPath(u.ID))
+}
+
+// expireTusUploads removes uploads older than tusExpiry. Must be called with
+// tusMu held.
+func expireTusUploads() {
+	paths, _ := filepath.Glob(filepath.Join(outputDirectory, ".upload-*.json"))
+	for _, path := range paths {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), ".upload-"), ".json")
+		if _, err := readTusUpload(id); errors.Is(err, os.ErrNotExist) && !tusBusy[id] {
+			(&tusUpload{ID: id}).remove()
+		}
+	}
+}
+
+// parseTusMetadata parses Upload-Metadata: comma separated keys, each with
+// a base64 value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s isn't base64", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// setTusHeaders adds the headers every tus response carries.
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusVersion refuses requests from clients speaking another version of
+// the protocol.
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeError(w, http.StatusPreconditionFailed, errCodeInvalidRequest, "Tus-Resumable must be "+tusVersion)
+		return false
+	}
+	return true
+}
+
+// Handler: OPTIONS /api/recordings/import/tus - Describe the tus server
+func handleTusOptions(maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setTusHeaders(w)
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination,expiration")
+		if maxBytes > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxBytes, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Handler: POST /api/recordings/import/tus - Create a resumable import of
+// Upload-Length bytes. Upload-Metadata may give its filename, title and
+// tags (comma separated).
+func handleTusCreate(maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setTusHeaders(w)
+		if !checkTusVersion(w, r) {
+			return
+		}
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Upload-Length is required")
+			return
+		}
+		if maxBytes > 0 && length > maxBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", maxBytes))
+			return
+		}
+		metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+			return
+		}
+
+		tusMu.Lock()
+		expireTusUploads()
+		tusMu.Unlock()
+		id := make([]byte, 16)
+		rand.Read(id)
+		upload := &tusUpload{
+			ID:       hex.EncodeToString(id),
+			Length:   length,
+			Filename: cmp.Or(metadata["filename"], metadata["name"], "import.wav"),
+			Title:    strings.TrimSpace(metadata["title"]),
+			Created:  time.Now().UTC(),
+		}
+		for _, tag := range strings.Split(metadata["tags"], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				upload.Tags = append(upload.Tags, tag)
+			}
+		}
+		f, err := createNewFile(tusDataPath(upload.ID))
+		if err != nil {
+			writeStorageError(w, errCodeInternal, err)
+			return
+		}
+		f.Close()
+		if err := upload.save(); err != nil {
+			upload.remove()
+			writeStorageError(w, errCodeInternal, err)
+			return
+		}
+
+		w.Header().Set("Location", tusPath+"/"+upload.ID)
+		w.Header().Set("Upload-Expires", upload.Created.Add(tusExpiry).Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// Handler: HEAD /api/recordings/import/tus/{id} - How much of an upload has
+// arrived, as Upload-Offset. A finished upload's session is in Session-Id.
+func handleTusHead(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	tusMu.Lock()
+	defer tusMu.Unlock()
+	upload, err := readTusUpload(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	offset := upload.Length
+	if upload.SessionID == "" {
+		info, err := os.Stat(tusDataPath(upload.ID))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		offset = info.Size()
+	} else {
+		w.Header().Set("Session-Id", upload.SessionID)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Created.Add(tusExpiry).Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Handler: PATCH /api/recordings/import/tus/{id} - Append to an upload at
+// Upload-Offset. Whatever arrives is kept even if the connection drops, and
+// the upload is imported like POST /api/recordings/import once it's whole,
+// with the new session's ID in Session-Id.
+func handleTusPatch(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, http.StatusUnsupportedMediaType, errCodeInvalidRequest, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Upload-Offset is required")
+		return
+	}
+
+	tusMu.Lock()
+	upload, err := readTusUpload(r.PathValue("id"))
+	if err != nil || upload.SessionID != "" {
+		tusMu.Unlock()
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	}
+	if tusBusy[upload.ID] {
+		tusMu.Unlock()
+		writeError(w, http.StatusLocked, errCodeInvalidRequest, "Upload is busy with another request")
+		return
+	}
+	tusBusy[upload.ID] = true
+	tusMu.Unlock()
+	defer func() {
+		tusMu.Lock()
+		delete(tusBusy, upload.ID)
+		tusMu.Unlock()
+	}()
+
+	f, err := os.OpenFile(tusDataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	if info.Size() != offset {
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, fmt.Sprintf("Upload-Offset is %d, not %d", info.Size(), offset))
+		return
+	}
+
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(copyErr, &tooLarge) {
+			writeDecodeError(w, copyErr)
+		} else {
+			// Most likely the client went away; it resumes from the offset
+			writeStorageError(w, errCodeInternal, copyErr)
+		}
+		return
+	}
+	if offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	f.Close()
+	data, err := os.Open(tusDataPath(upload.ID))
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	manifest, err := importUpload(data, upload.Filename, upload.Title, upload.Tags)
+	if errors.Is(err, errNotAudio) {
+		upload.remove()
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeStorageError(w, errCodeInternal, err)
+		return
+	}
+	tusMu.Lock()
+	upload.SessionID = manifest.ID
+	upload.save()
+	tusMu.Unlock()
+	w.Header().Set("Session-Id", manifest.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler: DELETE /api/recordings/import/tus/{id} - Abandon an upload
+func handleTusDelete(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	tusMu.Lock()
+	defer tusMu.Unlock()
+	upload, err := readTusUpload(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	}
+	if tusBusy[upload.ID] {
+		writeError(w, http.StatusLocked, errCodeInvalidRequest, "Upload is busy with another request")
+		return
+	}
+	upload.remove()
+	w.WriteHeader(http.StatusNoContent)
+}
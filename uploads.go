@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// uploadSpeedInterval is how often an upload's speed is measured.
+	uploadSpeedInterval = time.Second
+
+	// uploadSpeedSmoothing is how much each measurement moves the speed
+	// reported, so it doesn't jump about with every burst.
+	uploadSpeedSmoothing = 0.3
+)
+
+// uploadProgress follows an upload job's current attempt: which file it's
+// sending and how many bytes have gone, and how fast
+type uploadProgress struct {
+	mu               sync.Mutex
+	file             string // being sent
+	files, filesDone int
+	total, sent      int64
+	speed            float64 // bytes per second, smoothed
+
+	// When the speed was last measured and what had been sent by then, and
+	// when the last bytes went
+	sampledAt    time.Time
+	sampledSent  int64
+	lastProgress time.Time
+}
+
+// begin starts following an attempt that will send files of total bytes.
+func (u *uploadProgress) begin(files int, total int64) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.file, u.files, u.filesDone, u.total, u.sent, u.speed = "", files, 0, total, 0, 0
+	u.sampledAt, u.sampledSent, u.lastProgress = time.Now(), 0, time.Time{}
+}
+
+// startFile notes the file being sent.
+func (u *uploadProgress) startFile(name string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.file = name
+}
+
+// add counts n more bytes sent, measuring the speed every
+// uploadSpeedInterval.
+func (u *uploadProgress) add(n int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	u.sent += int64(n)
+	u.lastProgress = now
+	if elapsed := now.Sub(u.sampledAt); elapsed >= uploadSpeedInterval {
+		speed := float64(u.sent-u.sampledSent) / elapsed.Seconds()
+		if u.speed == 0 {
+			u.speed = speed
+		} else {
+			u.speed += uploadSpeedSmoothing * (speed - u.speed)
+		}
+		u.sampledAt, u.sampledSent = now, u.sent
+	}
+}
+
+// finishFile counts the file being sent as sent.
+func (u *uploadProgress) finishFile() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.file = ""
+	u.filesDone++
+}
+
+// Upload is an upload job as /api/uploads shows it, with how its current or
+// last attempt is going, to tell a slow upload from a stuck one
+type Upload struct {
+	ID        int64  `json:"id"` // the job's
+	SessionID string `json:"sessionId"`
+	Status    string `json:"status"` // queued, running, paused, done, failed or canceled
+
+	// File is being sent, and the counts are of files and bytes the
+	// attempt had to send, and has sent
+	File       string `json:"file,omitempty"`
+	FilesDone  int    `json:"filesDone"`
+	Files      int    `json:"files"`
+	BytesSent  int64  `json:"bytesSent"`
+	BytesTotal int64  `json:"bytesTotal"`
+
+	// BytesPerSecond falls towards 0 when nothing is getting through,
+	// and ETASeconds is left out while it's 0 or the upload isn't running
+	BytesPerSecond float64    `json:"bytesPerSecond"`
+	ETASeconds     *float64   `json:"etaSeconds,omitempty"`
+	LastProgressAt *time.Time `json:"lastProgressAt,omitempty"`
+
+	Attempts   int        `json:"attempts"`
+	LastError  string     `json:"lastError,omitempty"`
+	RetryAt    *time.Time `json:"retryAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// uploadOf describes an upload job.
+func uploadOf(job Job) Upload {
+	upload := Upload{
+		ID:         job.ID,
+		SessionID:  job.SessionID,
+		Status:     job.Status,
+		Attempts:   job.Attempts,
+		RetryAt:    job.RetryAt,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if len(job.Errors) > 0 {
+		upload.LastError = job.Errors[len(job.Errors)-1]
+	}
+	u := job.upload
+	if u == nil {
+		return upload
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload.File, upload.FilesDone, upload.Files = u.file, u.filesDone, u.files
+	upload.BytesSent, upload.BytesTotal = u.sent, u.total
+	if !u.lastProgress.IsZero() {
+		at := u.lastProgress.UTC()
+		upload.LastProgressAt = &at
+	}
+	if job.Status != jobRunning {
+		return upload
+	}
+	// A stall shows at once, rather than after the smoothing catches up
+	speed := u.speed
+	if since := time.Since(u.sampledAt); since > 2*uploadSpeedInterval {
+		speed = min(speed, float64(u.sent-u.sampledSent)/since.Seconds())
+	}
+	upload.BytesPerSecond = speed
+	if speed > 0 {
+		eta := float64(u.total-u.sent) / speed
+		upload.ETASeconds = &eta
+	}
+	return upload
+}
+
+// uploadJob finds the upload job with the ID in the request's path. Must be
+// called with jobs locked.
+func uploadJob(r *http.Request) *Job {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	for _, job := range jobs.list {
+		if job.ID == id && job.Kind == jobUpload {
+			return job
+		}
+	}
+	return nil
+}
+
+// Handler: GET /api/uploads - List uploads to -storage, oldest first,
+// optionally only those with ?status= or for ?session=
+func handleListUploads(w http.ResponseWriter, r *http.Request) {
+	status, sessionID := r.URL.Query().Get("status"), r.URL.Query().Get("session")
+	list := []Upload{}
+	for _, job := range jobList() {
+		if job.Kind != jobUpload || status != "" && job.Status != status || sessionID != "" && job.SessionID != sessionID {
+			continue
+		}
+		list = append(list, uploadOf(job))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Handler: GET /api/uploads/{id} - Get one upload and how it's going
+func handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	jobs.Lock()
+	found := uploadJob(r)
+	var job Job
+	if found != nil {
+		job = *found
+	}
+	jobs.Unlock()
+	if found == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadOf(job))
+}
+
+// Handler: POST /api/uploads/{id}/pause - Pause an upload, abandoning the
+// file it's sending, until it's resumed
+func handlePauseUpload(w http.ResponseWriter, r *http.Request) {
+	jobs.Lock()
+	job := uploadJob(r)
+	switch {
+	case job == nil:
+		jobs.Unlock()
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	case job.Status == jobPaused:
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Upload is already paused")
+		return
+	case job.Status != jobQueued && job.Status != jobRunning:
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Upload has already finished")
+		return
+	}
+	// A running attempt stops, and runJob leaves the job paused
+	if job.cancel != nil {
+		job.cancel()
+	}
+	job.Status, job.RetryAt = jobPaused, nil
+	paused := *job
+	jobs.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadOf(paused))
+}
+
+// Handler: POST /api/uploads/{id}/resume - Queue a paused upload again. It
+// starts from the first file that isn't stored yet.
+func handleResumeUpload(w http.ResponseWriter, r *http.Request) {
+	jobs.Lock()
+	job := uploadJob(r)
+	switch {
+	case job == nil:
+		jobs.Unlock()
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	case job.Status != jobPaused:
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Upload isn't paused")
+		return
+	case job.cancel != nil:
+		// The paused attempt hasn't stopped yet, and the job can't run twice
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Upload is still pausing; try again in a moment")
+		return
+	}
+	job.Status = jobQueued
+	resumed := *job
+	jobs.Unlock()
+	select {
+	case jobs.wake <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadOf(resumed))
+}
+
+// Handler: DELETE /api/uploads/{id} - Cancel a queued, running or paused
+// upload
+func handleCancelUpload(w http.ResponseWriter, r *http.Request) {
+	jobs.Lock()
+	job := uploadJob(r)
+	switch {
+	case job == nil:
+		jobs.Unlock()
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Upload not found")
+		return
+	case !slices.Contains([]string{jobQueued, jobRunning, jobPaused}, job.Status):
+		jobs.Unlock()
+		writeError(w, http.StatusConflict, errCodeInvalidRequest, "Upload has already finished")
+		return
+	}
+	canceled := cancelJob(job)
+	jobs.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadOf(canceled))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gen2brain/malgo"
+)
+
+// virtualOutputName is the playback device the live mix is fed to, from
+// -virtual-output. Empty turns the live mix off.
+var virtualOutputName string
+
+// liveOutput plays every recording device, mixed, into the virtual output,
+// for OBS or Discord to record exactly what's being recorded. Unlike the
+// monitor it isn't affected by solo, and it's on for as long as the server
+// runs.
+type liveOutput struct {
+	device *malgo.Device
+
+	// sources is read by the playback callback, so it's swapped atomically
+	sources atomic.Pointer[[]*captureDevice]
+	mix     []int32
+}
+
+// liveMix is the live mix fed to -virtual-output
+var liveMix = &liveOutput{}
+
+// start opens the playback device named name and starts feeding it. On Linux
+// a missing device is created as a PulseAudio/PipeWire null sink, whose
+// monitor other programs record from; elsewhere it has to be a virtual
+// cable that's already installed, such as VB-Cable or BlackHole.
+func (o *liveOutput) start(ctx malgo.Context, name string) error {
+	info, err := findPlaybackDevice(ctx, name)
+	if err != nil && runtime.GOOS == "linux" {
+		if createErr := createNullSink(name); createErr != nil {
+			return fmt.Errorf("%v, and creating it failed: %v", err, createErr)
+		}
+		info, err = findPlaybackDevice(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = 1
+	deviceConfig.SampleRate = 44100
+	deviceConfig.Playback.DeviceID = info.ID.Pointer()
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{Data: o.onData})
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s: %v", info.Name(), err)
+	}
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		return fmt.Errorf("failed to start %s: %v", info.Name(), err)
+	}
+	o.device = device
+	fmt.Printf("✓ Live mix → %s\n", info.Name())
+	return nil
+}
+
+// findPlaybackDevice finds the playback device called name, or failing that
+// the one whose name contains it, ignoring case.
+func findPlaybackDevice(ctx malgo.Context, name string) (malgo.DeviceInfo, error) {
+	infos, err := ctx.Devices(malgo.Playback)
+	if err != nil {
+		return malgo.DeviceInfo{}, fmt.Errorf("failed to get playback devices: %v", err)
+	}
+	for _, info := range infos {
+		if strings.EqualFold(info.Name(), name) {
+			return info, nil
+		}
+	}
+	for _, info := range infos {
+		if strings.Contains(strings.ToLower(info.Name()), strings.ToLower(name)) {
+			return info, nil
+		}
+	}
+	return malgo.DeviceInfo{}, fmt.Errorf("no playback device called %q", name)
+}
+
+// createNullSink creates a null sink described as name with pactl. It's left
+// in place when the server exits, and found by name next time.
+func createNullSink(name string) error {
+	if _, err := exec.LookPath("pactl"); err != nil {
+		return fmt.Errorf("pactl isn't installed")
+	}
+	sinkName := "skribbl_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	out, err := exec.Command("pactl", "load-module", "module-null-sink",
+		"sink_name="+sinkName,
+		"sink_properties=device.description=\""+strings.ReplaceAll(name, "\"", "")+"\"").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pactl: %s", strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("✓ Created virtual output %s\n", name)
+	return nil
+}
+
+// sync points the live mix at the active session's devices. Must be called
+// with recordingMutex held whenever the session's devices change.
+func (o *liveOutput) sync() {
+	if o.device == nil {
+		return
+	}
+	var captures []*captureDevice
+	if activeSession != nil {
+		captures = activeSession.captures
+	}
+	for _, old := range o.current() {
+		if !slices.Contains(captures, old) {
+			old.mixed.Store(false)
+		}
+	}
+	for _, cap := range captures {
+		if !cap.mixed.Load() {
+			// Nothing is reading or writing the ring yet
+			cap.mixRing.reset()
+		}
+	}
+	sources := append([]*captureDevice(nil), captures...)
+	o.sources.Store(&sources)
+	for _, cap := range sources {
+		cap.mixed.Store(true)
+	}
+}
+
+// current is the devices being mixed.
+func (o *liveOutput) current() []*captureDevice {
+	if sources := o.sources.Load(); sources != nil {
+		return *sources
+	}
+	return nil
+}
+
+// onData is the playback callback: it mixes every recording device's queued
+// samples into the output.
+func (o *liveOutput) onData(pOutput, pInput []byte, framecount uint32) {
+	frames := int(framecount)
+	if cap(o.mix) < frames {
+		o.mix = make([]int32, frames)
+	}
+	mix := o.mix[:frames]
+	clear(mix)
+
+	for _, source := range o.current() {
+		source.mixRing.mixInto(mix)
+	}
+
+	for i, sample := range mix {
+		sample = max(min(sample, 32767), -32768)
+		if 2*i+1 < len(pOutput) {
+			pOutput[2*i] = byte(sample)
+			pOutput[2*i+1] = byte(uint16(sample) >> 8)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// inputVolume sets each capture device's OS input volume to this percentage
+// and unmutes it for as long as it records, restoring it afterwards, so
+// sessions are recorded at the same level every night. Negative leaves the
+// OS mixer alone. Set from flags in web mode.
+var inputVolume = -1.0
+
+// OSVolume is a capture device's volume and mute state in the OS mixer
+type OSVolume struct {
+	Percent float64 `json:"percent"`
+	Muted   bool    `json:"muted"`
+}
+
+// normalizeInputVolume reads the device's OS input volume and, with
+// -input-volume, sets it for the recording, remembering the old state for
+// restoreInputVolume. Failures are only warned about: the recording goes
+// ahead at whatever level the OS has.
+func (c *captureDevice) normalizeInputVolume() {
+	if c.isLoopback {
+		return
+	}
+	current, err := readInputVolume(c.source)
+	if err != nil {
+		if inputVolume >= 0 {
+			fmt.Printf("⚠️  Can't read the input volume of %s: %v\n", c.name, err)
+		}
+		return
+	}
+	c.osVolume = &current
+	if inputVolume < 0 || (current.Percent == inputVolume && !current.Muted) {
+		return
+	}
+
+	want := OSVolume{Percent: inputVolume}
+	if err := writeInputVolume(c.source, want); err != nil {
+		fmt.Printf("⚠️  Can't set the input volume of %s: %v\n", c.name, err)
+		return
+	}
+	c.osVolume, c.osVolumeBefore = &want, &current
+}
+
+// restoreInputVolume puts back the OS input volume normalizeInputVolume
+// changed.
+func (c *captureDevice) restoreInputVolume() {
+	if c.osVolumeBefore == nil {
+		return
+	}
+	if err := writeInputVolume(c.source, *c.osVolumeBefore); err != nil {
+		fmt.Printf("⚠️  Can't restore the input volume of %s: %v\n", c.name, err)
+	}
+}
+
+// volumePercent rounds a 0-1 mixer level to a percentage with one decimal.
+func volumePercent(scalar float32) float64 {
+	return math.Round(float64(scalar)*1000) / 10
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// readInputVolume isn't implemented on this platform.
+func readInputVolume(selected selectableDevice) (OSVolume, error) {
+	return OSVolume{}, fmt.Errorf("input volume control is not available on %s", runtime.GOOS)
+}
+
+// writeInputVolume isn't implemented on this platform.
+func writeInputVolume(selected selectableDevice, v OSVolume) error {
+	return fmt.Errorf("input volume control is not available on %s", runtime.GOOS)
+}
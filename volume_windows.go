@@ -0,0 +1,153 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/gen2brain/malgo"
+)
+
+var (
+	ole32                = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx   = ole32.NewProc("CoInitializeEx")
+	procCoUninitialize   = ole32.NewProc("CoUninitialize")
+	procCoCreateInstance = ole32.NewProc("CoCreateInstance")
+)
+
+// Core Audio class and interface IDs
+var (
+	clsidMMDeviceEnumerator = syscall.GUID{Data1: 0xBCDE0395, Data2: 0xE52F, Data3: 0x467C, Data4: [8]byte{0x8E, 0x3D, 0xC4, 0x57, 0x92, 0x91, 0x69, 0x2E}}
+	iidIMMDeviceEnumerator  = syscall.GUID{Data1: 0xA95664D2, Data2: 0x9614, Data3: 0x4F35, Data4: [8]byte{0xA7, 0x46, 0xDE, 0x8D, 0xB6, 0x36, 0x17, 0xE6}}
+	iidIAudioEndpointVolume = syscall.GUID{Data1: 0x5CDF2C82, Data2: 0x841E, Data3: 0x4546, Data4: [8]byte{0x97, 0x22, 0x0C, 0xF7, 0x40, 0x78, 0x22, 0x9A}}
+)
+
+const (
+	clsctxAll           = 0x17
+	coinitMultithreaded = 0x0
+	rpcEChangedMode     = 0x80010106
+
+	// Vtable slots of the methods used, after IUnknown's three
+	comRelease                         = 2
+	enumeratorGetDevice                = 5
+	deviceActivate                     = 3
+	endpointSetMasterVolumeLevelScalar = 7
+	endpointGetMasterVolumeLevelScalar = 9
+	endpointSetMute                    = 14
+	endpointGetMute                    = 15
+)
+
+// comObject is a COM interface pointer's target: a pointer to its vtable.
+type comObject struct {
+	vtbl *[32]uintptr
+}
+
+func (o *comObject) release() {
+	syscall.SyscallN(o.vtbl[comRelease], uintptr(unsafe.Pointer(o)))
+}
+
+// hresult turns a COM call's result into an error.
+func hresult(hr, _ uintptr, _ syscall.Errno) error {
+	if int32(hr) < 0 {
+		return fmt.Errorf("HRESULT 0x%08X", uint32(hr))
+	}
+	return nil
+}
+
+// readInputVolume reads a capture device's master volume and mute state from
+// its Core Audio endpoint.
+func readInputVolume(selected selectableDevice) (OSVolume, error) {
+	var v OSVolume
+	err := withEndpointVolume(selected, func(vol *comObject) error {
+		var level float32
+		var muted int32
+		if err := hresult(syscall.SyscallN(vol.vtbl[endpointGetMasterVolumeLevelScalar], uintptr(unsafe.Pointer(vol)), uintptr(unsafe.Pointer(&level)))); err != nil {
+			return fmt.Errorf("failed to read volume: %w", err)
+		}
+		if err := hresult(syscall.SyscallN(vol.vtbl[endpointGetMute], uintptr(unsafe.Pointer(vol)), uintptr(unsafe.Pointer(&muted)))); err != nil {
+			return fmt.Errorf("failed to read mute state: %w", err)
+		}
+		v = OSVolume{Percent: volumePercent(level), Muted: muted != 0}
+		return nil
+	})
+	return v, err
+}
+
+// writeInputVolume sets a capture device's master volume and mute state.
+func writeInputVolume(selected selectableDevice, v OSVolume) error {
+	return withEndpointVolume(selected, func(vol *comObject) error {
+		// The level is a float, which the call passes in the matching
+		// floating point register
+		level := math.Float32bits(float32(v.Percent / 100))
+		if err := hresult(syscall.SyscallN(vol.vtbl[endpointSetMasterVolumeLevelScalar], uintptr(unsafe.Pointer(vol)), uintptr(level), 0)); err != nil {
+			return fmt.Errorf("failed to set volume: %w", err)
+		}
+		var muted uintptr
+		if v.Muted {
+			muted = 1
+		}
+		if err := hresult(syscall.SyscallN(vol.vtbl[endpointSetMute], uintptr(unsafe.Pointer(vol)), muted, 0)); err != nil {
+			return fmt.Errorf("failed to set mute state: %w", err)
+		}
+		return nil
+	})
+}
+
+// withEndpointVolume opens the IAudioEndpointVolume of the device's endpoint,
+// found by the WASAPI endpoint ID miniaudio reports for it.
+func withEndpointVolume(selected selectableDevice, fn func(vol *comObject) error) error {
+	id := endpointID(selected.info.ID)
+	if len(id) <= 1 {
+		return fmt.Errorf("no endpoint ID; input volume needs the WASAPI backend")
+	}
+
+	// COM is initialized per thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	hr, _, _ := procCoInitializeEx.Call(0, coinitMultithreaded)
+	if int32(hr) >= 0 {
+		defer procCoUninitialize.Call()
+	} else if uint32(hr) != rpcEChangedMode {
+		return fmt.Errorf("failed to initialize COM: HRESULT 0x%08X", uint32(hr))
+	}
+
+	var enumerator *comObject
+	hr, _, _ = procCoCreateInstance.Call(
+		uintptr(unsafe.Pointer(&clsidMMDeviceEnumerator)), 0, clsctxAll,
+		uintptr(unsafe.Pointer(&iidIMMDeviceEnumerator)), uintptr(unsafe.Pointer(&enumerator)))
+	if int32(hr) < 0 {
+		return fmt.Errorf("failed to open the device enumerator: HRESULT 0x%08X", uint32(hr))
+	}
+	defer enumerator.release()
+
+	var device *comObject
+	if err := hresult(syscall.SyscallN(enumerator.vtbl[enumeratorGetDevice], uintptr(unsafe.Pointer(enumerator)), uintptr(unsafe.Pointer(&id[0])), uintptr(unsafe.Pointer(&device)))); err != nil {
+		return fmt.Errorf("endpoint not found: %w", err)
+	}
+	defer device.release()
+
+	var vol *comObject
+	if err := hresult(syscall.SyscallN(device.vtbl[deviceActivate], uintptr(unsafe.Pointer(device)), uintptr(unsafe.Pointer(&iidIAudioEndpointVolume)), clsctxAll, 0, uintptr(unsafe.Pointer(&vol)))); err != nil {
+		return fmt.Errorf("failed to open endpoint volume: %w", err)
+	}
+	defer vol.release()
+	return fn(vol)
+}
+
+// endpointID reads the NUL-terminated UTF-16 endpoint ID miniaudio stores in
+// a WASAPI device ID.
+func endpointID(id malgo.DeviceID) []uint16 {
+	var s []uint16
+	for i := 0; i+1 < len(id); i += 2 {
+		c := uint16(id[i]) | uint16(id[i+1])<<8
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return append(s, 0)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// watchdogInterval is how often recording devices are checked for stalls.
+const watchdogInterval = time.Second
+
+// stallTimeout is how long a device may go without delivering audio before
+// the watchdog restarts it. 0 disables the watchdog.
+var stallTimeout = 5 * time.Second
+
+// runWatchdog restarts recording devices that stop delivering audio while
+// still reporting themselves started, which some drivers do silently.
+func runWatchdog() {
+	for range time.Tick(watchdogInterval) {
+		recordingMutex.Lock()
+		if activeSession != nil {
+			for _, cap := range activeSession.captures {
+				// Loopback devices legitimately go quiet when nothing plays
+				if !cap.isLoopback && cap.stalledFor() > stallTimeout {
+					restartCapture(cap)
+				}
+			}
+		}
+		recordingMutex.Unlock()
+	}
+}
+
+// stalledFor is how long it's been since the device last delivered audio.
+func (c *captureDevice) stalledFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastCallback.Load()))
+}
+
+// restartCapture replaces a stalled device with a fresh one recording into
+// the same file. The first callback from the new device works out how much
+// audio was missed, and the writer fills it with silence. Must be called
+// with recordingMutex held.
+func restartCapture(cap *captureDevice) {
+	stalled := cap.stalledFor()
+	// A failed restart is retried once the device has been quiet for
+	// another stallTimeout
+	cap.lastCallback.Store(time.Now().UnixNano())
+
+	if cap.device != nil {
+		cap.device.Uninit()
+		cap.device = nil
+	}
+	if cap.stopSource != nil {
+		cap.stopSource()
+		cap.stopSource = nil
+	}
+	cap.restarted = true
+
+	var device *malgo.Device
+	var err error
+	switch {
+	case cap.source.port != "":
+		err = cap.recordPort()
+	case cap.source.asio != "":
+		err = cap.recordASIO()
+	case cap.source.stream != nil:
+		err = cap.recordStream(false)
+	default:
+		device, err = malgo.InitDevice(malgoContext.Context, captureConfig(cap.source, cap.pick), malgo.DeviceCallbacks{
+			Data: cap.onData,
+		})
+		if err == nil {
+			if err = device.Start(); err != nil {
+				device.Uninit()
+			}
+		}
+	}
+	if err != nil {
+		raiseAlert(Alert{
+			Type:    "stall",
+			Device:  cap.name,
+			File:    filepath.Base(cap.filename),
+			Message: fmt.Sprintf("%s stopped delivering audio and couldn't be restarted: %v", cap.name, err),
+		})
+		return
+	}
+	cap.device = device
+	if resolveAlert("stall:"+cap.name, fmt.Sprintf("%s was restarted and is delivering audio again", cap.name)) {
+		return
+	}
+	raiseAlert(Alert{
+		Type:    "stall",
+		Device:  cap.name,
+		File:    filepath.Base(cap.filename),
+		Message: fmt.Sprintf("%s stopped delivering audio for %s and was restarted", cap.name, stalled.Round(time.Second)),
+	})
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxMetadataChunk is the largest chunk other than the audio that info reads
+// in; anything bigger isn't metadata it knows.
+const maxMetadataChunk = 1 << 20
+
+// FileInfo is what info found in an audio file, printed as JSON with -json
+type FileInfo struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	AudioInfo
+	Chunks []WAVChunk `json:"chunks,omitempty"`
+	Cues   []WAVCue   `json:"cues,omitempty"`
+	Bext   *BextInfo  `json:"bext,omitempty"`
+
+	// Tags are the LIST INFO tags, such as INAM for the title
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Problems are what's wrong with the file's structure, which some
+	// programs will refuse or misread
+	Problems []string `json:"problems"`
+}
+
+// WAVChunk is one RIFF chunk of a WAV file
+type WAVChunk struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// WAVCue is a cue point, which DAWs show as a marker, with its label from
+// the LIST adtl chunk
+type WAVCue struct {
+	ID      uint32  `json:"id"`
+	Sample  uint32  `json:"sample"`
+	Seconds float64 `json:"seconds"`
+	Label   string  `json:"label,omitempty"`
+	Note    string  `json:"note,omitempty"`
+
+	// LengthSeconds is set for a region rather than a single point
+	LengthSeconds float64 `json:"lengthSeconds,omitempty"`
+}
+
+// BextInfo is a Broadcast WAV file's bext chunk (EBU Tech 3285)
+type BextInfo struct {
+	Description         string `json:"description,omitempty"`
+	Originator          string `json:"originator,omitempty"`
+	OriginatorReference string `json:"originatorReference,omitempty"`
+	OriginationDate     string `json:"originationDate,omitempty"`
+	OriginationTime     string `json:"originationTime,omitempty"`
+	Version             uint16 `json:"version"`
+
+	// TimeReference is the first sample's position in samples since
+	// midnight, which DAWs place the recording by
+	TimeReference uint64 `json:"timeReference"`
+
+	// LoudnessLUFS and the rest are from version 2 on, when set
+	LoudnessLUFS  *float64 `json:"loudnessLufs,omitempty"`
+	TruePeakDB    *float64 `json:"truePeakDb,omitempty"`
+	CodingHistory string   `json:"codingHistory,omitempty"`
+}
+
+// runInfo prints the format, markers and metadata of audio files and what's
+// wrong with their structure, to check recordings without opening a DAW. It
+// exits with status 1 if any file couldn't be read or has problems.
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	addLangFlag(fs)
+	addOutputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: skribbl-capture info [flags] file...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ok := true
+	var results []*FileInfo
+	for i, path := range fs.Args() {
+		if i > 0 {
+			uiPrintln("")
+		}
+		info, err := inspectAudioFile(path)
+		if err != nil {
+			uiWarnf("✗ %s: %v\n", path, err)
+			ok = false
+			continue
+		}
+		printFileInfo(info)
+		results = append(results, info)
+		if len(info.Problems) > 0 {
+			ok = false
+		}
+	}
+	if len(results) == 1 {
+		printResult(results[0])
+	} else {
+		printResult(results)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// inspectAudioFile reads an audio file's format and, for a WAV file, its
+// chunks, markers and metadata, checking its structure as it goes.
+func inspectAudioFile(path string) (*FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info := &FileInfo{File: filepath.Base(path), Size: stat.Size(), Problems: []string{}}
+
+	audio, probeErr := probeAudio(f)
+	if probeErr != nil && audio.Format != "wav" {
+		return nil, probeErr
+	}
+	info.AudioInfo = audio
+	if probeErr != nil {
+		// The chunks can still be listed, and say more about what's wrong
+		info.Problems = append(info.Problems, probeErr.Error())
+	}
+	if audio.Format == "wav" {
+		inspectWAVChunks(f, info)
+	}
+	return info, nil
+}
+
+// inspectWAVChunks walks every chunk of a WAV file, reading the markers and
+// metadata and noting anything malformed.
+func inspectWAVChunks(f *os.File, info *FileInfo) {
+	problem := func(format string, args ...interface{}) {
+		info.Problems = append(info.Problems, fmt.Sprintf(format, args...))
+	}
+
+	var header [12]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil || string(header[8:12]) != "WAVE" {
+		return
+	}
+	if riffSize := int64(binary.LittleEndian.Uint32(header[4:])); riffSize == 0 {
+		problem("the RIFF size is 0: the header was never updated, so the recording wasn't stopped cleanly")
+	} else if riffSize != info.Size-8 {
+		problem("the RIFF size says %d bytes follow it, but %d do", riffSize, info.Size-8)
+	}
+
+	var cuePoints []WAVCue
+	labels, notes := map[uint32]string{}, map[uint32]string{}
+	lengths := map[uint32]uint32{}
+	var blockAlign, byteRate uint32
+	seenData, seenFormat := false, false
+	for offset := int64(12); offset < info.Size; {
+		if offset+8 > info.Size {
+			problem("%d stray bytes at the end of the file", info.Size-offset)
+			break
+		}
+		var chunk [8]byte
+		f.ReadAt(chunk[:], offset)
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+		info.Chunks = append(info.Chunks, WAVChunk{ID: id, Offset: offset, Size: size})
+		available := info.Size - offset - 8
+		if size > available && !(id == "data" && size == 0) {
+			problem("the %s chunk says it has %d bytes, but only %d are left in the file: it's truncated", strings.TrimSpace(id), size, available)
+		}
+
+		var body []byte
+		if id != "data" && size <= maxMetadataChunk {
+			body = make([]byte, min(size, available))
+			f.ReadAt(body, offset+8)
+		}
+		switch id {
+		case "fmt ":
+			if seenFormat {
+				problem("more than one fmt chunk")
+			}
+			seenFormat = true
+			if seenData {
+				problem("the fmt chunk comes after the data chunk, which some programs can't read")
+			}
+			if len(body) >= 16 {
+				byteRate = binary.LittleEndian.Uint32(body[8:])
+				blockAlign = uint32(binary.LittleEndian.Uint16(body[12:]))
+			}
+		case "data":
+			if seenData {
+				problem("more than one data chunk; only the first is played")
+			}
+			seenData = true
+			if size == 0 && available > 0 {
+				problem("the data size is 0 with %d bytes of audio after it: the header was never updated, so the recording wasn't stopped cleanly", available)
+				// Everything after is audio rather than more chunks
+				size = available
+			}
+		case "cue ":
+			cuePoints = parseCueChunk(body)
+		case "LIST":
+			if len(body) < 4 {
+				break
+			}
+			switch string(body[:4]) {
+			case "adtl":
+				parseAdtl(body[4:], labels, notes, lengths)
+			case "INFO":
+				info.Tags = parseListInfo(body[4:])
+			}
+		case "bext":
+			if len(body) < 602 {
+				problem("the bext chunk is %d bytes, shorter than the 602 it must have", len(body))
+				break
+			}
+			info.Bext = parseBext(body)
+		}
+		if size > available {
+			break
+		}
+		offset += 8 + size + size%2
+	}
+
+	if seenFormat && info.SampleRate > 0 && info.BitsPerSample > 0 {
+		frame := info.Channels * ((info.BitsPerSample + 7) / 8)
+		if blockAlign != frame {
+			problem("the block align is %d, but %d channel(s) of %d-bit samples take %d bytes", blockAlign, info.Channels, info.BitsPerSample, frame)
+		}
+		if byteRate != info.SampleRate*frame {
+			problem("the byte rate is %d, but should be %d", byteRate, info.SampleRate*frame)
+		}
+		if frame > 0 && info.dataSize%int64(frame) != 0 {
+			problem("the audio ends partway through a sample frame")
+		}
+	}
+
+	frames := uint32(0)
+	if frame := int64(info.Channels * info.BitsPerSample / 8); frame > 0 {
+		frames = uint32(info.dataSize / frame)
+	}
+	for _, cue := range cuePoints {
+		cue.Label, cue.Note = labels[cue.ID], notes[cue.ID]
+		if info.SampleRate > 0 {
+			cue.Seconds = float64(cue.Sample) / float64(info.SampleRate)
+			cue.LengthSeconds = float64(lengths[cue.ID]) / float64(info.SampleRate)
+		}
+		if cue.Sample > frames {
+			problem("cue %d is at sample %d, past the end of the audio", cue.ID, cue.Sample)
+		}
+		info.Cues = append(info.Cues, cue)
+	}
+}
+
+// parseCueChunk reads the cue points of a cue chunk: a count, then 24 bytes
+// for each one.
+func parseCueChunk(body []byte) []WAVCue {
+	if len(body) < 4 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint32(body))
+	var cues []WAVCue
+	for i := 0; i < count && 4+24*(i+1) <= len(body); i++ {
+		point := body[4+24*i:]
+		cues = append(cues, WAVCue{
+			ID:     binary.LittleEndian.Uint32(point),
+			Sample: binary.LittleEndian.Uint32(point[20:]), // the sample offset
+		})
+	}
+	return cues
+}
+
+// parseAdtl reads the labels, notes and region lengths of cue points from a
+// LIST adtl chunk.
+func parseAdtl(body []byte, labels, notes map[uint32]string, lengths map[uint32]uint32) {
+	for len(body) >= 8 {
+		id, size := string(body[:4]), int(binary.LittleEndian.Uint32(body[4:]))
+		sub := body[8:min(8+size, len(body))]
+		if len(sub) >= 4 {
+			cueID := binary.LittleEndian.Uint32(sub)
+			switch id {
+			case "labl":
+				labels[cueID] = cString(sub[4:])
+			case "note":
+				notes[cueID] = cString(sub[4:])
+			case "ltxt":
+				if len(sub) >= 8 {
+					lengths[cueID] = binary.LittleEndian.Uint32(sub[4:])
+				}
+			}
+		}
+		if 8+size+size%2 > len(body) {
+			break
+		}
+		body = body[8+size+size%2:]
+	}
+}
+
+// parseListInfo reads the tags of a LIST INFO chunk.
+func parseListInfo(body []byte) map[string]string {
+	tags := map[string]string{}
+	for len(body) >= 8 {
+		id, size := string(body[:4]), int(binary.LittleEndian.Uint32(body[4:]))
+		tags[id] = cString(body[8:min(8+size, len(body))])
+		if 8+size+size%2 > len(body) {
+			break
+		}
+		body = body[8+size+size%2:]
+	}
+	return tags
+}
+
+// parseBext reads the fixed fields of a bext chunk and the coding history
+// after them.
+func parseBext(body []byte) *BextInfo {
+	bext := &BextInfo{
+		Description:         cString(body[0:256]),
+		Originator:          cString(body[256:288]),
+		OriginatorReference: cString(body[288:320]),
+		OriginationDate:     cString(body[320:330]),
+		OriginationTime:     cString(body[330:338]),
+		TimeReference:       binary.LittleEndian.Uint64(body[338:]),
+		Version:             binary.LittleEndian.Uint16(body[346:]),
+		CodingHistory:       strings.TrimSpace(cString(body[602:])),
+	}
+	if bext.Version >= 2 {
+		// Stored in hundredths, with 0x7fff for unset
+		if value := int16(binary.LittleEndian.Uint16(body[412:])); value != 0x7fff && value != 0 {
+			lufs := float64(value) / 100
+			bext.LoudnessLUFS = &lufs
+		}
+		if value := int16(binary.LittleEndian.Uint16(body[416:])); value != 0x7fff && value != 0 {
+			peak := float64(value) / 100
+			bext.TruePeakDB = &peak
+		}
+	}
+	return bext
+}
+
+// cString is the text of a fixed-size or zero-terminated field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// printFileInfo prints what info found in a file.
+func printFileInfo(info *FileInfo) {
+	encoding := fmt.Sprintf("%d-bit", info.BitsPerSample)
+	switch {
+	case info.Format == "flac":
+	case info.formatTag == wavFormatFloat:
+		encoding += " float"
+	default:
+		encoding += " PCM"
+	}
+	uiPrintf("=== %s ===\n", info.File)
+	uiPrintf("Format:      %s, %s\n", strings.ToUpper(info.Format), encoding)
+	uiPrintf("Sample rate: %d Hz\n", info.SampleRate)
+	uiPrintf("Channels:    %d\n", info.Channels)
+	uiPrintf("Duration:    %s (%s)\n", subtitleTime(info.DurationSeconds, "."), formatBytes(info.Size))
+	if len(info.Chunks) > 0 {
+		ids := make([]string, len(info.Chunks))
+		for i, c := range info.Chunks {
+			ids[i] = strings.TrimSpace(c.ID)
+		}
+		uiVerbosef("Chunks:      %s\n", strings.Join(ids, ", "))
+	}
+	for _, tag := range slices.Sorted(maps.Keys(info.Tags)) {
+		uiPrintf("%-12s %s\n", tag+":", info.Tags[tag])
+	}
+
+	if len(info.Cues) > 0 {
+		uiPrintf("\nMarkers (%d):\n", len(info.Cues))
+		for _, cue := range info.Cues {
+			line := fmt.Sprintf("  %s", subtitleTime(cue.Seconds, "."))
+			if cue.LengthSeconds > 0 {
+				line += " - " + subtitleTime(cue.Seconds+cue.LengthSeconds, ".")
+			}
+			if cue.Label != "" {
+				line += "  " + cue.Label
+			}
+			if cue.Note != "" {
+				line += " (" + cue.Note + ")"
+			}
+			uiPrintf("%s\n", line)
+		}
+	}
+
+	if b := info.Bext; b != nil {
+		uiPrintf("\nBroadcast WAV (bext version %d):\n", b.Version)
+		if b.Description != "" {
+			uiPrintf("  Description: %s\n", b.Description)
+		}
+		if b.Originator != "" {
+			uiPrintf("  Originator:  %s\n", strings.TrimSpace(b.Originator+" "+b.OriginatorReference))
+		}
+		if b.OriginationDate != "" {
+			uiPrintf("  Originated:  %s %s\n", b.OriginationDate, b.OriginationTime)
+		}
+		if info.SampleRate > 0 {
+			uiPrintf("  Timecode:    %s (sample %d)\n", subtitleTime(float64(b.TimeReference)/float64(info.SampleRate), "."), b.TimeReference)
+		}
+		if b.LoudnessLUFS != nil {
+			uiPrintf("  Loudness:    %.1f LUFS\n", *b.LoudnessLUFS)
+		}
+		if b.CodingHistory != "" {
+			uiPrintf("  History:     %s\n", strings.ReplaceAll(b.CodingHistory, "\r\n", "; "))
+		}
+	}
+
+	uiPrintln("")
+	if len(info.Problems) == 0 {
+		uiPrintln("✓ No problems found")
+	}
+	for _, p := range info.Problems {
+		uiWarnf("⚠️  %s\n", p)
+	}
+}
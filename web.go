@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
-	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,29 +16,105 @@ import (
 
 var (
 	// Global state for recording
-	isRecording     bool
 	recordingMutex  sync.Mutex
-	activeCaptures  []*captureDevice
 	malgoContext    *malgo.AllocatedContext
 	outputDirectory = "recordings"
+
+	// lastDeviceIndices are the devices of the most recent session, which
+	// the Stream Deck toggle records again. Guarded by recordingMutex.
+	lastDeviceIndices []int
 )
 
 // DeviceInfo represents an audio device for the API
 type DeviceInfo struct {
 	Index int    `json:"index"`
 	Name  string `json:"name"`
-	Type  string `json:"type"` // "capture" or "loopback"
+	Type  string `json:"type"` // "capture", "loopback", "port", "asio" or "stream"
+
+	// ID stays the same across restarts and as devices come and go, unlike
+	// Index, for scripts to pick a device by
+	ID string `json:"id"`
+
+	// Default is set on the system's default capture and playback devices
+	Default bool `json:"default,omitempty"`
+
+	// Capabilities are the formats the device works in natively, if the
+	// audio backend says
+	Capabilities *DeviceCapabilities `json:"capabilities,omitempty"`
+
+	// Speaker is who the device records, if named
+	Speaker string `json:"speaker,omitempty"`
+
+	// Channels are the inputs picked to record, numbered from 1
+	Channels []int `json:"channels,omitempty"`
+
+	// Tap is where the device's live audio is offered while it records
+	Tap string `json:"tap,omitempty"`
+
+	// DuplexWith is the index of the device's other side, recorded with it
+	// by a duplex start
+	DuplexWith *int `json:"duplexWith,omitempty"`
+}
+
+// DeviceCapabilities are the formats a device works in natively. The
+// recorder converts to and from others, so they're a guide to what records
+// best rather than a limit.
+type DeviceCapabilities struct {
+	SampleRates   []uint32 `json:"sampleRates,omitempty"`
+	MinChannels   uint32   `json:"minChannels,omitempty"`
+	MaxChannels   uint32   `json:"maxChannels,omitempty"`
+	SampleFormats []string `json:"sampleFormats,omitempty"` // u8, s16, s24, s32 or f32
 }
 
 // RecordingStatus represents the current recording state
 type RecordingStatus struct {
-	IsRecording bool     `json:"isRecording"`
-	Devices     []string `json:"devices"`
+	IsRecording bool          `json:"isRecording"`
+	SessionID   string        `json:"sessionId,omitempty"`
+	Devices     []string      `json:"devices"`
+	DeviceStats []DeviceStats `json:"deviceStats"`
+	Resources   ResourceUsage `json:"resources"`
+
+	// ReadOnly is set when the caller may only list and download
+	ReadOnly bool `json:"readOnly,omitempty"`
 }
 
 // StartRecordingRequest is the request body for starting a recording
 type StartRecordingRequest struct {
 	DeviceIndices []int `json:"deviceIndices"`
+
+	// BestEffort starts whichever devices succeed instead of rolling
+	// everything back when one fails.
+	BestEffort bool `json:"bestEffort"`
+
+	// Title is added to the file names and, with Tags, saved in the session
+	// metadata.
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// Duplex also records the other side of each device, so picking a
+	// headset's mic records what it plays too, and the other way round.
+	Duplex bool `json:"duplex,omitempty"`
+
+	// LevelCheck listens to every device for a few seconds first and, for
+	// "refuse", doesn't start if any is silent or clipping, or for "warn"
+	// starts anyway with warnings. Empty uses -level-check.
+	LevelCheck string `json:"levelCheck,omitempty"`
+}
+
+// DeviceStartResult reports what happened to one requested device
+type DeviceStartResult struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Started bool   `json:"started"`
+	File    string `json:"file,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// FallbackFor names the preset device this default device stands in for
+	FallbackFor string `json:"fallbackFor,omitempty"`
+
+	// LevelCheck is how the device sounded in the level check before the
+	// start, if one was run
+	LevelCheck *LevelCheckResult `json:"levelCheck,omitempty"`
 }
 
 func initWebServer() error {
@@ -47,51 +124,16 @@ func initWebServer() error {
 	}
 
 	// Initialize malgo context
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	ctx, err := initAudioContext()
 	if err != nil {
 		return fmt.Errorf("failed to initialize audio context: %v", err)
 	}
 	malgoContext = ctx
 
-	return nil
-}
+	// Start sampling CPU and write throughput for /api/status and /metrics
+	resources.start()
 
-// Handler: GET /api/devices - List all available capture devices
-func handleListDevices(w http.ResponseWriter, r *http.Request) {
-	devices := []DeviceInfo{}
-
-	// List capture devices (microphones, virtual inputs)
-	captureInfos, err := malgoContext.Devices(malgo.Capture)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get capture devices: %v", err), http.StatusInternalServerError)
-		return
-	}
-	for i, info := range captureInfos {
-		devices = append(devices, DeviceInfo{
-			Index: i,
-			Name:  info.Name(),
-			Type:  "capture",
-		})
-	}
-
-	// On Windows, also list playback devices as loopback sources
-	if runtime.GOOS == "windows" {
-		playbackInfos, err := malgoContext.Devices(malgo.Playback)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get playback devices: %v", err), http.StatusInternalServerError)
-			return
-		}
-		for i, info := range playbackInfos {
-			devices = append(devices, DeviceInfo{
-				Index: len(captureInfos) + i,
-				Name:  info.Name(),
-				Type:  "loopback",
-			})
-		}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(devices)
+	return nil
 }
 
 // Handler: GET /api/status - Get current recording status
@@ -99,14 +141,19 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	recordingMutex.Lock()
 	defer recordingMutex.Unlock()
 
-	deviceNames := []string{}
-	for _, cap := range activeCaptures {
-		deviceNames = append(deviceNames, cap.name)
-	}
-
 	status := RecordingStatus{
-		IsRecording: isRecording,
-		Devices:     deviceNames,
+		Devices:     []string{},
+		DeviceStats: []DeviceStats{},
+		Resources:   resources.usage(),
+		ReadOnly:    readOnlyFor(r),
+	}
+	if activeSession != nil {
+		status.IsRecording = true
+		status.SessionID = activeSession.id
+		for _, cap := range activeSession.captures {
+			status.Devices = append(status.Devices, cap.name)
+		}
+		status.DeviceStats = deviceStats(activeSession.captures)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -118,176 +165,417 @@ func handleStartRecording(w http.ResponseWriter, r *http.Request) {
 	recordingMutex.Lock()
 	defer recordingMutex.Unlock()
 
-	if isRecording {
-		http.Error(w, "Already recording", http.StatusBadRequest)
+	if activeSession != nil {
+		writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Already recording")
 		return
 	}
 
 	var req StartRecordingRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
 	if len(req.DeviceIndices) == 0 {
-		http.Error(w, "No devices selected", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeNoDevices, "No devices selected")
 		return
 	}
-
-	// Build unified device list (capture + loopback on Windows)
-	allDevices := []selectableDevice{}
-
-	captureInfos, err := malgoContext.Devices(malgo.Capture)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get capture devices: %v", err), http.StatusInternalServerError)
-		return
+	levelCheck := req.LevelCheck
+	if levelCheck == "" {
+		levelCheck = levelCheckMode
 	}
-	for _, info := range captureInfos {
-		allDevices = append(allDevices, selectableDevice{info: info, isLoopback: false})
+	if !validLevelCheckMode(levelCheck) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "levelCheck must be off, warn or refuse")
+		return
 	}
 
-	if runtime.GOOS == "windows" {
-		playbackInfos, err := malgoContext.Devices(malgo.Playback)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get playback devices: %v", err), http.StatusInternalServerError)
-			return
-		}
-		for _, info := range playbackInfos {
-			allDevices = append(allDevices, selectableDevice{info: info, isLoopback: true})
-		}
+	// Use the same device list the client was shown (capture + loopback where supported)
+	allDevices, err := cachedDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeDeviceError, fmt.Sprintf("Failed to list devices: %v", err))
+		return
 	}
 
-	// Set up capture for each selected device
-	captures := []*captureDevice{}
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-
+	// Check every index before opening anything
 	for _, idx := range req.DeviceIndices {
 		if idx < 0 || idx >= len(allDevices) {
-			http.Error(w, fmt.Sprintf("Invalid device index: %d", idx), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Invalid device index: %d", idx))
 			return
 		}
-
-		selected := allDevices[idx]
-		deviceInfo := selected.info
-		deviceName := deviceInfo.Name()
-
-		// Create filename with timestamp
-		safeFilename := fmt.Sprintf("%s_%s.wav", timestamp, sanitizeFilename(deviceName))
-		fullPath := filepath.Join(outputDirectory, safeFilename)
-
-		// Configure the audio capture settings
-		// Use Loopback mode for playback devices on Windows, Capture for regular mics
-		deviceType := malgo.Capture
-		if selected.isLoopback {
-			deviceType = malgo.Loopback
-		}
-		deviceConfig := malgo.DefaultDeviceConfig(deviceType)
-		deviceConfig.Capture.Format = malgo.FormatS16
-		deviceConfig.Capture.Channels = 1
-		deviceConfig.SampleRate = 44100
-		deviceConfig.Capture.DeviceID = deviceInfo.ID.Pointer()
-
-		// Create output file
-		outputFile, err := os.Create(fullPath)
+	}
+	if req.Duplex {
+		indices, err := withDuplexPartners(allDevices, req.DeviceIndices)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+			writeError(w, http.StatusBadRequest, errCodeDeviceNotFound, fmt.Sprintf("Can't record both sides: %v", err))
 			return
 		}
+		req.DeviceIndices = indices
+	}
 
-		// Write WAV header
-		if err := writeWAVHeader(outputFile, deviceConfig.SampleRate, uint32(deviceConfig.Capture.Channels), 16, 0); err != nil {
-			outputFile.Close()
-			http.Error(w, fmt.Sprintf("Failed to write WAV header: %v", err), http.StatusInternalServerError)
+	var checks map[int]*LevelCheckResult
+	var problems []string
+	if levelCheck != levelCheckOff {
+		// The check takes a few seconds, which nothing else should wait on
+		samplesRunning++
+		recordingMutex.Unlock()
+		checks = runLevelCheck(r.Context(), allDevices, req.DeviceIndices)
+		recordingMutex.Lock()
+		samplesRunning--
+
+		if activeSession != nil {
+			writeError(w, http.StatusConflict, errCodeAlreadyRecording, "Already recording")
 			return
 		}
-
-		// Create capture device
-		cap := &captureDevice{
-			name:     deviceName,
-			file:     outputFile,
-			filename: fullPath,
+		problems = levelCheckProblems(allDevices, req.DeviceIndices, checks)
+		if len(problems) > 0 && levelCheck == levelCheckRefuse {
+			writeLevelCheckRefused(w, allDevices, req.DeviceIndices, checks, problems)
+			return
 		}
-		captures = append(captures, cap)
+	}
 
-		// Define callback
-		onRecvFrames := func(pSample2, pSample []byte, framecount uint32) {
-			n, _ := cap.file.Write(pSample)
-			cap.totalBytesWritten += uint32(n)
+	results, err := startSession(r.Context(), allDevices, req)
+	if err != nil {
+		writeStorageError(w, errCodeDeviceError, err)
+		return
+	}
+	for i := range results {
+		results[i].LevelCheck = checks[results[i].Index]
+	}
+	for _, problem := range problems {
+		activeSession.warnings = append(activeSession.warnings, "level check: "+problem)
+	}
+	writeSessionStarted(w, results)
+}
+
+// withDuplexPartners adds each device's other side to indices, right after
+// it, unless it's already there. Every device needs one.
+func withDuplexPartners(allDevices []selectableDevice, indices []int) ([]int, error) {
+	var expanded []int
+	for _, idx := range indices {
+		partner, ok := duplexPartner(allDevices, idx)
+		if !ok {
+			side := "loopback"
+			if allDevices[idx].isLoopback {
+				side = "capture"
+			}
+			return nil, fmt.Errorf("no %s side found for %s", side, allDevices[idx].name())
+		}
+		if !slices.Contains(expanded, idx) {
+			expanded = append(expanded, idx)
+		}
+		if !slices.Contains(indices, partner) && !slices.Contains(expanded, partner) {
+			expanded = append(expanded, partner)
 		}
+	}
+	return expanded, nil
+}
 
-		// Initialize device
-		device, err := malgo.InitDevice(malgoContext.Context, deviceConfig, malgo.DeviceCallbacks{
-			Data: onRecvFrames,
-		})
+// startSession records the requested devices as a new active session.
+// Without BestEffort a session is all or nothing: if any device fails, the
+// ones that did start are rolled back so no half-recorded files are left
+// behind. Must be called with recordingMutex held and valid indices.
+func startSession(ctx context.Context, allDevices []selectableDevice, req StartRecordingRequest) ([]DeviceStartResult, error) {
+	indices, bestEffort := req.DeviceIndices, req.BestEffort
+	ctx, sessionSpan := startSpan(ctx, "session.start", spanKindInternal)
+	sessionSpan.setAttr("session.devices", len(indices))
+	sessionSpan.setAttr("session.best_effort", bestEffort)
+
+	// Set up capture for each selected device
+	captures := []*captureDevice{}
+	results := []DeviceStartResult{}
+	startedAt := time.Now()
+	id := newSessionID(startedAt)
+	sessionSpan.setAttr("session.id", id)
+
+	var firstErr error
+	for _, idx := range indices {
+		selected := allDevices[idx]
+		result := DeviceStartResult{Index: idx, Name: selected.name()}
+
+		safeFilename, fullPath := trackFilename(id, req.Title, selected.name())
+
+		cap, err := startCapture(ctx, selected, fullPath, 0)
 		if err != nil {
-			outputFile.Close()
-			http.Error(w, fmt.Sprintf("Failed to initialize device: %v", err), http.StatusInternalServerError)
-			return
+			result.Error = err.Error()
+			results = append(results, result)
+			if firstErr == nil {
+				firstErr = err
+			}
+			if !bestEffort {
+				break
+			}
+			continue
 		}
-		cap.device = device
+		cap.deviceIndex = idx
+		cap.inSession.Store(true)
+		result.Started = true
+		result.File = safeFilename
+		results = append(results, result)
+		captures = append(captures, cap)
+	}
 
-		// Start device
-		if err := device.Start(); err != nil {
-			device.Uninit()
-			outputFile.Close()
-			http.Error(w, fmt.Sprintf("Failed to start device: %v", err), http.StatusInternalServerError)
-			return
+	if firstErr != nil && (!bestEffort || len(captures) == 0) {
+		for _, cap := range captures {
+			cap.discard()
 		}
+		sessionSpan.finish(firstErr)
+		return nil, firstErr
 	}
 
-	activeCaptures = captures
-	isRecording = true
+	activeSession = &session{
+		id:        id,
+		startedAt: startedAt,
+		captures:  captures,
+		title:     req.Title,
+		tags:      req.Tags,
+		gameTitle: req.Title,
+	}
+	go func(sess *session) {
+		sess.system.Store(takeSystemSnapshot(allDevices))
+	}(activeSession)
+	activeSession.transcript = newLiveTranscript(activeSession)
+	for _, cap := range captures {
+		activeSession.transcript.attach(cap)
+	}
+	lastDeviceIndices = indices
+	if err := saveLastDevices(allDevices, indices); err != nil {
+		// The recording itself is fine
+		fmt.Printf("⚠️  Failed to remember the devices recorded: %v\n", err)
+	}
+	monitoring.sync()
+	liveMix.sync()
+	notifySessionChanged()
+	rememberSessionSpan(id, sessionSpan)
+	sessionSpan.finish(nil)
+	return results, nil
+}
 
+// writeSessionStarted responds with the new active session and what happened
+// to each requested device.
+func writeSessionStarted(w http.ResponseWriter, results []DeviceStartResult) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "recording started"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "recording started",
+		"sessionId": activeSession.id,
+		"devices":   results,
+	})
 }
 
-// Handler: POST /api/stop - Stop recording
-func handleStopRecording(w http.ResponseWriter, r *http.Request) {
-	recordingMutex.Lock()
-	defer recordingMutex.Unlock()
+// captureConfig is the malgo configuration a device is recorded with. A
+// device with picked channels is opened with enough channels to reach the
+// highest of them.
+func captureConfig(selected selectableDevice, pick []int) malgo.DeviceConfig {
+	// Use Loopback mode for playback devices, Capture for regular mics
+	deviceType := malgo.Capture
+	if selected.isLoopback {
+		deviceType = malgo.Loopback
+	}
+	deviceConfig := malgo.DefaultDeviceConfig(deviceType)
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = 1
+	if len(pick) > 0 {
+		deviceConfig.Capture.Channels = uint32(slices.Max(pick) + 1)
+	}
+	deviceConfig.SampleRate = 44100
+	deviceConfig.Capture.DeviceID = selected.info.ID.Pointer()
+	return deviceConfig
+}
 
-	if !isRecording {
-		http.Error(w, "Not currently recording", http.StatusBadRequest)
-		return
+// startCapture opens a WAV file and starts recording a device into it. A
+// non-zero offset pads the start of the file with that much silence, so a
+// device added to a running session lines up with the other tracks.
+func startCapture(ctx context.Context, selected selectableDevice, fullPath string, offset time.Duration) (*captureDevice, error) {
+	_, s := startSpan(ctx, "device.start", spanKindInternal)
+	deviceName := selected.name()
+	s.setAttr("device.name", deviceName)
+	s.setAttr("device.loopback", selected.isLoopback)
+
+	settings, err := loadDeviceSettings()
+	if err != nil {
+		fmt.Printf("⚠️  Ignoring device settings: %v\n", err)
+	}
+	var pick []int
+	if selected.isAudioDevice() || selected.asio != "" {
+		pick = pickedChannels(settings[deviceName].Channels)
+	}
+	deviceConfig := captureConfig(selected, pick)
+	channels := deviceConfig.Capture.Channels
+	if pick != nil {
+		channels = uint32(len(pick))
 	}
 
-	// Stop all devices and clean up
-	for _, cap := range activeCaptures {
-		cap.device.Uninit()
+	// Create output file
+	outputFile, err := createNewFile(fullPath)
+	if err != nil {
+		err = fmt.Errorf("failed to create file: %w", err)
+		s.finish(err)
+		return nil, err
+	}
 
-		// Update WAV header with correct size
-		cap.file.Seek(0, 0)
-		writeWAVHeader(cap.file, 44100, 1, 16, cap.totalBytesWritten)
-		cap.file.Close()
+	// Write WAV header
+	if err := writeWAVHeader(outputFile, deviceConfig.SampleRate, channels, 16, 0); err != nil {
+		outputFile.Close()
+		os.Remove(fullPath)
+		err = fmt.Errorf("failed to write WAV header: %w", err)
+		s.finish(err)
+		return nil, err
 	}
 
-	activeCaptures = []*captureDevice{}
-	isRecording = false
+	// Create capture device
+	cap := newCaptureDevice(deviceName, outputFile, fullPath, deviceConfig.SampleRate, channels)
+	if splitEvery > 0 {
+		cap.startSegments()
+	}
+	cap.isLoopback = selected.isLoopback
+	cap.source = selected
+	cap.pick, cap.inputChannels = pick, deviceConfig.Capture.Channels
+	if gainDB := settings[deviceName].GainDB; gainDB != 0 {
+		cap.gain, cap.gainDB = dbToGain(gainDB), gainDB
+	}
+	cap.speaker = settings[deviceName].Speaker
+	if addr := settings[deviceName].Tap; addr != "" {
+		// The recording matters more than the tap, so it goes ahead without
+		tap, err := openTap(addr)
+		if err != nil {
+			fmt.Printf("⚠️  No live tap for %s: %v\n", deviceName, err)
+		}
+		cap.tap = tap
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "recording stopped"})
+	if offset > 0 {
+		if err := cap.writeSilence(offset); err != nil {
+			cap.discard()
+			err = fmt.Errorf("failed to pad track: %w", err)
+			s.finish(err)
+			return nil, err
+		}
+	}
+
+	if selected.port != "" {
+		if err := cap.recordPort(); err != nil {
+			cap.discard()
+			err = fmt.Errorf("failed to record %s: %w", selected.port, err)
+			s.finish(err)
+			return nil, err
+		}
+		s.finish(nil)
+		return cap, nil
+	}
+	if selected.asio != "" {
+		if err := cap.recordASIO(); err != nil {
+			cap.discard()
+			err = fmt.Errorf("failed to record %s: %w", selected.asio, err)
+			s.finish(err)
+			return nil, err
+		}
+		s.finish(nil)
+		return cap, nil
+	}
+	if selected.stream != nil {
+		if err := cap.recordStream(true); err != nil {
+			cap.discard()
+			err = fmt.Errorf("failed to record %s: %w", selected.stream.Name, err)
+			s.finish(err)
+			return nil, err
+		}
+		s.finish(nil)
+		return cap, nil
+	}
+
+	// Initialize device
+	device, err := malgo.InitDevice(malgoContext.Context, deviceConfig, malgo.DeviceCallbacks{
+		Data: cap.onData,
+	})
+	if err != nil {
+		cap.discard()
+		err = fmt.Errorf("failed to initialize device: %v", err)
+		s.finish(err)
+		return nil, err
+	}
+	cap.device = device
+	cap.normalizeInputVolume()
+
+	// Start device
+	cap.keepTime()
+	if err := device.Start(); err != nil {
+		cap.discard()
+		err = fmt.Errorf("failed to start device: %v", err)
+		s.finish(err)
+		return nil, err
+	}
+
+	s.finish(nil)
+	return cap, nil
 }
 
-// Handler: GET /api/recordings - List all recordings
+// Handler: POST /api/stop - Stop recording and return the finalized files
+func handleStopRecording(w http.ResponseWriter, r *http.Request) {
+	recordingMutex.Lock()
+	if activeSession == nil {
+		recordingMutex.Unlock()
+		writeError(w, http.StatusConflict, errCodeNotRecording, "Not currently recording")
+		return
+	}
+
+	stopActiveSession(w, r)
+}
+
+// Handler: GET /api/recordings?starred= - List all recordings, oldest first,
+// or only those in starred sessions, or not
 func handleListRecordings(w http.ResponseWriter, r *http.Request) {
-	files, err := filepath.Glob(filepath.Join(outputDirectory, "*.wav"))
+	starred, err := starredFilter(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list recordings: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	entries, err := os.ReadDir(outputDirectory)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to list recordings: %v", err))
 		return
 	}
 
-	recordings := []map[string]interface{}{}
-	for _, file := range files {
-		info, err := os.Stat(file)
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if !isAudioFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
+		infos = append(infos, info)
+	}
+	// Sorted by when they were written rather than by name, since local
+	// time names don't sort across a DST change
+	var files []storedFile
+	local := map[string]bool{}
+	for _, info := range infos {
+		files = append(files, storedFile{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		local[info.Name()] = true
+	}
+	// Files moved to -storage are listed from there, apart from those of
+	// sessions in the trash
+	known := catalogFiles()
+	for _, f := range storedAudio() {
+		if !local[f.Name] && known[f.Name] {
+			files = append(files, f)
+		}
+	}
+	slices.SortStableFunc(files, func(a, b storedFile) int { return a.ModTime.Compare(b.ModTime) })
 
+	own := requestUser(r).ownTracks()
+	inStarred := starredFiles()
+	recordings := []map[string]interface{}{}
+	for _, f := range files {
+		if own != nil && !own[f.Name] || starred != nil && inStarred[f.Name] != *starred {
+			continue
+		}
 		recordings = append(recordings, map[string]interface{}{
-			"name": filepath.Base(file),
-			"size": info.Size(),
-			"time": info.ModTime().Format("2006-01-02 15:04:05"),
+			"name":       f.Name,
+			"size":       f.Size,
+			"time":       f.ModTime.Local().Format("2006-01-02 15:04:05"),
+			"modifiedAt": f.ModTime.UTC(),
+			"starred":    inStarred[f.Name],
 		})
 	}
 
@@ -295,29 +583,39 @@ func handleListRecordings(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(recordings)
 }
 
-// Handler: GET /recordings/{filename} - Download a recording
+// Handler: GET /recordings/{filename} - Download a recording. Only files the
+// recorder wrote itself are served.
 func handleDownloadRecording(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Path[len("/recordings/"):]
-	fullPath := filepath.Join(outputDirectory, filepath.Base(filename))
-
-	// Security: prevent directory traversal
-	if !filepath.HasPrefix(fullPath, outputDirectory) {
-		http.Error(w, "Invalid filename", http.StatusBadRequest)
+	// The path is already decoded, so an encoded slash shows up as a slash
+	// and is rejected along with "..", backslashes and subdirectories
+	name := strings.TrimPrefix(r.URL.Path, "/recordings/")
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid filename")
 		return
 	}
-
-	http.ServeFile(w, r, fullPath)
+	if !inCatalog(name) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Recording not found")
+		return
+	}
+	if !requestUser(r).mayDownload(name) {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "You can only download your own tracks and what's shared with you")
+		return
+	}
+	serveRecording(w, r, name)
 }
 
-func sanitizeFilename(name string) string {
-	// Replace spaces and special characters with underscores
-	result := ""
-	for _, r := range name {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
-			result += string(r)
-		} else {
-			result += "_"
-		}
+// serveRecording serves a file from the recordings folder, or -storage.
+func serveRecording(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := openRecording(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Recording not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Recording not found")
+		return
 	}
-	return result
+	http.ServeContent(w, r, name, info.ModTime(), file)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testSessionID = "2024-05-01_20-15-00"
+	testTrack     = testSessionID + "_Mic.wav"
+	testSecret    = "outside the recordings folder"
+)
+
+// setupRecordings points outputDirectory at a temporary recordings folder
+// holding one finished session, next to a file outside it. The session lists
+// escape.wav, a symlink to that file, and inside.wav, a symlink to its track.
+func setupRecordings(t *testing.T) {
+	t.Helper()
+	base := t.TempDir()
+	dir := filepath.Join(base, "recordings")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(base, "secret.txt")
+	if err := os.WriteFile(secret, []byte(testSecret), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, testTrack), []byte("RIFF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "escape.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(testTrack, filepath.Join(dir, "inside.wav")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := SessionManifest{ID: testSessionID, Tracks: []TrackInfo{{File: testTrack}, {File: "escape.wav"}, {File: "inside.wav"}}}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, testSessionID+".json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := outputDirectory
+	outputDirectory = dir
+	t.Cleanup(func() { outputDirectory = saved })
+}
+
+func TestHandleDownloadRecording(t *testing.T) {
+	setupRecordings(t)
+
+	tests := []struct {
+		name, target string
+		status       int
+	}{
+		{"track", "/recordings/" + testTrack, http.StatusOK},
+		{"sidecar", "/recordings/" + testSessionID + ".json", http.StatusOK},
+		{"symlink inside the folder", "/recordings/inside.wav", http.StatusOK},
+		{"dot dot", "/recordings/..", http.StatusBadRequest},
+		{"encoded dot dot", "/recordings/%2E%2E", http.StatusBadRequest},
+		{"encoded slash", "/recordings/..%2Fsecret.txt", http.StatusBadRequest},
+		{"encoded slash in a name", "/recordings/sub%2F" + testTrack, http.StatusBadRequest},
+		{"encoded backslash", "/recordings/..%5Csecret.txt", http.StatusBadRequest},
+		{"empty name", "/recordings/", http.StatusBadRequest},
+		{"not in the catalog", "/recordings/secret.txt", http.StatusNotFound},
+		{"symlink out of the folder", "/recordings/escape.wav", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleDownloadRecording(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.status {
+				t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, tt.status)
+			}
+			if strings.Contains(w.Body.String(), testSecret) {
+				t.Errorf("GET %s served the file outside the recordings folder", tt.target)
+			}
+		})
+	}
+}
+
+func TestDownloadRecordingThroughMux(t *testing.T) {
+	setupRecordings(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/recordings/", handleDownloadRecording)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Through a real server the mux redirects away from ".." in the raw
+	// path, and the handler rejects what's left once it's decoded
+	for _, path := range []string{"/recordings/../secret.txt", "/recordings/%2e%2e/secret.txt", "/recordings/..%2fsecret.txt", "/recordings/escape.wav"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK || strings.Contains(string(body), testSecret) {
+			t.Errorf("GET %s: status %d, body %q", path, resp.StatusCode, body)
+		}
+	}
+}
+
+// errorCode returns the code of an API error response.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return response.Error.Code
+}
+
+func TestAlreadyRecordingIsConflict(t *testing.T) {
+	activeSession = &session{id: testSessionID}
+	defer func() { activeSession = nil }()
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"start":      handleStartRecording,
+		"quickstart": handleQuickstart,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+		if w.Code != http.StatusConflict || errorCode(t, w) != errCodeAlreadyRecording {
+			t.Errorf("%s while recording: status %d, code %q, want 409 %s", name, w.Code, errorCode(t, w), errCodeAlreadyRecording)
+		}
+	}
+}
+
+func TestNotRecordingIsConflict(t *testing.T) {
+	setupRecordings(t)
+
+	w := httptest.NewRecorder()
+	handleStopRecording(w, httptest.NewRequest(http.MethodPost, "/api/stop", nil))
+	if w.Code != http.StatusConflict || errorCode(t, w) != errCodeNotRecording {
+		t.Errorf("stop while not recording: status %d, code %q, want 409 %s", w.Code, errorCode(t, w), errCodeNotRecording)
+	}
+
+	w = httptest.NewRecorder()
+	if runningSession(w, testSessionID) != nil {
+		t.Fatal("runningSession found a stopped session")
+	}
+	if w.Code != http.StatusConflict || errorCode(t, w) != errCodeNotRecording {
+		t.Errorf("stopped session: status %d, code %q, want 409 %s", w.Code, errorCode(t, w), errCodeNotRecording)
+	}
+}